@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/twitchtv/twirp"
+	"github.com/urfave/cli/v2"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	redisTC "github.com/liuhailove/tc-base-go/protocol/redis"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+)
+
+const (
+	// adminTokenTTL 管理命令使用的token有效期
+	adminTokenTTL = 5 * time.Minute
+	// adminRequestTimeout 管理命令请求超时时间
+	adminRequestTimeout = 10 * time.Second
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found in config")
+
+// printConfigSchema 输出由 config.Config 生成的 JSON Schema
+func printConfigSchema(_ *cli.Context) error {
+	schema, err := config.GenerateJSONSchema()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// createToken 创建加入房间的token
+func createToken(c *cli.Context) error {
+	conf, err := getConfig(c)
+	if err != nil {
+		return err
+	}
+
+	grant := &auth.VideoGrant{
+		RoomJoin:  true,
+		Room:      c.String("room"),
+		RoomAdmin: c.Bool("room-admin"),
+		Hidden:    c.Bool("hidden"),
+		Recorder:  c.Bool("recorder"),
+	}
+	grant.SetCanPublish(c.Bool("can-publish"))
+	grant.SetCanSubscribe(c.Bool("can-subscribe"))
+	grant.SetCanPublishData(c.Bool("can-publish-data"))
+	if sources := c.StringSlice("can-publish-source"); len(sources) > 0 {
+		grant.CanPublishSources = make([]string, 0, len(sources))
+		for _, source := range sources {
+			grant.CanPublishSources = append(grant.CanPublishSources, strings.ToLower(source))
+		}
+	}
+
+	apiKey, apiSecret, err := getAPIKey(c, conf)
+	if err != nil {
+		return err
+	}
+
+	at := auth.NewAccessToken(apiKey, apiSecret).
+		AddGrant(grant).
+		SetIdentity(c.String("identity")).
+		SetName(c.String("name")).
+		SetValidFor(c.Duration("ttl"))
+	token, err := at.ToJWT()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Token:", token)
+	return nil
+}
+
+// listNodes 通过 Router.ListNodes 列举集群中的节点
+func listNodes(c *cli.Context) error {
+	conf, err := getConfig(c)
+	if err != nil {
+		return err
+	}
+	// 单节点模式下只能看到当前命令自身
+	if !conf.Redis.IsConfigured() {
+		return redisTC.ErrNotConfigured
+	}
+
+	currentNode, err := routing.NewLocalNode(conf)
+	if err != nil {
+		return err
+	}
+	rc, err := redisTC.GetRedisClient(&conf.Redis)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	router := routing.CreateRouter(rc, currentNode)
+	nodes, err := router.ListNodes()
+	if err != nil {
+		return err
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Id < nodes[j].Id
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tIP\tREGION\tSTATE\tCPUS\tCPU LOAD\tROOMS\tCLIENTS\tTRACKS IN/OUT\tSTARTED AT\tUPDATED AT")
+	for _, node := range nodes {
+		stats := node.GetStats()
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%.2f\t%d\t%d\t%d/%d\t%s\t%s\n",
+			node.Id,
+			node.Ip,
+			node.Region,
+			node.State.String(),
+			node.NumCpus,
+			stats.GetCpuLoad(),
+			stats.GetNumRooms(),
+			stats.GetNumClients(),
+			stats.GetNumTracksIn(),
+			stats.GetNumTracksOut(),
+			time.Unix(stats.GetStartedAt(), 0).Format(time.RFC3339),
+			time.Unix(stats.GetUpdatedAt(), 0).Format(time.RFC3339),
+		)
+	}
+	return w.Flush()
+}
+
+// listRooms 通过 RoomService 列举房间
+func listRooms(c *cli.Context) error {
+	conf, err := getConfig(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := adminContext(c, conf, &auth.VideoGrant{RoomList: true})
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	res, err := newRoomServiceClient(c, conf).ListRooms(ctx, &tc.ListRoomsRequest{
+		Names: c.StringSlice("names"),
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SID\tNAME\tPARTICIPANTS\tPUBLISHERS\tMAX PARTICIPANTS\tCREATED AT\tMETADATA")
+	for _, room := range res.Rooms {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n",
+			room.Sid,
+			room.Name,
+			room.NumParticipants,
+			room.NumPublishers,
+			room.MaxParticipants,
+			time.Unix(room.CreationTime, 0).Format(time.RFC3339),
+			room.Metadata,
+		)
+	}
+	return w.Flush()
+}
+
+// removeParticipant 通过 RoomService 将参与者移出房间
+func removeParticipant(c *cli.Context) error {
+	conf, err := getConfig(c)
+	if err != nil {
+		return err
+	}
+
+	roomName := c.String("room")
+	ctx, cancel, err := adminContext(c, conf, &auth.VideoGrant{RoomAdmin: true, Room: roomName})
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	_, err = newRoomServiceClient(c, conf).RemoveParticipant(ctx, &tc.RoomParticipantIdentity{
+		Room:     roomName,
+		Identity: c.String("identity"),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("participant removed:", c.String("identity"))
+	return nil
+}
+
+// getAPIKey 获取用于签名的API key，未指定时使用排序后的第一个key
+func getAPIKey(c *cli.Context, conf *config.Config) (string, string, error) {
+	if err := conf.ValidateKeys(); err != nil {
+		return "", "", err
+	}
+
+	if apiKey := c.String("api-key"); apiKey != "" {
+		secret, ok := conf.Keys[apiKey]
+		if !ok {
+			return "", "", ErrAPIKeyNotFound
+		}
+		return apiKey, secret, nil
+	}
+
+	keys := make([]string, 0, len(conf.Keys))
+	for key := range conf.Keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys[0], conf.Keys[keys[0]], nil
+}
+
+// adminContext 创建携带管理token的请求上下文
+func adminContext(c *cli.Context, conf *config.Config, grant *auth.VideoGrant) (context.Context, context.CancelFunc, error) {
+	apiKey, apiSecret, err := getAPIKey(c, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token, err := auth.NewAccessToken(apiKey, apiSecret).
+		AddGrant(grant).
+		SetValidFor(adminTokenTTL).
+		ToJWT()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+token)
+
+	ctx, cancel := context.WithTimeout(context.Background(), adminRequestTimeout)
+	ctx, err = twirp.WithHTTPRequestHeaders(ctx, header)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return ctx, cancel, nil
+}
+
+func newRoomServiceClient(c *cli.Context, conf *config.Config) tc.RoomService {
+	url := c.String("url")
+	if url == "" {
+		url = fmt.Sprintf("http://localhost:%d", conf.Port)
+	}
+	return tc.NewRoomServiceProtobufClient(url, &http.Client{Timeout: adminRequestTimeout})
+}
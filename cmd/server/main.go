@@ -1,14 +1,22 @@
 package main
 
 import (
+	"fmt"
 	"math/rand"
 	"os"
-	"tc-server/pkg/rtc"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/urfave/cli/v2"
 
 	"github.com/liuhailove/tc-base-go/protocol/logger"
+	redisTC "github.com/liuhailove/tc-base-go/protocol/redis"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc"
+	"github.com/liuhailove/tc-server/pkg/service"
 )
 
 // baseFlags --config=config.yaml 从yaml加载配置文件
@@ -86,6 +94,13 @@ var baseFlags = []cli.Flag{
 	},
 }
 
+// urlFlag 管理命令访问的服务地址
+var urlFlag = &cli.StringFlag{
+	Name:    "url",
+	Usage:   "tc-server的HTTP地址，默认为 http://localhost:<port>",
+	EnvVars: []string{"TCLIVE_URL"},
+}
+
 func init() {
 	rand.Seed(time.Now().Unix())
 }
@@ -98,4 +113,209 @@ func main() {
 	}()
 
 	generatedFlags, err := config.GenerateCLIFlags(baseFlags, true)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	app := &cli.App{
+		Name:   "tc-server",
+		Usage:  "基于WebRTC的高性能SFU服务",
+		Flags:  append(baseFlags, generatedFlags...),
+		Action: startServer,
+		Commands: []*cli.Command{
+			{
+				Name:   "create-join-token",
+				Usage:  "使用配置中的API key创建加入房间的token",
+				Action: createToken,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "room",
+						Usage:    "要加入的房间名称",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "identity",
+						Usage:    "参与者标识",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "参与者名称",
+					},
+					&cli.StringFlag{
+						Name:  "api-key",
+						Usage: "用于签名的API key，默认使用配置中的第一个key",
+					},
+					&cli.DurationFlag{
+						Name:  "ttl",
+						Usage: "token的有效期",
+						Value: 6 * time.Hour,
+					},
+					&cli.BoolFlag{
+						Name:  "can-publish",
+						Usage: "是否允许发布音视频",
+						Value: true,
+					},
+					&cli.BoolFlag{
+						Name:  "can-subscribe",
+						Usage: "是否允许订阅",
+						Value: true,
+					},
+					&cli.BoolFlag{
+						Name:  "can-publish-data",
+						Usage: "是否允许发布数据",
+						Value: true,
+					},
+					&cli.StringSliceFlag{
+						Name:  "can-publish-source",
+						Usage: "允许发布的音轨来源，可以多次指定，如camera、microphone、screen_share",
+					},
+					&cli.BoolFlag{
+						Name:  "room-admin",
+						Usage: "授予房间管理权限",
+					},
+					&cli.BoolFlag{
+						Name:  "hidden",
+						Usage: "参与者对其他参与者不可见",
+					},
+					&cli.BoolFlag{
+						Name:  "recorder",
+						Usage: "参与者为录制者",
+					},
+				},
+			},
+			{
+				Name:   "list-nodes",
+				Usage:  "列举集群中的节点，需要配置redis",
+				Action: listNodes,
+			},
+			{
+				Name:   "list-rooms",
+				Usage:  "通过RoomService列举房间",
+				Action: listRooms,
+				Flags: []cli.Flag{
+					urlFlag,
+					&cli.StringSliceFlag{
+						Name:  "names",
+						Usage: "只列举指定名称的房间，可以多次指定",
+					},
+				},
+			},
+			{
+				Name:   "remove-participant",
+				Usage:  "通过RoomService将参与者移出房间",
+				Action: removeParticipant,
+				Flags: []cli.Flag{
+					urlFlag,
+					&cli.StringFlag{
+						Name:     "room",
+						Usage:    "房间名称",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "identity",
+						Usage:    "参与者标识",
+						Required: true,
+					},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "配置相关的工具命令",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "schema",
+						Usage:  "输出配置文件的JSON Schema，用于编辑器补全和校验",
+						Action: printConfigSchema,
+					},
+				},
+			},
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// startServer 启动服务，收到退出信号后先下线节点再停止，再次收到信号时立即停止
+func startServer(c *cli.Context) error {
+	conf, err := getConfig(c)
+	if err != nil {
+		return err
+	}
+	if err = conf.ValidateKeys(); err != nil {
+		return err
+	}
+
+	currentNode, err := routing.NewLocalNode(conf)
+	if err != nil {
+		return err
+	}
+
+	var rc redis.UniversalClient
+	if conf.Redis.IsConfigured() {
+		if rc, err = redisTC.GetRedisClient(&conf.Redis); err != nil {
+			return err
+		}
+		defer func() {
+			_ = rc.Close()
+		}()
+	}
+	router := routing.CreateRouter(rc, currentNode)
+
+	server, err := service.NewTCServer(conf, router, currentNode, rc)
+	if err != nil {
+		return err
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	go func() {
+		for i := 0; i < 2; i++ {
+			sig := <-sigChan
+			force := i > 0
+			logger.Infow("exit requested, shutting down", "signal", sig, "force", force)
+			go server.Stop(force)
+		}
+	}()
+
+	return server.Start()
+}
+
+// getConfig 与服务启动使用相同的方式加载配置
+func getConfig(c *cli.Context) (*config.Config, error) {
+	confString, err := getConfigString(c.String("config"), c.String("config-body"))
+	if err != nil {
+		return nil, err
+	}
+
+	strictMode := true
+	if c.Bool("disable-strict-config") {
+		strictMode = false
+	}
+
+	conf, err := config.NewConfig(confString, strictMode, c, baseFlags)
+	if err != nil {
+		return nil, err
+	}
+	config.InitLoggerFromConfig(&conf.Logging)
+
+	return conf, nil
+}
+
+// getConfigString 优先使用配置内容，否则读取配置文件
+func getConfigString(configFile string, inConfigBody string) (string, error) {
+	if inConfigBody != "" || configFile == "" {
+		return inConfigBody, nil
+	}
+
+	outConfigBody, err := os.ReadFile(configFile)
+	if err != nil {
+		return "", err
+	}
+
+	return string(outConfigBody), nil
 }
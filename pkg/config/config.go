@@ -54,10 +54,11 @@ type Config struct {
 	Video          VideoConfig         `yaml:"video,omitempty"`
 	Room           RoomConfig          `yaml:"room,omitempty"`
 	TURN           TURNConfig          `yaml:"turn,omitempty"`
-	Ingress        IngressConifg       `yaml:"ingress,omitempty"`
+	Ingress        IngressConfig       `yaml:"ingress,omitempty"`
 	WebHook        WebHookConfig       `yaml:"webhook,omitempty"`
 	NodeSelector   NodeSelectorConfig  `yaml:"node_selector,omitempty"`
 	KeyFile        string              `yaml:"key_file,omitempty"`
+	KeyRotation    KeyRotationConfig   `yaml:"key_rotation,omitempty"`
 	Keys           map[string]string   `yaml:"keys,omitempty"`
 	Region         string              `yaml:"region,omitempty"`
 	SignalRelay    SignalRelayConfig   `yaml:"signal_relay,omitempty"`
@@ -67,6 +68,9 @@ type Config struct {
 	Limit    LimitConfig   `yaml:"limit,omitempty"`
 
 	Development bool `yaml:"development,omitempty"`
+
+	// inlineKeys 配置中直接写入的密钥，不包括密钥文件中的密钥
+	inlineKeys map[string]string
 }
 
 type RTCConfig struct {
@@ -146,7 +150,6 @@ type CongestionControlConfig struct {
 	AllowPause                    bool                                   `yaml:"allow_pause,omitempty"`
 	NackRatioAttenuator           float64                                `yaml:"nack_ratio_attenuator,omitempty"`
 	ExpectedUsageThreshold        float64                                `yaml:"expected_usage_threshold,omitempty"`
-	UseSendSideBWE                bool                                   `yaml:"send_side_bandwidth_estimation,omitempty"`
 	ProbeMode                     CongestionControlProbeMode             `yaml:"padding_mode,omitempty"`
 	MinChannelCapacity            int64                                  `yaml:"min_channel_capacity,omitempty"`
 	ProbeConfig                   CongestionControlProbeConfig           `yaml:"probe_config,omitempty"`
@@ -194,6 +197,7 @@ type StreamTrackersConfig struct {
 type PlayoutDelayConfig struct {
 	Enabled bool `yaml:"enabled,omitempty"`
 	Min     int  `yaml:"min,omitempty"`
+	Max     int  `yaml:"max,omitempty"`
 }
 
 type VideoConfig struct {
@@ -210,6 +214,7 @@ type RoomConfig struct {
 	EnabledRemoteUnmute bool               `yaml:"enabled_remote_unmute,omitempty"`
 	MaxMetadataSize     uint32             `yaml:"max_metadata_size,omitempty"`
 	PlayoutDelay        PlayoutDelayConfig `yaml:"playout_delay,omitempty"`
+	SyncStreams         bool               `yaml:"sync_streams,omitempty"`
 }
 
 type CodecSpec struct {
@@ -271,6 +276,14 @@ type LimitConfig struct {
 	SubscriptionLimitAudio int32   `yaml:"subscription_limit_audio,omitempty"`
 }
 
+// KeyRotationConfig 密钥文件轮换配置
+type KeyRotationConfig struct {
+	// 检查密钥文件是否变化的周期，0 表示不监听
+	WatchInterval time.Duration `yaml:"watch_interval,omitempty"`
+	// 被移除或替换的密钥继续用于校验已签发token的宽限期
+	GracePeriod time.Duration `yaml:"grace_period,omitempty"`
+}
+
 type IngressConfig struct {
 	RTMPBaseURL string `yaml:"rtmp_base_url"`
 	WHIPBaseURL string `yaml:"whip_base_url"`
@@ -473,6 +486,11 @@ var DefaultConfig = Config{
 		StreamBufferSize: 1000,
 	},
 	Keys: map[string]string{},
+	KeyRotation: KeyRotationConfig{
+		WatchInterval: 5 * time.Second,
+		// 与默认的token有效期一致
+		GracePeriod: 6 * time.Hour,
+	},
 }
 
 func NewConfig(confString string, strictMode bool, c *cli.Context, baseFlags []cli.Flag) (*Config, error) {
@@ -569,12 +587,7 @@ func (conf *Config) ToCLIFlagNames(existingFlags []cli.Flag) map[string]reflect.
 		for i := 0; i < currNode.TypeNode.NumField(); i++ {
 			// 检查 struct 字段中的 yaml 标记以获取路径
 			field := currNode.TypeNode.Type().Field(i)
-			yamlTagArray := strings.SplitN(field.Tag.Get("yaml"), ",", 2)
-			yamlTag := yamlTagArray[0]
-			isInline := false
-			if len(yamlTagArray) > 1 && yamlTagArray[1] == "inline" {
-				isInline = true
-			}
+			yamlTag, isInline := yamlFieldTag(field)
 			if (yamlTag == "" && (!isInline || currNode.TagPrefix == "")) || yamlTag == "-" {
 				continue
 			}
@@ -603,25 +616,35 @@ func (conf *Config) ToCLIFlagNames(existingFlags []cli.Flag) map[string]reflect.
 	return flagNames
 }
 
+// yamlFieldTag 解析 struct 字段的 yaml 标记，返回字段名以及是否内联
+func yamlFieldTag(field reflect.StructField) (name string, isInline bool) {
+	yamlTagArray := strings.SplitN(field.Tag.Get("yaml"), ",", 2)
+	name = yamlTagArray[0]
+	if len(yamlTagArray) > 1 && yamlTagArray[1] == "inline" {
+		isInline = true
+	}
+	return
+}
+
 func (conf *Config) ValidateKeys() error {
+	if conf.inlineKeys == nil {
+		conf.inlineKeys = make(map[string]string, len(conf.Keys))
+		for key, secret := range conf.Keys {
+			conf.inlineKeys[key] = secret
+		}
+	}
+
 	// 如果设置则首选keyfile文件
 	if conf.KeyFile != "" {
-		var otherFilter os.FileMode = 0007
-		if st, err := os.Stat(conf.KeyFile); err != nil {
-			return err
-		} else if st.Mode().Perm()&otherFilter != 0000 {
-			return ErrKeyFileIncorrectPermission
-		}
-		f, err := os.Open(conf.KeyFile)
+		keys, err := ReadKeyFile(conf.KeyFile)
 		if err != nil {
 			return err
 		}
-		defer func() {
-			_ = f.Close()
-		}()
-		decoder := yaml.NewDecoder(f)
-		if err = decoder.Decode(conf.Keys); err != nil {
-			return err
+		if conf.Keys == nil {
+			conf.Keys = make(map[string]string, len(keys))
+		}
+		for key, secret := range keys {
+			conf.Keys[key] = secret
 		}
 	}
 
@@ -639,6 +662,39 @@ func (conf *Config) ValidateKeys() error {
 	return nil
 }
 
+// InlineKeys 配置中直接写入的密钥，需要在 ValidateKeys 之后调用
+func (conf *Config) InlineKeys() map[string]string {
+	keys := make(map[string]string, len(conf.inlineKeys))
+	for key, secret := range conf.inlineKeys {
+		keys[key] = secret
+	}
+	return keys
+}
+
+// ReadKeyFile 读取密钥文件，文件对其他用户的权限必须为0
+func ReadKeyFile(keyFile string) (map[string]string, error) {
+	var otherFilter os.FileMode = 0007
+	if st, err := os.Stat(keyFile); err != nil {
+		return nil, err
+	} else if st.Mode().Perm()&otherFilter != 0000 {
+		return nil, ErrKeyFileIncorrectPermission
+	}
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	keys := make(map[string]string)
+	decoder := yaml.NewDecoder(f)
+	if err = decoder.Decode(keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
 func GenerateCLIFlags(existingFlags []cli.Flag, hidden bool) ([]cli.Flag, error) {
 	blankConfig := &Config{}
 	flags := make([]cli.Flag, 0)
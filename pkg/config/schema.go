@@ -0,0 +1,240 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+	// durationPattern 与 time.ParseDuration 接受的格式一致
+	durationPattern = `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	// intKeyPattern map 中整数类型 key 的格式
+	intKeyPattern = `^-?[0-9]+$`
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	// schemaEnumsByType 按类型定义的枚举值
+	schemaEnumsByType = map[reflect.Type][]interface{}{
+		reflect.TypeOf(CongestionControlProbeMode("")): {
+			CongestionControlProbeModePadding,
+			CongestionControlProbeModeMedia,
+		},
+		reflect.TypeOf(StreamTrackerType("")): {
+			StreamTrackerTypePacket,
+			StreamTrackerTypeFrame,
+		},
+	}
+
+	// schemaEnumsByPath 按 yaml 路径定义的枚举值，用于普通 string 类型的字段
+	schemaEnumsByPath = map[string][]interface{}{
+		"node_selector.kind":        {"any", "cpuload", "sysload", "regionaware"},
+		"node_selector.sort_by":     {"random", "sysload", "cpuload", "rooms", "clients", "tracks", "bytespersec"},
+		"rtc.turn_servers.protocol": {"udp", "tcp", "tls"},
+	}
+)
+
+// JSONSchema 配置文件的 JSON Schema 描述，只包含编辑器补全和校验需要的部分关键字
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	PropertyNames        *JSONSchema            `json:"propertyNames,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+}
+
+// GenerateJSONSchema 按 yaml 标记遍历 Config，生成配置文件的 JSON Schema，
+// 默认值取自 DefaultConfig
+func GenerateJSONSchema() (*JSONSchema, error) {
+	schema, err := schemaForType(reflect.TypeOf(DefaultConfig), reflect.ValueOf(DefaultConfig), "")
+	if err != nil {
+		return nil, err
+	}
+	schema.Schema = jsonSchemaDraft
+	schema.Title = "tc-server config"
+	return schema, nil
+}
+
+// schemaForType 生成类型 t 的 schema，defaultValue 为对应的默认值，可能无效
+func schemaForType(t reflect.Type, defaultValue reflect.Value, path string) (*JSONSchema, error) {
+	if t == durationType {
+		schema := &JSONSchema{
+			Type:    "string",
+			Format:  "duration",
+			Pattern: durationPattern,
+		}
+		schema.Default = schemaDefault(defaultValue)
+		return schema, nil
+	}
+
+	if t.Kind() == reflect.Ptr {
+		if defaultValue.IsValid() && !defaultValue.IsNil() {
+			defaultValue = defaultValue.Elem()
+		} else {
+			defaultValue = reflect.Value{}
+		}
+		return schemaForType(t.Elem(), defaultValue, path)
+	}
+
+	schema := &JSONSchema{}
+	switch t.Kind() {
+	case reflect.Struct:
+		schema.Type = "object"
+		schema.Properties = map[string]*JSONSchema{}
+		// 严格模式下未知字段会被拒绝
+		schema.AdditionalProperties = false
+		if err := addStructProperties(schema, t, defaultValue, path); err != nil {
+			return nil, err
+		}
+		return schema, nil
+	case reflect.Slice:
+		var err error
+		schema.Type = "array"
+		schema.Items, err = schemaForType(t.Elem(), reflect.Value{}, path)
+		if err != nil {
+			return nil, err
+		}
+	case reflect.Map:
+		itemSchema, err := schemaForType(t.Elem(), reflect.Value{}, path)
+		if err != nil {
+			return nil, err
+		}
+		schema.Type = "object"
+		schema.AdditionalProperties = itemSchema
+		switch t.Key().Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			schema.PropertyNames = &JSONSchema{Pattern: intKeyPattern}
+		}
+	case reflect.Bool:
+		schema.Type = "boolean"
+	case reflect.String:
+		schema.Type = "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema.Type = "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		minimum := float64(0)
+		schema.Type = "integer"
+		schema.Minimum = &minimum
+	case reflect.Float32, reflect.Float64:
+		schema.Type = "number"
+	default:
+		return nil, fmt.Errorf("json schema generation unsupported for config type: %s is a %s", path, t.Kind().String())
+	}
+
+	if enum, ok := schemaEnumsByType[t]; ok {
+		schema.Enum = enum
+	} else if enum, ok := schemaEnumsByPath[path]; ok {
+		schema.Enum = enum
+	}
+	schema.Default = schemaDefault(defaultValue)
+	return schema, nil
+}
+
+// addStructProperties 将结构体字段加入 schema，内联字段直接展开到当前层级
+func addStructProperties(schema *JSONSchema, t reflect.Type, defaultValue reflect.Value, path string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		yamlTag, isInline := yamlFieldTag(field)
+		if (yamlTag == "" && !isInline) || yamlTag == "-" {
+			continue
+		}
+
+		var fieldDefault reflect.Value
+		if defaultValue.IsValid() {
+			fieldDefault = defaultValue.Field(i)
+		}
+
+		if isInline {
+			if err := addStructProperties(schema, field.Type, fieldDefault, path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fieldPath := yamlTag
+		if path != "" {
+			fieldPath = fmt.Sprintf("%s.%s", path, yamlTag)
+		}
+		fieldSchema, err := schemaForType(field.Type, fieldDefault, fieldPath)
+		if err != nil {
+			return err
+		}
+		schema.Properties[yamlTag] = fieldSchema
+	}
+	return nil
+}
+
+// schemaDefault 将默认值转换为可以 JSON 序列化的形式，零值不输出
+func schemaDefault(v reflect.Value) interface{} {
+	if !v.IsValid() || v.IsZero() {
+		return nil
+	}
+
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		return schemaDefault(v.Elem())
+	case reflect.Struct:
+		out := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			yamlTag, _ := yamlFieldTag(field)
+			if yamlTag == "" || yamlTag == "-" {
+				continue
+			}
+			if d := schemaDefault(v.Field(i)); d != nil {
+				out[yamlTag] = d
+			}
+		}
+		return out
+	case reflect.Slice:
+		out := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out = append(out, schemaDefault(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = schemaDefault(iter.Value())
+		}
+		return out
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	case reflect.Float32:
+		// 避免 float32 转换为 float64 后出现多余的精度
+		return json.Number(strconv.FormatFloat(v.Float(), 'f', -1, 32))
+	case reflect.Float64:
+		return v.Float()
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateJSONSchema(t *testing.T) {
+	schema, err := GenerateJSONSchema()
+	require.NoError(t, err)
+	require.Equal(t, "object", schema.Type)
+	require.Equal(t, false, schema.AdditionalProperties)
+
+	t.Run("defaults from DefaultConfig", func(t *testing.T) {
+		require.Equal(t, uint64(7880), schema.Properties["port"].Default)
+
+		cc := schema.Properties["rtc"].Properties["congestion_control"]
+		require.Equal(t, "3s", cc.Properties["probe_config"].Properties["base_interval"].Default)
+		require.Equal(t, "duration", cc.Properties["probe_config"].Properties["base_interval"].Format)
+		require.Equal(t, json.Number("0.9"), schema.Properties["node_selector"].Properties["cpu_load_limit"].Default)
+	})
+
+	t.Run("enums", func(t *testing.T) {
+		cc := schema.Properties["rtc"].Properties["congestion_control"]
+		require.ElementsMatch(t, []interface{}{CongestionControlProbeModePadding, CongestionControlProbeModeMedia}, cc.Properties["padding_mode"].Enum)
+
+		tracker := schema.Properties["video"].Properties["stream_tracker"].Properties["video"]
+		require.ElementsMatch(t, []interface{}{StreamTrackerTypePacket, StreamTrackerTypeFrame}, tracker.Properties["stream_tracker_type"].Enum)
+		require.Equal(t, intKeyPattern, tracker.Properties["packet_tracker"].PropertyNames.Pattern)
+
+		require.Contains(t, schema.Properties["node_selector"].Properties["kind"].Enum, "regionaware")
+		require.Contains(t, schema.Properties["rtc"].Properties["turn_servers"].Items.Properties["protocol"].Enum, "tls")
+	})
+
+	t.Run("yaml tags", func(t *testing.T) {
+		// 内联字段展开到父级
+		logging := schema.Properties["logging"]
+		require.Contains(t, logging.Properties, "level")
+		require.Contains(t, logging.Properties, "pion_level")
+
+		// yaml:"-" 的字段不输出
+		_, ok := schema.Properties["rtc"].Properties["udp_port"]
+		require.False(t, ok)
+	})
+
+	t.Run("serializable", func(t *testing.T) {
+		_, err := json.Marshal(schema)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,11 @@
+package routing
+
+import "errors"
+
+var (
+	ErrNotFound          = errors.New("could not find object")
+	ErrNodeNotFound      = errors.New("could not locate the node")
+	ErrHandlerNotDefined = errors.New("handler not defined")
+	ErrChannelClosed     = errors.New("channel closed")
+	ErrChannelFull       = errors.New("channel is full")
+)
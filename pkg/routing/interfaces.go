@@ -3,6 +3,7 @@ package routing
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"google.golang.org/protobuf/proto"
@@ -51,7 +52,7 @@ type ParticipantInit struct {
 	// Client 客户端信息
 	Client *tc.ClientInfo
 	// Grants 授权
-	Grants *auth.ClaimsGrants
+	Grants *auth.ClaimGrants
 	// Region 地区
 	Region string
 	// AdaptiveStream 自适应流
@@ -60,6 +61,17 @@ type ParticipantInit struct {
 	ID tc.ParticipantID
 	// SubscriberAllowPause 订阅者是否允许暂停
 	SubscriberAllowPause *bool
+	// APIKey 签发参与者 token 的 API key
+	APIKey string
+	// TokenExpiry 参与者 token 的过期时间
+	TokenExpiry time.Time
+}
+
+// sessionGrants StartSession 没有 token 信息的字段，和授权一起编码在 GrantsJson 中转发给 RTC 节点
+type sessionGrants struct {
+	*auth.ClaimGrants
+	APIKey      string `json:"apiKey,omitempty"`
+	TokenExpiry int64  `json:"tokenExpiry,omitempty"`
 }
 
 // NewParticipantCallback 新参与者回调
@@ -79,11 +91,19 @@ type RTCMessageCallback func(
 	msg *tc.RTCNodeMessage,
 )
 
+// NodeRequestHandler 处理其他节点发到当前节点的请求，返回值作为响应发回请求方
+type NodeRequestHandler func(
+	ctx context.Context,
+	roomName tc.RoomName,
+	payload []byte,
+) ([]byte, error)
+
 // Router 允许多个节点协调参与者会话
 //
 //counterfeiter:generate . Router
 type Router interface {
 	MessageSource
+	MessageRouter
 
 	// RegisterNode 注册节点
 	RegisterNode() error
@@ -107,7 +127,9 @@ type Router interface {
 
 	// Start 开始
 	Start() error
-	// Drain 取出
+	// IsStarted 是否已经启动
+	IsStarted() bool
+	// Drain 取出，当前节点状态会被设置为 SHUTTING_DOWN
 	Drain()
 	// Stop 停止
 	Stop()
@@ -117,6 +139,8 @@ type Router interface {
 
 	// OnRTCMessage 执行RTC node的操作时被调用
 	OnRTCMessage(callback RTCMessageCallback)
+	// OnNodeRequest 设置当前节点处理指定请求的回调
+	OnNodeRequest(method string, handler NodeRequestHandler)
 }
 
 // MessageRouter 消息路由
@@ -126,14 +150,24 @@ type MessageRouter interface {
 	// WriteParticipantRTC 向参与者或房间写入消息
 	WriteParticipantRTC(ctx context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity, msg *tc.RTCNodeMessage) error
 	WriteRoomRTC(ctx context.Context, roomName tc.RoomName, msg *tc.RTCNodeMessage) error
+
+	// RequestNode 向指定节点发送请求并等待响应
+	RequestNode(ctx context.Context, nodeID tc.NodeID, roomName tc.RoomName, method string, payload []byte) ([]byte, error)
+	// RequestRoomNode 向房间所在的节点发送请求并等待响应
+	RequestRoomNode(ctx context.Context, roomName tc.RoomName, method string, payload []byte) ([]byte, error)
+
+	// SetMigrationSource 记录参与者从当前节点开始迁移，迁移的目标节点据此取回会话状态
+	SetMigrationSource(ctx context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity) error
+	// TakeMigrationSource 取出参与者迁移前所在的节点，没有记录时返回 ErrNotFound
+	TakeMigrationSource(ctx context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity) (tc.NodeID, error)
 }
 
-// CreateRouter 创建路由
-func CreateRouter(config *config.Config, rc redis.UniversalClient, node LocalNode, signalClient SignalClient) Router {
-	lr := NewLocalRouter(node, signalClient)
+// CreateRouter 创建路由，配置了redis时使用多节点路由
+func CreateRouter(rc redis.UniversalClient, node *LocalNode) Router {
+	lr := NewLocalRouter(node)
 
 	if rc != nil {
-		return NewRedisRouter(config, lr, rc)
+		return NewRedisRouter(lr, rc)
 	}
 
 	// 本地路由和存储
@@ -143,7 +177,11 @@ func CreateRouter(config *config.Config, rc redis.UniversalClient, node LocalNod
 
 // ToStartSession 开启会话
 func (pi *ParticipantInit) ToStartSession(roomName tc.RoomName, connectID tc.ConnectionID) (*tc.StartSession, error) {
-	claims, err := json.Marshal(pi.Grants)
+	grants := sessionGrants{ClaimGrants: pi.Grants, APIKey: pi.APIKey}
+	if !pi.TokenExpiry.IsZero() {
+		grants.TokenExpiry = pi.TokenExpiry.Unix()
+	}
+	claims, err := json.Marshal(grants)
 	if err != nil {
 		return nil, err
 	}
@@ -173,8 +211,8 @@ func (pi *ParticipantInit) ToStartSession(roomName tc.RoomName, connectID tc.Con
 
 // ParticipantInitFromStartSession 从会话session初始化参与者Init
 func ParticipantInitFromStartSession(ss *tc.StartSession, region string) (*ParticipantInit, error) {
-	claims := &auth.ClaimGrants{}
-	if err := json.Unmarshal([]byte(ss.GrantsJson), claims); err != nil {
+	grants := &sessionGrants{ClaimGrants: &auth.ClaimGrants{}}
+	if err := json.Unmarshal([]byte(ss.GrantsJson), grants); err != nil {
 		return nil, err
 	}
 
@@ -185,12 +223,16 @@ func ParticipantInitFromStartSession(ss *tc.StartSession, region string) (*Parti
 		ReconnectReason: ss.ReconnectReason,
 		Client:          ss.Client,
 		AutoSubscribe:   ss.AutoSubscribe,
-		Grants:          claims,
+		Grants:          grants.ClaimGrants,
 		Region:          region,
 		AdaptiveStream:  ss.AdaptiveStream,
 		ID:              tc.ParticipantID(ss.ParticipantId),
 	}
 	pi.SubscriberAllowPause = &ss.SubscriberAllowPause
+	pi.APIKey = grants.APIKey
+	if grants.TokenExpiry != 0 {
+		pi.TokenExpiry = time.Unix(grants.TokenExpiry, 0)
+	}
 
 	return pi, nil
 }
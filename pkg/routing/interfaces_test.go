@@ -0,0 +1,46 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+func TestParticipantInitStartSession(t *testing.T) {
+	pi := &ParticipantInit{
+		Identity:      "p1",
+		AutoSubscribe: true,
+		Client:        &tc.ClientInfo{Protocol: 9},
+		Grants: &auth.ClaimGrants{
+			Name:  "name",
+			Video: &auth.VideoGrant{RoomJoin: true, Room: "room"},
+		},
+		APIKey:      "APIkey",
+		TokenExpiry: time.Unix(time.Now().Add(time.Hour).Unix(), 0),
+	}
+	ss, err := pi.ToStartSession("room", "CO_test")
+	require.NoError(t, err)
+
+	// token 信息随授权一起转发给 RTC 节点
+	decoded, err := ParticipantInitFromStartSession(ss, "region")
+	require.NoError(t, err)
+	require.Equal(t, pi.Identity, decoded.Identity)
+	require.Equal(t, "name", decoded.Grants.Name)
+	require.True(t, decoded.Grants.Video.RoomJoin)
+	require.Equal(t, "APIkey", decoded.APIKey)
+	require.True(t, pi.TokenExpiry.Equal(decoded.TokenExpiry))
+
+	// 没有 token 信息的会话仍然可以解析
+	pi.APIKey = ""
+	pi.TokenExpiry = time.Time{}
+	ss, err = pi.ToStartSession("room", "CO_test")
+	require.NoError(t, err)
+	decoded, err = ParticipantInitFromStartSession(ss, "region")
+	require.NoError(t, err)
+	require.Empty(t, decoded.APIKey)
+	require.True(t, decoded.TokenExpiry.IsZero())
+}
@@ -0,0 +1,99 @@
+package routing
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+	"github.com/liuhailove/tc-server/pkg/config"
+)
+
+var ErrIPNotSet = errors.New("ip address is required")
+
+// LocalNode 当前节点，状态和统计会被路由、统计协程和健康检查并发访问，只能通过方法读写
+type LocalNode struct {
+	lock sync.RWMutex
+	node *tc.Node
+}
+
+// NewLocalNode 根据配置创建当前节点
+func NewLocalNode(conf *config.Config) (*LocalNode, error) {
+	nodeID := utils.NewGuid(utils.NodePrefix)
+	if conf.RTC.NodeIP == "" {
+		return nil, ErrIPNotSet
+	}
+	return NewLocalNodeFromProto(&tc.Node{
+		Id:      nodeID,
+		Ip:      conf.RTC.NodeIP,
+		NumCpus: uint32(runtime.NumCPU()),
+		Region:  conf.Region,
+		State:   tc.NodeState_SERVING,
+		Stats: &tc.NodeStats{
+			StartedAt: time.Now().Unix(),
+			UpdatedAt: time.Now().Unix(),
+		},
+	}), nil
+}
+
+// NewLocalNodeFromProto 使用已有的节点信息创建当前节点
+func NewLocalNodeFromProto(node *tc.Node) *LocalNode {
+	if node.Stats == nil {
+		node.Stats = &tc.NodeStats{}
+	}
+	return &LocalNode{node: node}
+}
+
+// NodeID 节点ID，创建之后不会变化
+func (n *LocalNode) NodeID() tc.NodeID {
+	return tc.NodeID(n.node.Id)
+}
+
+// NodeIP 节点IP，创建之后不会变化
+func (n *LocalNode) NodeIP() string {
+	return n.node.Ip
+}
+
+// Region 节点所在地区，创建之后不会变化
+func (n *LocalNode) Region() string {
+	return n.node.Region
+}
+
+// State 节点状态
+func (n *LocalNode) State() tc.NodeState {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.node.State
+}
+
+// SetState 设置节点状态
+func (n *LocalNode) SetState(state tc.NodeState) {
+	n.lock.Lock()
+	n.node.State = state
+	n.lock.Unlock()
+}
+
+// Stats 节点统计的副本
+func (n *LocalNode) Stats() *tc.NodeStats {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return proto.Clone(n.node.Stats).(*tc.NodeStats)
+}
+
+// SetStats 更新节点统计
+func (n *LocalNode) SetStats(stats *tc.NodeStats) {
+	n.lock.Lock()
+	n.node.Stats = proto.Clone(stats).(*tc.NodeStats)
+	n.lock.Unlock()
+}
+
+// Clone 节点信息的副本，用于序列化和对外返回
+func (n *LocalNode) Clone() *tc.Node {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return proto.Clone(n.node).(*tc.Node)
+}
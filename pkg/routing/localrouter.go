@@ -0,0 +1,273 @@
+package routing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+)
+
+// connectionPrefix 信令连接ID前缀
+const connectionPrefix = "CO_"
+
+var _ Router = (*LocalRouter)(nil)
+var _ MessageRouter = (*LocalRouter)(nil)
+
+// LocalRouter 单节点路由，房间和信令都在当前节点上处理
+type LocalRouter struct {
+	currentNode *LocalNode
+
+	lock sync.RWMutex
+	// 节点上的回调
+	onNewParticipant NewParticipantCallback
+	onRTCMessage     RTCMessageCallback
+
+	// nodeRequestHandlers 当前节点处理的请求，key 为请求方法
+	nodeRequestHandlers map[string]NodeRequestHandler
+	// migrationSources 从当前节点开始迁移的参与者
+	migrationSources map[migrationKey]struct{}
+
+	// rtcMessageChan 发往当前节点的RTC消息，按写入顺序分发给 onRTCMessage
+	rtcMessageChan *MessageChannel
+	isStarted      atomic.Bool
+}
+
+type migrationKey struct {
+	roomName tc.RoomName
+	identity tc.ParticipantIdentity
+}
+
+// NewLocalRouter 创建单节点路由
+func NewLocalRouter(currentNode *LocalNode) *LocalRouter {
+	return &LocalRouter{
+		currentNode:         currentNode,
+		nodeRequestHandlers: make(map[string]NodeRequestHandler),
+		migrationSources:    make(map[migrationKey]struct{}),
+		rtcMessageChan:      NewMessageChannel("", DefaultMessageChannelSize),
+	}
+}
+
+// GetNodeForRoom 单节点模式下所有房间都在当前节点上
+func (r *LocalRouter) GetNodeForRoom(_ context.Context, _ tc.RoomName) (*tc.Node, error) {
+	return r.currentNode.Clone(), nil
+}
+
+// SetNodeForRoom 单节点模式下无需记录
+func (r *LocalRouter) SetNodeForRoom(_ context.Context, _ tc.RoomName, _ tc.NodeID) error {
+	return nil
+}
+
+// ClearRoomState 单节点模式下无需清除
+func (r *LocalRouter) ClearRoomState(_ context.Context, _ tc.RoomName) error {
+	return nil
+}
+
+// RegisterNode 单节点模式下无需注册
+func (r *LocalRouter) RegisterNode() error {
+	return nil
+}
+
+// UnregisterNode 单节点模式下无需解除注册
+func (r *LocalRouter) UnregisterNode() error {
+	return nil
+}
+
+// RemoveDeadNodes 单节点模式下没有其他节点
+func (r *LocalRouter) RemoveDeadNodes() error {
+	return nil
+}
+
+// ListNodes 只返回当前节点
+func (r *LocalRouter) ListNodes() ([]*tc.Node, error) {
+	return []*tc.Node{r.currentNode.Clone()}, nil
+}
+
+// StartParticipantSignal 在当前节点上启动参与者的信令连接
+func (r *LocalRouter) StartParticipantSignal(ctx context.Context, roomName tc.RoomName, pi ParticipantInit) (connectionID tc.ConnectionID, reqSink MessageSink, resSource MessageSource, err error) {
+	connectionID = tc.ConnectionID(utils.NewGuid(connectionPrefix))
+	reqChan, resChan, err := r.startLocalSession(ctx, roomName, pi, connectionID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return connectionID, reqChan, resChan, nil
+}
+
+// startLocalSession 创建进程内的请求和响应通道并通知节点有新的参与者
+func (r *LocalRouter) startLocalSession(ctx context.Context, roomName tc.RoomName, pi ParticipantInit, connectionID tc.ConnectionID) (*MessageChannel, *MessageChannel, error) {
+	r.lock.RLock()
+	onNewParticipant := r.onNewParticipant
+	r.lock.RUnlock()
+	if onNewParticipant == nil {
+		return nil, nil, ErrHandlerNotDefined
+	}
+
+	reqChan := NewMessageChannel(connectionID, DefaultMessageChannelSize)
+	resChan := NewMessageChannel(connectionID, DefaultMessageChannelSize)
+	if err := onNewParticipant(ctx, roomName, pi, reqChan, resChan); err != nil {
+		reqChan.Close()
+		resChan.Close()
+		return nil, nil, err
+	}
+	return reqChan, resChan, nil
+}
+
+// WriteParticipantRTC 向当前节点上的参与者写入消息
+func (r *LocalRouter) WriteParticipantRTC(_ context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity, msg *tc.RTCNodeMessage) error {
+	return r.writeRTCMessage(roomName, identity, msg)
+}
+
+// WriteRoomRTC 向当前节点上的房间写入消息
+func (r *LocalRouter) WriteRoomRTC(_ context.Context, roomName tc.RoomName, msg *tc.RTCNodeMessage) error {
+	return r.writeRTCMessage(roomName, "", msg)
+}
+
+func (r *LocalRouter) writeRTCMessage(roomName tc.RoomName, identity tc.ParticipantIdentity, msg *tc.RTCNodeMessage) error {
+	msg.RoomName = string(roomName)
+	msg.Identity = string(identity)
+	if msg.SenderTime == 0 {
+		msg.SenderTime = time.Now().Unix()
+	}
+	return r.rtcMessageChan.WriteMessage(msg)
+}
+
+// RequestNode 单节点模式下只能请求当前节点
+func (r *LocalRouter) RequestNode(ctx context.Context, nodeID tc.NodeID, roomName tc.RoomName, method string, payload []byte) ([]byte, error) {
+	if nodeID != r.currentNode.NodeID() {
+		return nil, ErrNodeNotFound
+	}
+	return r.handleNodeRequest(ctx, roomName, method, payload)
+}
+
+// RequestRoomNode 由当前节点处理请求
+func (r *LocalRouter) RequestRoomNode(ctx context.Context, roomName tc.RoomName, method string, payload []byte) ([]byte, error) {
+	return r.handleNodeRequest(ctx, roomName, method, payload)
+}
+
+func (r *LocalRouter) handleNodeRequest(ctx context.Context, roomName tc.RoomName, method string, payload []byte) ([]byte, error) {
+	r.lock.RLock()
+	handler := r.nodeRequestHandlers[method]
+	r.lock.RUnlock()
+	if handler == nil {
+		return nil, ErrHandlerNotDefined
+	}
+	return handler(ctx, roomName, payload)
+}
+
+// SetMigrationSource 记录参与者从当前节点开始迁移
+func (r *LocalRouter) SetMigrationSource(_ context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity) error {
+	r.lock.Lock()
+	r.migrationSources[migrationKey{roomName: roomName, identity: identity}] = struct{}{}
+	r.lock.Unlock()
+	return nil
+}
+
+// TakeMigrationSource 单节点模式下迁移前后都在当前节点
+func (r *LocalRouter) TakeMigrationSource(_ context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity) (tc.NodeID, error) {
+	key := migrationKey{roomName: roomName, identity: identity}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.migrationSources[key]; !ok {
+		return "", ErrNotFound
+	}
+	delete(r.migrationSources, key)
+	return r.currentNode.NodeID(), nil
+}
+
+// OnNewParticipantRTC 设置新参与者的回调
+func (r *LocalRouter) OnNewParticipantRTC(callback NewParticipantCallback) {
+	r.lock.Lock()
+	r.onNewParticipant = callback
+	r.lock.Unlock()
+}
+
+// OnRTCMessage 设置RTC消息的回调
+func (r *LocalRouter) OnRTCMessage(callback RTCMessageCallback) {
+	r.lock.Lock()
+	r.onRTCMessage = callback
+	r.lock.Unlock()
+}
+
+// OnNodeRequest 设置当前节点处理指定请求的回调
+func (r *LocalRouter) OnNodeRequest(method string, handler NodeRequestHandler) {
+	r.lock.Lock()
+	if handler == nil {
+		delete(r.nodeRequestHandlers, method)
+	} else {
+		r.nodeRequestHandlers[method] = handler
+	}
+	r.lock.Unlock()
+}
+
+// GetRegion 当前节点所在地区
+func (r *LocalRouter) GetRegion() string {
+	return r.currentNode.Region()
+}
+
+// Start 启动RTC消息的分发
+func (r *LocalRouter) Start() error {
+	if r.isStarted.Swap(true) {
+		return nil
+	}
+	go r.rtcMessageWorker()
+	return nil
+}
+
+// IsStarted 是否已经启动
+func (r *LocalRouter) IsStarted() bool {
+	return r.isStarted.Load()
+}
+
+// Drain 将当前节点标记为 SHUTTING_DOWN，不再接收新的房间
+func (r *LocalRouter) Drain() {
+	r.currentNode.SetState(tc.NodeState_SHUTTING_DOWN)
+}
+
+// Stop 停止RTC消息的分发
+func (r *LocalRouter) Stop() {
+	r.rtcMessageChan.Close()
+}
+
+// ReadChan 发往当前节点的RTC消息，启动后由路由自身消费
+func (r *LocalRouter) ReadChan() <-chan proto.Message {
+	return r.rtcMessageChan.ReadChan()
+}
+
+// IsClosed 路由是否已经停止
+func (r *LocalRouter) IsClosed() bool {
+	return r.rtcMessageChan.IsClosed()
+}
+
+// Close 同 Stop
+func (r *LocalRouter) Close() {
+	r.Stop()
+}
+
+// ConnectionID 路由没有连接ID
+func (r *LocalRouter) ConnectionID() tc.ConnectionID {
+	return ""
+}
+
+func (r *LocalRouter) rtcMessageWorker() {
+	for msg := range r.rtcMessageChan.ReadChan() {
+		rtcMsg, ok := msg.(*tc.RTCNodeMessage)
+		if !ok {
+			continue
+		}
+
+		r.lock.RLock()
+		onRTCMessage := r.onRTCMessage
+		r.lock.RUnlock()
+		if onRTCMessage == nil {
+			logger.Warnw("no handler for rtc message", ErrHandlerNotDefined, "room", rtcMsg.RoomName)
+			continue
+		}
+		onRTCMessage(context.Background(), tc.RoomName(rtcMsg.RoomName), tc.ParticipantIdentity(rtcMsg.Identity), rtcMsg)
+	}
+}
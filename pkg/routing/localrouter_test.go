@@ -0,0 +1,147 @@
+package routing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+func newTestLocalRouter() *LocalRouter {
+	return NewLocalRouter(NewLocalNodeFromProto(&tc.Node{
+		Id:    "ND_test",
+		Ip:    "127.0.0.1",
+		State: tc.NodeState_SERVING,
+		Stats: &tc.NodeStats{},
+	}))
+}
+
+func TestLocalRouterRTCMessageOrder(t *testing.T) {
+	r := newTestLocalRouter()
+	received := make(chan *tc.RTCNodeMessage, 10)
+	r.OnRTCMessage(func(_ context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity, msg *tc.RTCNodeMessage) {
+		require.Equal(t, tc.RoomName("room"), roomName)
+		require.Equal(t, string(identity), msg.Identity)
+		received <- msg
+	})
+	require.NoError(t, r.Start())
+	defer r.Stop()
+
+	for _, metadata := range []string{"a", "b", "c"} {
+		require.NoError(t, r.WriteRoomRTC(context.Background(), "room", &tc.RTCNodeMessage{
+			Message: &tc.RTCNodeMessage_UpdateRoomMetadata{
+				UpdateRoomMetadata: &tc.UpdateRoomMetadataRequest{Room: "room", Metadata: metadata},
+			},
+		}))
+	}
+	require.NoError(t, r.WriteParticipantRTC(context.Background(), "room", "p1", &tc.RTCNodeMessage{
+		Message: &tc.RTCNodeMessage_KeepAlive{KeepAlive: &tc.KeepAlive{}},
+	}))
+
+	for _, metadata := range []string{"a", "b", "c"} {
+		select {
+		case msg := <-received:
+			require.Equal(t, metadata, msg.GetUpdateRoomMetadata().Metadata)
+			require.NotZero(t, msg.SenderTime)
+		case <-time.After(time.Second):
+			t.Fatal("rtc message not delivered")
+		}
+	}
+	select {
+	case msg := <-received:
+		require.Equal(t, "p1", msg.Identity)
+	case <-time.After(time.Second):
+		t.Fatal("rtc message not delivered")
+	}
+}
+
+func TestLocalRouterStartParticipantSignal(t *testing.T) {
+	r := newTestLocalRouter()
+	_, _, _, err := r.StartParticipantSignal(context.Background(), "room", ParticipantInit{Identity: "p1"})
+	require.ErrorIs(t, err, ErrHandlerNotDefined)
+
+	var source MessageSource
+	var sink MessageSink
+	r.OnNewParticipantRTC(func(_ context.Context, roomName tc.RoomName, pi ParticipantInit, requestSource MessageSource, responseSink MessageSink) error {
+		require.Equal(t, tc.RoomName("room"), roomName)
+		require.Equal(t, tc.ParticipantIdentity("p1"), pi.Identity)
+		source = requestSource
+		sink = responseSink
+		return nil
+	})
+	connectionID, reqSink, resSource, err := r.StartParticipantSignal(context.Background(), "room", ParticipantInit{Identity: "p1"})
+	require.NoError(t, err)
+	require.Equal(t, connectionID, source.ConnectionID())
+
+	// 信令写入的请求由房间节点读取，房间节点写入的响应由信令读取
+	req := &tc.SignalRequest{Message: &tc.SignalRequest_Leave{Leave: &tc.LeaveRequest{}}}
+	require.NoError(t, reqSink.WriteMessage(req))
+	require.Equal(t, req, <-source.ReadChan())
+
+	res := &tc.SignalResponse{Message: &tc.SignalResponse_Leave{Leave: &tc.LeaveRequest{}}}
+	require.NoError(t, sink.WriteMessage(res))
+	require.Equal(t, res, <-resSource.ReadChan())
+
+	reqSink.Close()
+	require.True(t, source.IsClosed())
+	require.ErrorIs(t, reqSink.WriteMessage(req), ErrChannelClosed)
+}
+
+func TestLocalRouterListNodes(t *testing.T) {
+	r := newTestLocalRouter()
+	nodes, err := r.ListNodes()
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, "ND_test", nodes[0].Id)
+
+	r.Drain()
+	node, err := r.GetNodeForRoom(context.Background(), "room")
+	require.NoError(t, err)
+	require.Equal(t, tc.NodeState_SHUTTING_DOWN, node.State)
+	// 之前返回的是副本，不受影响
+	require.Equal(t, tc.NodeState_SERVING, nodes[0].State)
+}
+
+func TestLocalRouterNodeRequest(t *testing.T) {
+	r := newTestLocalRouter()
+	ctx := context.Background()
+
+	_, err := r.RequestRoomNode(ctx, "room", "echo", []byte("hello"))
+	require.ErrorIs(t, err, ErrHandlerNotDefined)
+
+	r.OnNodeRequest("echo", func(_ context.Context, roomName tc.RoomName, payload []byte) ([]byte, error) {
+		return append([]byte(roomName+":"), payload...), nil
+	})
+	res, err := r.RequestRoomNode(ctx, "room", "echo", []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "room:hello", string(res))
+
+	res, err = r.RequestNode(ctx, "ND_test", "room", "echo", []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "room:hello", string(res))
+	_, err = r.RequestNode(ctx, "ND_other", "room", "echo", nil)
+	require.ErrorIs(t, err, ErrNodeNotFound)
+
+	r.OnNodeRequest("echo", nil)
+	_, err = r.RequestRoomNode(ctx, "room", "echo", nil)
+	require.ErrorIs(t, err, ErrHandlerNotDefined)
+}
+
+func TestLocalRouterMigrationSource(t *testing.T) {
+	r := newTestLocalRouter()
+	ctx := context.Background()
+
+	_, err := r.TakeMigrationSource(ctx, "room", "p1")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, r.SetMigrationSource(ctx, "room", "p1"))
+	nodeID, err := r.TakeMigrationSource(ctx, "room", "p1")
+	require.NoError(t, err)
+	require.Equal(t, tc.NodeID("ND_test"), nodeID)
+
+	_, err = r.TakeMigrationSource(ctx, "room", "p1")
+	require.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,87 @@
+package routing
+
+import (
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+// DefaultMessageChannelSize 消息通道默认缓冲大小
+const DefaultMessageChannelSize = 200
+
+// MessageChannel 基于 channel 的 MessageSink 和 MessageSource，用于同一进程内传递消息
+type MessageChannel struct {
+	connectionID tc.ConnectionID
+	msgChan      chan proto.Message
+	onClose      func()
+	isClosed     bool
+	lock         sync.RWMutex
+}
+
+// NewMessageChannel 创建消息通道
+func NewMessageChannel(connectionID tc.ConnectionID, size int) *MessageChannel {
+	return &MessageChannel{
+		connectionID: connectionID,
+		// 设置缓冲区，避免阻塞写入方
+		msgChan: make(chan proto.Message, size),
+	}
+}
+
+// OnClose 设置通道关闭时的回调
+func (m *MessageChannel) OnClose(f func()) {
+	m.lock.Lock()
+	m.onClose = f
+	m.lock.Unlock()
+}
+
+// IsClosed 通道是否已经关闭
+func (m *MessageChannel) IsClosed() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.isClosed
+}
+
+// WriteMessage 写入消息，通道已满时返回 ErrChannelFull
+func (m *MessageChannel) WriteMessage(msg proto.Message) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.isClosed {
+		return ErrChannelClosed
+	}
+
+	select {
+	case m.msgChan <- msg:
+		return nil
+	default:
+		return ErrChannelFull
+	}
+}
+
+// ReadChan 读取消息的通道，通道关闭后读取结束
+func (m *MessageChannel) ReadChan() <-chan proto.Message {
+	return m.msgChan
+}
+
+// Close 关闭通道
+func (m *MessageChannel) Close() {
+	m.lock.Lock()
+	if m.isClosed {
+		m.lock.Unlock()
+		return
+	}
+	m.isClosed = true
+	close(m.msgChan)
+	onClose := m.onClose
+	m.lock.Unlock()
+
+	if onClose != nil {
+		onClose()
+	}
+}
+
+// ConnectionID 连接ID
+func (m *MessageChannel) ConnectionID() tc.ConnectionID {
+	return m.connectionID
+}
@@ -0,0 +1,652 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+)
+
+const (
+	// NodesKey 节点信息，hash: node_id => Node
+	NodesKey = "nodes"
+	// NodeRoomKey 房间所在节点，hash: room_name => node_id
+	NodeRoomKey = "room_node_map"
+
+	// rtcChannelPrefix 发往节点的RTC消息，channel: rtc_channel:<node_id>
+	rtcChannelPrefix = "rtc_channel:"
+	// signalChannelPrefix 发往信令连接的响应，channel: signal_channel:<connection_id>
+	signalChannelPrefix = "signal_channel:"
+	// nodeRequestChannelPrefix 发往节点的请求，channel: node_request:<node_id>
+	nodeRequestChannelPrefix = "node_request:"
+	// nodeResponseChannelPrefix 请求的响应，channel: node_response:<request_id>
+	nodeResponseChannelPrefix = "node_response:"
+	// migrationSourceKeyPrefix 参与者迁移前所在的节点，key: migration_source:<room_name>:<identity> => node_id
+	migrationSourceKeyPrefix = "migration_source:"
+
+	// nodeRequestPrefix 节点请求ID前缀
+	nodeRequestPrefix = "NR_"
+	// nodeRequestTimeout 上下文没有截止时间时等待响应的时间
+	nodeRequestTimeout = 5 * time.Second
+	// migrationSourceTTL 迁移记录的有效期，超过后目标节点不再取回会话状态
+	migrationSourceTTL = 30 * time.Second
+
+	// statsUpdateInterval 节点信息刷新间隔
+	statsUpdateInterval = 2 * time.Second
+	// statsMaxDelay 超过该时间未刷新的节点被认为已失效
+	statsMaxDelay = 30 * time.Second
+)
+
+var _ Router = (*RedisRouter)(nil)
+var _ MessageRouter = (*RedisRouter)(nil)
+
+// RedisRouter 基于redis的多节点路由，节点和房间的对应关系保存在redis中，节点之间通过 pub/sub 传递消息
+type RedisRouter struct {
+	*LocalRouter
+
+	rc        redis.UniversalClient
+	ctx       context.Context
+	cancel    func()
+	isStarted atomic.Bool
+
+	pubsub        *redis.PubSub
+	requestPubSub *redis.PubSub
+	// signalConns 信令在其他节点上的参与者请求通道，key 为连接ID
+	signalLock  sync.Mutex
+	signalConns map[tc.ConnectionID]*MessageChannel
+}
+
+// NewRedisRouter 创建基于redis的路由
+func NewRedisRouter(lr *LocalRouter, rc redis.UniversalClient) *RedisRouter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RedisRouter{
+		LocalRouter: lr,
+		rc:          rc,
+		ctx:         ctx,
+		cancel:      cancel,
+		signalConns: make(map[tc.ConnectionID]*MessageChannel),
+	}
+}
+
+// RegisterNode 将当前节点写入redis
+func (r *RedisRouter) RegisterNode() error {
+	data, err := proto.Marshal(r.currentNode.Clone())
+	if err != nil {
+		return err
+	}
+	return r.rc.HSet(r.ctx, NodesKey, string(r.currentNode.NodeID()), data).Err()
+}
+
+// UnregisterNode 从redis中删除当前节点
+func (r *RedisRouter) UnregisterNode() error {
+	logger.Debugw("unregistering node", "nodeID", r.currentNode.NodeID())
+	return r.rc.HDel(context.Background(), NodesKey, string(r.currentNode.NodeID())).Err()
+}
+
+// RemoveDeadNodes 删除长时间未刷新的节点
+func (r *RedisRouter) RemoveDeadNodes() error {
+	nodes, err := r.ListNodes()
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if time.Since(time.Unix(n.GetStats().GetUpdatedAt(), 0)) > statsMaxDelay {
+			if err := r.rc.HDel(r.ctx, NodesKey, n.Id).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ListNodes 列举集群中所有已注册的节点
+func (r *RedisRouter) ListNodes() ([]*tc.Node, error) {
+	items, err := r.rc.HVals(r.ctx, NodesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*tc.Node, 0, len(items))
+	for _, item := range items {
+		n := &tc.Node{}
+		if err := proto.Unmarshal([]byte(item), n); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// GetNodeForRoom 获取房间所在的节点，房间未分配时返回 ErrNotFound
+func (r *RedisRouter) GetNodeForRoom(ctx context.Context, roomName tc.RoomName) (*tc.Node, error) {
+	nodeID, err := r.rc.HGet(ctx, NodeRoomKey, string(roomName)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return r.getNode(ctx, nodeID)
+}
+
+// SetNodeForRoom 记录房间所在的节点
+func (r *RedisRouter) SetNodeForRoom(ctx context.Context, roomName tc.RoomName, nodeID tc.NodeID) error {
+	return r.rc.HSet(ctx, NodeRoomKey, string(roomName), string(nodeID)).Err()
+}
+
+// ClearRoomState 清除房间所在节点的记录
+func (r *RedisRouter) ClearRoomState(ctx context.Context, roomName tc.RoomName) error {
+	return r.rc.HDel(ctx, NodeRoomKey, string(roomName)).Err()
+}
+
+func (r *RedisRouter) getNode(ctx context.Context, nodeID string) (*tc.Node, error) {
+	data, err := r.rc.HGet(ctx, NodesKey, nodeID).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNodeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	n := &tc.Node{}
+	if err := proto.Unmarshal([]byte(data), n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// StartParticipantSignal 启动参与者的信令连接，房间未分配时分配到当前节点，
+// 房间在其他节点上时通过redis转发请求和响应
+func (r *RedisRouter) StartParticipantSignal(ctx context.Context, roomName tc.RoomName, pi ParticipantInit) (connectionID tc.ConnectionID, reqSink MessageSink, resSource MessageSource, err error) {
+	nodeID, err := r.nodeIDForRoom(ctx, roomName)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if nodeID == string(r.currentNode.NodeID()) {
+		return r.LocalRouter.StartParticipantSignal(ctx, roomName, pi)
+	}
+
+	connectionID = tc.ConnectionID(utils.NewGuid(connectionPrefix))
+	ss, err := pi.ToStartSession(roomName, connectionID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	// 先订阅响应，避免丢失房间节点的第一条响应
+	sub := r.rc.Subscribe(r.ctx, signalChannelPrefix+string(connectionID))
+	if _, err = sub.Receive(ctx); err != nil {
+		_ = sub.Close()
+		return "", nil, nil, err
+	}
+	resChan := NewMessageChannel(connectionID, DefaultMessageChannelSize)
+	resChan.OnClose(func() {
+		_ = sub.Close()
+	})
+	go r.signalResponseWorker(sub, resChan)
+
+	sink := &rtcNodeSink{
+		rc:           r.rc,
+		nodeID:       nodeID,
+		connectionID: connectionID,
+		roomName:     roomName,
+		identity:     pi.Identity,
+	}
+	if err = sink.publish(&tc.RTCNodeMessage{
+		Message: &tc.RTCNodeMessage_StartSession{StartSession: ss},
+	}); err != nil {
+		resChan.Close()
+		return "", nil, nil, err
+	}
+	return connectionID, sink, resChan, nil
+}
+
+// nodeIDForRoom 房间所在的节点，未分配的房间分配到当前节点
+func (r *RedisRouter) nodeIDForRoom(ctx context.Context, roomName tc.RoomName) (string, error) {
+	node, err := r.GetNodeForRoom(ctx, roomName)
+	if errors.Is(err, ErrNotFound) {
+		if err = r.SetNodeForRoom(ctx, roomName, r.currentNode.NodeID()); err != nil {
+			return "", err
+		}
+		return string(r.currentNode.NodeID()), nil
+	} else if err != nil {
+		return "", err
+	}
+	return node.Id, nil
+}
+
+// WriteParticipantRTC 向参与者所在房间的节点写入消息
+func (r *RedisRouter) WriteParticipantRTC(ctx context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity, msg *tc.RTCNodeMessage) error {
+	return r.writeRTC(ctx, roomName, identity, msg)
+}
+
+// WriteRoomRTC 向房间所在的节点写入消息
+func (r *RedisRouter) WriteRoomRTC(ctx context.Context, roomName tc.RoomName, msg *tc.RTCNodeMessage) error {
+	return r.writeRTC(ctx, roomName, "", msg)
+}
+
+func (r *RedisRouter) writeRTC(ctx context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity, msg *tc.RTCNodeMessage) error {
+	node, err := r.GetNodeForRoom(ctx, roomName)
+	if err != nil {
+		return err
+	}
+	if node.Id == string(r.currentNode.NodeID()) {
+		return r.writeRTCMessage(roomName, identity, msg)
+	}
+	sink := &rtcNodeSink{
+		rc:       r.rc,
+		nodeID:   node.Id,
+		roomName: roomName,
+		identity: identity,
+	}
+	return sink.publish(msg)
+}
+
+// nodeRequest 节点之间请求的格式
+type nodeRequest struct {
+	RequestID string `json:"requestId"`
+	RoomName  string `json:"roomName"`
+	Method    string `json:"method"`
+	Payload   []byte `json:"payload,omitempty"`
+	// Deadline 请求方等待响应的截止时间，unix 纳秒，处理方按照该时间结束处理
+	Deadline int64 `json:"deadline,omitempty"`
+}
+
+// nodeResponse 节点之间响应的格式，Error 不为空表示处理失败
+type nodeResponse struct {
+	Payload []byte `json:"payload,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RequestNode 向指定节点发送请求并等待响应，请求当前节点时直接处理
+func (r *RedisRouter) RequestNode(ctx context.Context, nodeID tc.NodeID, roomName tc.RoomName, method string, payload []byte) ([]byte, error) {
+	if nodeID == r.currentNode.NodeID() {
+		return r.handleNodeRequest(ctx, roomName, method, payload)
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, nodeRequestTimeout)
+		defer cancel()
+	}
+
+	req := nodeRequest{
+		RequestID: utils.NewGuid(nodeRequestPrefix),
+		RoomName:  string(roomName),
+		Method:    method,
+		Payload:   payload,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Deadline = deadline.UnixNano()
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先订阅响应，避免响应在订阅之前到达
+	sub := r.rc.Subscribe(ctx, nodeResponseChannelPrefix+req.RequestID)
+	defer func() {
+		_ = sub.Close()
+	}()
+	if _, err = sub.Receive(ctx); err != nil {
+		return nil, err
+	}
+
+	receivers, err := r.rc.Publish(ctx, nodeRequestChannelPrefix+string(nodeID), data).Result()
+	if err != nil {
+		return nil, err
+	}
+	if receivers == 0 {
+		return nil, ErrNodeNotFound
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case m, ok := <-sub.Channel():
+		if !ok {
+			return nil, ErrChannelClosed
+		}
+		res := nodeResponse{}
+		if err = json.Unmarshal([]byte(m.Payload), &res); err != nil {
+			return nil, err
+		}
+		if res.Error != "" {
+			return nil, errors.New(res.Error)
+		}
+		return res.Payload, nil
+	}
+}
+
+// RequestRoomNode 向房间所在的节点发送请求并等待响应
+func (r *RedisRouter) RequestRoomNode(ctx context.Context, roomName tc.RoomName, method string, payload []byte) ([]byte, error) {
+	node, err := r.GetNodeForRoom(ctx, roomName)
+	if err != nil {
+		return nil, err
+	}
+	return r.RequestNode(ctx, tc.NodeID(node.Id), roomName, method, payload)
+}
+
+// SetMigrationSource 记录参与者从当前节点开始迁移
+func (r *RedisRouter) SetMigrationSource(ctx context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity) error {
+	return r.rc.Set(ctx, migrationSourceKey(roomName, identity), string(r.currentNode.NodeID()), migrationSourceTTL).Err()
+}
+
+// TakeMigrationSource 取出参与者迁移前所在的节点，每次迁移只能取出一次
+func (r *RedisRouter) TakeMigrationSource(ctx context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity) (tc.NodeID, error) {
+	nodeID, err := r.rc.GetDel(ctx, migrationSourceKey(roomName, identity)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return tc.NodeID(nodeID), nil
+}
+
+func migrationSourceKey(roomName tc.RoomName, identity tc.ParticipantIdentity) string {
+	return migrationSourceKeyPrefix + string(roomName) + ":" + string(identity)
+}
+
+// Start 注册节点并开始接收发往当前节点的消息
+func (r *RedisRouter) Start() error {
+	if r.isStarted.Swap(true) {
+		return nil
+	}
+	if err := r.LocalRouter.Start(); err != nil {
+		return err
+	}
+	if err := r.RegisterNode(); err != nil {
+		return err
+	}
+
+	r.pubsub = r.rc.Subscribe(r.ctx, rtcChannelPrefix+string(r.currentNode.NodeID()))
+	if _, err := r.pubsub.Receive(r.ctx); err != nil {
+		return err
+	}
+	r.requestPubSub = r.rc.Subscribe(r.ctx, nodeRequestChannelPrefix+string(r.currentNode.NodeID()))
+	if _, err := r.requestPubSub.Receive(r.ctx); err != nil {
+		return err
+	}
+	go r.rtcWorker()
+	go r.nodeRequestWorker()
+	go r.statsWorker()
+	return nil
+}
+
+// IsStarted 是否已经启动
+func (r *RedisRouter) IsStarted() bool {
+	return r.isStarted.Load()
+}
+
+// Drain 将当前节点标记为 SHUTTING_DOWN 并同步到redis
+func (r *RedisRouter) Drain() {
+	r.LocalRouter.Drain()
+	if err := r.RegisterNode(); err != nil {
+		logger.Errorw("failed to mark node as draining", err, "nodeID", r.currentNode.NodeID())
+	}
+}
+
+// Stop 解除注册并停止接收消息
+func (r *RedisRouter) Stop() {
+	if !r.isStarted.Swap(false) {
+		return
+	}
+	logger.Debugw("stopping RedisRouter")
+	if err := r.UnregisterNode(); err != nil {
+		logger.Errorw("failed to unregister node", err)
+	}
+	r.cancel()
+	if r.pubsub != nil {
+		_ = r.pubsub.Close()
+	}
+	if r.requestPubSub != nil {
+		_ = r.requestPubSub.Close()
+	}
+
+	r.signalLock.Lock()
+	for _, reqChan := range r.signalConns {
+		reqChan.Close()
+	}
+	r.signalLock.Unlock()
+	r.LocalRouter.Stop()
+}
+
+// Close 同 Stop
+func (r *RedisRouter) Close() {
+	r.Stop()
+}
+
+// rtcWorker 处理其他节点发来的消息
+func (r *RedisRouter) rtcWorker() {
+	for m := range r.pubsub.Channel() {
+		msg := &tc.RTCNodeMessage{}
+		if err := proto.Unmarshal([]byte(m.Payload), msg); err != nil {
+			logger.Errorw("could not unmarshal rtc message", err)
+			continue
+		}
+
+		// 带连接ID的是信令在其他节点上的参与者会话，其余交给节点处理
+		if ss := msg.GetStartSession(); ss != nil {
+			r.startRemoteSession(ss)
+		} else if req := msg.GetRequest(); req != nil && msg.ConnectionId != "" {
+			r.writeSignalRequest(tc.ConnectionID(msg.ConnectionId), req)
+		} else {
+			if err := r.writeRTCMessage(tc.RoomName(msg.RoomName), tc.ParticipantIdentity(msg.Identity), msg); err != nil {
+				logger.Warnw("could not forward rtc message", err, "room", msg.RoomName)
+			}
+		}
+	}
+}
+
+// nodeRequestWorker 处理其他节点发来的请求，每个请求单独处理，慢请求不会阻塞其他请求
+func (r *RedisRouter) nodeRequestWorker() {
+	for m := range r.requestPubSub.Channel() {
+		req := nodeRequest{}
+		if err := json.Unmarshal([]byte(m.Payload), &req); err != nil {
+			logger.Errorw("could not unmarshal node request", err)
+			continue
+		}
+		go r.handleRemoteNodeRequest(req)
+	}
+}
+
+func (r *RedisRouter) handleRemoteNodeRequest(req nodeRequest) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if req.Deadline != 0 {
+		ctx, cancel = context.WithDeadline(r.ctx, time.Unix(0, req.Deadline))
+	} else {
+		ctx, cancel = context.WithTimeout(r.ctx, nodeRequestTimeout)
+	}
+	defer cancel()
+
+	res := nodeResponse{}
+	payload, err := r.handleNodeRequest(ctx, tc.RoomName(req.RoomName), req.Method, req.Payload)
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Payload = payload
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		logger.Errorw("could not marshal node response", err, "method", req.Method)
+		return
+	}
+	if err = r.rc.Publish(r.ctx, nodeResponseChannelPrefix+req.RequestID, data).Err(); err != nil {
+		logger.Warnw("could not publish node response", err, "method", req.Method, "room", req.RoomName)
+	}
+}
+
+// startRemoteSession 信令在其他节点上的参与者加入当前节点的房间
+func (r *RedisRouter) startRemoteSession(ss *tc.StartSession) {
+	connectionID := tc.ConnectionID(ss.ConnectionId)
+	pi, err := ParticipantInitFromStartSession(ss, r.GetRegion())
+	if err != nil {
+		logger.Errorw("could not parse start session", err, "room", ss.RoomName, "connectionID", connectionID)
+		return
+	}
+
+	reqChan, resChan, err := r.startLocalSession(r.ctx, tc.RoomName(ss.RoomName), *pi, connectionID)
+	if err != nil {
+		logger.Warnw("could not start remote session", err, "room", ss.RoomName, "participant", ss.Identity)
+		r.publishSignal(connectionID, &tc.SignalNodeMessage{
+			ConnectionId: string(connectionID),
+			Message:      &tc.SignalNodeMessage_EndSession{EndSession: &tc.EndSession{}},
+		})
+		return
+	}
+
+	r.signalLock.Lock()
+	r.signalConns[connectionID] = reqChan
+	r.signalLock.Unlock()
+	reqChan.OnClose(func() {
+		r.signalLock.Lock()
+		delete(r.signalConns, connectionID)
+		r.signalLock.Unlock()
+	})
+
+	go func() {
+		// 响应通道关闭时通知信令节点结束会话
+		defer reqChan.Close()
+		for msg := range resChan.ReadChan() {
+			res, ok := msg.(*tc.SignalResponse)
+			if !ok {
+				continue
+			}
+			r.publishSignal(connectionID, &tc.SignalNodeMessage{
+				ConnectionId: string(connectionID),
+				Message:      &tc.SignalNodeMessage_Response{Response: res},
+			})
+		}
+		r.publishSignal(connectionID, &tc.SignalNodeMessage{
+			ConnectionId: string(connectionID),
+			Message:      &tc.SignalNodeMessage_EndSession{EndSession: &tc.EndSession{}},
+		})
+	}()
+}
+
+func (r *RedisRouter) writeSignalRequest(connectionID tc.ConnectionID, req *tc.SignalRequest) {
+	r.signalLock.Lock()
+	reqChan := r.signalConns[connectionID]
+	r.signalLock.Unlock()
+	if reqChan == nil {
+		logger.Debugw("signal connection not found", "connectionID", connectionID)
+		return
+	}
+	if err := reqChan.WriteMessage(req); err != nil {
+		logger.Warnw("could not write signal request", err, "connectionID", connectionID)
+	}
+}
+
+func (r *RedisRouter) publishSignal(connectionID tc.ConnectionID, msg *tc.SignalNodeMessage) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		logger.Errorw("could not marshal signal message", err)
+		return
+	}
+	if err = r.rc.Publish(r.ctx, signalChannelPrefix+string(connectionID), data).Err(); err != nil {
+		logger.Warnw("could not publish signal message", err, "connectionID", connectionID)
+	}
+}
+
+// signalResponseWorker 将房间节点的响应写入信令连接
+func (r *RedisRouter) signalResponseWorker(sub *redis.PubSub, resChan *MessageChannel) {
+	defer resChan.Close()
+	for m := range sub.Channel() {
+		msg := &tc.SignalNodeMessage{}
+		if err := proto.Unmarshal([]byte(m.Payload), msg); err != nil {
+			logger.Errorw("could not unmarshal signal message", err)
+			continue
+		}
+		switch sm := msg.Message.(type) {
+		case *tc.SignalNodeMessage_Response:
+			if err := resChan.WriteMessage(sm.Response); err != nil {
+				logger.Warnw("could not write signal response", err, "connectionID", msg.ConnectionId)
+			}
+		case *tc.SignalNodeMessage_EndSession:
+			return
+		}
+	}
+}
+
+// statsWorker 定期将当前节点的状态和统计同步到redis，避免被其他节点当作失效节点删除
+func (r *RedisRouter) statsWorker() {
+	ticker := time.NewTicker(statsUpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.RegisterNode(); err != nil {
+				logger.Errorw("could not update node", err)
+			}
+		}
+	}
+}
+
+// rtcNodeSink 将消息发布到指定节点的RTC channel
+type rtcNodeSink struct {
+	rc           redis.UniversalClient
+	nodeID       string
+	connectionID tc.ConnectionID
+	roomName     tc.RoomName
+	identity     tc.ParticipantIdentity
+	isClosed     atomic.Bool
+}
+
+// WriteMessage 发送参与者的信令请求
+func (s *rtcNodeSink) WriteMessage(msg proto.Message) error {
+	if s.isClosed.Load() {
+		return ErrChannelClosed
+	}
+	req, ok := msg.(*tc.SignalRequest)
+	if !ok {
+		return nil
+	}
+	return s.publish(&tc.RTCNodeMessage{
+		Message: &tc.RTCNodeMessage_Request{Request: req},
+	})
+}
+
+func (s *rtcNodeSink) publish(msg *tc.RTCNodeMessage) error {
+	msg.ConnectionId = string(s.connectionID)
+	msg.RoomName = string(s.roomName)
+	msg.Identity = string(s.identity)
+	msg.SenderTime = time.Now().Unix()
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.rc.Publish(context.Background(), rtcChannelPrefix+s.nodeID, data).Err()
+}
+
+// IsClosed sink是否已经关闭
+func (s *rtcNodeSink) IsClosed() bool {
+	return s.isClosed.Load()
+}
+
+// Close 关闭sink，并通知房间节点参与者已离开
+func (s *rtcNodeSink) Close() {
+	if s.isClosed.Swap(true) {
+		return
+	}
+	_ = s.publish(&tc.RTCNodeMessage{
+		Message: &tc.RTCNodeMessage_Request{
+			Request: &tc.SignalRequest{
+				Message: &tc.SignalRequest_Leave{Leave: &tc.LeaveRequest{}},
+			},
+		},
+	})
+}
+
+// ConnectionID 连接ID
+func (s *rtcNodeSink) ConnectionID() tc.ConnectionID {
+	return s.connectionID
+}
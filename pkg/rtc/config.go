@@ -0,0 +1,36 @@
+package rtc
+
+import (
+	"github.com/pion/webrtc/v3"
+
+	"github.com/liuhailove/tc-base-go/mediatransportutil/pkg/rtcconfig"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+)
+
+// WebRTCConfig 创建 PeerConnection 使用的配置
+type WebRTCConfig struct {
+	rtcconfig.WebRTCConfig
+
+	BufferFactory     *buffer.FactoryOfBufferFactory
+	PLIThrottle       config.PLIThrottleConfig
+	CongestionControl config.CongestionControlConfig
+}
+
+// NewWebRTCConfig 根据服务配置创建 WebRTC 配置
+func NewWebRTCConfig(conf *config.Config) (*WebRTCConfig, error) {
+	rtcConf := conf.RTC
+
+	webRTCConfig, err := rtcconfig.NewWebRTCConfig(&rtcConf.RTCConfig, conf.Development)
+	if err != nil {
+		return nil, err
+	}
+	webRTCConfig.Configuration.SDPSemantics = webrtc.SDPSemanticsUnifiedPlan
+
+	return &WebRTCConfig{
+		WebRTCConfig:      *webRTCConfig,
+		BufferFactory:     buffer.NewFactoryOfBufferFactory(rtcConf.PacketBufferSize),
+		PLIThrottle:       rtcConf.PLIThrottle,
+		CongestionControl: rtcConf.CongestionControl,
+	}, nil
+}
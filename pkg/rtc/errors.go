@@ -0,0 +1,36 @@
+package rtc
+
+import (
+	"errors"
+)
+
+var (
+	ErrTrackClosed       = errors.New("track is closed")
+	ErrNoReceiver        = errors.New("track has no receiver")
+	ErrAlreadySubscribed = errors.New("already subscribed")
+	ErrSubscriberClosed  = errors.New("subscriber is closed")
+	ErrTrackNotFound     = errors.New("track cannot be found")
+	ErrNoTransceiver     = errors.New("no transceiver for subscriber")
+
+	ErrSubscriptionLimitExceeded = errors.New("participant has exceeded its subscription limit")
+	ErrNoTrackPermission         = errors.New("participant is not allowed to subscribe to this track")
+
+	ErrRoomClosed              = errors.New("room has already closed")
+	ErrAlreadyJoined           = errors.New("a participant with the same identity is already in the room")
+	ErrMaxParticipantsExceeded = errors.New("room has exceeded its max participants")
+	ErrParticipantNotFound     = errors.New("participant cannot be found")
+
+	ErrRoomMetadataVersionConflict = errors.New("room metadata version does not match")
+
+	ErrNumTracksExceeded   = errors.New("node has exceeded its track limit")
+	ErrBytesPerSecExceeded = errors.New("node has exceeded its ingress bandwidth limit")
+
+	ErrDataPacketTooLarge = errors.New("data packet exceeds the size limit")
+
+	ErrInvalidAttributeKey = errors.New("participant attribute key is empty or too long")
+	ErrAttributesTooLarge  = errors.New("participant attributes exceed the size limit")
+
+	ErrEmptyIdentity      = errors.New("participant identity cannot be empty")
+	ErrEmptyParticipantID = errors.New("participant ID cannot be empty")
+	ErrMissingGrants      = errors.New("VideoGrant is missing")
+)
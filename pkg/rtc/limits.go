@@ -0,0 +1,22 @@
+package rtc
+
+import (
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+)
+
+// CheckLimits 检查节点是否还能接受新的参与者和发布，音轨数量包括发布和订阅的音轨，超过限制时返回错误并计入指标
+func CheckLimits(limit config.LimitConfig) error {
+	if limit.NumTracks > 0 {
+		published, subscribed := prometheus.TrackCount()
+		if published+subscribed >= limit.NumTracks {
+			prometheus.RecordLimitRejected(prometheus.LimitNumTracks)
+			return ErrNumTracksExceeded
+		}
+	}
+	if limit.BytesPerSec > 0 && prometheus.IngressBytesPerSec() >= float64(limit.BytesPerSec) {
+		prometheus.RecordLimitRejected(prometheus.LimitBytesPerSec)
+		return ErrBytesPerSecExceeded
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package rtc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+)
+
+func TestCheckLimits(t *testing.T) {
+	require.NoError(t, CheckLimits(config.LimitConfig{}))
+
+	published, subscribed := prometheus.TrackCount()
+	limit := config.LimitConfig{NumTracks: published + subscribed + 2}
+	require.NoError(t, CheckLimits(limit))
+
+	prometheus.AddPublishedTrack(tc.TrackType_AUDIO)
+	prometheus.AddSubscribedTrack(tc.TrackType_AUDIO)
+	defer prometheus.SubPublishedTrack(tc.TrackType_AUDIO)
+	defer prometheus.SubSubscribedTrack(tc.TrackType_AUDIO)
+	require.ErrorIs(t, CheckLimits(limit), ErrNumTracksExceeded)
+}
@@ -0,0 +1,573 @@
+package rtc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+	"github.com/liuhailove/tc-server/pkg/sfu"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+)
+
+const (
+	// layerSelectionTolerance 请求尺寸的容忍度，略小于请求尺寸的层也可以满足订阅者
+	layerSelectionTolerance = 0.9
+)
+
+// MediaTrackParams 媒体音轨参数
+type MediaTrackParams struct {
+	TrackInfo           *tc.TrackInfo
+	ParticipantID       tc.ParticipantID
+	ParticipantIdentity tc.ParticipantIdentity
+	ParticipantVersion  uint32
+	Logger              logger.Logger
+}
+
+// MediaTrack 参与者发布的媒体音轨，管理按编解码器区分的接收器和全部订阅者
+type MediaTrack struct {
+	params MediaTrackParams
+
+	lock        sync.RWMutex
+	trackInfo   *tc.TrackInfo
+	receivers   map[string]sfu.TrackReceiver
+	subscribers map[tc.ParticipantID]types.SubscribedTrack
+	// pendingSubscribers 正在创建下行音轨的订阅者，避免同一订阅者并发添加两次
+	pendingSubscribers map[tc.ParticipantID]struct{}
+
+	closed atomic.Bool
+
+	onTrackSubscribed func()
+	onClose           []func()
+}
+
+// NewMediaTrack 创建媒体音轨
+func NewMediaTrack(params MediaTrackParams) *MediaTrack {
+	if params.Logger == nil {
+		params.Logger = logger.GetLogger()
+	}
+	params.Logger = params.Logger.WithValues("trackID", params.TrackInfo.Sid)
+
+	t := &MediaTrack{
+		params:             params,
+		trackInfo:          proto.Clone(params.TrackInfo).(*tc.TrackInfo),
+		receivers:          make(map[string]sfu.TrackReceiver),
+		subscribers:        make(map[tc.ParticipantID]types.SubscribedTrack),
+		pendingSubscribers: make(map[tc.ParticipantID]struct{}),
+	}
+	prometheus.AddPublishedTrack(t.trackInfo.Type)
+	return t
+}
+
+// ID 音轨ID
+func (t *MediaTrack) ID() tc.TrackID {
+	return tc.TrackID(t.params.TrackInfo.Sid)
+}
+
+// Kind 音轨类型
+func (t *MediaTrack) Kind() tc.TrackType {
+	return t.params.TrackInfo.Type
+}
+
+// Name 音轨名称
+func (t *MediaTrack) Name() string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.trackInfo.Name
+}
+
+// Source 音轨来源
+func (t *MediaTrack) Source() tc.TrackSource {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.trackInfo.Source
+}
+
+// Stream 音轨所属的流
+func (t *MediaTrack) Stream() string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.trackInfo.Stream
+}
+
+// PublisherID 发布者ID
+func (t *MediaTrack) PublisherID() tc.ParticipantID {
+	return t.params.ParticipantID
+}
+
+// PublisherIdentity 发布者标识
+func (t *MediaTrack) PublisherIdentity() tc.ParticipantIdentity {
+	return t.params.ParticipantIdentity
+}
+
+// PublisherVersion 发布者的版本
+func (t *MediaTrack) PublisherVersion() uint32 {
+	return t.params.ParticipantVersion
+}
+
+// UpdateTrackInfo 更新音轨信息，音轨ID和类型不会改变
+func (t *MediaTrack) UpdateTrackInfo(ti *tc.TrackInfo) {
+	clone := proto.Clone(ti).(*tc.TrackInfo)
+	clone.Sid = t.params.TrackInfo.Sid
+	clone.Type = t.params.TrackInfo.Type
+
+	t.lock.Lock()
+	t.trackInfo = clone
+	t.lock.Unlock()
+}
+
+// ToProto 转化为协议对象
+func (t *MediaTrack) ToProto() *tc.TrackInfo {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return proto.Clone(t.trackInfo).(*tc.TrackInfo)
+}
+
+// IsMuted 是否静音
+func (t *MediaTrack) IsMuted() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.trackInfo.Muted
+}
+
+// SetMuted 设置静音，静音时暂停上行音轨并通知全部订阅者
+func (t *MediaTrack) SetMuted(muted bool) {
+	t.lock.Lock()
+	if t.trackInfo.Muted == muted {
+		t.lock.Unlock()
+		return
+	}
+	t.trackInfo.Muted = muted
+	receivers := t.receiversLocked()
+	subscribers := t.subscribersLocked()
+	t.lock.Unlock()
+
+	for _, receiver := range receivers {
+		receiver.SetUpTrackPaused(muted)
+	}
+	for _, st := range subscribers {
+		st.SetPublisherMuted(muted)
+	}
+}
+
+// IsSimulcast 是否为联播
+func (t *MediaTrack) IsSimulcast() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.trackInfo.Simulcast
+}
+
+// GetAudioLevel 获取音频级别，非音频音轨返回 0
+func (t *MediaTrack) GetAudioLevel() (float64, bool) {
+	if t.Kind() != tc.TrackType_AUDIO {
+		return 0, false
+	}
+
+	receivers := t.Receivers()
+	if len(receivers) == 0 {
+		return 0, false
+	}
+	return receivers[0].GetAudioLevel()
+}
+
+// AddReceiver 添加编解码器对应的接收器，联播多个编解码器时每个编解码器一个接收器
+func (t *MediaTrack) AddReceiver(receiver sfu.TrackReceiver) {
+	mime := strings.ToLower(receiver.Codec().MimeType)
+
+	t.lock.Lock()
+	t.receivers[mime] = receiver
+	muted := t.trackInfo.Muted
+	t.lock.Unlock()
+
+	receiver.SetUpTrackPaused(muted)
+	t.params.Logger.Debugw("added receiver", "mime", mime)
+}
+
+// HasAllReceivers 音轨信息中的每个编解码器都已经有接收器
+func (t *MediaTrack) HasAllReceivers() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	expected := len(t.trackInfo.Codecs)
+	if expected == 0 {
+		expected = 1
+	}
+	return len(t.receivers) >= expected
+}
+
+// Receivers 按照音轨信息中编解码器的顺序返回全部接收器
+func (t *MediaTrack) Receivers() []sfu.TrackReceiver {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.receiversLocked()
+}
+
+func (t *MediaTrack) receiversLocked() []sfu.TrackReceiver {
+	receivers := make([]sfu.TrackReceiver, 0, len(t.receivers))
+	seen := make(map[string]bool, len(t.receivers))
+	for _, codec := range t.trackInfo.Codecs {
+		mime := strings.ToLower(codec.MimeType)
+		if receiver, ok := t.receivers[mime]; ok && !seen[mime] {
+			receivers = append(receivers, receiver)
+			seen[mime] = true
+		}
+	}
+
+	// 音轨信息中没有列出的编解码器放在最后，保证顺序稳定
+	var rest []string
+	for mime := range t.receivers {
+		if !seen[mime] {
+			rest = append(rest, mime)
+		}
+	}
+	sort.Strings(rest)
+	for _, mime := range rest {
+		receivers = append(receivers, t.receivers[mime])
+	}
+	return receivers
+}
+
+// Receiver 获取指定编解码器的接收器
+func (t *MediaTrack) Receiver(mime string) sfu.TrackReceiver {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.receivers[strings.ToLower(mime)]
+}
+
+// AddSubscriber 为订阅者创建下行音轨并添加到订阅者的连接，已经订阅时返回已有的订阅和 ErrAlreadySubscribed，
+// 同一订阅者正在添加时只返回 ErrAlreadySubscribed
+func (t *MediaTrack) AddSubscriber(sub types.LocalParticipant) (types.SubscribedTrack, error) {
+	if t.closed.Load() {
+		return nil, ErrTrackClosed
+	}
+	if sub.IsClosed() {
+		return nil, ErrSubscriberClosed
+	}
+
+	subID := sub.ID()
+	t.lock.Lock()
+	if existing, ok := t.subscribers[subID]; ok {
+		t.lock.Unlock()
+		return existing, ErrAlreadySubscribed
+	}
+	if _, pending := t.pendingSubscribers[subID]; pending {
+		t.lock.Unlock()
+		return nil, ErrAlreadySubscribed
+	}
+	trackInfo := proto.Clone(t.trackInfo).(*tc.TrackInfo)
+	receivers := t.receiversLocked()
+	if len(receivers) == 0 {
+		t.lock.Unlock()
+		return nil, ErrNoReceiver
+	}
+	t.pendingSubscribers[subID] = struct{}{}
+	t.lock.Unlock()
+
+	st, err := t.addSubscriber(sub, trackInfo, receivers)
+
+	t.lock.Lock()
+	delete(t.pendingSubscribers, subID)
+	if err == nil {
+		t.subscribers[subID] = st
+	}
+	onTrackSubscribed := t.onTrackSubscribed
+	t.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	// 添加期间音轨被关闭时 Close 看不到这个订阅，需要自行关闭
+	if t.closed.Load() {
+		st.DownTrack().Close()
+		return nil, ErrTrackClosed
+	}
+
+	t.params.Logger.Debugw("added subscriber", "subscriberID", subID)
+	if onTrackSubscribed != nil {
+		onTrackSubscribed()
+	}
+	return st, nil
+}
+
+// addSubscriber 创建下行音轨并挂到订阅者的连接和全部接收器上，失败时释放已经创建的发送器和下行音轨
+func (t *MediaTrack) addSubscriber(sub types.LocalParticipant, trackInfo *tc.TrackInfo, receivers []sfu.TrackReceiver) (types.SubscribedTrack, error) {
+	subID := sub.ID()
+	codecs := make([]webrtc.RTPCodecParameters, 0, len(receivers))
+	for _, receiver := range receivers {
+		codecs = append(codecs, receiver.Codec())
+	}
+
+	streamID := PackStreamID(t.params.ParticipantID, t.ID())
+	if trackInfo.Stream != "" && sub.SupportSyncStreamID() {
+		streamID = PackSyncStreamID(t.params.ParticipantID, trackInfo.Stream)
+	}
+
+	downTrack, err := sfu.NewDownTrack(sfu.DownTrackParams{
+		Codec:             codecs,
+		Receiver:          receivers[0],
+		BufferFactory:     sub.GetBufferFactory(),
+		SubID:             subID,
+		StreamID:          streamID,
+		PlayoutDelayLimit: sub.GetPlayoutDelayConfig(),
+		Pacer:             sub.GetPacer(),
+		Logger:            sub.GetLogger().WithValues("trackID", t.ID(), "publisherID", t.params.ParticipantID),
+		Trailer:           sub.GetTrailer(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sender, transceiver, err := sub.AddTrackToSubscriber(downTrack, types.AddTrackParams{
+		Stereo: trackInfo.Stereo,
+		Red:    !trackInfo.DisableRed,
+	})
+	if err == nil && transceiver == nil {
+		err = ErrNoTransceiver
+	}
+	if err != nil {
+		if sender != nil {
+			if removeErr := sub.RemoveTrackFromSubscriber(sender); removeErr != nil {
+				t.params.Logger.Debugw("could not remove track from subscriber", "subscriberID", subID, "error", removeErr)
+			}
+		}
+		downTrack.Close()
+		return nil, err
+	}
+	downTrack.SetTransceiver(transceiver)
+	downTrack.OnRttUpdate(func(_ *sfu.DownTrack, rtt uint32) {
+		prometheus.RecordRTT(rtt)
+	})
+
+	// 迁移或者恢复的订阅从之前的状态继续，订阅者看到连续的序列号和时间戳
+	if _, dts, ok := sub.GetCachedDownTrack(t.ID()); ok {
+		t.params.Logger.Debugw("seeding down track state", "subscriberID", subID, "state", dts.String())
+		downTrack.SeedState(dts)
+	}
+
+	st := NewSubscribedTrack(SubscribedTrackParams{
+		MediaTrack:     t,
+		Subscriber:     sub,
+		DownTrack:      downTrack,
+		PublisherMuted: trackInfo.Muted,
+		Logger:         sub.GetLogger(),
+	})
+
+	downTrack.OnCloseHandler(func(willBeResumed bool) {
+		t.lock.Lock()
+		if t.subscribers[subID] == st {
+			delete(t.subscribers, subID)
+		}
+		t.lock.Unlock()
+
+		if willBeResumed {
+			// 缓存状态，恢复订阅时继续使用
+			sub.CacheDownTrack(t.ID(), transceiver, downTrack.GetState())
+		} else if !sub.IsClosed() {
+			if err := sub.RemoveTrackFromSubscriber(sender); err != nil {
+				t.params.Logger.Debugw("could not remove track from subscriber", "subscriberID", subID, "error", err)
+			}
+		}
+		st.Close(willBeResumed)
+	})
+
+	for i, receiver := range receivers {
+		if err := receiver.AddDownTrack(downTrack); err != nil {
+			// 下行音轨关闭时只从主接收器移除，其余已经添加的接收器需要单独移除
+			for _, added := range receivers[:i] {
+				added.DeleteDownTrack(subID)
+			}
+			downTrack.Close()
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// RemoveSubscriber 移除订阅者
+func (t *MediaTrack) RemoveSubscriber(subID tc.ParticipantID, willBeResumed bool) {
+	t.lock.RLock()
+	st := t.subscribers[subID]
+	t.lock.RUnlock()
+
+	if st == nil {
+		return
+	}
+	st.DownTrack().CloseWithFlush(!willBeResumed)
+}
+
+// IsSubscriber 是否为订阅者
+func (t *MediaTrack) IsSubscriber(subID tc.ParticipantID) bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	_, ok := t.subscribers[subID]
+	return ok
+}
+
+// GetAllSubscribers 获取全部订阅者
+func (t *MediaTrack) GetAllSubscribers() []tc.ParticipantID {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	subIDs := make([]tc.ParticipantID, 0, len(t.subscribers))
+	for subID := range t.subscribers {
+		subIDs = append(subIDs, subID)
+	}
+	return subIDs
+}
+
+// GetNumSubscribers 获取订阅者数量
+func (t *MediaTrack) GetNumSubscribers() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return len(t.subscribers)
+}
+
+// RevokeDisallowedSubscribers 移除不再允许订阅的订阅者
+func (t *MediaTrack) RevokeDisallowedSubscribers(allowedSubscriberIdentities []tc.ParticipantIdentity) []tc.ParticipantIdentity {
+	allowed := make(map[tc.ParticipantIdentity]bool, len(allowedSubscriberIdentities))
+	for _, identity := range allowedSubscriberIdentities {
+		allowed[identity] = true
+	}
+
+	var revoked []types.SubscribedTrack
+	t.lock.RLock()
+	for _, st := range t.subscribers {
+		if !allowed[st.SubscriberIdentity()] {
+			revoked = append(revoked, st)
+		}
+	}
+	t.lock.RUnlock()
+
+	revokedIdentities := make([]tc.ParticipantIdentity, 0, len(revoked))
+	for _, st := range revoked {
+		t.params.Logger.Infow("revoking subscription", "subscriber", st.SubscriberIdentity())
+		st.DownTrack().Close()
+		revokedIdentities = append(revokedIdentities, st.SubscriberIdentity())
+	}
+	return revokedIdentities
+}
+
+// GetQualityForDimension 在已发布的层中选择能够满足订阅者显示尺寸的最小层
+func (t *MediaTrack) GetQualityForDimension(width, height uint32) tc.VideoQuality {
+	quality := tc.VideoQuality_HIGH
+	if t.Kind() == tc.TrackType_AUDIO {
+		return quality
+	}
+
+	trackInfo := t.ToProto()
+
+	// 竖屏时按宽度比较，横屏时按高度比较
+	portrait := trackInfo.Height > trackInfo.Width
+	requestedSize, mediaSize := height, trackInfo.Height
+	if portrait {
+		requestedSize, mediaSize = width, trackInfo.Width
+	}
+
+	// 没有层信息时使用默认的低、中、高三层，发布尺寸小于默认层时不超过发布尺寸，保证尺寸单调不减
+	layerSizes := []uint32{180, 360, mediaSize}
+	if mediaSize != 0 {
+		for i, size := range layerSizes {
+			if size > mediaSize {
+				layerSizes[i] = mediaSize
+			}
+		}
+	}
+	layerQualities := []tc.VideoQuality{tc.VideoQuality_LOW, tc.VideoQuality_MEDIUM, tc.VideoQuality_HIGH}
+	if len(trackInfo.Layers) != 0 {
+		layers := make([]*tc.VideoLayer, len(trackInfo.Layers))
+		copy(layers, trackInfo.Layers)
+		sort.Slice(layers, func(i, j int) bool { return layers[i].Quality < layers[j].Quality })
+
+		layerSizes = layerSizes[:0]
+		layerQualities = layerQualities[:0]
+		for _, layer := range layers {
+			size := layer.Height
+			if portrait {
+				size = layer.Width
+			}
+			layerSizes = append(layerSizes, size)
+			layerQualities = append(layerQualities, layer.Quality)
+		}
+	}
+
+	requestedSize = uint32(float32(requestedSize) * layerSelectionTolerance)
+	for i, size := range layerSizes {
+		quality = layerQualities[i]
+		if size >= requestedSize {
+			break
+		}
+	}
+	return quality
+}
+
+// OnTrackSubscribed 音轨被订阅事件
+func (t *MediaTrack) OnTrackSubscribed(f func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.onTrackSubscribed = f
+}
+
+// AddOnClose 添加关闭事件
+func (t *MediaTrack) AddOnClose(f func()) {
+	if f == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.onClose = append(t.onClose, f)
+}
+
+// IsOpen 音轨是否仍然可用
+func (t *MediaTrack) IsOpen() bool {
+	return !t.closed.Load()
+}
+
+// Close 关闭音轨及其全部订阅
+func (t *MediaTrack) Close(willBeResumed bool) {
+	if t.closed.Swap(true) {
+		return
+	}
+
+	t.lock.Lock()
+	subscribers := t.subscribersLocked()
+	onClose := t.onClose
+	t.onClose = nil
+	t.lock.Unlock()
+
+	for _, st := range subscribers {
+		st.DownTrack().CloseWithFlush(!willBeResumed)
+	}
+
+	prometheus.SubPublishedTrack(t.Kind())
+	for _, f := range onClose {
+		f()
+	}
+	t.params.Logger.Debugw("closed track", "willBeResumed", willBeResumed)
+}
+
+func (t *MediaTrack) subscribersLocked() []types.SubscribedTrack {
+	subscribers := make([]types.SubscribedTrack, 0, len(t.subscribers))
+	for _, st := range t.subscribers {
+		subscribers = append(subscribers, st)
+	}
+	return subscribers
+}
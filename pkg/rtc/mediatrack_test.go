@@ -0,0 +1,270 @@
+package rtc
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+	"github.com/liuhailove/tc-server/pkg/sfu"
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+	"github.com/liuhailove/tc-server/pkg/sfu/connectionquality"
+	"github.com/liuhailove/tc-server/pkg/sfu/pacer"
+)
+
+// fakeReceiver 只实现创建和挂载下行音轨需要的方法
+type fakeReceiver struct {
+	sfu.TrackReceiver
+
+	trackID tc.TrackID
+	codec   webrtc.RTPCodecParameters
+
+	lock       sync.Mutex
+	downTracks map[tc.ParticipantID]sfu.TrackSender
+	addErr     error
+	score      float32
+}
+
+func newFakeReceiver(trackID tc.TrackID, mimeType string) *fakeReceiver {
+	return &fakeReceiver{
+		trackID: trackID,
+		codec: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: 90000},
+			PayloadType:        96,
+		},
+		downTracks: make(map[tc.ParticipantID]sfu.TrackSender),
+	}
+}
+
+func (r *fakeReceiver) TrackID() tc.TrackID                        { return r.trackID }
+func (r *fakeReceiver) Codec() webrtc.RTPCodecParameters           { return r.codec }
+func (r *fakeReceiver) SetUpTrackPaused(bool)                      {}
+func (r *fakeReceiver) GetLayeredBitrate() ([]int32, sfu.Bitrates) { return nil, sfu.Bitrates{} }
+func (r *fakeReceiver) SendPLI(int32, bool)                        {}
+func (r *fakeReceiver) GetConnectionScoreAndQuality() (float32, connectionquality.Quality) {
+	return r.score, connectionquality.Score2Quality(r.score)
+}
+
+func (r *fakeReceiver) AddDownTrack(track sfu.TrackSender) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.addErr != nil {
+		return r.addErr
+	}
+	r.downTracks[track.SubscriberID()] = track
+	return nil
+}
+
+func (r *fakeReceiver) DeleteDownTrack(subID tc.ParticipantID) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.downTracks, subID)
+}
+
+func (r *fakeReceiver) numDownTracks() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.downTracks)
+}
+
+// fakeSubscriber 只实现订阅需要的方法，下行音轨添加到本地的 PeerConnection 上
+type fakeSubscriber struct {
+	types.LocalParticipant
+
+	id       tc.ParticipantID
+	identity tc.ParticipantIdentity
+	pc       *webrtc.PeerConnection
+	closed   atomic.Bool
+
+	// noTransceiver 模拟添加音轨后拿不到收发器
+	noTransceiver bool
+	removed       atomic.Int32
+}
+
+func newFakeSubscriber(t *testing.T, id tc.ParticipantID) *fakeSubscriber {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = pc.Close()
+	})
+	return &fakeSubscriber{id: id, identity: tc.ParticipantIdentity(id), pc: pc}
+}
+
+func (s *fakeSubscriber) ID() tc.ParticipantID                    { return s.id }
+func (s *fakeSubscriber) Identity() tc.ParticipantIdentity        { return s.identity }
+func (s *fakeSubscriber) IsClosed() bool                          { return s.closed.Load() }
+func (s *fakeSubscriber) SupportSyncStreamID() bool               { return false }
+func (s *fakeSubscriber) GetBufferFactory() *buffer.Factory       { return nil }
+func (s *fakeSubscriber) GetPlayoutDelayConfig() *tc.PlayoutDelay { return nil }
+func (s *fakeSubscriber) GetPacer() pacer.Pacer                   { return nil }
+func (s *fakeSubscriber) GetLogger() logger.Logger                { return logger.GetLogger() }
+func (s *fakeSubscriber) GetTrailer() []byte                      { return nil }
+
+func (s *fakeSubscriber) GetCachedDownTrack(tc.TrackID) (*webrtc.RTPTransceiver, sfu.DownTrackState, bool) {
+	return nil, sfu.DownTrackState{}, false
+}
+
+func (s *fakeSubscriber) CacheDownTrack(tc.TrackID, *webrtc.RTPTransceiver, sfu.DownTrackState) {}
+
+func (s *fakeSubscriber) AddTrackToSubscriber(trackLocal webrtc.TrackLocal, _ types.AddTrackParams) (*webrtc.RTPSender, *webrtc.RTPTransceiver, error) {
+	sender, err := s.pc.AddTrack(trackLocal)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.noTransceiver {
+		return sender, nil, nil
+	}
+	for _, tr := range s.pc.GetTransceivers() {
+		if tr.Sender() == sender {
+			return sender, tr, nil
+		}
+	}
+	return sender, nil, nil
+}
+
+func (s *fakeSubscriber) RemoveTrackFromSubscriber(sender *webrtc.RTPSender) error {
+	s.removed.Inc()
+	return s.pc.RemoveTrack(sender)
+}
+
+func newTestMediaTrack(info *tc.TrackInfo) *MediaTrack {
+	return NewMediaTrack(MediaTrackParams{
+		TrackInfo:           info,
+		ParticipantID:       "PA_publisher",
+		ParticipantIdentity: "publisher",
+	})
+}
+
+func TestMediaTrackAddSubscriber(t *testing.T) {
+	t.Run("subscribe and unsubscribe", func(t *testing.T) {
+		mt := newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO})
+		receiver := newFakeReceiver("TR_video", webrtc.MimeTypeVP8)
+		mt.AddReceiver(receiver)
+		sub := newFakeSubscriber(t, "PA_sub")
+
+		st, err := mt.AddSubscriber(sub)
+		require.NoError(t, err)
+		require.True(t, mt.IsSubscriber("PA_sub"))
+		require.Equal(t, 1, receiver.numDownTracks())
+
+		existing, err := mt.AddSubscriber(sub)
+		require.ErrorIs(t, err, ErrAlreadySubscribed)
+		require.Equal(t, st, existing)
+
+		mt.RemoveSubscriber("PA_sub", false)
+		require.False(t, mt.IsSubscriber("PA_sub"))
+		require.Equal(t, 0, receiver.numDownTracks())
+		require.Equal(t, int32(1), sub.removed.Load())
+	})
+
+	t.Run("concurrent adds create one subscription", func(t *testing.T) {
+		mt := newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO})
+		mt.AddReceiver(newFakeReceiver("TR_video", webrtc.MimeTypeVP8))
+		sub := newFakeSubscriber(t, "PA_sub")
+
+		var wg sync.WaitGroup
+		var added atomic.Int32
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := mt.AddSubscriber(sub); err == nil {
+					added.Inc()
+				} else {
+					require.ErrorIs(t, err, ErrAlreadySubscribed)
+				}
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int32(1), added.Load())
+		require.Equal(t, 1, mt.GetNumSubscribers())
+		require.Len(t, sub.pc.GetSenders(), 1)
+	})
+
+	t.Run("missing transceiver releases sender", func(t *testing.T) {
+		mt := newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO})
+		receiver := newFakeReceiver("TR_video", webrtc.MimeTypeVP8)
+		mt.AddReceiver(receiver)
+		sub := newFakeSubscriber(t, "PA_sub")
+		sub.noTransceiver = true
+
+		_, err := mt.AddSubscriber(sub)
+		require.ErrorIs(t, err, ErrNoTransceiver)
+		require.Equal(t, int32(1), sub.removed.Load())
+		require.Zero(t, mt.GetNumSubscribers())
+		require.Zero(t, receiver.numDownTracks())
+
+		// 失败之后可以重新订阅
+		sub.noTransceiver = false
+		_, err = mt.AddSubscriber(sub)
+		require.NoError(t, err)
+	})
+
+	t.Run("receiver failure detaches added receivers", func(t *testing.T) {
+		mt := newTestMediaTrack(&tc.TrackInfo{
+			Sid:    "TR_video",
+			Type:   tc.TrackType_VIDEO,
+			Codecs: []*tc.SimulcastCodecInfo{{MimeType: webrtc.MimeTypeVP8}, {MimeType: webrtc.MimeTypeH264}},
+		})
+		vp8 := newFakeReceiver("TR_video", webrtc.MimeTypeVP8)
+		h264 := newFakeReceiver("TR_video", webrtc.MimeTypeH264)
+		h264.addErr = errors.New("receiver closed")
+		mt.AddReceiver(vp8)
+		mt.AddReceiver(h264)
+
+		_, err := mt.AddSubscriber(newFakeSubscriber(t, "PA_sub"))
+		require.Error(t, err)
+		require.Zero(t, vp8.numDownTracks())
+		require.Zero(t, mt.GetNumSubscribers())
+	})
+
+	t.Run("closed track", func(t *testing.T) {
+		mt := newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO})
+		mt.AddReceiver(newFakeReceiver("TR_video", webrtc.MimeTypeVP8))
+		mt.Close(false)
+
+		_, err := mt.AddSubscriber(newFakeSubscriber(t, "PA_sub"))
+		require.ErrorIs(t, err, ErrTrackClosed)
+	})
+}
+
+func TestMediaTrackGetQualityForDimension(t *testing.T) {
+	t.Run("default layers", func(t *testing.T) {
+		mt := newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO, Width: 1280, Height: 720})
+		require.Equal(t, tc.VideoQuality_LOW, mt.GetQualityForDimension(320, 180))
+		require.Equal(t, tc.VideoQuality_MEDIUM, mt.GetQualityForDimension(640, 360))
+		require.Equal(t, tc.VideoQuality_HIGH, mt.GetQualityForDimension(1280, 720))
+	})
+
+	t.Run("default layers of small media are monotonic", func(t *testing.T) {
+		mt := newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO, Width: 320, Height: 240})
+		require.Equal(t, tc.VideoQuality_LOW, mt.GetQualityForDimension(160, 120))
+		require.Equal(t, tc.VideoQuality_MEDIUM, mt.GetQualityForDimension(320, 240))
+		// 超过发布尺寸的请求选择最高层，而不是名义上 360 的中间层
+		require.Equal(t, tc.VideoQuality_HIGH, mt.GetQualityForDimension(400, 300))
+		require.Equal(t, tc.VideoQuality_HIGH, mt.GetQualityForDimension(1280, 720))
+	})
+
+	t.Run("published layers in portrait", func(t *testing.T) {
+		mt := newTestMediaTrack(&tc.TrackInfo{
+			Sid:    "TR_video",
+			Type:   tc.TrackType_VIDEO,
+			Width:  720,
+			Height: 1280,
+			Layers: []*tc.VideoLayer{
+				{Quality: tc.VideoQuality_HIGH, Width: 720, Height: 1280},
+				{Quality: tc.VideoQuality_LOW, Width: 180, Height: 320},
+				{Quality: tc.VideoQuality_MEDIUM, Width: 360, Height: 640},
+			},
+		})
+		require.Equal(t, tc.VideoQuality_LOW, mt.GetQualityForDimension(180, 320))
+		require.Equal(t, tc.VideoQuality_MEDIUM, mt.GetQualityForDimension(360, 640))
+		require.Equal(t, tc.VideoQuality_HIGH, mt.GetQualityForDimension(700, 1280))
+	})
+}
@@ -0,0 +1,1678 @@
+package rtc
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+	"github.com/liuhailove/tc-server/pkg/sfu"
+	"github.com/liuhailove/tc-server/pkg/sfu/audio"
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+	"github.com/liuhailove/tc-server/pkg/sfu/connectionquality"
+	"github.com/liuhailove/tc-server/pkg/sfu/pacer"
+	"github.com/liuhailove/tc-server/pkg/sfu/streamallocator"
+	sutils "github.com/liuhailove/tc-server/pkg/sfu/utils"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+	serverutils "github.com/liuhailove/tc-server/pkg/utils"
+)
+
+const (
+	// migrationTimeout 开始迁移后等待客户端迁移完成的时间
+	migrationTimeout = 15 * time.Second
+
+	// MaxReliableDataPacketSize 可靠数据包的最大长度
+	MaxReliableDataPacketSize = 15 * 1024
+	// MaxLossyDataPacketSize 有损数据包的最大长度，超过一个 MTU 的有损数据在弱网下几乎无法送达
+	MaxLossyDataPacketSize = 1300
+)
+
+// pendingTrackInfo 客户端请求发布但还没有收到媒体的音轨
+type pendingTrackInfo struct {
+	trackInfo *tc.TrackInfo
+}
+
+// ParticipantParams 参与者参数
+type ParticipantParams struct {
+	Identity                     tc.ParticipantIdentity
+	Name                         tc.ParticipantName
+	SID                          tc.ParticipantID
+	Config                       *WebRTCConfig
+	Sink                         routing.MessageSink
+	AudioConfig                  config.AudioConfig
+	VideoConfig                  config.VideoConfig
+	ProtocolVersion              types.ProtocolVersion
+	EnabledCodecs                []*tc.Codec
+	Logger                       logger.Logger
+	Grants                       *auth.ClaimGrants
+	InitialVersion               uint32
+	ClientConf                   *tc.ClientConfiguration
+	ClientInfo                   *tc.ClientInfo
+	Region                       string
+	AdaptiveStream               bool
+	Trailer                      []byte
+	PlayoutDelay                 *tc.PlayoutDelay
+	SyncStreams                  bool
+	SubscriberAllowPause         bool
+	ReconnectOnPublicationError  bool
+	ReconnectOnSubscriptionError bool
+	SubscriptionLimitVideo       int32
+	SubscriptionLimitAudio       int32
+	// Limit 节点的发布限制，超过时拒绝新的发布
+	Limit config.LimitConfig
+	// APIKey 签发参与者 token 的 API key，刷新 token 时使用同一个 key 重新签名
+	APIKey string
+	// KeyProvider 获取 API key 对应的密钥，为 nil 时不刷新 token
+	KeyProvider auth.KeyProvider
+	// TokenExpiry 客户端加入时使用的 token 的过期时间，为零时按照默认有效期计算
+	TokenExpiry time.Time
+	// Migration 为 true 表示这是从其他节点迁移过来的会话
+	Migration        bool
+	VersionGenerator utils.TimedVersionGenerator
+	// TrackResolver 根据音轨ID查找房间中发布的音轨，用于订阅
+	TrackResolver types.MediaTrackResolver
+	// GetParticipantInfo 获取房间中其他参与者的最新信息
+	GetParticipantInfo func(pID tc.ParticipantID) *tc.ParticipantInfo
+}
+
+// ParticipantImpl 基于 pion PeerConnection 的本地参与者，发布和订阅分别使用独立的连接
+type ParticipantImpl struct {
+	params ParticipantParams
+
+	isClosed  atomic.Bool
+	closeCh   chan struct{}
+	state     atomic.Value // tc.ParticipantInfo_State
+	resSinkMu sync.Mutex
+	resSink   routing.MessageSink
+
+	publisher           *PCTransport
+	subscriber          *PCTransport
+	subscriberAsPrimary bool
+	bufferFactory       *buffer.Factory
+	pacer               pacer.Pacer
+	// streamAllocator 开启拥塞控制时按下行带宽分配订阅的视频
+	streamAllocator *streamallocator.StreamAllocator
+
+	*UpTrackManager
+
+	// 客户端请求发布、等待媒体到达的音轨，以 cid 为键
+	pendingTracksLock sync.RWMutex
+	pendingTracks     map[string]*pendingTrackInfo
+	// 已经发布的音轨，以客户端的音轨ID和各编解码器的 cid 为键，联播的其他层和编解码器据此找到音轨
+	publishedTrackCids map[string]tc.TrackID
+
+	*SubscriptionManager
+
+	// 已经发送给客户端的其他参与者的版本
+	updateLock  sync.Mutex
+	updateCache map[tc.ParticipantID]uint32
+	// 旧协议客户端只接收完整的发言者列表和有变化的连接质量，这里记录已经发送的状态
+	activeSpeakers    map[tc.ParticipantID]*tc.SpeakerInfo
+	connectionQuality map[tc.ParticipantID]tc.ConnectionQuality
+
+	lock             sync.RWMutex
+	grants           *auth.ClaimGrants
+	attributes       map[string]string
+	dataTopics       map[string]struct{} // nil 表示接收所有主题
+	isPublisher      atomic.Bool
+	version          atomic.Uint32
+	timedVersion     utils.TimedVersion
+	iceConfig        *tc.ICEConfig
+	migrateState     atomic.Value // types.MigrateState
+	cachedDownTracks map[tc.TrackID]*downTrackState
+
+	connectedAt     time.Time
+	lastSeenSignal  atomic.Value // time.Time
+	signalValid     atomic.Bool
+	mediaRTT        atomic.Uint32
+	signalRTT       atomic.Uint32
+	allowPause      atomic.Bool
+	channelCapacity atomic.Int64
+	tokenExpiry     atomic.Time // 客户端当前 token 的过期时间
+
+	// 状态变化事件按顺序在队列中回调
+	stateChangeQueue *serverutils.OpsQueue
+
+	// 回调
+	cbLock               sync.RWMutex
+	onStateChange        func(p types.LocalParticipant, oldState tc.ParticipantInfo_State)
+	onMigrateStateChange func(p types.LocalParticipant, migrateState types.MigrateState)
+	onTrackPublished     func(types.LocalParticipant, types.MediaTrack)
+	onTrackUpdated       func(types.LocalParticipant, types.MediaTrack)
+	onTrackUnpublished   func(types.LocalParticipant, types.MediaTrack)
+	onParticipantUpdate  func(types.LocalParticipant)
+	onDataPacket         func(types.LocalParticipant, *tc.DataPacket)
+	onAttributesChanged  func(types.LocalParticipant, *types.ParticipantAttributesDelta)
+	onClose              func(types.LocalParticipant)
+	onClaimsChanged      func(types.LocalParticipant)
+	onICEConfigChanged   func(participant types.LocalParticipant, iceConfig *tc.ICEConfig)
+}
+
+// downTrackState 迁移时缓存的下行音轨状态
+type downTrackState struct {
+	transceiver    *webrtc.RTPTransceiver
+	downTrackState sfu.DownTrackState
+}
+
+// NewParticipant 创建参与者
+func NewParticipant(params ParticipantParams) (*ParticipantImpl, error) {
+	if params.Identity == "" {
+		return nil, ErrEmptyIdentity
+	}
+	if params.SID == "" {
+		return nil, ErrEmptyParticipantID
+	}
+	if params.Grants == nil || params.Grants.Video == nil {
+		return nil, ErrMissingGrants
+	}
+	if params.Logger == nil {
+		params.Logger = logger.GetLogger()
+	}
+	if params.VersionGenerator == nil {
+		params.VersionGenerator = utils.NewDefaultTimedVersionGenerator()
+	}
+
+	p := &ParticipantImpl{
+		params:             params,
+		closeCh:            make(chan struct{}),
+		grants:             params.Grants.Clone(),
+		attributes:         make(map[string]string),
+		bufferFactory:      params.Config.BufferFactory.CreateBufferFactory(),
+		pacer:              pacer.NewPassThrough(params.Logger),
+		pendingTracks:      make(map[string]*pendingTrackInfo),
+		publishedTrackCids: make(map[string]tc.TrackID),
+		updateCache:        make(map[tc.ParticipantID]uint32),
+		activeSpeakers:     make(map[tc.ParticipantID]*tc.SpeakerInfo),
+		connectionQuality:  make(map[tc.ParticipantID]tc.ConnectionQuality),
+		cachedDownTracks:   make(map[tc.TrackID]*downTrackState),
+		connectedAt:        time.Now(),
+	}
+	p.state.Store(tc.ParticipantInfo_JOINING)
+	p.stateChangeQueue = serverutils.NewOpsQueue(params.Logger, "state-change", 16)
+	p.stateChangeQueue.Start()
+	if params.Migration {
+		p.migrateState.Store(types.MigrateStateSync)
+	} else {
+		p.migrateState.Store(types.MigrateStateInit)
+	}
+	p.version.Store(params.InitialVersion)
+	p.timedVersion.Update(params.VersionGenerator.New())
+	p.lastSeenSignal.Store(time.Now())
+	p.signalValid.Store(true)
+	p.allowPause.Store(params.SubscriberAllowPause)
+	p.resSink = params.Sink
+
+	// 订阅者作为主连接需要客户端支持，并且参与者有订阅权限
+	p.subscriberAsPrimary = params.ProtocolVersion.SubscriberAsPrimary() && p.CanSubscribe()
+
+	p.UpTrackManager = NewUpTrackManager(UpTrackManagerParams{
+		SID:              params.SID,
+		Logger:           params.Logger,
+		VersionGenerator: params.VersionGenerator,
+	})
+	p.UpTrackManager.OnPublishedTrackUpdated(p.handleTrackUpdated)
+
+	p.SubscriptionManager = NewSubscriptionManager(SubscriptionManagerParams{
+		Participant:            p,
+		Logger:                 params.Logger.WithComponent("subscriptions"),
+		TrackResolver:          params.TrackResolver,
+		SubscriptionLimitVideo: params.SubscriptionLimitVideo,
+		SubscriptionLimitAudio: params.SubscriptionLimitAudio,
+		OnTrackSubscribed:      p.onTrackSubscribed,
+		OnTrackUnsubscribed:    p.onTrackUnsubscribed,
+		OnSubscriptionError:    p.onSubscriptionError,
+	})
+
+	if err := p.setupTransports(); err != nil {
+		// 连接创建失败时参与者不会被关闭，释放已经启动的协程
+		p.SubscriptionManager.Close(false)
+		p.stateChangeQueue.Stop()
+		return nil, err
+	}
+
+	// 连接创建成功之后才启动，失败时不会留下分配器的协程
+	if params.Config.CongestionControl.Enabled {
+		p.streamAllocator = streamallocator.NewStreamAllocator(streamallocator.Params{
+			Config: params.Config.CongestionControl,
+			Logger: params.Logger.WithComponent(sutils.ComponentCongestionControl),
+		})
+		p.streamAllocator.SetAllowPause(params.SubscriberAllowPause)
+		p.streamAllocator.Start()
+	}
+
+	if params.TokenExpiry.IsZero() {
+		p.tokenExpiry.Store(p.connectedAt.Add(tokenValidity))
+	} else {
+		p.tokenExpiry.Store(params.TokenExpiry)
+	}
+
+	go p.rtpStatsWorker()
+	go p.downTracksRTCPWorker()
+	go p.tokenRefreshWorker()
+
+	prometheus.AddParticipant()
+	prometheus.IncrementParticipantJoin(prometheus.JoinStateSignalConnected)
+	return p, nil
+}
+
+func (p *ParticipantImpl) setupTransports() error {
+	var err error
+	p.publisher, err = NewPCTransport(TransportParams{
+		ParticipantID:       p.params.SID,
+		ParticipantIdentity: p.params.Identity,
+		ProtocolVersion:     p.params.ProtocolVersion,
+		Config:              p.params.Config,
+		BufferFactory:       p.bufferFactory,
+		Target:              tc.SignalTarget_PUBLISHER,
+		EnabledCodecs:       p.params.EnabledCodecs,
+		Logger:              p.params.Logger.WithComponent("publisher"),
+	})
+	if err != nil {
+		return err
+	}
+
+	p.subscriber, err = NewPCTransport(TransportParams{
+		ParticipantID:       p.params.SID,
+		ParticipantIdentity: p.params.Identity,
+		ProtocolVersion:     p.params.ProtocolVersion,
+		Config:              p.params.Config,
+		BufferFactory:       p.bufferFactory,
+		Target:              tc.SignalTarget_SUBSCRIBER,
+		EnabledCodecs:       p.params.EnabledCodecs,
+		EnablePlayoutDelay:  p.params.PlayoutDelay != nil && p.params.PlayoutDelay.Enabled,
+		Logger:              p.params.Logger.WithComponent("subscriber"),
+	})
+	if err != nil {
+		p.publisher.Close()
+		return err
+	}
+
+	p.publisher.OnICECandidate(func(c *webrtc.ICECandidate) error {
+		return p.sendICECandidate(c, tc.SignalTarget_PUBLISHER)
+	})
+	p.subscriber.OnICECandidate(func(c *webrtc.ICECandidate) error {
+		return p.sendICECandidate(c, tc.SignalTarget_SUBSCRIBER)
+	})
+	p.subscriber.OnOffer(p.sendOffer)
+
+	p.publisher.OnTrack(p.onMediaTrack)
+	p.publisher.OnDataPacket(p.onDataPacketReceived)
+	p.subscriber.OnDataPacket(p.onDataPacketReceived)
+
+	primary, secondary := p.publisher, p.subscriber
+	if p.subscriberAsPrimary {
+		primary, secondary = p.subscriber, p.publisher
+	}
+	primary.OnFullyEstablished(p.onPrimaryTransportFullyEstablished)
+	primary.OnFailed(p.onTransportFailed)
+	secondary.OnFailed(p.onTransportFailed)
+	return nil
+}
+
+// ID 参与者ID
+func (p *ParticipantImpl) ID() tc.ParticipantID {
+	return p.params.SID
+}
+
+// Identity 参与者标识
+func (p *ParticipantImpl) Identity() tc.ParticipantIdentity {
+	return p.params.Identity
+}
+
+// State 参与者状态
+func (p *ParticipantImpl) State() tc.ParticipantInfo_State {
+	return p.state.Load().(tc.ParticipantInfo_State)
+}
+
+// ProtocolVersion 协议版本
+func (p *ParticipantImpl) ProtocolVersion() types.ProtocolVersion {
+	return p.params.ProtocolVersion
+}
+
+// GetTrailer 获取加密的尾部标记
+func (p *ParticipantImpl) GetTrailer() []byte {
+	trailer := make([]byte, len(p.params.Trailer))
+	copy(trailer, p.params.Trailer)
+	return trailer
+}
+
+// GetLogger 获取日志
+func (p *ParticipantImpl) GetLogger() logger.Logger {
+	return p.params.Logger
+}
+
+// GetAdaptiveStream 获取是否支持自适应流
+func (p *ParticipantImpl) GetAdaptiveStream() bool {
+	return p.params.AdaptiveStream
+}
+
+// SupportSyncStreamID 是否支持同步流ID，需要同时开启同步流并且客户端支持
+func (p *ParticipantImpl) SupportSyncStreamID() bool {
+	return p.params.SyncStreams && p.params.ProtocolVersion.SupportSyncStreamID()
+}
+
+// ConnectedAt 连接时间
+func (p *ParticipantImpl) ConnectedAt() time.Time {
+	return p.connectedAt
+}
+
+// GetClientInfo 获取客户端信息
+func (p *ParticipantImpl) GetClientInfo() *tc.ClientInfo {
+	return p.params.ClientInfo
+}
+
+// GetClientConfiguration 获取客户端配置
+func (p *ParticipantImpl) GetClientConfiguration() *tc.ClientConfiguration {
+	if p.params.ClientConf == nil {
+		return nil
+	}
+	return proto.Clone(p.params.ClientConf).(*tc.ClientConfiguration)
+}
+
+// GetICEConnectionType 获取主连接的ICE连接类型
+func (p *ParticipantImpl) GetICEConnectionType() types.ICEConnectionType {
+	return p.primaryTransport().GetICEConnectionType()
+}
+
+// GetBufferFactory 获取参与者的缓冲工厂
+func (p *ParticipantImpl) GetBufferFactory() *buffer.Factory {
+	return p.bufferFactory
+}
+
+// GetPlayoutDelayConfig 获取播放延迟配置
+func (p *ParticipantImpl) GetPlayoutDelayConfig() *tc.PlayoutDelay {
+	return p.params.PlayoutDelay
+}
+
+// GetPacer 获取发送器
+func (p *ParticipantImpl) GetPacer() pacer.Pacer {
+	return p.pacer
+}
+
+// ToProto 转化为协议对象
+func (p *ParticipantImpl) ToProto() *tc.ParticipantInfo {
+	pi, _ := p.ToProtoWithVersion()
+	return pi
+}
+
+// ToProtoWithVersion 转化为协议对象，同时返回对应的 TimedVersion
+func (p *ParticipantImpl) ToProtoWithVersion() (*tc.ParticipantInfo, utils.TimedVersion) {
+	p.lock.RLock()
+	grants := p.grants.Clone()
+	timedVersion := p.timedVersion.Load()
+	p.lock.RUnlock()
+
+	pi := &tc.ParticipantInfo{
+		Sid:         string(p.params.SID),
+		Identity:    string(p.params.Identity),
+		Name:        grants.Name,
+		State:       p.State(),
+		JoinedAt:    p.ConnectedAt().Unix(),
+		Version:     p.version.Load(),
+		Permission:  grants.Video.ToPermission(),
+		Metadata:    grants.Metadata,
+		Region:      p.params.Region,
+		IsPublisher: p.IsPublisher(),
+	}
+	pi.Tracks = p.UpTrackManager.ToProto()
+	return pi, timedVersion
+}
+
+// SetName 设置参与者名称
+func (p *ParticipantImpl) SetName(name string) {
+	p.lock.Lock()
+	if p.grants.Name == name {
+		p.lock.Unlock()
+		return
+	}
+	p.grants.Name = name
+	p.lock.Unlock()
+
+	p.dirty()
+	p.notifyParticipantUpdate()
+	p.notifyClaimsChanged()
+}
+
+// SetMetadata 设置元数据
+func (p *ParticipantImpl) SetMetadata(metadata string) {
+	p.lock.Lock()
+	if p.grants.Metadata == metadata {
+		p.lock.Unlock()
+		return
+	}
+	p.grants.Metadata = metadata
+	p.lock.Unlock()
+
+	p.dirty()
+	p.notifyParticipantUpdate()
+	p.notifyClaimsChanged()
+}
+
+// ClaimGrants 当前的授权
+func (p *ParticipantImpl) ClaimGrants() *auth.ClaimGrants {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.grants.Clone()
+}
+
+// SetPermission 更新参与者权限，权限没有变化时返回 false
+func (p *ParticipantImpl) SetPermission(permission *tc.ParticipantPermission) bool {
+	if permission == nil {
+		return false
+	}
+
+	p.lock.Lock()
+	video := p.grants.Video
+	if video.MatchesPermission(permission) {
+		p.lock.Unlock()
+		return false
+	}
+	p.params.Logger.Infow("updating participant permission", "permission", permission)
+	video.UpdateFromPermission(permission)
+	p.lock.Unlock()
+
+	p.enforcePermissions()
+
+	p.dirty()
+	p.notifyParticipantUpdate()
+	p.notifyClaimsChanged()
+	return true
+}
+
+// enforcePermissions 权限收回后立即生效，取消发布不再允许的来源并取消全部订阅，数据包在收到时检查权限
+func (p *ParticipantImpl) enforcePermissions() {
+	for _, track := range p.GetPublishedTracks() {
+		if !p.CanPublishSource(track.Source()) {
+			p.params.Logger.Infow("unpublishing track, permission revoked", "trackID", track.ID(), "source", track.Source())
+			p.RemovePublishedTrack(track, false, true)
+		}
+	}
+
+	var revoked []tc.TrackID
+	p.pendingTracksLock.Lock()
+	for cid, pti := range p.pendingTracks {
+		if !p.CanPublishSource(pti.trackInfo.Source) {
+			delete(p.pendingTracks, cid)
+			revoked = append(revoked, tc.TrackID(pti.trackInfo.Sid))
+		}
+	}
+	p.pendingTracksLock.Unlock()
+	if p.params.ProtocolVersion.SupportsUnpublish() {
+		for _, trackID := range revoked {
+			p.sendTrackUnpublished(trackID)
+		}
+	}
+
+	if !p.CanSubscribe() {
+		p.params.Logger.Infow("unsubscribing from all tracks, permission revoked")
+		p.SubscriptionManager.UnsubscribeFromAllTracks()
+	}
+}
+
+// CanPublishSource 是否可以发布指定来源的音轨
+func (p *ParticipantImpl) CanPublishSource(source tc.TrackSource) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.grants.Video.GetCanPublishSource(source)
+}
+
+// CanSubscribe 是否可以订阅
+func (p *ParticipantImpl) CanSubscribe() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.grants.Video.GetCanSubscribe()
+}
+
+// CanPublishData 是否可以发布数据
+func (p *ParticipantImpl) CanPublishData() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.grants.Video.GetCanPublishData()
+}
+
+// Hidden 是否对其他参与者隐藏
+func (p *ParticipantImpl) Hidden() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.grants.Video.Hidden
+}
+
+// IsRecorder 是否为录制者
+func (p *ParticipantImpl) IsRecorder() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.grants.Video.Recorder
+}
+
+// CanSkipBroadcast 隐藏的参与者不需要广播
+func (p *ParticipantImpl) CanSkipBroadcast() bool {
+	return p.Hidden()
+}
+
+// IsPublisher 是否发布过音轨
+func (p *ParticipantImpl) IsPublisher() bool {
+	return p.isPublisher.Load()
+}
+
+// IsClosed 是否已经关闭
+func (p *ParticipantImpl) IsClosed() bool {
+	return p.isClosed.Load()
+}
+
+// IsReady 是否就绪，JOINED 或者 ACTIVE 状态
+func (p *ParticipantImpl) IsReady() bool {
+	state := p.State()
+	return state == tc.ParticipantInfo_JOINED || state == tc.ParticipantInfo_ACTIVE
+}
+
+// IsDisconnected 是否已经断开
+func (p *ParticipantImpl) IsDisconnected() bool {
+	return p.State() == tc.ParticipantInfo_DISCONNECTED
+}
+
+// IsIdle 没有发布也没有订阅任何音轨
+func (p *ParticipantImpl) IsIdle() bool {
+	if len(p.GetPublishedTracks()) != 0 {
+		return false
+	}
+
+	return !p.SubscriptionManager.HasSubscriptions()
+}
+
+// SubscriberAsPrimary 订阅连接是否为主连接
+func (p *ParticipantImpl) SubscriberAsPrimary() bool {
+	return p.subscriberAsPrimary
+}
+
+// UpdateLastSeenSignal 更新最后收到信令的时间
+func (p *ParticipantImpl) UpdateLastSeenSignal() {
+	p.lastSeenSignal.Store(time.Now())
+}
+
+// LastSeenSignal 最后收到信令的时间
+func (p *ParticipantImpl) LastSeenSignal() time.Time {
+	return p.lastSeenSignal.Load().(time.Time)
+}
+
+// SetSignalSourceValid 设置信令源是否有效
+func (p *ParticipantImpl) SetSignalSourceValid(valid bool) {
+	p.signalValid.Store(valid)
+}
+
+// HandleSignalSourceClose 信令源关闭，没有等待恢复的会话时关闭参与者
+func (p *ParticipantImpl) HandleSignalSourceClose() {
+	p.SetResponseSink(nil)
+	if !p.signalValid.Load() {
+		return
+	}
+
+	if !p.primaryTransport().IsEstablished() {
+		p.params.Logger.Infow("signal source closed before connection established, closing participant")
+		_ = p.Close(false, types.ParticipantCloseReasonJoinFailed, false)
+	}
+}
+
+// Start 开始
+func (p *ParticipantImpl) Start() {
+	p.params.Logger.Debugw("starting participant", "protocol", p.params.ProtocolVersion, "subscriberPrimary", p.subscriberAsPrimary)
+}
+
+// Close 关闭参与者，sendLeave 为 true 时通知客户端离开
+func (p *ParticipantImpl) Close(sendLeave bool, reason types.ParticipantCloseReason, isExpectedToResume bool) error {
+	if p.isClosed.Swap(true) {
+		return nil
+	}
+	close(p.closeCh)
+
+	p.params.Logger.Infow("participant closing", "sendLeave", sendLeave, "reason", reason.String(), "isExpectedToResume", isExpectedToResume)
+	if sendLeave {
+		p.sendLeave(reason, isExpectedToResume)
+	}
+
+	p.pendingTracksLock.Lock()
+	p.pendingTracks = make(map[string]*pendingTrackInfo)
+	p.pendingTracksLock.Unlock()
+
+	// 先关闭发布的音轨，订阅者会收到取消发布的通知
+	p.UpTrackManager.Close(isExpectedToResume)
+
+	p.SubscriptionManager.Close(isExpectedToResume)
+
+	p.updateState(tc.ParticipantInfo_DISCONNECTED)
+	// 已经入队的状态变化仍然会回调
+	p.stateChangeQueue.Stop()
+	p.CloseSignalConnection(types.SignallingCloseReasonParticipantClose)
+
+	p.publisher.Close()
+	p.subscriber.Close()
+	p.pacer.Stop()
+	if p.streamAllocator != nil {
+		p.streamAllocator.Stop()
+	}
+
+	prometheus.SubParticipant()
+
+	p.cbLock.RLock()
+	onClose := p.onClose
+	p.cbLock.RUnlock()
+	if onClose != nil {
+		onClose(p)
+	}
+	return nil
+}
+
+// ---------------- 对等连接 ----------------
+
+// HandleOffer 处理发布连接的 offer
+func (p *ParticipantImpl) HandleOffer(offer webrtc.SessionDescription) {
+	p.params.Logger.Debugw("received offer", "transport", tc.SignalTarget_PUBLISHER)
+	answer, err := p.publisher.HandleOffer(offer)
+	if err != nil {
+		p.params.Logger.Warnw("could not handle offer", err)
+		p.IssueFullReconnect(types.ParticipantCloseReasonNegotiateFailed)
+		return
+	}
+
+	if err := p.sendAnswer(answer); err != nil {
+		p.params.Logger.Warnw("could not send answer", err)
+	}
+}
+
+// HandleAnswer 处理订阅连接的应答
+func (p *ParticipantImpl) HandleAnswer(answer webrtc.SessionDescription) {
+	if answer.Type != webrtc.SDPTypeAnswer {
+		return
+	}
+
+	p.params.Logger.Debugw("received answer", "transport", tc.SignalTarget_SUBSCRIBER)
+	if err := p.subscriber.HandleAnswer(answer); err != nil {
+		p.params.Logger.Warnw("could not handle answer", err)
+		p.IssueFullReconnect(types.ParticipantCloseReasonNegotiateFailed)
+	}
+}
+
+// AddICECandidate 添加 ICE 候选
+func (p *ParticipantImpl) AddICECandidate(candidate webrtc.ICECandidateInit, target tc.SignalTarget) {
+	transport := p.publisher
+	if target == tc.SignalTarget_SUBSCRIBER {
+		transport = p.subscriber
+	}
+	if err := transport.AddICECandidate(candidate); err != nil {
+		p.params.Logger.Warnw("could not add ICE candidate", err, "target", target)
+	}
+}
+
+// Negotiate 订阅连接协商
+func (p *ParticipantImpl) Negotiate(force bool) {
+	if p.IsClosed() {
+		return
+	}
+	p.subscriber.Negotiate(force)
+}
+
+// ICERestart 重启订阅连接的 ICE
+func (p *ParticipantImpl) ICERestart(iceConfig *tc.ICEConfig) {
+	if iceConfig != nil {
+		p.SetICEConfig(iceConfig)
+	}
+	p.subscriber.ICERestart()
+}
+
+// SetICEConfig 设置 ICE 配置
+func (p *ParticipantImpl) SetICEConfig(iceConfig *tc.ICEConfig) {
+	p.lock.Lock()
+	p.iceConfig = iceConfig
+	p.lock.Unlock()
+
+	p.cbLock.RLock()
+	onICEConfigChanged := p.onICEConfigChanged
+	p.cbLock.RUnlock()
+	if onICEConfigChanged != nil {
+		onICEConfigChanged(p, iceConfig)
+	}
+}
+
+// AddTrackToSubscriber 向订阅连接添加音轨，客户端支持时复用空闲的收发器
+func (p *ParticipantImpl) AddTrackToSubscriber(trackLocal webrtc.TrackLocal, params types.AddTrackParams) (*webrtc.RTPSender, *webrtc.RTPTransceiver, error) {
+	if !p.params.ProtocolVersion.SupportsTransceiverReuse() {
+		return p.AddTransceiverFromTrackToSubscriber(trackLocal, params)
+	}
+	sender, transceiver, err := p.subscriber.AddTrack(trackLocal)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.addToStreamAllocator(trackLocal)
+	return sender, transceiver, nil
+}
+
+// AddTransceiverFromTrackToSubscriber 总是为音轨创建新的收发器
+func (p *ParticipantImpl) AddTransceiverFromTrackToSubscriber(trackLocal webrtc.TrackLocal, _ types.AddTrackParams) (*webrtc.RTPSender, *webrtc.RTPTransceiver, error) {
+	sender, transceiver, err := p.subscriber.AddTransceiverFromTrack(trackLocal)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.addToStreamAllocator(trackLocal)
+	return sender, transceiver, nil
+}
+
+// RemoveTrackFromSubscriber 从订阅连接移除音轨
+func (p *ParticipantImpl) RemoveTrackFromSubscriber(sender *webrtc.RTPSender) error {
+	if p.streamAllocator != nil {
+		if dt, ok := sender.Track().(*sfu.DownTrack); ok {
+			p.streamAllocator.RemoveTrack(dt)
+		}
+	}
+	if err := p.subscriber.RemoveTrack(sender); err != nil {
+		return err
+	}
+	p.Negotiate(false)
+	return nil
+}
+
+// addToStreamAllocator 订阅的视频由流分配器选择转发的层，关闭的下行由流分配器自行清理
+func (p *ParticipantImpl) addToStreamAllocator(trackLocal webrtc.TrackLocal) {
+	if p.streamAllocator == nil {
+		return
+	}
+	if dt, ok := trackLocal.(*sfu.DownTrack); ok {
+		p.streamAllocator.AddTrack(dt)
+	}
+}
+
+// ---------------- 发布 ----------------
+
+// AddTrack 客户端请求发布音轨，先分配音轨ID，媒体到达后再创建 MediaTrack
+func (p *ParticipantImpl) AddTrack(req *tc.AddTrackRequest) {
+	if !p.CanPublishSource(req.Source) {
+		p.params.Logger.Warnw("no permission to publish track", nil, "cid", req.Cid, "source", req.Source)
+		return
+	}
+	if err := CheckLimits(p.params.Limit); err != nil {
+		p.params.Logger.Infow("rejecting track, node limit exceeded", "cid", req.Cid, "error", err)
+		p.sendTrackPublishRejected(req.Cid)
+		return
+	}
+
+	p.pendingTracksLock.Lock()
+	ti := p.addPendingTrackLocked(req)
+	p.pendingTracksLock.Unlock()
+	if ti == nil {
+		return
+	}
+
+	p.sendTrackPublished(req.Cid, ti)
+}
+
+func (p *ParticipantImpl) addPendingTrackLocked(req *tc.AddTrackRequest) *tc.TrackInfo {
+	if req.Cid == "" {
+		p.params.Logger.Warnw("track request without cid", nil, "name", req.Name)
+		return nil
+	}
+	if _, ok := p.pendingTracks[req.Cid]; ok {
+		p.params.Logger.Infow("track already pending", "cid", req.Cid)
+		return nil
+	}
+
+	ti := &tc.TrackInfo{
+		Sid:        utils.NewGuid(utils.TrackPrefix),
+		Type:       req.Type,
+		Name:       req.Name,
+		Width:      req.Width,
+		Height:     req.Height,
+		Muted:      req.Muted,
+		DisableDtx: req.DisableDtx,
+		Source:     req.Source,
+		Layers:     req.Layers,
+		Stereo:     req.Stereo,
+		DisableRed: req.DisableRed,
+		Encryption: req.Encryption,
+		Stream:     req.Stream,
+	}
+	for _, codec := range req.SimulcastCodecs {
+		ti.Codecs = append(ti.Codecs, &tc.SimulcastCodecInfo{
+			MimeType: codec.Codec,
+			Cid:      codec.Cid,
+		})
+	}
+	ti.Simulcast = len(req.Layers) > 1
+
+	p.pendingTracks[req.Cid] = &pendingTrackInfo{trackInfo: ti}
+	return proto.Clone(ti).(*tc.TrackInfo)
+}
+
+// getPendingTrackLocked 根据客户端的音轨ID查找等待中的音轨，找不到时按类型匹配第一个
+func (p *ParticipantImpl) getPendingTrackLocked(clientID string, kind tc.TrackType) (string, *tc.TrackInfo) {
+	if pti, ok := p.pendingTracks[clientID]; ok {
+		return clientID, pti.trackInfo
+	}
+	for cid, pti := range p.pendingTracks {
+		for _, codec := range pti.trackInfo.Codecs {
+			if codec.Cid == clientID {
+				return cid, pti.trackInfo
+			}
+		}
+	}
+	for cid, pti := range p.pendingTracks {
+		if pti.trackInfo.Type == kind {
+			return cid, pti.trackInfo
+		}
+	}
+	return "", nil
+}
+
+func (p *ParticipantImpl) onMediaTrack(track *webrtc.TrackRemote, rtpReceiver *webrtc.RTPReceiver) {
+	if p.IsClosed() {
+		return
+	}
+
+	kind := tc.TrackType_AUDIO
+	if track.Kind() == webrtc.RTPCodecTypeVideo {
+		kind = tc.TrackType_VIDEO
+	}
+
+	p.pendingTracksLock.Lock()
+	// 联播的其他层和其他编解码器属于已经发布的音轨
+	var mt *MediaTrack
+	if trackID, ok := p.publishedTrackCids[track.ID()]; ok {
+		mt, _ = p.GetPublishedTrack(trackID).(*MediaTrack)
+	}
+	newTrack := mt == nil
+	if newTrack {
+		cid, ti := p.getPendingTrackLocked(track.ID(), kind)
+		if ti == nil {
+			p.pendingTracksLock.Unlock()
+			p.params.Logger.Warnw("no pending track for media", nil, "trackID", track.ID(), "rid", track.RID(), "kind", kind)
+			return
+		}
+
+		ti.MimeType = track.Codec().MimeType
+		ti.Mid = p.getMid(rtpReceiver)
+		mt = NewMediaTrack(MediaTrackParams{
+			TrackInfo:           ti,
+			ParticipantID:       p.params.SID,
+			ParticipantIdentity: p.params.Identity,
+			ParticipantVersion:  p.version.Load(),
+			Logger:              p.params.Logger,
+		})
+		p.publishedTrackCids[cid] = mt.ID()
+		p.publishedTrackCids[track.ID()] = mt.ID()
+		for _, codec := range ti.Codecs {
+			if codec.Cid != "" {
+				p.publishedTrackCids[codec.Cid] = mt.ID()
+			}
+		}
+	}
+	p.pendingTracksLock.Unlock()
+
+	buff, rtcpReader := p.bufferFactory.GetBufferPair(uint32(track.SSRC()))
+	if buff == nil {
+		p.params.Logger.Warnw("no buffer for media", nil, "trackID", track.ID(), "ssrc", track.SSRC())
+		return
+	}
+	buff.SetLogger(p.params.Logger.WithValues("trackID", mt.ID(), "rid", track.RID()))
+	buff.SetAudioLevelParams(audio.AudioLevelParams{
+		ActiveLevel:     p.params.AudioConfig.ActiveLevel,
+		MinPercentile:   p.params.AudioConfig.MinPercentile,
+		ObserveDuration: p.params.AudioConfig.UpdateInterval,
+		SmoothIntervals: p.params.AudioConfig.SmoothIntervals,
+	})
+	buff.Bind(rtpReceiver.GetParameters(), track.Codec().RTPCodecCapability)
+	if rtcpReader != nil {
+		ssrc := uint32(track.SSRC())
+		rtcpReader.OnPacket(func(bytes []byte) {
+			pkts, err := rtcp.Unmarshal(bytes)
+			if err != nil {
+				return
+			}
+			for _, pkt := range pkts {
+				if sr, ok := pkt.(*rtcp.SenderReport); ok && sr.SSRC == ssrc {
+					buff.SetSenderReportData(sr.RTPTime, sr.NTPTime)
+				}
+			}
+		})
+	}
+
+	if existing, ok := mt.Receiver(track.Codec().MimeType).(*sfu.WebRTCReceiver); ok {
+		// 同一个编解码器的其他联播层
+		existing.AddUpTrack(track, buff)
+	} else {
+		receiver := sfu.NewWebRTCReceiver(rtpReceiver, track, mt.ToProto(), p.params.Logger, p.writePublisherRTCP)
+		receiver.AddUpTrack(track, buff)
+		mt.AddReceiver(receiver)
+	}
+
+	// 全部编解码器都到达之后不再等待
+	if mt.HasAllReceivers() {
+		p.pendingTracksLock.Lock()
+		for cid, pti := range p.pendingTracks {
+			if pti.trackInfo.Sid == string(mt.ID()) {
+				delete(p.pendingTracks, cid)
+			}
+		}
+		p.pendingTracksLock.Unlock()
+	}
+
+	if newTrack {
+		p.handleTrackPublished(mt)
+	}
+}
+
+// writePublisherRTCP 向发布者发送接收报告、NACK 和 PLI
+func (p *ParticipantImpl) writePublisherRTCP(pkts []rtcp.Packet) {
+	if err := p.publisher.WriteRTCP(pkts); err != nil && !errors.Is(err, ErrTransportClosed) {
+		p.params.Logger.Debugw("could not write rtcp to publisher", "error", err)
+	}
+}
+
+// writeSubscriberRTCP 向订阅者发送下行音轨的发送者报告
+func (p *ParticipantImpl) writeSubscriberRTCP(pkts []rtcp.Packet) {
+	if err := p.subscriber.WriteRTCP(pkts); err != nil && !errors.Is(err, ErrTransportClosed) {
+		p.params.Logger.Debugw("could not write rtcp to subscriber", "error", err)
+	}
+}
+
+func (p *ParticipantImpl) getMid(rtpReceiver *webrtc.RTPReceiver) string {
+	for _, tr := range p.publisher.pc.GetTransceivers() {
+		if tr.Receiver() == rtpReceiver {
+			return tr.Mid()
+		}
+	}
+	return ""
+}
+
+func (p *ParticipantImpl) handleTrackPublished(mt *MediaTrack) {
+	mt.AddOnClose(func() {
+		p.pendingTracksLock.Lock()
+		for cid, trackID := range p.publishedTrackCids {
+			if trackID == mt.ID() {
+				delete(p.publishedTrackCids, cid)
+			}
+		}
+		p.pendingTracksLock.Unlock()
+
+		if p.UpTrackManager.RemovePublishedTrack(mt, false, false) {
+			p.handleTrackUnpublished(mt)
+		}
+	})
+	p.UpTrackManager.AddPublishedTrack(mt)
+	p.isPublisher.Store(true)
+	p.dirty()
+
+	p.params.Logger.Infow("track published", "trackID", mt.ID(), "kind", mt.Kind(), "source", mt.Source())
+
+	p.cbLock.RLock()
+	onTrackPublished := p.onTrackPublished
+	p.cbLock.RUnlock()
+	if onTrackPublished != nil {
+		onTrackPublished(p, mt)
+	}
+}
+
+func (p *ParticipantImpl) handleTrackUnpublished(track types.MediaTrack) {
+	p.dirty()
+	if p.params.ProtocolVersion.SupportsUnpublish() {
+		p.sendTrackUnpublished(track.ID())
+	}
+
+	p.cbLock.RLock()
+	onTrackUnpublished := p.onTrackUnpublished
+	p.cbLock.RUnlock()
+	if onTrackUnpublished != nil {
+		onTrackUnpublished(p, track)
+	}
+}
+
+func (p *ParticipantImpl) handleTrackUpdated(track types.MediaTrack) {
+	p.dirty()
+
+	p.cbLock.RLock()
+	onTrackUpdated := p.onTrackUpdated
+	p.cbLock.RUnlock()
+	if onTrackUpdated != nil {
+		onTrackUpdated(p, track)
+	}
+}
+
+// RemovePublishedTrack 移除发布的音轨
+func (p *ParticipantImpl) RemovePublishedTrack(track types.MediaTrack, willBeResumed bool, shouldClose bool) {
+	if p.UpTrackManager.RemovePublishedTrack(track, willBeResumed, shouldClose) {
+		p.handleTrackUnpublished(track)
+	}
+}
+
+// SetTrackMuted 设置发布的音轨静音，管理员只能静音不能取消静音
+func (p *ParticipantImpl) SetTrackMuted(trackID tc.TrackID, muted bool, fromAdmin bool) {
+	if !muted && fromAdmin {
+		p.params.Logger.Infow("admin cannot unmute track", "trackID", trackID)
+		return
+	}
+
+	if track := p.UpTrackManager.SetPublishedTrackMuted(trackID, muted); track == nil {
+		// 媒体还没有到达，更新等待中的音轨
+		p.pendingTracksLock.Lock()
+		for _, pti := range p.pendingTracks {
+			if pti.trackInfo.Sid == string(trackID) {
+				pti.trackInfo.Muted = muted
+			}
+		}
+		p.pendingTracksLock.Unlock()
+	}
+
+	if fromAdmin {
+		p.sendTrackMuted(trackID, muted)
+	}
+}
+
+// UpdateVideoLayers 更新发布音轨的视频层
+func (p *ParticipantImpl) UpdateVideoLayers(updateVideoLayers *tc.UpdateVideoLayers) error {
+	return p.UpTrackManager.UpdateVideoLayers(updateVideoLayers)
+}
+
+// ---------------- 订阅 ----------------
+
+// SubscribeToTrack 订阅音轨，订阅由 SubscriptionManager 异步完成
+func (p *ParticipantImpl) SubscribeToTrack(trackID tc.TrackID) {
+	if !p.CanSubscribe() {
+		p.params.Logger.Debugw("no permission to subscribe", "trackID", trackID)
+		return
+	}
+	p.SubscriptionManager.SubscribeToTrack(trackID)
+}
+
+func (p *ParticipantImpl) onTrackSubscribed(_ types.SubscribedTrack) {
+	p.Negotiate(false)
+}
+
+func (p *ParticipantImpl) onTrackUnsubscribed(_ types.SubscribedTrack) {
+	if !p.IsClosed() {
+		p.Negotiate(false)
+	}
+}
+
+// onSubscriptionError 订阅最终失败，通知客户端，多次重试后仍然失败时根据配置要求客户端完全重连
+func (p *ParticipantImpl) onSubscriptionError(trackID tc.TrackID, fatal bool, err error) {
+	subErr := tc.SubscriptionError_SE_UNKNOWN
+	if errors.Is(err, ErrTrackNotFound) {
+		subErr = tc.SubscriptionError_SE_TRACK_NOTFOUND
+	}
+	_ = p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_SubscriptionResponse{
+			SubscriptionResponse: &tc.SubscriptionResponse{
+				TrackSid: string(trackID),
+				Err:      subErr,
+			},
+		},
+	})
+
+	if fatal && p.params.ReconnectOnSubscriptionError {
+		p.params.Logger.Infow("issuing full reconnect on subscription error", "trackID", trackID, "error", err)
+		p.IssueFullReconnect(types.ParticipantCloseReasonSubscriptionError)
+	}
+}
+
+// VerifySubscribeParticipantInfo 客户端没有发布者的最新信息时补发
+func (p *ParticipantImpl) VerifySubscribeParticipantInfo(pID tc.ParticipantID, version uint32) {
+	if !p.IsReady() || p.params.GetParticipantInfo == nil {
+		return
+	}
+
+	p.updateLock.Lock()
+	lastVersion, ok := p.updateCache[pID]
+	p.updateLock.Unlock()
+	if ok && lastVersion >= version {
+		return
+	}
+
+	if info := p.params.GetParticipantInfo(pID); info != nil {
+		_ = p.SendParticipantUpdate([]*tc.ParticipantInfo{info})
+	}
+}
+
+// ---------------- 数据 ----------------
+
+// SendDataPacket 通过主连接的数据通道发送数据
+func (p *ParticipantImpl) SendDataPacket(packet *tc.DataPacket, data []byte) error {
+	if p.State() != tc.ParticipantInfo_ACTIVE {
+		return ErrDataChannelUnavailable
+	}
+	if !p.params.ProtocolVersion.HandlesDataPackets() {
+		return nil
+	}
+
+	return p.primaryTransport().SendDataPacket(packet.Kind, data)
+}
+
+func (p *ParticipantImpl) onDataPacketReceived(kind tc.DataPacket_Kind, data []byte) {
+	if p.IsClosed() {
+		return
+	}
+	if err := ValidateDataPacketSize(kind, len(data)); err != nil {
+		p.params.Logger.Warnw("dropping data packet", err, "kind", kind, "size", len(data))
+		return
+	}
+
+	dp := &tc.DataPacket{}
+	if err := proto.Unmarshal(data, dp); err != nil {
+		p.params.Logger.Warnw("could not parse data packet", err)
+		return
+	}
+	dp.Kind = kind
+
+	// 订阅主题只影响自己接收的数据，不需要发布数据的权限
+	if up := dp.GetUser(); up != nil && up.Topic == DataSubscriptionTopic {
+		p.handleDataSubscription(up)
+		return
+	}
+	if !p.CanPublishData() {
+		return
+	}
+
+	switch payload := dp.Value.(type) {
+	case *tc.DataPacket_User:
+		if payload.User.Topic == ParticipantAttributesTopic {
+			p.handleAttributesPatch(payload.User)
+			return
+		}
+		// 发送者信息由服务端填写，防止冒充
+		payload.User.ParticipantSid = string(p.params.SID)
+		payload.User.ParticipantIdentity = string(p.params.Identity)
+	default:
+		p.params.Logger.Debugw("unsupported data packet", "payload", payload)
+		return
+	}
+
+	p.cbLock.RLock()
+	onDataPacket := p.onDataPacket
+	p.cbLock.RUnlock()
+	if onDataPacket != nil {
+		onDataPacket(p, dp)
+	}
+}
+
+// ValidateDataPacketSize 检查数据包长度是否超过对应类型的限制
+func ValidateDataPacketSize(kind tc.DataPacket_Kind, size int) error {
+	limit := MaxReliableDataPacketSize
+	if kind == tc.DataPacket_LOSSY {
+		limit = MaxLossyDataPacketSize
+	}
+	if size > limit {
+		return ErrDataPacketTooLarge
+	}
+	return nil
+}
+
+// ---------------- 状态 ----------------
+
+// GetAudioLevel 发布的音频中最大的音量
+func (p *ParticipantImpl) GetAudioLevel() (smoothLevel float64, active bool) {
+	for _, track := range p.GetPublishedTracks() {
+		if track.Kind() != tc.TrackType_AUDIO || track.IsMuted() {
+			continue
+		}
+		level, trackActive := track.GetAudioLevel()
+		if trackActive {
+			active = true
+			if level > smoothLevel {
+				smoothLevel = level
+			}
+		}
+	}
+	return
+}
+
+// GetConnectionQuality 获取连接质量，取发布和订阅的全部音轨中最差的一个，一个周期内没有收到数据的音轨分数为 0
+func (p *ParticipantImpl) GetConnectionQuality() *tc.ConnectionQualityInfo {
+	var scores []float32
+	for _, track := range p.GetPublishedTracks() {
+		for _, receiver := range track.Receivers() {
+			score, _ := receiver.GetConnectionScoreAndQuality()
+			scores = append(scores, score)
+		}
+	}
+	for _, st := range p.GetSubscribedTracks() {
+		if dt := st.DownTrack(); dt != nil {
+			score, _ := dt.GetConnectionScoreAndQuality()
+			scores = append(scores, score)
+		}
+	}
+
+	score, quality := connectionquality.AggregateScoreAndQuality(scores)
+	return connectionquality.ToConnectionQualityInfo(p.params.SID, score, quality)
+}
+
+// UpdateMediaRTT 更新媒体RTT
+func (p *ParticipantImpl) UpdateMediaRTT(rtt uint32) {
+	p.mediaRTT.Store(rtt)
+}
+
+// UpdateSignalRTT 更新信令RTT
+func (p *ParticipantImpl) UpdateSignalRTT(rtt uint32) {
+	p.signalRTT.Store(rtt)
+}
+
+// UpdateSubscribedQuality 订阅者需要的最高质量变化，通知客户端调整发布的层
+func (p *ParticipantImpl) UpdateSubscribedQuality(_ tc.NodeID, trackID tc.TrackID, maxQualities []types.SubscribedCodecQuality) error {
+	if p.GetPublishedTrack(trackID) == nil {
+		return ErrTrackNotFound
+	}
+
+	subscribedCodecs := make([]*tc.SubscribedCodec, 0, len(maxQualities))
+	for _, maxQuality := range maxQualities {
+		var qualities []*tc.SubscribedQuality
+		for q := tc.VideoQuality_LOW; q <= tc.VideoQuality_HIGH; q++ {
+			qualities = append(qualities, &tc.SubscribedQuality{
+				Quality: q,
+				Enabled: maxQuality.Quality != tc.VideoQuality_OFF && q <= maxQuality.Quality,
+			})
+		}
+		subscribedCodecs = append(subscribedCodecs, &tc.SubscribedCodec{
+			Codec:     maxQuality.CodecMime,
+			Qualities: qualities,
+		})
+	}
+
+	return p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_SubscribedQualityUpdate{
+			SubscribedQualityUpdate: &tc.SubscribedQualityUpdate{
+				TrackSid:         string(trackID),
+				SubscribedCodecs: subscribedCodecs,
+			},
+		},
+	})
+}
+
+// UpdateMediaLoss 订阅者的丢包率
+func (p *ParticipantImpl) UpdateMediaLoss(nodeID tc.NodeID, trackID tc.TrackID, fractionLoss uint32) error {
+	if p.GetPublishedTrack(trackID) == nil {
+		return ErrTrackNotFound
+	}
+	p.params.Logger.Debugw("media loss update", "nodeID", nodeID, "trackID", trackID, "fractionLoss", fractionLoss)
+	return nil
+}
+
+// SetSubscriberAllowPause 设置订阅者是否允许暂停
+func (p *ParticipantImpl) SetSubscriberAllowPause(allowPause bool) {
+	p.allowPause.Store(allowPause)
+	if p.streamAllocator != nil {
+		p.streamAllocator.SetAllowPause(allowPause)
+	}
+}
+
+// SetSubscriberChannelCapacity 设置订阅者的信道容量
+func (p *ParticipantImpl) SetSubscriberChannelCapacity(channelCapacity int64) {
+	p.channelCapacity.Store(channelCapacity)
+	if p.streamAllocator != nil {
+		p.streamAllocator.SetChannelCapacity(channelCapacity)
+	}
+}
+
+// OnReceiverReport 订阅者的接收报告，丢包和RTT由下行音轨的统计汇总到节点指标中
+func (p *ParticipantImpl) OnReceiverReport(dt *sfu.DownTrack, report *rtcp.ReceiverReport) {
+	p.params.Logger.Debugw("receiver report", "trackID", dt.ID(), "reports", len(report.Reports))
+}
+
+// DebugInfo 调试信息
+func (p *ParticipantImpl) DebugInfo() map[string]interface{} {
+	info := map[string]interface{}{
+		"ID":                  p.params.SID,
+		"State":               p.State().String(),
+		"ProtocolVersion":     p.params.ProtocolVersion,
+		"SubscriberAsPrimary": p.subscriberAsPrimary,
+	}
+
+	publishedTracks := make(map[string]interface{})
+	for _, track := range p.GetPublishedTracks() {
+		publishedTracks[string(track.ID())] = map[string]interface{}{
+			"Kind":        track.Kind().String(),
+			"Muted":       track.IsMuted(),
+			"Subscribers": track.GetNumSubscribers(),
+		}
+	}
+	info["PublishedTracks"] = publishedTracks
+
+	p.pendingTracksLock.RLock()
+	pendingTracks := make(map[string]interface{}, len(p.pendingTracks))
+	for cid, pti := range p.pendingTracks {
+		pendingTracks[cid] = pti.trackInfo.String()
+	}
+	p.pendingTracksLock.RUnlock()
+	info["PendingTracks"] = pendingTracks
+
+	subscribedTracks := make(map[string]interface{})
+	for _, st := range p.GetSubscribedTracks() {
+		if dt := st.DownTrack(); dt != nil {
+			subscribedTracks[string(st.ID())] = dt.DebugInfo()
+		}
+	}
+	info["SubscribedTracks"] = subscribedTracks
+
+	info["SubscribedParticipants"] = p.GetSubscribedParticipants()
+	return info
+}
+
+// ---------------- 迁移 ----------------
+
+// MaybeStartMigration 开始迁移，通知客户端重连到其他节点。
+// 非强制迁移需要连接已经建立，迁移超时后关闭当前会话
+func (p *ParticipantImpl) MaybeStartMigration(force bool, onStart func()) bool {
+	if !p.params.ProtocolVersion.SupportsSessionMigrate() || p.IsClosed() {
+		return false
+	}
+	if !force && !p.primaryTransport().IsEstablished() {
+		return false
+	}
+
+	p.params.Logger.Infow("starting migration", "force", force)
+	if onStart != nil {
+		onStart()
+	}
+
+	p.sendLeave(types.ParticipantCloseReasonMigrationRequested, true)
+	p.CloseSignalConnection(types.SignallingCloseReasonMigration)
+
+	time.AfterFunc(migrationTimeout, func() {
+		if p.IsClosed() {
+			return
+		}
+		p.params.Logger.Infow("migration timed out, closing participant")
+		_ = p.Close(false, types.ParticipantCloseReasonMigrationRequested, false)
+	})
+	return true
+}
+
+// SetMigrateState 设置迁移状态
+func (p *ParticipantImpl) SetMigrateState(s types.MigrateState) {
+	if p.MigrateState() == s {
+		return
+	}
+	p.migrateState.Store(s)
+
+	p.cbLock.RLock()
+	onMigrateStateChange := p.onMigrateStateChange
+	p.cbLock.RUnlock()
+	if onMigrateStateChange != nil {
+		go onMigrateStateChange(p, s)
+	}
+}
+
+// MigrateState 获取迁移状态
+func (p *ParticipantImpl) MigrateState() types.MigrateState {
+	return p.migrateState.Load().(types.MigrateState)
+}
+
+// SetMigrateInfo 设置迁移前订阅连接的协商信息
+func (p *ParticipantImpl) SetMigrateInfo(previousOffer, previousAnswer *webrtc.SessionDescription) {
+	p.subscriber.SetPreviousNegotiation(previousOffer, previousAnswer)
+}
+
+// ExportMigrationInfo 导出订阅连接的协商信息和全部订阅音轨的下行状态。
+// 订阅的下行音轨会停止转发，保证导出的状态之后源会话不再发送数据
+func (p *ParticipantImpl) ExportMigrationInfo() *types.MigrationInfo {
+	// 以可恢复的方式关闭，下行状态会被缓存
+	for _, st := range p.GetSubscribedTracks() {
+		st.MediaTrack().RemoveSubscriber(p.ID(), true)
+	}
+
+	offer, answer := p.subscriber.GetPreviousNegotiation()
+	info := &types.MigrationInfo{
+		PreviousOffer:   offer,
+		PreviousAnswer:  answer,
+		DownTrackStates: make(map[tc.TrackID]sfu.DownTrackState),
+		Attributes:      p.GetAttributes(),
+	}
+
+	p.lock.RLock()
+	for trackID, dts := range p.cachedDownTracks {
+		info.DownTrackStates[trackID] = dts.downTrackState
+	}
+	p.lock.RUnlock()
+	return info
+}
+
+// ImportMigrationInfo 导入源会话的状态，订阅相同音轨时从源会话的位置继续转发
+func (p *ParticipantImpl) ImportMigrationInfo(info *types.MigrationInfo) {
+	if info == nil {
+		return
+	}
+
+	p.params.Logger.Infow("importing migration info", "numDownTracks", len(info.DownTrackStates))
+	p.SetMigrateInfo(info.PreviousOffer, info.PreviousAnswer)
+	for trackID, dts := range info.DownTrackStates {
+		p.CacheDownTrack(trackID, nil, dts)
+	}
+
+	// 迁移前后是同一个参与者，直接恢复属性，不需要广播
+	p.lock.Lock()
+	for k, v := range info.Attributes {
+		p.attributes[k] = v
+	}
+	p.lock.Unlock()
+}
+
+// CacheDownTrack 缓存下行音轨的状态，恢复订阅时使用
+func (p *ParticipantImpl) CacheDownTrack(trackID tc.TrackID, rtpTransceiver *webrtc.RTPTransceiver, dts sfu.DownTrackState) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.cachedDownTracks[trackID] = &downTrackState{
+		transceiver:    rtpTransceiver,
+		downTrackState: dts,
+	}
+}
+
+// UncacheDownTrack 移除缓存的下行音轨
+func (p *ParticipantImpl) UncacheDownTrack(rtpTransceiver *webrtc.RTPTransceiver) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for trackID, dts := range p.cachedDownTracks {
+		if dts.transceiver == rtpTransceiver {
+			delete(p.cachedDownTracks, trackID)
+			return
+		}
+	}
+}
+
+// GetCachedDownTrack 取出缓存的下行音轨
+func (p *ParticipantImpl) GetCachedDownTrack(trackID tc.TrackID) (*webrtc.RTPTransceiver, sfu.DownTrackState, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	dts, ok := p.cachedDownTracks[trackID]
+	if !ok {
+		return nil, sfu.DownTrackState{}, false
+	}
+	delete(p.cachedDownTracks, trackID)
+	return dts.transceiver, dts.downTrackState, true
+}
+
+// ---------------- 回调 ----------------
+
+// OnStateChange 状态变化事件
+func (p *ParticipantImpl) OnStateChange(callback func(p types.LocalParticipant, oldState tc.ParticipantInfo_State)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onStateChange = callback
+}
+
+// OnMigrateStateChange 迁移状态变化事件
+func (p *ParticipantImpl) OnMigrateStateChange(callback func(p types.LocalParticipant, migrateState types.MigrateState)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onMigrateStateChange = callback
+}
+
+// OnTrackPublished 音轨发布事件
+func (p *ParticipantImpl) OnTrackPublished(callback func(types.LocalParticipant, types.MediaTrack)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onTrackPublished = callback
+}
+
+// OnTrackUpdated 音轨更新事件
+func (p *ParticipantImpl) OnTrackUpdated(callback func(types.LocalParticipant, types.MediaTrack)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onTrackUpdated = callback
+}
+
+// OnTrackUnpublished 音轨取消发布事件
+func (p *ParticipantImpl) OnTrackUnpublished(callback func(types.LocalParticipant, types.MediaTrack)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onTrackUnpublished = callback
+}
+
+// OnParticipantUpdate 参与者信息更新事件
+func (p *ParticipantImpl) OnParticipantUpdate(callback func(types.LocalParticipant)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onParticipantUpdate = callback
+}
+
+// OnDataPacket 收到数据包事件
+func (p *ParticipantImpl) OnDataPacket(callback func(types.LocalParticipant, *tc.DataPacket)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onDataPacket = callback
+}
+
+// OnSubscribedStatusChanged 订阅状态变化事件
+func (p *ParticipantImpl) OnSubscribedStatusChanged(fn func(publisherID tc.ParticipantID, subscribed bool)) {
+	p.SubscriptionManager.OnSubscribeStatusChanged(fn)
+}
+
+// OnClose 关闭事件
+func (p *ParticipantImpl) OnClose(callback func(types.LocalParticipant)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onClose = callback
+}
+
+// OnClaimsChanged 授权变化事件
+func (p *ParticipantImpl) OnClaimsChanged(callback func(types.LocalParticipant)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onClaimsChanged = callback
+}
+
+// OnICEConfigChanged ICE 配置变化事件
+func (p *ParticipantImpl) OnICEConfigChanged(callback func(participant types.LocalParticipant, iceConfig *tc.ICEConfig)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onICEConfigChanged = callback
+}
+
+// ---------------- 内部 ----------------
+
+func (p *ParticipantImpl) primaryTransport() *PCTransport {
+	if p.subscriberAsPrimary {
+		return p.subscriber
+	}
+	return p.publisher
+}
+
+func (p *ParticipantImpl) onPrimaryTransportFullyEstablished() {
+	p.updateState(tc.ParticipantInfo_ACTIVE)
+	prometheus.IncrementParticipantJoin(prometheus.JoinStateRTCConnected)
+	prometheus.RecordJoinLatency(time.Since(p.connectedAt))
+
+	// 迁移过来的会话连接建立后，源会话可以关闭
+	if p.MigrateState() == types.MigrateStateSync {
+		p.SetMigrateState(types.MigrateStateComplete)
+	}
+}
+
+func (p *ParticipantImpl) onTransportFailed() {
+	if p.IsClosed() {
+		return
+	}
+	p.params.Logger.Infow("peer connection failed, closing participant")
+	go func() {
+		_ = p.Close(false, types.ParticipantCloseReasonPeerConnectionDisconnected, false)
+	}()
+}
+
+// updateState 状态只能向前推进 JOINING -> JOINED -> ACTIVE -> DISCONNECTED
+func (p *ParticipantImpl) updateState(state tc.ParticipantInfo_State) {
+	var oldState tc.ParticipantInfo_State
+	for {
+		oldState = p.State()
+		if state <= oldState {
+			return
+		}
+		if p.state.CompareAndSwap(oldState, state) {
+			break
+		}
+	}
+	p.params.Logger.Debugw("updating participant state", "state", state.String(), "oldState", oldState.String())
+	p.dirty()
+
+	p.cbLock.RLock()
+	onStateChange := p.onStateChange
+	p.cbLock.RUnlock()
+	if onStateChange != nil {
+		p.stateChangeQueue.Enqueue(func() {
+			onStateChange(p, oldState)
+		})
+	}
+}
+
+// dirty 参与者信息发生变化，增加版本
+func (p *ParticipantImpl) dirty() {
+	p.version.Inc()
+
+	p.lock.Lock()
+	p.timedVersion.Update(p.params.VersionGenerator.New())
+	p.lock.Unlock()
+}
+
+func (p *ParticipantImpl) notifyParticipantUpdate() {
+	p.cbLock.RLock()
+	onParticipantUpdate := p.onParticipantUpdate
+	p.cbLock.RUnlock()
+	if onParticipantUpdate != nil {
+		onParticipantUpdate(p)
+	}
+}
+
+// notifyClaimsChanged 授权变化后立即刷新 token，客户端重连时使用新的授权
+func (p *ParticipantImpl) notifyClaimsChanged() {
+	if err := p.refreshToken(); err != nil {
+		p.params.Logger.Warnw("could not refresh token", err)
+	}
+
+	p.cbLock.RLock()
+	onClaimsChanged := p.onClaimsChanged
+	p.cbLock.RUnlock()
+	if onClaimsChanged != nil {
+		onClaimsChanged(p)
+	}
+}
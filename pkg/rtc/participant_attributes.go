@@ -0,0 +1,143 @@
+package rtc
+
+import (
+	"encoding/json"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+const (
+	// ParticipantAttributesTopic 参与者属性使用的数据包主题，服务端下发增量，客户端上报自己的修改
+	ParticipantAttributesTopic = "tc.participant.attributes"
+
+	// MaxParticipantAttributesSize 全部属性键和值的总长度上限
+	MaxParticipantAttributesSize = 16 * 1024
+	// maxParticipantAttributeKeyLength 单个属性键的长度上限
+	maxParticipantAttributeKeyLength = 256
+)
+
+// ParticipantAttributesUpdate 下发给客户端的属性增量
+type ParticipantAttributesUpdate struct {
+	ParticipantSid      string `json:"participantSid"`
+	ParticipantIdentity string `json:"participantIdentity"`
+	types.ParticipantAttributesDelta
+}
+
+// participantAttributesPatch 客户端上报的属性修改
+type participantAttributesPatch struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// GetAttributes 获取参与者属性
+func (p *ParticipantImpl) GetAttributes() map[string]string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	attributes := make(map[string]string, len(p.attributes))
+	for k, v := range p.attributes {
+		attributes[k] = v
+	}
+	return attributes
+}
+
+// UpdateAttributes 设置和删除部分属性，修改后超过长度限制时整体拒绝，有变化时广播增量
+func (p *ParticipantImpl) UpdateAttributes(set map[string]string, remove []string) error {
+	for k := range set {
+		if k == "" || len(k) > maxParticipantAttributeKeyLength {
+			return ErrInvalidAttributeKey
+		}
+	}
+
+	p.lock.Lock()
+	updated := make(map[string]string, len(p.attributes)+len(set))
+	for k, v := range p.attributes {
+		updated[k] = v
+	}
+
+	delta := &types.ParticipantAttributesDelta{}
+	for _, k := range remove {
+		if _, ok := updated[k]; !ok {
+			continue
+		}
+		if _, ok := set[k]; ok {
+			continue
+		}
+		delete(updated, k)
+		delta.Removed = append(delta.Removed, k)
+	}
+	for k, v := range set {
+		if old, ok := updated[k]; ok && old == v {
+			continue
+		}
+		updated[k] = v
+		if delta.Changed == nil {
+			delta.Changed = make(map[string]string)
+		}
+		delta.Changed[k] = v
+	}
+
+	if len(delta.Changed) == 0 && len(delta.Removed) == 0 {
+		p.lock.Unlock()
+		return nil
+	}
+	if attributesSize(updated) > MaxParticipantAttributesSize {
+		p.lock.Unlock()
+		return ErrAttributesTooLarge
+	}
+	p.attributes = updated
+	p.lock.Unlock()
+
+	p.dirty()
+	delta.Version = p.version.Load()
+
+	p.cbLock.RLock()
+	onAttributesChanged := p.onAttributesChanged
+	p.cbLock.RUnlock()
+	if onAttributesChanged != nil {
+		onAttributesChanged(p, delta)
+	}
+	return nil
+}
+
+// CanUpdateOwnMetadata 是否允许参与者修改自己的元数据和属性
+func (p *ParticipantImpl) CanUpdateOwnMetadata() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.grants.Video.GetCanUpdateOwnMetadata()
+}
+
+// OnAttributesChanged 参与者属性变化事件
+func (p *ParticipantImpl) OnAttributesChanged(callback func(types.LocalParticipant, *types.ParticipantAttributesDelta)) {
+	p.cbLock.Lock()
+	defer p.cbLock.Unlock()
+
+	p.onAttributesChanged = callback
+}
+
+// handleAttributesPatch 处理客户端上报的属性修改，需要有修改自己元数据的权限
+func (p *ParticipantImpl) handleAttributesPatch(up *tc.UserPacket) {
+	if !p.CanUpdateOwnMetadata() {
+		p.params.Logger.Infow("participant is not allowed to update own attributes")
+		return
+	}
+
+	patch := &participantAttributesPatch{}
+	if err := json.Unmarshal(up.Payload, patch); err != nil {
+		p.params.Logger.Debugw("could not parse attributes patch", "error", err)
+		return
+	}
+	if err := p.UpdateAttributes(patch.Set, patch.Remove); err != nil {
+		p.params.Logger.Warnw("could not update attributes", err)
+	}
+}
+
+func attributesSize(attributes map[string]string) int {
+	size := 0
+	for k, v := range attributes {
+		size += len(k) + len(v)
+	}
+	return size
+}
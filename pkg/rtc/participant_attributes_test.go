@@ -0,0 +1,91 @@
+package rtc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+func TestParticipantUpdateAttributes(t *testing.T) {
+	p, _ := newTestParticipant(t, newTestWebRTCConfig(t), "p")
+	var deltas []*types.ParticipantAttributesDelta
+	p.OnAttributesChanged(func(_ types.LocalParticipant, delta *types.ParticipantAttributesDelta) {
+		deltas = append(deltas, delta)
+	})
+
+	require.NoError(t, p.UpdateAttributes(map[string]string{"a": "1", "b": "2"}, nil))
+	require.Equal(t, map[string]string{"a": "1", "b": "2"}, p.GetAttributes())
+	require.Len(t, deltas, 1)
+	require.Equal(t, map[string]string{"a": "1", "b": "2"}, deltas[0].Changed)
+
+	// 删除不存在的键和没有变化的值不产生增量
+	require.NoError(t, p.UpdateAttributes(map[string]string{"a": "1"}, []string{"missing"}))
+	require.Len(t, deltas, 1)
+
+	// 同时设置和删除同一个键时以设置为准
+	require.NoError(t, p.UpdateAttributes(map[string]string{"b": "3"}, []string{"a", "b"}))
+	require.Equal(t, map[string]string{"b": "3"}, p.GetAttributes())
+	require.Len(t, deltas, 2)
+	require.Equal(t, map[string]string{"b": "3"}, deltas[1].Changed)
+	require.Equal(t, []string{"a"}, deltas[1].Removed)
+	require.Greater(t, deltas[1].Version, deltas[0].Version)
+
+	require.ErrorIs(t, p.UpdateAttributes(map[string]string{"": "x"}, nil), ErrInvalidAttributeKey)
+	require.ErrorIs(t, p.UpdateAttributes(map[string]string{strings.Repeat("k", maxParticipantAttributeKeyLength+1): "x"}, nil), ErrInvalidAttributeKey)
+
+	// 超过总长度限制时整体拒绝
+	err := p.UpdateAttributes(map[string]string{"c": "ok", "big": strings.Repeat("v", MaxParticipantAttributesSize)}, nil)
+	require.ErrorIs(t, err, ErrAttributesTooLarge)
+	require.Equal(t, map[string]string{"b": "3"}, p.GetAttributes())
+	require.Len(t, deltas, 2)
+}
+
+func TestParticipantAttributesPatch(t *testing.T) {
+	rtcConf := newTestWebRTCConfig(t)
+	patch := func(t *testing.T, p *ParticipantImpl, set map[string]string, remove []string) {
+		payload, err := json.Marshal(&participantAttributesPatch{Set: set, Remove: remove})
+		require.NoError(t, err)
+		data, err := proto.Marshal(userPacket(tc.DataPacket_RELIABLE, &tc.UserPacket{Topic: ParticipantAttributesTopic, Payload: payload}))
+		require.NoError(t, err)
+		p.onDataPacketReceived(tc.DataPacket_RELIABLE, data)
+	}
+
+	t.Run("requires permission to update own metadata", func(t *testing.T) {
+		p, _ := newTestParticipant(t, rtcConf, "p", func(params *ParticipantParams) {
+			params.Grants.Video.SetCanPublishData(true)
+		})
+		patch(t, p, map[string]string{"a": "1"}, nil)
+		require.Empty(t, p.GetAttributes())
+	})
+
+	t.Run("set and remove own attributes", func(t *testing.T) {
+		p, _ := newTestParticipant(t, rtcConf, "p", func(params *ParticipantParams) {
+			params.Grants.Video.SetCanPublishData(true)
+			params.Grants.Video.SetCanUpdateOnwMetadata(true)
+		})
+		var forwarded []*tc.DataPacket
+		p.OnDataPacket(func(_ types.LocalParticipant, dp *tc.DataPacket) {
+			forwarded = append(forwarded, dp)
+		})
+
+		patch(t, p, map[string]string{"a": "1", "b": "2"}, nil)
+		require.Equal(t, map[string]string{"a": "1", "b": "2"}, p.GetAttributes())
+		patch(t, p, nil, []string{"a"})
+		require.Equal(t, map[string]string{"b": "2"}, p.GetAttributes())
+
+		// 修改后超过总长度限制时被拒绝
+		half := strings.Repeat("v", MaxParticipantAttributesSize/2)
+		require.NoError(t, p.UpdateAttributes(map[string]string{"c": half}, nil))
+		patch(t, p, map[string]string{"d": half}, nil)
+		require.Equal(t, map[string]string{"b": "2", "c": half}, p.GetAttributes())
+
+		// 属性修改不会作为普通数据转发
+		require.Empty(t, forwarded)
+	})
+}
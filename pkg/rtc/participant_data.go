@@ -0,0 +1,55 @@
+package rtc
+
+import (
+	"encoding/json"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+// DataSubscriptionTopic 客户端设置接收的数据主题使用的数据包主题
+const DataSubscriptionTopic = "tc.data.subscription"
+
+// dataSubscription 客户端设置的数据主题，Topics 为空表示接收所有主题
+type dataSubscription struct {
+	Topics []string `json:"topics,omitempty"`
+}
+
+// SetDataTopics 设置接收的数据主题，为空时接收所有主题
+func (p *ParticipantImpl) SetDataTopics(topics []string) {
+	var dataTopics map[string]struct{}
+	if len(topics) != 0 {
+		dataTopics = make(map[string]struct{}, len(topics))
+		for _, topic := range topics {
+			dataTopics[topic] = struct{}{}
+		}
+	}
+
+	p.lock.Lock()
+	p.dataTopics = dataTopics
+	p.lock.Unlock()
+}
+
+// SubscribesToDataTopic 是否接收指定主题的数据包，没有主题的数据包总是接收
+func (p *ParticipantImpl) SubscribesToDataTopic(topic string) bool {
+	if topic == "" {
+		return true
+	}
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.dataTopics == nil {
+		return true
+	}
+	_, ok := p.dataTopics[topic]
+	return ok
+}
+
+func (p *ParticipantImpl) handleDataSubscription(up *tc.UserPacket) {
+	sub := &dataSubscription{}
+	if err := json.Unmarshal(up.Payload, sub); err != nil {
+		p.params.Logger.Debugw("could not parse data subscription", "error", err)
+		return
+	}
+	p.params.Logger.Debugw("updating data topics", "topics", sub.Topics)
+	p.SetDataTopics(sub.Topics)
+}
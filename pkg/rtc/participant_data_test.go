@@ -0,0 +1,216 @@
+package rtc
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+// fakeDataReceiver 记录房间转发给它的数据包和房间更新
+type fakeDataReceiver struct {
+	types.LocalParticipant
+
+	sid      tc.ParticipantID
+	identity tc.ParticipantIdentity
+	state    tc.ParticipantInfo_State
+	topics   map[string]struct{}
+
+	lock        sync.Mutex
+	received    []*tc.DataPacket
+	roomUpdates []*tc.Room
+	// audioLevel 大于 0 时表示正在说话
+	audioLevel float64
+}
+
+func (f *fakeDataReceiver) ID() tc.ParticipantID             { return f.sid }
+func (f *fakeDataReceiver) Identity() tc.ParticipantIdentity { return f.identity }
+func (f *fakeDataReceiver) State() tc.ParticipantInfo_State  { return f.state }
+func (f *fakeDataReceiver) Hidden() bool                     { return false }
+func (f *fakeDataReceiver) IsPublisher() bool                { return false }
+func (f *fakeDataReceiver) SendDataPacket(dp *tc.DataPacket, _ []byte) error {
+	f.lock.Lock()
+	f.received = append(f.received, dp)
+	f.lock.Unlock()
+	return nil
+}
+func (f *fakeDataReceiver) Close(bool, types.ParticipantCloseReason, bool) error {
+	f.state = tc.ParticipantInfo_DISCONNECTED
+	return nil
+}
+func (f *fakeDataReceiver) SubscribesToDataTopic(topic string) bool {
+	if topic == "" || f.topics == nil {
+		return true
+	}
+	_, ok := f.topics[topic]
+	return ok
+}
+
+func (f *fakeDataReceiver) GetAudioLevel() (float64, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.audioLevel, f.audioLevel > 0
+}
+func (f *fakeDataReceiver) SendSpeakerUpdate([]*tc.SpeakerInfo, bool) error { return nil }
+func (f *fakeDataReceiver) SendRoomUpdate(room *tc.Room) error {
+	f.lock.Lock()
+	f.roomUpdates = append(f.roomUpdates, room)
+	f.lock.Unlock()
+	return nil
+}
+
+func (f *fakeDataReceiver) setAudioLevel(level float64) {
+	f.lock.Lock()
+	f.audioLevel = level
+	f.lock.Unlock()
+}
+
+func (f *fakeDataReceiver) rooms() []*tc.Room {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]*tc.Room(nil), f.roomUpdates...)
+}
+
+func (f *fakeDataReceiver) packets() []*tc.DataPacket {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]*tc.DataPacket(nil), f.received...)
+}
+
+func addDataReceiver(r *Room, identity tc.ParticipantIdentity, state tc.ParticipantInfo_State, topics ...string) *fakeDataReceiver {
+	f := &fakeDataReceiver{
+		sid:      tc.ParticipantID("PA_" + identity),
+		identity: identity,
+		state:    state,
+	}
+	if len(topics) != 0 {
+		f.topics = make(map[string]struct{})
+		for _, topic := range topics {
+			f.topics[topic] = struct{}{}
+		}
+	}
+	r.lock.Lock()
+	r.participants[identity] = f
+	r.lock.Unlock()
+	return f
+}
+
+func userPacket(kind tc.DataPacket_Kind, up *tc.UserPacket) *tc.DataPacket {
+	return &tc.DataPacket{Kind: kind, Value: &tc.DataPacket_User{User: up}}
+}
+
+func TestRoomBroadcastDataPacket(t *testing.T) {
+	t.Run("server packets go to every active participant", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		a := addDataReceiver(r, "a", tc.ParticipantInfo_ACTIVE)
+		b := addDataReceiver(r, "b", tc.ParticipantInfo_ACTIVE)
+		joining := addDataReceiver(r, "joining", tc.ParticipantInfo_JOINED)
+
+		r.SendDataPacket(&tc.UserPacket{Payload: []byte("hello")}, tc.DataPacket_LOSSY)
+		require.Len(t, a.packets(), 1)
+		require.Len(t, b.packets(), 1)
+		require.Empty(t, joining.packets())
+		require.Equal(t, tc.DataPacket_LOSSY, a.packets()[0].Kind)
+		require.Equal(t, []byte("hello"), a.packets()[0].GetUser().Payload)
+	})
+
+	t.Run("sender does not receive its own packet", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		a := addDataReceiver(r, "a", tc.ParticipantInfo_ACTIVE)
+		b := addDataReceiver(r, "b", tc.ParticipantInfo_ACTIVE)
+
+		r.broadcastDataPacket(a, userPacket(tc.DataPacket_RELIABLE, &tc.UserPacket{Payload: []byte("hi")}))
+		require.Empty(t, a.packets())
+		require.Len(t, b.packets(), 1)
+		require.Equal(t, tc.DataPacket_RELIABLE, b.packets()[0].Kind)
+	})
+
+	t.Run("destinations by sid or identity", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		a := addDataReceiver(r, "a", tc.ParticipantInfo_ACTIVE)
+		b := addDataReceiver(r, "b", tc.ParticipantInfo_ACTIVE)
+		c := addDataReceiver(r, "c", tc.ParticipantInfo_ACTIVE)
+
+		r.SendDataPacket(&tc.UserPacket{
+			DestinationSids:       []string{string(a.sid)},
+			DestinationIdentities: []string{"c"},
+		}, tc.DataPacket_RELIABLE)
+		require.Len(t, a.packets(), 1)
+		require.Empty(t, b.packets())
+		require.Len(t, c.packets(), 1)
+	})
+
+	t.Run("topic filter", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		all := addDataReceiver(r, "all", tc.ParticipantInfo_ACTIVE)
+		chat := addDataReceiver(r, "chat", tc.ParticipantInfo_ACTIVE, "chat")
+
+		r.SendDataPacket(&tc.UserPacket{Topic: "cursor"}, tc.DataPacket_LOSSY)
+		require.Len(t, all.packets(), 1)
+		require.Empty(t, chat.packets())
+
+		r.SendDataPacket(&tc.UserPacket{Topic: "chat"}, tc.DataPacket_RELIABLE)
+		require.Len(t, all.packets(), 2)
+		require.Len(t, chat.packets(), 1)
+
+		// 没有主题的数据包总是接收
+		r.SendDataPacket(&tc.UserPacket{}, tc.DataPacket_RELIABLE)
+		require.Len(t, chat.packets(), 2)
+	})
+}
+
+func TestParticipantDataPacketReceived(t *testing.T) {
+	rtcConf := newTestWebRTCConfig(t)
+
+	t.Run("size limits depend on kind", func(t *testing.T) {
+		p, _ := newTestParticipant(t, rtcConf, "sender", func(params *ParticipantParams) {
+			params.Grants.Video.SetCanPublishData(true)
+		})
+		var received []*tc.DataPacket
+		p.OnDataPacket(func(_ types.LocalParticipant, dp *tc.DataPacket) {
+			received = append(received, dp)
+		})
+
+		data, err := proto.Marshal(userPacket(tc.DataPacket_LOSSY, &tc.UserPacket{Payload: make([]byte, MaxLossyDataPacketSize)}))
+		require.NoError(t, err)
+		require.Greater(t, len(data), MaxLossyDataPacketSize)
+
+		p.onDataPacketReceived(tc.DataPacket_LOSSY, data)
+		require.Empty(t, received)
+
+		p.onDataPacketReceived(tc.DataPacket_RELIABLE, data)
+		require.Len(t, received, 1)
+		require.Equal(t, tc.DataPacket_RELIABLE, received[0].Kind)
+		require.Equal(t, "sender", received[0].GetUser().ParticipantIdentity)
+	})
+
+	t.Run("data subscription does not need publish permission", func(t *testing.T) {
+		p, _ := newTestParticipant(t, rtcConf, "viewer", func(params *ParticipantParams) {
+			params.Grants.Video.SetCanPublishData(false)
+		})
+		var received []*tc.DataPacket
+		p.OnDataPacket(func(_ types.LocalParticipant, dp *tc.DataPacket) {
+			received = append(received, dp)
+		})
+		require.True(t, p.SubscribesToDataTopic("chat"))
+
+		payload, err := json.Marshal(dataSubscription{Topics: []string{"chat"}})
+		require.NoError(t, err)
+		data, err := proto.Marshal(userPacket(tc.DataPacket_RELIABLE, &tc.UserPacket{Topic: DataSubscriptionTopic, Payload: payload}))
+		require.NoError(t, err)
+		p.onDataPacketReceived(tc.DataPacket_RELIABLE, data)
+
+		require.Empty(t, received)
+		require.True(t, p.SubscribesToDataTopic("chat"))
+		require.False(t, p.SubscribesToDataTopic("cursor"))
+		require.True(t, p.SubscribesToDataTopic(""))
+
+		p.SetDataTopics(nil)
+		require.True(t, p.SubscribesToDataTopic("cursor"))
+	})
+}
@@ -0,0 +1,91 @@
+package rtc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+func newPermissionTestParticipant(t *testing.T) (*ParticipantImpl, *fakeClient) {
+	p, client := newProtocolTestParticipant(9)
+	p.params.VersionGenerator = utils.NewDefaultTimedVersionGenerator()
+	p.grants = &auth.ClaimGrants{
+		Identity: "subscriber",
+		Video:    &auth.VideoGrant{RoomJoin: true, Room: "room"},
+	}
+	p.SubscriptionManager = NewSubscriptionManager(SubscriptionManagerParams{
+		Participant: p,
+		Logger:      p.params.Logger,
+	})
+	t.Cleanup(func() {
+		p.SubscriptionManager.Close(false)
+	})
+	return p, client
+}
+
+func TestEnforcePermissions(t *testing.T) {
+	t.Run("pending tracks from revoked sources", func(t *testing.T) {
+		p, client := newPermissionTestParticipant(t)
+		p.AddTrack(&tc.AddTrackRequest{Cid: "mic", Type: tc.TrackType_AUDIO, Source: tc.TrackSource_MICROPHONE})
+		p.AddTrack(&tc.AddTrackRequest{Cid: "cam", Type: tc.TrackType_VIDEO, Source: tc.TrackSource_CAMERA})
+		messages := client.received()
+		require.Len(t, messages, 2)
+		camSid := messages[1].GetTrackPublished().Track.Sid
+
+		require.True(t, p.SetPermission(&tc.ParticipantPermission{
+			CanPublish:        true,
+			CanSubscribe:      true,
+			CanPublishSources: []tc.TrackSource{tc.TrackSource_MICROPHONE},
+		}))
+
+		require.Contains(t, p.pendingTracks, "mic")
+		require.NotContains(t, p.pendingTracks, "cam")
+
+		var unpublished []string
+		for _, msg := range client.received()[2:] {
+			if u := msg.GetTrackUnpublished(); u != nil {
+				unpublished = append(unpublished, u.TrackSid)
+			}
+		}
+		require.Equal(t, []string{camSid}, unpublished)
+
+		permission := p.ToProto().Permission
+		require.False(t, permission.CanPublishData)
+		require.Equal(t, []tc.TrackSource{tc.TrackSource_MICROPHONE}, permission.CanPublishSources)
+	})
+
+	t.Run("subscriptions dropped", func(t *testing.T) {
+		p, _ := newPermissionTestParticipant(t)
+		p.SubscribeToTrack("TR_a")
+		p.SubscriptionManager.lock.RLock()
+		sub := p.SubscriptionManager.subscriptions["TR_a"]
+		p.SubscriptionManager.lock.RUnlock()
+		require.NotNil(t, sub)
+		require.True(t, sub.isDesired())
+
+		require.True(t, p.SetPermission(&tc.ParticipantPermission{CanPublish: true}))
+		require.False(t, sub.isDesired())
+
+		// 没有订阅权限时不再接受新的订阅
+		p.SubscribeToTrack("TR_b")
+		p.SubscriptionManager.lock.RLock()
+		require.NotContains(t, p.SubscriptionManager.subscriptions, tc.TrackID("TR_b"))
+		p.SubscriptionManager.lock.RUnlock()
+	})
+
+	t.Run("data publishing blocked", func(t *testing.T) {
+		p, _ := newPermissionTestParticipant(t)
+		var received int
+		p.OnDataPacket(func(_ types.LocalParticipant, _ *tc.DataPacket) {
+			received++
+		})
+		require.True(t, p.SetPermission(&tc.ParticipantPermission{CanSubscribe: true}))
+		p.onDataPacketReceived(tc.DataPacket_RELIABLE, []byte{})
+		require.Zero(t, received)
+	})
+}
@@ -0,0 +1,190 @@
+package rtc
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+func newProtocolTestParticipant(v types.ProtocolVersion) (*ParticipantImpl, *fakeClient) {
+	client := &fakeClient{}
+	p := &ParticipantImpl{
+		params: ParticipantParams{
+			Identity:        "subscriber",
+			SID:             "PA_subscriber",
+			ProtocolVersion: v,
+			SyncStreams:     true,
+			Logger:          logger.GetLogger(),
+		},
+		closeCh:           make(chan struct{}),
+		resSink:           client,
+		pendingTracks:     make(map[string]*pendingTrackInfo),
+		updateCache:       make(map[tc.ParticipantID]uint32),
+		activeSpeakers:    make(map[tc.ParticipantID]*tc.SpeakerInfo),
+		connectionQuality: make(map[tc.ParticipantID]tc.ConnectionQuality),
+	}
+	p.UpTrackManager = NewUpTrackManager(UpTrackManagerParams{SID: p.params.SID, Logger: p.params.Logger})
+	p.state.Store(tc.ParticipantInfo_JOINED)
+	return p, client
+}
+
+func TestProtocolCapabilities(t *testing.T) {
+	for v := types.ProtocolVersion(0); v <= types.CurrentProtocol; v++ {
+		t.Run(fmt.Sprintf("v%d", v), func(t *testing.T) {
+			require.Equal(t, v >= 1, v.SupportsProtobuf())
+			require.Equal(t, v >= 1, v.SupportsPackedStreamId())
+			require.Equal(t, v >= 2, v.HandlesDataPackets())
+			require.Equal(t, v >= 3, v.SubscriberAsPrimary())
+			require.Equal(t, v >= 3, v.SupportsSpeakerChanged())
+			require.Equal(t, v >= 4, v.SupportsTransceiverReuse())
+			require.Equal(t, v >= 5, v.SupportsConnectionQuality())
+			require.Equal(t, v >= 6, v.SupportsSessionMigrate())
+			require.Equal(t, v >= 6, v.SupportsICELite())
+			require.Equal(t, v >= 7, v.SupportsUnpublish())
+			require.Equal(t, v >= 8, v.SupportsFastStart())
+			require.Equal(t, v >= 9, v.SupportHandlesDisconnectedUpdate())
+			require.Equal(t, v >= 10, v.SupportSyncStreamID())
+		})
+	}
+}
+
+func TestProtocolConformance(t *testing.T) {
+	rtcConf := newTestWebRTCConfig(t)
+	withVersion := func(v types.ProtocolVersion) func(params *ParticipantParams) {
+		return func(params *ParticipantParams) {
+			params.ProtocolVersion = v
+		}
+	}
+
+	for v := types.ProtocolVersion(1); v <= types.CurrentProtocol; v++ {
+		t.Run(fmt.Sprintf("v%d", v), func(t *testing.T) {
+			t.Run("speaker updates", func(t *testing.T) {
+				p, client := newProtocolTestParticipant(v)
+				require.NoError(t, p.SendSpeakerUpdate([]*tc.SpeakerInfo{{Sid: "PA_a", Level: 0.5, Active: true}}, false))
+				require.NoError(t, p.SendSpeakerUpdate([]*tc.SpeakerInfo{{Sid: "PA_b", Level: 0.8, Active: true}}, false))
+				require.NoError(t, p.SendSpeakerUpdate([]*tc.SpeakerInfo{{Sid: "PA_a", Active: false}}, false))
+
+				messages := client.received()
+				require.Len(t, messages, 3)
+				speakers := messages[2].GetSpeakersChanged().Speakers
+				if v.SupportsSpeakerChanged() {
+					// 增量
+					require.Len(t, speakers, 1)
+					require.Equal(t, "PA_a", speakers[0].Sid)
+					require.False(t, speakers[0].Active)
+				} else {
+					// 完整的活跃发言者列表
+					require.Len(t, speakers, 1)
+					require.Equal(t, "PA_b", speakers[0].Sid)
+					require.True(t, speakers[0].Active)
+
+					speakers = messages[1].GetSpeakersChanged().Speakers
+					require.Len(t, speakers, 2)
+					require.Equal(t, "PA_b", speakers[0].Sid)
+					require.Equal(t, "PA_a", speakers[1].Sid)
+				}
+			})
+
+			t.Run("connection quality", func(t *testing.T) {
+				p, client := newProtocolTestParticipant(v)
+				update := &tc.ConnectionQualityUpdate{
+					Updates: []*tc.ConnectionQualityInfo{{ParticipantSid: "PA_a", Quality: tc.ConnectionQuality_GOOD, Score: 4}},
+				}
+				require.NoError(t, p.SendConnectionQualityUpdate(update))
+				require.NoError(t, p.SendConnectionQualityUpdate(update))
+
+				if v.SupportsConnectionQuality() {
+					require.Len(t, client.received(), 2)
+				} else {
+					require.Len(t, client.received(), 1)
+				}
+			})
+
+			t.Run("reconnected participant", func(t *testing.T) {
+				p, client := newProtocolTestParticipant(v)
+				require.NoError(t, p.SendParticipantUpdate([]*tc.ParticipantInfo{
+					{Sid: "PA_old", Identity: "publisher", State: tc.ParticipantInfo_DISCONNECTED, Version: 3},
+					{Sid: "PA_new", Identity: "publisher", State: tc.ParticipantInfo_JOINED, Version: 1},
+				}))
+
+				messages := client.received()
+				require.Len(t, messages, 1)
+				participants := messages[0].GetUpdate().Participants
+				if v.SupportHandlesDisconnectedUpdate() {
+					require.Len(t, participants, 2)
+				} else {
+					require.Len(t, participants, 1)
+					require.Equal(t, "PA_new", participants[0].Sid)
+				}
+			})
+
+			t.Run("data packets", func(t *testing.T) {
+				p, client := newTestParticipant(t, rtcConf, "subscriber", withVersion(v))
+				p.state.Store(tc.ParticipantInfo_ACTIVE)
+				err := p.SendDataPacket(&tc.DataPacket{Kind: tc.DataPacket_RELIABLE}, []byte("data"))
+				if v.HandlesDataPackets() {
+					// 交给主连接的数据通道，通道还没有打开
+					require.ErrorIs(t, err, ErrDataChannelUnavailable)
+				} else {
+					require.NoError(t, err)
+				}
+				require.Empty(t, client.received())
+			})
+
+			t.Run("transceiver reuse", func(t *testing.T) {
+				p, _ := newTestParticipant(t, rtcConf, "subscriber", withVersion(v))
+				newTrack := func(id string) webrtc.TrackLocal {
+					track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, id, "stream")
+					require.NoError(t, err)
+					return track
+				}
+
+				sender, first, err := p.AddTrackToSubscriber(newTrack("TR_a"), types.AddTrackParams{})
+				require.NoError(t, err)
+				require.NoError(t, p.RemoveTrackFromSubscriber(sender))
+				_, second, err := p.AddTrackToSubscriber(newTrack("TR_b"), types.AddTrackParams{})
+				require.NoError(t, err)
+				require.Equal(t, v.SupportsTransceiverReuse(), first == second)
+			})
+
+			t.Run("fast start", func(t *testing.T) {
+				p, client := newTestParticipant(t, rtcConf, "subscriber", withVersion(v))
+				require.NoError(t, p.SendJoinResponse(&tc.JoinResponse{}))
+
+				messages := client.received()
+				require.NotNil(t, messages[0].GetJoin())
+				if v.SubscriberAsPrimary() && v.SupportsFastStart() {
+					// 加入响应之后立即发起订阅连接协商
+					require.Len(t, messages, 2)
+					require.NotNil(t, messages[1].GetOffer())
+				} else {
+					require.Len(t, messages, 1)
+				}
+			})
+
+			t.Run("unpublish", func(t *testing.T) {
+				p, client := newTestParticipant(t, rtcConf, "publisher", withVersion(v))
+				p.handleTrackUnpublished(newTestMediaTrack(&tc.TrackInfo{Sid: "TR_a", Type: tc.TrackType_AUDIO}))
+
+				messages := client.received()
+				if v.SupportsUnpublish() {
+					require.Len(t, messages, 1)
+					require.Equal(t, "TR_a", messages[0].GetTrackUnpublished().TrackSid)
+				} else {
+					require.Empty(t, messages)
+				}
+			})
+
+			t.Run("sync stream id", func(t *testing.T) {
+				p, _ := newProtocolTestParticipant(v)
+				require.Equal(t, v.SupportSyncStreamID(), p.SupportSyncStreamID())
+			})
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package rtc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/sfu/connectionquality"
+)
+
+func TestParticipantConnectionQuality(t *testing.T) {
+	p, _ := newTestParticipant(t, newTestWebRTCConfig(t), "publisher")
+
+	// 没有音轨时为最高分
+	info := p.GetConnectionQuality()
+	require.Equal(t, string(p.ID()), info.ParticipantSid)
+	require.Equal(t, tc.ConnectionQuality_EXCELLENT, info.Quality)
+
+	mt := newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO})
+	receiver := newFakeReceiver("TR_video", webrtc.MimeTypeVP8)
+	receiver.score = connectionquality.MaxMOS
+	mt.AddReceiver(receiver)
+	p.UpTrackManager.AddPublishedTrack(mt)
+	require.Equal(t, tc.ConnectionQuality_EXCELLENT, p.GetConnectionQuality().Quality)
+
+	// 发布的音轨上行质量差时参与者的质量也差
+	receiver.score = 2.0
+	info = p.GetConnectionQuality()
+	require.Equal(t, tc.ConnectionQuality_POOR, info.Quality)
+	require.Equal(t, float32(2.0), info.Score)
+}
@@ -0,0 +1,414 @@
+package rtc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pion/webrtc/v3"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+// SetResponseSink 设置响应接收器
+func (p *ParticipantImpl) SetResponseSink(sink routing.MessageSink) {
+	p.resSinkMu.Lock()
+	defer p.resSinkMu.Unlock()
+
+	p.resSink = sink
+}
+
+func (p *ParticipantImpl) getResponseSink() routing.MessageSink {
+	p.resSinkMu.Lock()
+	defer p.resSinkMu.Unlock()
+
+	return p.resSink
+}
+
+// CloseSignalConnection 关闭信令连接
+func (p *ParticipantImpl) CloseSignalConnection(reason types.SignallingCloseReason) {
+	sink := p.getResponseSink()
+	if sink != nil {
+		p.params.Logger.Infow("closing signal connection", "reason", reason, "connID", sink.ConnectionID())
+		sink.Close()
+	}
+	p.SetResponseSink(nil)
+}
+
+// SendJoinResponse 发送加入响应，发送成功后参与者进入 JOINED 状态
+func (p *ParticipantImpl) SendJoinResponse(joinResponse *tc.JoinResponse) error {
+	// 记录已经发送给客户端的参与者版本
+	p.updateLock.Lock()
+	for _, op := range joinResponse.OtherParticipants {
+		p.updateCache[tc.ParticipantID(op.Sid)] = op.Version
+	}
+	p.updateLock.Unlock()
+
+	joinResponse.SubscriberPrimary = p.SubscriberAsPrimary()
+	if err := p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_Join{
+			Join: joinResponse,
+		},
+	}); err != nil {
+		return err
+	}
+
+	p.updateState(tc.ParticipantInfo_JOINED)
+
+	// 支持快速启动的客户端不需要等到第一个订阅的音轨才建立订阅连接
+	if p.SubscriberAsPrimary() && p.params.ProtocolVersion.SupportsFastStart() {
+		p.Negotiate(true)
+	}
+	return nil
+}
+
+// SendParticipantUpdate 发送参与者更新，客户端已经有相同或者更新版本的参与者会被跳过
+func (p *ParticipantImpl) SendParticipantUpdate(participantsToUpdate []*tc.ParticipantInfo) error {
+	// 旧协议客户端以身份区分参与者，同一身份新会话的更新之后不能再发送旧会话的断开
+	var reconnected map[string]bool
+	if !p.params.ProtocolVersion.SupportHandlesDisconnectedUpdate() {
+		reconnected = make(map[string]bool)
+		for _, pi := range participantsToUpdate {
+			if pi.State != tc.ParticipantInfo_DISCONNECTED {
+				reconnected[pi.Identity] = true
+			}
+		}
+	}
+
+	p.updateLock.Lock()
+	validUpdates := make([]*tc.ParticipantInfo, 0, len(participantsToUpdate))
+	for _, pi := range participantsToUpdate {
+		pID := tc.ParticipantID(pi.Sid)
+		if pi.State == tc.ParticipantInfo_DISCONNECTED && reconnected[pi.Identity] {
+			delete(p.updateCache, pID)
+			continue
+		}
+		if lastVersion, ok := p.updateCache[pID]; ok && lastVersion > pi.Version {
+			// 客户端已经有更新的版本
+			continue
+		}
+		if pi.State == tc.ParticipantInfo_DISCONNECTED {
+			delete(p.updateCache, pID)
+			delete(p.activeSpeakers, pID)
+			delete(p.connectionQuality, pID)
+		} else {
+			p.updateCache[pID] = pi.Version
+		}
+		validUpdates = append(validUpdates, pi)
+	}
+	p.updateLock.Unlock()
+
+	if len(validUpdates) == 0 {
+		return nil
+	}
+
+	return p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_Update{
+			Update: &tc.ParticipantUpdate{
+				Participants: validUpdates,
+			},
+		},
+	})
+}
+
+// SendSpeakerUpdate 发送发言者更新，speakers 为增量，旧协议客户端收到合并后的完整活跃发言者列表
+func (p *ParticipantImpl) SendSpeakerUpdate(speakers []*tc.SpeakerInfo, force bool) error {
+	if !force && !p.IsReady() {
+		return nil
+	}
+
+	if !p.params.ProtocolVersion.SupportsSpeakerChanged() {
+		speakers = p.mergeActiveSpeakers(speakers)
+	}
+
+	return p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_SpeakersChanged{
+			SpeakersChanged: &tc.SpeakersChanged{
+				Speakers: speakers,
+			},
+		},
+	})
+}
+
+// SendRoomUpdate 发送房间更新
+func (p *ParticipantImpl) SendRoomUpdate(room *tc.Room) error {
+	return p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_RoomUpdate{
+			RoomUpdate: &tc.RoomUpdate{
+				Room: room,
+			},
+		},
+	})
+}
+
+// SendConnectionQualityUpdate 发送连接质量更新，旧协议客户端只接收质量等级有变化的参与者
+func (p *ParticipantImpl) SendConnectionQualityUpdate(update *tc.ConnectionQualityUpdate) error {
+	if !p.params.ProtocolVersion.SupportsConnectionQuality() {
+		update = p.filterConnectionQualityChanges(update)
+		if update == nil {
+			return nil
+		}
+	}
+
+	return p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_ConnectionQuality{
+			ConnectionQuality: update,
+		},
+	})
+}
+
+// mergeActiveSpeakers 将发言者增量合并到已发送的列表，返回按音量排序的完整活跃发言者列表
+func (p *ParticipantImpl) mergeActiveSpeakers(changed []*tc.SpeakerInfo) []*tc.SpeakerInfo {
+	p.updateLock.Lock()
+	defer p.updateLock.Unlock()
+
+	for _, si := range changed {
+		pID := tc.ParticipantID(si.Sid)
+		if si.Active {
+			p.activeSpeakers[pID] = si
+		} else {
+			delete(p.activeSpeakers, pID)
+		}
+	}
+
+	speakers := make([]*tc.SpeakerInfo, 0, len(p.activeSpeakers))
+	for _, si := range p.activeSpeakers {
+		speakers = append(speakers, si)
+	}
+	sortSpeakers(speakers)
+	return speakers
+}
+
+// filterConnectionQualityChanges 过滤掉质量等级与上次发送相同的参与者，没有变化时返回 nil
+func (p *ParticipantImpl) filterConnectionQualityChanges(update *tc.ConnectionQualityUpdate) *tc.ConnectionQualityUpdate {
+	p.updateLock.Lock()
+	defer p.updateLock.Unlock()
+
+	changed := make([]*tc.ConnectionQualityInfo, 0, len(update.Updates))
+	for _, cqi := range update.Updates {
+		pID := tc.ParticipantID(cqi.ParticipantSid)
+		if last, ok := p.connectionQuality[pID]; ok && last == cqi.Quality {
+			continue
+		}
+		p.connectionQuality[pID] = cqi.Quality
+		changed = append(changed, cqi)
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return &tc.ConnectionQualityUpdate{Updates: changed}
+}
+
+// SubscriptionPermissionUpdate 通知客户端对某个音轨的订阅权限发生变化，权限状态没有变化时不重复通知
+func (p *ParticipantImpl) SubscriptionPermissionUpdate(publisherID tc.ParticipantID, trackID tc.TrackID, allowed bool) {
+	if !p.SubscriptionManager.UpdateTrackPermission(trackID, allowed) {
+		return
+	}
+
+	p.params.Logger.Debugw("sending subscription permission update", "publisher", publisherID, "trackID", trackID, "allowed", allowed)
+	err := p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_SubscriptionPermissionUpdate{
+			SubscriptionPermissionUpdate: &tc.SubscriptionPermissionUpdate{
+				ParticipantSid: string(publisherID),
+				TrackSid:       string(trackID),
+				Allowed:        allowed,
+			},
+		},
+	})
+	if err != nil {
+		p.params.Logger.Errorw("could not send subscription permission update", err)
+	}
+}
+
+// SendRefreshToken 发送刷新后的 token
+func (p *ParticipantImpl) SendRefreshToken(token string) error {
+	return p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_RefreshToken{
+			RefreshToken: token,
+		},
+	})
+}
+
+// HandleReconnectAndSendResponse 处理重连，重连原因为订阅连接失败时重启订阅连接的 ICE
+func (p *ParticipantImpl) HandleReconnectAndSendResponse(reconnectReason tc.ReconnectReason, reconnectResponse *tc.ReconnectResponse) error {
+	if err := p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_Reconnect{
+			Reconnect: reconnectResponse,
+		},
+	}); err != nil {
+		return err
+	}
+
+	if reconnectReason == tc.ReconnectReason_RR_SUBSCRIBER_FAILED || reconnectReason == tc.ReconnectReason_RR_SWITCH_CANDIDATE {
+		p.subscriber.ICERestart()
+	}
+	return nil
+}
+
+// IssueFullReconnect 通知客户端完全重连，并关闭当前参与者
+func (p *ParticipantImpl) IssueFullReconnect(reason types.ParticipantCloseReason) {
+	_ = p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_Leave{
+			Leave: &tc.LeaveRequest{
+				CanReconnect: true,
+				Reason:       reason.ToDisconnectReason(),
+			},
+		},
+	})
+
+	scr := types.SignallingCloseReasonUnknown
+	switch reason {
+	case types.ParticipantCloseReasonPublicationError, types.ParticipantCloseReasonMigrationRequested:
+		scr = types.SignallingCloseReasonFullReconnectPublicationError
+	case types.ParticipantCloseReasonSubscriptionError:
+		scr = types.SignallingCloseReasonFullReconnectSubscriptionError
+	case types.ParticipantCloseReasonNegotiateFailed:
+		scr = types.SignallingCloseReasonFullReconnectNegotiateFailed
+	}
+	p.CloseSignalConnection(scr)
+
+	// 客户端会以新的会话重连，当前会话不会恢复
+	go func() {
+		_ = p.Close(false, reason, false)
+	}()
+}
+
+func (p *ParticipantImpl) sendICECandidate(c *webrtc.ICECandidate, target tc.SignalTarget) error {
+	ci := c.ToJSON()
+	candidateInit, err := json.Marshal(&ci)
+	if err != nil {
+		return err
+	}
+
+	return p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_Trickle{
+			Trickle: &tc.TrickleRequest{
+				CandidateInit: string(candidateInit),
+				Target:        target,
+			},
+		},
+	})
+}
+
+func (p *ParticipantImpl) sendOffer(offer webrtc.SessionDescription) error {
+	return p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_Offer{
+			Offer: ToProtoSessionDescription(offer),
+		},
+	})
+}
+
+func (p *ParticipantImpl) sendAnswer(answer webrtc.SessionDescription) error {
+	return p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_Answer{
+			Answer: ToProtoSessionDescription(answer),
+		},
+	})
+}
+
+func (p *ParticipantImpl) sendTrackPublished(cid string, ti *tc.TrackInfo) {
+	p.params.Logger.Debugw("sending track published", "cid", cid, "trackInfo", ti.String())
+	_ = p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_TrackPublished{
+			TrackPublished: &tc.TrackPublishedResponse{
+				Cid:   cid,
+				Track: ti,
+			},
+		},
+	})
+}
+
+// sendTrackPublishRejected 发布请求被拒绝时只返回 cid，不带音轨信息
+func (p *ParticipantImpl) sendTrackPublishRejected(cid string) {
+	_ = p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_TrackPublished{
+			TrackPublished: &tc.TrackPublishedResponse{
+				Cid: cid,
+			},
+		},
+	})
+}
+
+func (p *ParticipantImpl) sendTrackUnpublished(trackID tc.TrackID) {
+	_ = p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_TrackUnpublished{
+			TrackUnpublished: &tc.TrackUnpublishedResponse{
+				TrackSid: string(trackID),
+			},
+		},
+	})
+}
+
+func (p *ParticipantImpl) sendTrackMuted(trackID tc.TrackID, muted bool) {
+	_ = p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_Mute{
+			Mute: &tc.MuteTrackRequest{
+				Sid:   string(trackID),
+				Muted: muted,
+			},
+		},
+	})
+}
+
+func (p *ParticipantImpl) sendLeave(reason types.ParticipantCloseReason, isExpectedToResume bool) {
+	_ = p.writeMessage(&tc.SignalResponse{
+		Message: &tc.SignalResponse_Leave{
+			Leave: &tc.LeaveRequest{
+				CanReconnect: isExpectedToResume,
+				Reason:       reason.ToDisconnectReason(),
+			},
+		},
+	})
+}
+
+func (p *ParticipantImpl) writeMessage(msg *tc.SignalResponse) error {
+	if p.IsDisconnected() {
+		if _, isLeave := msg.Message.(*tc.SignalResponse_Leave); !isLeave {
+			return nil
+		}
+	}
+
+	sink := p.getResponseSink()
+	if sink == nil {
+		p.params.Logger.Debugw("could not send message to participant, no sink", "messageType", messageTypeName(msg))
+		return nil
+	}
+
+	if err := sink.WriteMessage(msg); err != nil {
+		p.params.Logger.Warnw("could not send message to participant", err, "messageType", messageTypeName(msg))
+		return err
+	}
+	return nil
+}
+
+func messageTypeName(msg proto.Message) string {
+	if res, ok := msg.(*tc.SignalResponse); ok && res.Message != nil {
+		return fmt.Sprintf("%T", res.Message)
+	}
+	return string(msg.ProtoReflect().Descriptor().Name())
+}
+
+// ToProtoSessionDescription 转化为协议对象
+func ToProtoSessionDescription(sd webrtc.SessionDescription) *tc.SessionDescription {
+	return &tc.SessionDescription{
+		Type: sd.Type.String(),
+		Sdp:  sd.SDP,
+	}
+}
+
+// FromProtoSessionDescription 由协议对象转化为 pion 会话描述
+func FromProtoSessionDescription(sd *tc.SessionDescription) webrtc.SessionDescription {
+	return webrtc.SessionDescription{
+		Type: webrtc.NewSDPType(sd.Type),
+		SDP:  sd.Sdp,
+	}
+}
+
+// FromProtoTrickle 解析 ICE 候选
+func FromProtoTrickle(trickle *tc.TrickleRequest) (webrtc.ICECandidateInit, error) {
+	ci := webrtc.ICECandidateInit{}
+	err := json.Unmarshal([]byte(trickle.CandidateInit), &ci)
+	return ci, err
+}
@@ -0,0 +1,78 @@
+package rtc
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+
+	"github.com/liuhailove/tc-server/pkg/telemetry"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+)
+
+const (
+	// rtpStatsInterval 汇总收发统计的间隔
+	rtpStatsInterval = time.Second
+	// senderReportInterval 向订阅者发送下行音轨发送者报告的间隔
+	senderReportInterval = 5 * time.Second
+	// senderReportBatchSize 一个 RTCP 复合包中最多包含的发送者报告数量
+	senderReportBatchSize = 10
+)
+
+// rtpStatsWorker 定期将发布连接的接收统计和订阅音轨的发送统计计入节点的指标，节点的接收码率以此为准
+func (p *ParticipantImpl) rtpStatsWorker() {
+	ticker := time.NewTicker(rtpStatsInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if p.IsClosed() {
+			return
+		}
+		p.recordRTPStats()
+	}
+}
+
+func (p *ParticipantImpl) recordRTPStats() {
+	for _, delta := range p.bufferFactory.GetDeltaStats() {
+		telemetry.RecordRTPDelta(prometheus.Incoming, delta)
+	}
+	for _, st := range p.GetSubscribedTracks() {
+		if dt := st.DownTrack(); dt != nil {
+			telemetry.RecordRTPDelta(prometheus.Outgoing, dt.GetDeltaStats())
+		}
+	}
+}
+
+// downTracksRTCPWorker 定期为已经绑定的下行音轨发送 RTCP 发送者报告，订阅者的接收报告据此计算 RTT
+func (p *ParticipantImpl) downTracksRTCPWorker() {
+	ticker := time.NewTicker(senderReportInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if p.IsClosed() {
+			return
+		}
+		p.writeSenderReports()
+	}
+}
+
+func (p *ParticipantImpl) writeSenderReports() {
+	var pkts []rtcp.Packet
+	for _, st := range p.GetSubscribedTracks() {
+		dt := st.DownTrack()
+		if dt == nil {
+			continue
+		}
+		if sr := dt.CreateSenderReport(); sr != nil {
+			pkts = append(pkts, sr)
+		}
+	}
+
+	for len(pkts) > 0 {
+		n := len(pkts)
+		if n > senderReportBatchSize {
+			n = senderReportBatchSize
+		}
+		p.writeSubscriberRTCP(pkts[:n])
+		pkts = pkts[n:]
+	}
+}
@@ -0,0 +1,69 @@
+package rtc
+
+import (
+	"time"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+)
+
+const (
+	// tokenValidity 刷新后 token 的有效期，与客户端默认获取的 token 相同
+	tokenValidity = 6 * time.Hour
+	// tokenRefreshBefore token 过期前多久刷新
+	tokenRefreshBefore = 30 * time.Minute
+	// tokenCheckInterval 检查 token 是否需要刷新的间隔
+	tokenCheckInterval = time.Minute
+)
+
+// refreshToken 使用参与者当前的身份和授权重新签发 token 并通过信令发送给客户端，没有配置密钥时不刷新
+func (p *ParticipantImpl) refreshToken() error {
+	if p.params.KeyProvider == nil || p.params.APIKey == "" {
+		return nil
+	}
+	// 信令连接断开时消息发不出去，保持原来的过期时间，重连之后的下一次检查再刷新
+	if p.getResponseSink() == nil {
+		return nil
+	}
+	secret := p.params.KeyProvider.GetSecret(p.params.APIKey)
+	if secret == "" {
+		return auth.ErrKeysMissing
+	}
+
+	grants := p.ClaimGrants()
+	token, err := auth.NewAccessToken(p.params.APIKey, secret).
+		SetIdentity(string(p.params.Identity)).
+		SetName(grants.Name).
+		SetMetadata(grants.Metadata).
+		AddGrant(grants.Video).
+		SetValidFor(tokenValidity).
+		ToJWT()
+	if err != nil {
+		return err
+	}
+
+	if err := p.SendRefreshToken(token); err != nil {
+		return err
+	}
+	p.tokenExpiry.Store(time.Now().Add(tokenValidity))
+	return nil
+}
+
+// tokenRefreshWorker 在客户端的 token 过期之前刷新，保证长时间的会话仍然能够重连
+func (p *ParticipantImpl) tokenRefreshWorker() {
+	ticker := time.NewTicker(tokenCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.closeCh:
+			return
+		case <-ticker.C:
+		}
+		if time.Until(p.tokenExpiry.Load()) > tokenRefreshBefore {
+			continue
+		}
+		if err := p.refreshToken(); err != nil {
+			p.params.Logger.Warnw("could not refresh token", err)
+		}
+	}
+}
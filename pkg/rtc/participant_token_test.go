@@ -0,0 +1,104 @@
+package rtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+)
+
+func TestRefreshToken(t *testing.T) {
+	const (
+		apiKey    = "APIkey"
+		apiSecret = "secret-that-is-at-least-32-characters"
+	)
+
+	newParticipant := func() (*ParticipantImpl, *fakeClient) {
+		p, client := newProtocolTestParticipant(9)
+		p.params.APIKey = apiKey
+		p.params.KeyProvider = auth.NewSimpleKeyProvider(apiKey, apiSecret)
+		p.params.VersionGenerator = utils.NewDefaultTimedVersionGenerator()
+		p.grants = &auth.ClaimGrants{
+			Identity: "subscriber",
+			Name:     "name",
+			Video:    &auth.VideoGrant{RoomJoin: true, Room: "room"},
+		}
+		return p, client
+	}
+
+	verify := func(t *testing.T, msg *tc.SignalResponse) *auth.ClaimGrants {
+		token := msg.GetRefreshToken()
+		require.NotEmpty(t, token)
+		v, err := auth.ParseAPIToken(token)
+		require.NoError(t, err)
+		grants, err := v.Verify(apiSecret)
+		require.NoError(t, err)
+		return grants
+	}
+
+	t.Run("keeps identity and grants", func(t *testing.T) {
+		p, client := newParticipant()
+		require.NoError(t, p.refreshToken())
+
+		messages := client.received()
+		require.Len(t, messages, 1)
+		grants := verify(t, messages[0])
+		require.Equal(t, "name", grants.Name)
+		require.Equal(t, "room", grants.Video.Room)
+		require.WithinDuration(t, time.Now().Add(tokenValidity), p.tokenExpiry.Load(), time.Minute)
+	})
+
+	t.Run("picks up permission changes", func(t *testing.T) {
+		p, client := newParticipant()
+		require.True(t, p.SetPermission(&tc.ParticipantPermission{CanSubscribe: true}))
+
+		messages := client.received()
+		require.Len(t, messages, 1)
+		grants := verify(t, messages[0])
+		require.True(t, grants.Video.GetCanSubscribe())
+		require.False(t, grants.Video.GetCanPublish())
+	})
+
+	t.Run("signal connection closed", func(t *testing.T) {
+		p, client := newParticipant()
+		expiry := time.Now().Add(10 * time.Minute)
+		p.tokenExpiry.Store(expiry)
+
+		// 消息没有发出去，过期时间不变，下一次检查仍然会刷新
+		p.SetResponseSink(nil)
+		require.NoError(t, p.refreshToken())
+		require.Equal(t, expiry, p.tokenExpiry.Load())
+
+		p.SetResponseSink(client)
+		require.NoError(t, p.refreshToken())
+		require.Len(t, client.received(), 1)
+		require.True(t, p.tokenExpiry.Load().After(expiry))
+	})
+
+	t.Run("no key provider", func(t *testing.T) {
+		p, client := newProtocolTestParticipant(9)
+		require.NoError(t, p.refreshToken())
+		require.Empty(t, client.received())
+	})
+}
+
+func TestTokenRefreshWorkerStopsOnClose(t *testing.T) {
+	p, _ := newProtocolTestParticipant(9)
+	done := make(chan struct{})
+	go func() {
+		p.tokenRefreshWorker()
+		close(done)
+	}()
+
+	// 关闭后立即退出，不需要等到下一次检查
+	close(p.closeCh)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "token refresh worker did not stop")
+	}
+}
@@ -0,0 +1,1380 @@
+package rtc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+	"github.com/liuhailove/tc-server/pkg/sfu/connectionquality"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+)
+
+const (
+	// updateBatchInterval 参与者更新的批量发送间隔
+	updateBatchInterval = 200 * time.Millisecond
+	// emptyCheckInterval 检查房间是否为空的间隔
+	emptyCheckInterval = time.Second
+	// migrationRequestTimeout 向源节点取回会话状态或者通知迁移完成的超时时间
+	migrationRequestTimeout = 5 * time.Second
+)
+
+const (
+	// MigrationExportMethod 迁移的目标节点向源节点取回会话状态，请求为参与者标识，响应为 MigrationInfo 的JSON
+	MigrationExportMethod = "migration.export"
+	// MigrationCompleteMethod 目标会话连接建立后通知源节点关闭源会话，请求为参与者标识
+	MigrationCompleteMethod = "migration.complete"
+)
+
+// ParticipantOptions 参与者加入房间的选项
+type ParticipantOptions struct {
+	AutoSubscribe bool
+}
+
+// broadcastOptions 广播参与者状态的选项
+type broadcastOptions struct {
+	// skipSource 不发送给参与者自己
+	skipSource bool
+	// immediate 不等待批量发送
+	immediate bool
+}
+
+// Room 房间，管理参与者并在参与者之间转发更新
+type Room struct {
+	lock sync.RWMutex
+
+	protoRoom   *tc.Room
+	serverInfo  *tc.ServerInfo
+	audioConfig *config.AudioConfig
+	router      routing.MessageRouter
+	logger      logger.Logger
+
+	// metadataLock 串行化元数据的更新和广播，参与者按照版本顺序收到房间更新
+	metadataLock sync.Mutex
+	// metadataVersion 房间元数据的版本，每次更新加一，用于并发更新时的比较设置
+	metadataVersion uint32
+
+	participants    map[tc.ParticipantIdentity]types.LocalParticipant
+	participantOpts map[tc.ParticipantIdentity]*ParticipantOptions
+	// 上次广播时参与者是否隐藏，权限变化导致隐藏状态变化时需要通知其他参与者
+	participantHidden map[tc.ParticipantIdentity]bool
+	// 迁移中的源会话，目标会话迁移完成后关闭
+	migratingFrom map[tc.ParticipantIdentity]types.LocalParticipant
+	// 从其他节点迁移过来的参与者的源节点，迁移完成后通知源节点关闭源会话
+	migratingFromNode map[tc.ParticipantIdentity]tc.NodeID
+
+	// 等待批量发送的参与者更新，同一个参与者只保留最新的版本
+	batchedUpdatesMu sync.Mutex
+	batchedUpdates   map[tc.ParticipantIdentity]*tc.ParticipantInfo
+	// 同一身份被新会话替换的旧会话断开更新，先于其他更新发送
+	batchedDisconnects []*tc.ParticipantInfo
+
+	// 活跃发言者和主讲人
+	speakersLock sync.Mutex
+	speakers     *speakerAggregator
+
+	// 上次下发的参与者连接质量，只下发等级有变化的参与者
+	qualityLock         sync.Mutex
+	connectionQualities map[tc.ParticipantID]*tc.ConnectionQualityInfo
+
+	// 等待响应的 RPC 调用，以调用方身份和请求ID为键
+	rpcLock     sync.Mutex
+	pendingRpcs map[string]*pendingRpc
+
+	createdAt time.Time
+	joinedAt  atomic.Int64
+	leftAt    atomic.Int64
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	onParticipantChanged func(p types.LocalParticipant)
+	onClose              func()
+}
+
+// NewRoom 创建房间，房间为空超过 EmptyTimeout 后自动关闭，router 用于向其他节点上的参与者推送消息，可以为 nil
+func NewRoom(room *tc.Room, serverInfo *tc.ServerInfo, audioConfig *config.AudioConfig, router routing.MessageRouter, logger logger.Logger) *Room {
+	r := &Room{
+		protoRoom:           proto.Clone(room).(*tc.Room),
+		audioConfig:         audioConfig,
+		speakers:            newSpeakerAggregator(),
+		connectionQualities: make(map[tc.ParticipantID]*tc.ConnectionQualityInfo),
+		serverInfo:          serverInfo,
+		router:              router,
+		logger:              logger.WithValues("room", room.Name, "roomID", room.Sid),
+		participants:        make(map[tc.ParticipantIdentity]types.LocalParticipant),
+		participantOpts:     make(map[tc.ParticipantIdentity]*ParticipantOptions),
+		participantHidden:   make(map[tc.ParticipantIdentity]bool),
+		migratingFrom:       make(map[tc.ParticipantIdentity]types.LocalParticipant),
+		migratingFromNode:   make(map[tc.ParticipantIdentity]tc.NodeID),
+		batchedUpdates:      make(map[tc.ParticipantIdentity]*tc.ParticipantInfo),
+		pendingRpcs:         make(map[string]*pendingRpc),
+		createdAt:           time.Now(),
+		closed:              make(chan struct{}),
+	}
+	if r.protoRoom.CreationTime == 0 {
+		r.protoRoom.CreationTime = r.createdAt.Unix()
+	}
+
+	go r.changeUpdateWorker()
+	go r.emptyTimeoutWorker()
+	go r.audioUpdateWorker()
+	go r.connectionQualityWorker()
+
+	prometheus.RoomStarted()
+	return r
+}
+
+// Name 房间名称
+func (r *Room) Name() tc.RoomName {
+	return tc.RoomName(r.protoRoom.Name)
+}
+
+// ID 房间ID
+func (r *Room) ID() tc.RoomID {
+	return tc.RoomID(r.protoRoom.Sid)
+}
+
+// Logger 房间日志
+func (r *Room) Logger() logger.Logger {
+	return r.logger
+}
+
+// ToProto 转化为协议对象
+func (r *Room) ToProto() *tc.Room {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	room := proto.Clone(r.protoRoom).(*tc.Room)
+	room.NumParticipants = 0
+	room.NumPublishers = 0
+	for _, p := range r.participants {
+		if p.Hidden() {
+			continue
+		}
+		room.NumParticipants++
+		if p.IsPublisher() {
+			room.NumPublishers++
+		}
+	}
+	return room
+}
+
+// MetadataVersion 房间元数据的当前版本
+func (r *Room) MetadataVersion() uint32 {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.metadataVersion
+}
+
+// UpdateMetadata 更新房间元数据，expectedVersion 不为空时必须与当前版本一致，成功后通知所有参与者并返回新版本
+func (r *Room) UpdateMetadata(metadata string, expectedVersion *uint32) (uint32, error) {
+	if r.IsClosed() {
+		return 0, ErrRoomClosed
+	}
+
+	r.metadataLock.Lock()
+	defer r.metadataLock.Unlock()
+
+	r.lock.Lock()
+	if expectedVersion != nil && *expectedVersion != r.metadataVersion {
+		version := r.metadataVersion
+		r.lock.Unlock()
+		return version, ErrRoomMetadataVersionConflict
+	}
+	r.protoRoom.Metadata = metadata
+	r.metadataVersion++
+	version := r.metadataVersion
+	r.lock.Unlock()
+
+	r.logger.Debugw("room metadata updated", "version", version)
+	r.broadcastRoomUpdate()
+	return version, nil
+}
+
+// updateActiveRecording 房间中有录制者时标记为正在录制，状态变化时通知所有参与者
+func (r *Room) updateActiveRecording() {
+	r.metadataLock.Lock()
+	defer r.metadataLock.Unlock()
+
+	r.lock.Lock()
+	recording := false
+	for _, p := range r.participants {
+		if p.IsRecorder() {
+			recording = true
+			break
+		}
+	}
+	changed := r.protoRoom.ActiveRecording != recording
+	r.protoRoom.ActiveRecording = recording
+	r.lock.Unlock()
+
+	if changed {
+		r.logger.Debugw("room recording changed", "activeRecording", recording)
+		r.broadcastRoomUpdate()
+	}
+}
+
+// broadcastRoomUpdate 将房间信息发送给已经加入的参与者，调用方持有 metadataLock 保证更新按顺序发送
+func (r *Room) broadcastRoomUpdate() {
+	roomInfo := r.ToProto()
+	for _, op := range r.GetParticipants() {
+		// 还没有收到加入响应的参与者会在加入响应中拿到最新的房间信息
+		if op.State() == tc.ParticipantInfo_JOINING {
+			continue
+		}
+		if err := op.SendRoomUpdate(roomInfo); err != nil {
+			r.logger.Debugw("could not send room update", "participant", op.Identity(), "error", err)
+		}
+	}
+}
+
+// GetParticipant 根据标识获取参与者
+func (r *Room) GetParticipant(identity tc.ParticipantIdentity) types.LocalParticipant {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.participants[identity]
+}
+
+// GetParticipantByID 根据ID获取参与者
+func (r *Room) GetParticipantByID(participantID tc.ParticipantID) types.LocalParticipant {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, p := range r.participants {
+		if p.ID() == participantID {
+			return p
+		}
+	}
+	return nil
+}
+
+// GetParticipants 获取全部参与者
+func (r *Room) GetParticipants() []types.LocalParticipant {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	participants := make([]types.LocalParticipant, 0, len(r.participants))
+	for _, p := range r.participants {
+		participants = append(participants, p)
+	}
+	return participants
+}
+
+// GetParticipantCount 参与者数量
+func (r *Room) GetParticipantCount() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return len(r.participants)
+}
+
+// FirstJoinedAt 第一个参与者加入的时间
+func (r *Room) FirstJoinedAt() int64 {
+	return r.joinedAt.Load()
+}
+
+// LastLeftAt 最后一个参与者离开的时间
+func (r *Room) LastLeftAt() int64 {
+	return r.leftAt.Load()
+}
+
+// IsClosed 房间是否已经关闭
+func (r *Room) IsClosed() bool {
+	select {
+	case <-r.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Join 参与者加入房间，相同标识的旧会话会被关闭
+func (r *Room) Join(participant types.LocalParticipant, opts *ParticipantOptions, iceServers []*tc.ICEServer) error {
+	if r.IsClosed() {
+		return ErrRoomClosed
+	}
+	if opts == nil {
+		opts = &ParticipantOptions{}
+	}
+
+	existing := r.GetParticipant(participant.Identity())
+	switch {
+	case existing != nil && existing.ID() != participant.ID():
+		if participant.MigrateState() == types.MigrateStateSync {
+			r.migrateParticipant(existing, participant)
+		} else {
+			r.logger.Infow("closing duplicate participant", "participant", existing.Identity(), "pID", existing.ID(), "newPID", participant.ID())
+			r.RemoveParticipant(existing.Identity(), existing.ID(), types.ParticipantCloseReasonDuplicateIdentity)
+		}
+	case existing == nil && participant.MigrateState() == types.MigrateStateSync:
+		r.migrateRemoteParticipant(participant)
+	}
+
+	r.lock.Lock()
+	if _, ok := r.participants[participant.Identity()]; ok {
+		r.lock.Unlock()
+		return ErrAlreadyJoined
+	}
+	if r.protoRoom.MaxParticipants > 0 && uint32(len(r.participants)) >= r.protoRoom.MaxParticipants {
+		r.lock.Unlock()
+		return ErrMaxParticipantsExceeded
+	}
+
+	r.setupParticipant(participant)
+	r.participants[participant.Identity()] = participant
+	r.participantOpts[participant.Identity()] = opts
+	r.participantHidden[participant.Identity()] = participant.Hidden()
+	r.joinedAt.CompareAndSwap(0, time.Now().Unix())
+
+	otherParticipants := make([]*tc.ParticipantInfo, 0, len(r.participants))
+	for _, op := range r.participants {
+		if op.ID() != participant.ID() && !op.Hidden() {
+			otherParticipants = append(otherParticipants, op.ToProto())
+		}
+	}
+	r.lock.Unlock()
+
+	r.logger.Infow("new participant joined",
+		"participant", participant.Identity(),
+		"pID", participant.ID(),
+		"protocol", participant.ProtocolVersion(),
+		"autoSubscribe", opts.AutoSubscribe,
+	)
+
+	if err := participant.SendJoinResponse(&tc.JoinResponse{
+		Room:              r.ToProto(),
+		Participant:       participant.ToProto(),
+		OtherParticipants: otherParticipants,
+		ServerInfo:        r.serverInfo,
+		IceServers:        iceServers,
+		SifTrailer:        participant.GetTrailer(),
+	}); err != nil {
+		r.RemoveParticipant(participant.Identity(), participant.ID(), types.ParticipantCloseReasonJoinFailed)
+		return err
+	}
+
+	prometheus.IncrementParticipantJoin(prometheus.JoinStateJoined)
+	r.broadcastParticipantState(participant, broadcastOptions{skipSource: true, immediate: true})
+	if participant.IsRecorder() {
+		r.updateActiveRecording()
+	}
+	if opts.AutoSubscribe {
+		r.subscribeToExistingTracks(participant)
+	}
+	r.notifyParticipantChanged(participant)
+	return nil
+}
+
+func (r *Room) setupParticipant(participant types.LocalParticipant) {
+	participant.OnStateChange(r.onParticipantStateChange)
+	participant.OnTrackPublished(r.onTrackPublished)
+	participant.OnTrackUpdated(r.onTrackUpdated)
+	participant.OnTrackUnpublished(r.onTrackUnpublished)
+	participant.OnParticipantUpdate(r.onParticipantUpdate)
+	participant.OnDataPacket(r.onDataPacket)
+	participant.OnAttributesChanged(r.onAttributesChanged)
+	participant.OnMigrateStateChange(r.onMigrateStateChange)
+	participant.OnClose(func(p types.LocalParticipant) {
+		r.RemoveParticipant(p.Identity(), p.ID(), types.ParticipantCloseReasonStateDisconnected)
+	})
+}
+
+// RemoveParticipant 移除参与者，pID 与当前会话不一致时忽略
+func (r *Room) RemoveParticipant(identity tc.ParticipantIdentity, pID tc.ParticipantID, reason types.ParticipantCloseReason) {
+	r.lock.Lock()
+	p, ok := r.participants[identity]
+	if !ok || (pID != "" && p.ID() != pID) {
+		r.lock.Unlock()
+		return
+	}
+	delete(r.participants, identity)
+	delete(r.participantOpts, identity)
+	delete(r.participantHidden, identity)
+	delete(r.migratingFromNode, identity)
+	if len(r.participants) == 0 {
+		r.leftAt.Store(time.Now().Unix())
+	}
+	r.lock.Unlock()
+
+	r.logger.Infow("removing participant", "participant", identity, "pID", p.ID(), "reason", reason.String())
+
+	// 取消其他参与者对该参与者音轨的订阅
+	for _, track := range p.GetPublishedTracks() {
+		for _, subID := range track.GetAllSubscribers() {
+			track.RemoveSubscriber(subID, false)
+		}
+	}
+
+	p.OnStateChange(nil)
+	p.OnTrackPublished(nil)
+	p.OnTrackUpdated(nil)
+	p.OnTrackUnpublished(nil)
+	p.OnParticipantUpdate(nil)
+	p.OnDataPacket(nil)
+	p.OnAttributesChanged(nil)
+	p.OnMigrateStateChange(nil)
+	p.OnClose(nil)
+
+	_ = p.Close(true, reason, false)
+	r.failRpcsForParticipant(identity)
+
+	r.speakersLock.Lock()
+	previousDominant := r.speakers.dominant
+	speaker := r.speakers.remove(p.ID())
+	dominant := r.speakers.dominant
+	r.speakersLock.Unlock()
+	if speaker != nil {
+		r.sendSpeakerChanges([]*tc.SpeakerInfo{speaker})
+	}
+	if dominant != previousDominant {
+		r.broadcastDataPacket(nil, dominantSpeakerPacket(dominant))
+	}
+
+	r.qualityLock.Lock()
+	delete(r.connectionQualities, p.ID())
+	r.qualityLock.Unlock()
+
+	pi := p.ToProto()
+	pi.State = tc.ParticipantInfo_DISCONNECTED
+	if !p.Hidden() {
+		r.sendParticipantUpdates(r.pushAndDequeueUpdates(pi, true))
+	}
+	if p.IsRecorder() {
+		r.updateActiveRecording()
+	}
+	r.notifyParticipantChanged(p)
+}
+
+// StartMigration 通知参与者迁移到其他节点，并记录当前节点为源节点，目标节点据此取回会话状态
+func (r *Room) StartMigration(identity tc.ParticipantIdentity, force bool) bool {
+	p := r.GetParticipant(identity)
+	if p == nil {
+		return false
+	}
+	return p.MaybeStartMigration(force, func() {
+		if r.router == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), migrationRequestTimeout)
+		defer cancel()
+		if err := r.router.SetMigrationSource(ctx, r.Name(), identity); err != nil {
+			r.logger.Warnw("could not record migration source", err, "participant", identity)
+		}
+	})
+}
+
+// ExportMigration 导出参与者的会话状态，源会话从房间中移出，保持连接直到目标会话迁移完成
+func (r *Room) ExportMigration(identity tc.ParticipantIdentity) ([]byte, error) {
+	source := r.GetParticipant(identity)
+	if source == nil {
+		return nil, ErrParticipantNotFound
+	}
+
+	data, err := json.Marshal(source.ExportMigrationInfo())
+	if err != nil {
+		return nil, err
+	}
+	r.detachMigrationSource(source)
+	return data, nil
+}
+
+// CompleteMigration 目标会话迁移完成，关闭源会话
+func (r *Room) CompleteMigration(identity tc.ParticipantIdentity) {
+	r.lock.Lock()
+	source := r.migratingFrom[identity]
+	delete(r.migratingFrom, identity)
+	r.lock.Unlock()
+	if source == nil {
+		return
+	}
+
+	r.logger.Infow("migration complete, closing source participant", "participant", identity, "pID", source.ID())
+	_ = source.Close(false, types.ParticipantCloseReasonMigrationComplete, false)
+}
+
+// migrateParticipant 源会话和目标会话在同一个节点上，状态同样经过序列化，和跨节点迁移保持一致
+func (r *Room) migrateParticipant(source types.LocalParticipant, target types.LocalParticipant) {
+	r.logger.Infow("migrating participant", "participant", source.Identity(), "fromPID", source.ID(), "toPID", target.ID())
+	data, err := r.ExportMigration(source.Identity())
+	if err == nil {
+		err = importMigrationInfo(target, data)
+	}
+	if err != nil {
+		r.logger.Warnw("could not migrate participant", err, "participant", source.Identity())
+	}
+}
+
+// migrateRemoteParticipant 向源节点取回会话状态，失败时目标会话按照新会话订阅
+func (r *Room) migrateRemoteParticipant(target types.LocalParticipant) {
+	if r.router == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrationRequestTimeout)
+	defer cancel()
+	nodeID, err := r.router.TakeMigrationSource(ctx, r.Name(), target.Identity())
+	if err != nil {
+		r.logger.Infow("no migration source for participant", "participant", target.Identity(), "error", err)
+		return
+	}
+
+	r.logger.Infow("migrating participant from remote node", "participant", target.Identity(), "nodeID", nodeID, "toPID", target.ID())
+	data, err := r.router.RequestNode(ctx, nodeID, r.Name(), MigrationExportMethod, []byte(target.Identity()))
+	if err == nil {
+		err = importMigrationInfo(target, data)
+	}
+	if err != nil {
+		r.logger.Warnw("could not migrate participant from remote node", err, "participant", target.Identity(), "nodeID", nodeID)
+		return
+	}
+
+	r.lock.Lock()
+	r.migratingFromNode[target.Identity()] = nodeID
+	r.lock.Unlock()
+}
+
+func importMigrationInfo(target types.LocalParticipant, data []byte) error {
+	info := &types.MigrationInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return err
+	}
+	target.ImportMigrationInfo(info)
+	return nil
+}
+
+// detachMigrationSource 源会话不再参与房间的广播，只等待迁移完成
+func (r *Room) detachMigrationSource(source types.LocalParticipant) {
+	r.lock.Lock()
+	if r.participants[source.Identity()] == source {
+		delete(r.participants, source.Identity())
+		delete(r.participantOpts, source.Identity())
+		delete(r.participantHidden, source.Identity())
+	}
+	if previous := r.migratingFrom[source.Identity()]; previous != nil && previous != source {
+		defer func() {
+			_ = previous.Close(false, types.ParticipantCloseReasonMigrationComplete, false)
+		}()
+	}
+	r.migratingFrom[source.Identity()] = source
+	r.lock.Unlock()
+
+	source.OnStateChange(nil)
+	source.OnTrackPublished(nil)
+	source.OnTrackUpdated(nil)
+	source.OnTrackUnpublished(nil)
+	source.OnParticipantUpdate(nil)
+	source.OnDataPacket(nil)
+	source.OnAttributesChanged(nil)
+	source.OnMigrateStateChange(nil)
+	source.OnClose(func(p types.LocalParticipant) {
+		r.lock.Lock()
+		if r.migratingFrom[p.Identity()] == p {
+			delete(r.migratingFrom, p.Identity())
+		}
+		r.lock.Unlock()
+	})
+}
+
+func (r *Room) onMigrateStateChange(p types.LocalParticipant, migrateState types.MigrateState) {
+	if migrateState != types.MigrateStateComplete {
+		return
+	}
+
+	r.lock.Lock()
+	nodeID, remote := r.migratingFromNode[p.Identity()]
+	delete(r.migratingFromNode, p.Identity())
+	r.lock.Unlock()
+	if !remote {
+		r.CompleteMigration(p.Identity())
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), migrationRequestTimeout)
+		defer cancel()
+		if _, err := r.router.RequestNode(ctx, nodeID, r.Name(), MigrationCompleteMethod, []byte(p.Identity())); err != nil {
+			r.logger.Warnw("could not notify migration source", err, "participant", p.Identity(), "nodeID", nodeID)
+		}
+	}()
+}
+
+// ResolveMediaTrack 查找房间中发布的音轨以及订阅者的权限，作为参与者订阅时的 TrackResolver
+func (r *Room) ResolveMediaTrack(subscriber types.LocalParticipant, trackID tc.TrackID) types.MediaResolverResult {
+	res := types.MediaResolverResult{}
+	for _, p := range r.GetParticipants() {
+		// 隐藏的参与者对其他人不可见，它发布的音轨也不能被订阅
+		if p.Hidden() {
+			continue
+		}
+		if track := p.GetPublishedTrack(trackID); track != nil {
+			res.Track = track
+			res.PublisherID = p.ID()
+			res.PublisherIdentity = p.Identity()
+			res.HasPermission = p.HasPermission(trackID, subscriber.Identity())
+			break
+		}
+	}
+	return res
+}
+
+// UpdateSubscriptionPermission 更新发布者的订阅权限，比较更新前后每个订阅者可以订阅的音轨：
+// 本节点上新获得权限的订阅者收到通知，失去权限的订阅由发布者直接撤销，
+// 新旧权限中列出的其他节点上的订阅者通过路由取消订阅失去权限的音轨
+func (r *Room) UpdateSubscriptionPermission(participant types.LocalParticipant, subscriptionPermission *tc.SubscriptionPermission) error {
+	// 允许全部参与者和只允许部分参与者之间切换时，房间中的每个参与者都可能受影响
+	oldPermission, _ := participant.SubscriptionPermission()
+	candidates := make(map[tc.ParticipantIdentity]struct{})
+	for _, op := range r.GetParticipants() {
+		if op.ID() != participant.ID() {
+			candidates[op.Identity()] = struct{}{}
+		}
+	}
+	for _, identity := range permissionIdentities(oldPermission, r.resolveParticipantByID) {
+		candidates[identity] = struct{}{}
+	}
+	for _, identity := range permissionIdentities(subscriptionPermission, r.resolveParticipantByID) {
+		candidates[identity] = struct{}{}
+	}
+
+	tracks := participant.GetPublishedTracks()
+	previouslyAllowed := make(map[tc.ParticipantIdentity]map[tc.TrackID]bool, len(candidates))
+	for identity := range candidates {
+		allowed := make(map[tc.TrackID]bool, len(tracks))
+		for _, track := range tracks {
+			allowed[track.ID()] = participant.HasPermission(track.ID(), identity)
+		}
+		previouslyAllowed[identity] = allowed
+	}
+
+	if err := participant.UpdateSubscriptionPermission(subscriptionPermission, utils.TimedVersion{}, r.resolveParticipantByIdentity, r.resolveParticipantByID); err != nil {
+		return err
+	}
+
+	for identity, allowed := range previouslyAllowed {
+		var granted, revoked []tc.TrackID
+		for _, track := range tracks {
+			nowAllowed := participant.HasPermission(track.ID(), identity)
+			switch {
+			case !allowed[track.ID()] && nowAllowed:
+				granted = append(granted, track.ID())
+			case allowed[track.ID()] && !nowAllowed:
+				revoked = append(revoked, track.ID())
+			}
+		}
+
+		if sub := r.GetParticipant(identity); sub != nil {
+			// 失去权限的订阅已经由发布者撤销并通知
+			for _, trackID := range granted {
+				sub.SubscriptionPermissionUpdate(participant.ID(), trackID, true)
+			}
+			continue
+		}
+		if len(revoked) != 0 {
+			r.pushSubscriptionRevocation(participant, identity, revoked)
+		}
+	}
+	return nil
+}
+
+// pushSubscriptionRevocation 通知其他节点上的订阅者取消订阅失去权限的音轨
+func (r *Room) pushSubscriptionRevocation(publisher types.LocalParticipant, identity tc.ParticipantIdentity, trackIDs []tc.TrackID) {
+	if r.router == nil {
+		return
+	}
+
+	trackSids := make([]string, 0, len(trackIDs))
+	for _, trackID := range trackIDs {
+		trackSids = append(trackSids, string(trackID))
+	}
+	r.logger.Debugw("pushing subscription revocation to remote subscriber", "publisher", publisher.Identity(), "subscriber", identity, "trackIDs", trackSids)
+	err := r.router.WriteParticipantRTC(context.Background(), r.Name(), identity, &tc.RTCNodeMessage{
+		ParticipantKey: string(identity),
+		Message: &tc.RTCNodeMessage_UpdateSubscriptions{
+			UpdateSubscriptions: &tc.UpdateSubscriptionsRequest{
+				Room:      string(r.Name()),
+				Identity:  string(identity),
+				TrackSids: trackSids,
+				Subscribe: false,
+			},
+		},
+	})
+	if err != nil {
+		r.logger.Warnw("could not push subscription revocation", err, "subscriber", identity)
+	}
+}
+
+func (r *Room) resolveParticipantByIdentity(identity tc.ParticipantIdentity) types.LocalParticipant {
+	return r.GetParticipant(identity)
+}
+
+func (r *Room) resolveParticipantByID(participantID tc.ParticipantID) types.LocalParticipant {
+	return r.GetParticipantByID(participantID)
+}
+
+// UpdateSubscriptions 更新参与者的订阅
+func (r *Room) UpdateSubscriptions(participant types.LocalParticipant, trackIDs []tc.TrackID, participantTracks []*tc.ParticipantTracks, subscribe bool) {
+	for _, pt := range participantTracks {
+		for _, trackID := range pt.TrackSids {
+			trackIDs = append(trackIDs, tc.TrackID(trackID))
+		}
+	}
+
+	for _, trackID := range trackIDs {
+		if subscribe {
+			participant.SubscribeToTrack(trackID)
+		} else {
+			participant.UnsubscribeFromTrack(trackID)
+		}
+	}
+}
+
+// CloseIfEmpty 房间为空并且超过 EmptyTimeout 后关闭房间
+func (r *Room) CloseIfEmpty() {
+	if r.IsClosed() {
+		return
+	}
+
+	r.lock.RLock()
+	numParticipants := len(r.participants)
+	emptyTimeout := r.protoRoom.EmptyTimeout
+	r.lock.RUnlock()
+	if numParticipants > 0 {
+		return
+	}
+
+	var elapsed int64
+	if leftAt := r.leftAt.Load(); leftAt > 0 {
+		elapsed = time.Now().Unix() - leftAt
+	} else {
+		elapsed = time.Now().Unix() - r.createdAt.Unix()
+	}
+
+	if elapsed >= int64(emptyTimeout) {
+		r.logger.Infow("closing empty room", "emptyTimeout", emptyTimeout)
+		r.Close()
+	}
+}
+
+// Close 关闭房间以及房间中的全部参与者
+func (r *Room) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+
+		r.logger.Infow("closing room")
+		for _, p := range r.GetParticipants() {
+			_ = p.Close(true, types.ParticipantCloseReasonRoomClose, false)
+		}
+
+		r.lock.Lock()
+		migratingFrom := r.migratingFrom
+		r.migratingFrom = make(map[tc.ParticipantIdentity]types.LocalParticipant)
+		r.lock.Unlock()
+		for _, p := range migratingFrom {
+			_ = p.Close(false, types.ParticipantCloseReasonRoomClose, false)
+		}
+		r.failAllRpcs()
+		prometheus.RoomEnded()
+
+		r.lock.RLock()
+		onClose := r.onClose
+		r.lock.RUnlock()
+		if onClose != nil {
+			onClose()
+		}
+	})
+}
+
+// OnClose 房间关闭事件
+func (r *Room) OnClose(f func()) {
+	r.lock.Lock()
+	r.onClose = f
+	r.lock.Unlock()
+}
+
+// OnParticipantChanged 参与者变化事件
+func (r *Room) OnParticipantChanged(f func(participant types.LocalParticipant)) {
+	r.lock.Lock()
+	r.onParticipantChanged = f
+	r.lock.Unlock()
+}
+
+// ---------------- 参与者事件 ----------------
+
+func (r *Room) onParticipantStateChange(p types.LocalParticipant, oldState tc.ParticipantInfo_State) {
+	r.logger.Debugw("participant state changed", "participant", p.Identity(), "state", p.State().String(), "oldState", oldState.String())
+	if p.State() == tc.ParticipantInfo_DISCONNECTED {
+		go r.RemoveParticipant(p.Identity(), p.ID(), types.ParticipantCloseReasonStateDisconnected)
+		return
+	}
+
+	r.broadcastParticipantState(p, broadcastOptions{skipSource: true})
+	r.notifyParticipantChanged(p)
+
+	// 数据通道在 ACTIVE 之后才可用，此时补发房间中其他参与者的全部属性、当前的活跃发言者和主讲人
+	if p.State() == tc.ParticipantInfo_ACTIVE {
+		r.sendAttributesSnapshot(p)
+		if speakers := r.GetActiveSpeakers(); len(speakers) != 0 {
+			_ = p.SendSpeakerUpdate(speakers, false)
+		}
+		if dominant := r.GetDominantSpeaker(); dominant != "" && p.SubscribesToDataTopic(DominantSpeakerTopic) {
+			dp := dominantSpeakerPacket(dominant)
+			if data, err := proto.Marshal(dp); err == nil {
+				_ = p.SendDataPacket(dp, data)
+			}
+		}
+		if qualities := r.connectionQualitySnapshot(); len(qualities) != 0 {
+			_ = p.SendConnectionQualityUpdate(&tc.ConnectionQualityUpdate{Updates: qualities})
+		}
+	}
+}
+
+// onAttributesChanged 属性变化以增量的形式广播给包括参与者自己在内的所有人
+func (r *Room) onAttributesChanged(p types.LocalParticipant, delta *types.ParticipantAttributesDelta) {
+	update := &ParticipantAttributesUpdate{
+		ParticipantSid:             string(p.ID()),
+		ParticipantIdentity:        string(p.Identity()),
+		ParticipantAttributesDelta: *delta,
+	}
+	payload, err := json.Marshal(update)
+	if err != nil {
+		r.logger.Errorw("could not marshal attributes update", err)
+		return
+	}
+
+	up := &tc.UserPacket{
+		Payload: payload,
+		Topic:   ParticipantAttributesTopic,
+	}
+	if p.Hidden() {
+		up.DestinationIdentities = []string{string(p.Identity())}
+	}
+	r.broadcastDataPacket(nil, &tc.DataPacket{
+		Kind: tc.DataPacket_RELIABLE,
+		Value: &tc.DataPacket_User{
+			User: up,
+		},
+	})
+	r.broadcastParticipantState(p, broadcastOptions{skipSource: false})
+	r.notifyParticipantChanged(p)
+}
+
+// sendAttributesSnapshot 将房间中其他参与者的全部属性发送给参与者
+func (r *Room) sendAttributesSnapshot(p types.LocalParticipant) {
+	for _, op := range r.GetParticipants() {
+		if op.ID() == p.ID() || op.Hidden() {
+			continue
+		}
+		attributes := op.GetAttributes()
+		if len(attributes) == 0 {
+			continue
+		}
+
+		payload, err := json.Marshal(&ParticipantAttributesUpdate{
+			ParticipantSid:      string(op.ID()),
+			ParticipantIdentity: string(op.Identity()),
+			ParticipantAttributesDelta: types.ParticipantAttributesDelta{
+				Version: op.ToProto().Version,
+				Changed: attributes,
+			},
+		})
+		if err != nil {
+			r.logger.Errorw("could not marshal attributes update", err)
+			continue
+		}
+		dp := &tc.DataPacket{
+			Kind: tc.DataPacket_RELIABLE,
+			Value: &tc.DataPacket_User{
+				User: &tc.UserPacket{
+					Payload: payload,
+					Topic:   ParticipantAttributesTopic,
+				},
+			},
+		}
+		data, err := proto.Marshal(dp)
+		if err != nil {
+			continue
+		}
+		if err := p.SendDataPacket(dp, data); err != nil {
+			r.logger.Debugw("could not send attributes snapshot", "participant", p.Identity(), "error", err)
+		}
+	}
+}
+
+// onTrackPublished 新发布的音轨，自动订阅的参与者订阅该音轨
+func (r *Room) onTrackPublished(participant types.LocalParticipant, track types.MediaTrack) {
+	r.broadcastParticipantState(participant, broadcastOptions{skipSource: true})
+	if !participant.Hidden() {
+		r.autoSubscribe(participant, []types.MediaTrack{track})
+	}
+	r.notifyParticipantChanged(participant)
+}
+
+// autoSubscribe 开启自动订阅的其他参与者订阅发布者的音轨
+func (r *Room) autoSubscribe(publisher types.LocalParticipant, tracks []types.MediaTrack) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for identity, op := range r.participants {
+		if op.ID() == publisher.ID() {
+			continue
+		}
+		if opts := r.participantOpts[identity]; opts == nil || !opts.AutoSubscribe {
+			continue
+		}
+		for _, track := range tracks {
+			r.logger.Debugw("auto subscribing to track",
+				"publisher", publisher.Identity(),
+				"subscriber", op.Identity(),
+				"trackID", track.ID(),
+			)
+			op.SubscribeToTrack(track.ID())
+		}
+	}
+}
+
+func (r *Room) onTrackUpdated(p types.LocalParticipant, _ types.MediaTrack) {
+	// 静音状态等音轨信息变化需要通知客户端
+	r.broadcastParticipantState(p, broadcastOptions{skipSource: false})
+	r.notifyParticipantChanged(p)
+}
+
+func (r *Room) onTrackUnpublished(p types.LocalParticipant, _ types.MediaTrack) {
+	r.broadcastParticipantState(p, broadcastOptions{skipSource: true})
+	r.notifyParticipantChanged(p)
+}
+
+func (r *Room) onParticipantUpdate(p types.LocalParticipant) {
+	// 权限变化后被隐藏的参与者对其他人表现为离开，其他人不再订阅它的音轨，
+	// 取消隐藏后按正常的更新广播，开启自动订阅的参与者重新订阅
+	if r.updateParticipantHidden(p) {
+		if p.Hidden() {
+			r.sendHiddenAsDisconnected(p)
+			r.unsubscribeFromHidden(p)
+		} else {
+			r.autoSubscribe(p, p.GetPublishedTracks())
+		}
+	}
+
+	// 名称、元数据和权限变化，参与者自己也需要收到
+	r.broadcastParticipantState(p, broadcastOptions{skipSource: false})
+	r.updateActiveRecording()
+	r.notifyParticipantChanged(p)
+}
+
+// unsubscribeFromHidden 取消其他参与者对被隐藏的参与者音轨的订阅
+func (r *Room) unsubscribeFromHidden(p types.LocalParticipant) {
+	tracks := p.GetPublishedTracks()
+	if len(tracks) == 0 {
+		return
+	}
+	for _, op := range r.GetParticipants() {
+		if op.ID() == p.ID() {
+			continue
+		}
+		for _, track := range tracks {
+			op.UnsubscribeFromTrack(track.ID())
+		}
+	}
+}
+
+// updateParticipantHidden 记录参与者当前的隐藏状态，返回是否发生了变化
+func (r *Room) updateParticipantHidden(p types.LocalParticipant) bool {
+	hidden := p.Hidden()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	previous, ok := r.participantHidden[p.Identity()]
+	if !ok || previous == hidden {
+		return false
+	}
+	r.participantHidden[p.Identity()] = hidden
+	return true
+}
+
+// sendHiddenAsDisconnected 通知其他参与者该参与者已经离开，并丢弃还没有发送的更新
+func (r *Room) sendHiddenAsDisconnected(p types.LocalParticipant) {
+	pi := p.ToProto()
+	pi.State = tc.ParticipantInfo_DISCONNECTED
+
+	r.batchedUpdatesMu.Lock()
+	if existing, ok := r.batchedUpdates[p.Identity()]; ok && existing.Sid == pi.Sid {
+		delete(r.batchedUpdates, p.Identity())
+	}
+	r.batchedUpdatesMu.Unlock()
+
+	for _, op := range r.GetParticipants() {
+		if op.ID() == p.ID() || op.State() == tc.ParticipantInfo_JOINING {
+			continue
+		}
+		if err := op.SendParticipantUpdate([]*tc.ParticipantInfo{pi}); err != nil {
+			r.logger.Errorw("could not send update to participant", err, "participant", op.Identity())
+		}
+	}
+}
+
+func (r *Room) onDataPacket(source types.LocalParticipant, dp *tc.DataPacket) {
+	if up := dp.GetUser(); up != nil {
+		switch up.Topic {
+		case RpcRequestTopic:
+			r.handleRpcRequest(source, up)
+			return
+		case RpcResponseTopic:
+			r.handleRpcResponse(source, up)
+			return
+		}
+	}
+	r.broadcastDataPacket(source, dp)
+}
+
+// SendDataPacket 由服务端向房间中的参与者发送数据，目标为空时发送给所有人
+func (r *Room) SendDataPacket(up *tc.UserPacket, kind tc.DataPacket_Kind) {
+	dp := &tc.DataPacket{
+		Kind: kind,
+		Value: &tc.DataPacket_User{
+			User: up,
+		},
+	}
+	r.broadcastDataPacket(nil, dp)
+}
+
+// broadcastDataPacket 按目标身份、参与者ID和接收的主题过滤后转发数据包，source 为空表示服务端发送
+func (r *Room) broadcastDataPacket(source types.LocalParticipant, dp *tc.DataPacket) {
+	data, err := proto.Marshal(dp)
+	if err != nil {
+		r.logger.Errorw("could not marshal data packet", err)
+		return
+	}
+
+	var destinationSids, destinationIdentities []string
+	var topic string
+	if user := dp.GetUser(); user != nil {
+		destinationSids = user.DestinationSids
+		destinationIdentities = user.DestinationIdentities
+		topic = user.Topic
+	}
+
+	for _, op := range r.GetParticipants() {
+		if source != nil && op.ID() == source.ID() {
+			continue
+		}
+		if op.State() != tc.ParticipantInfo_ACTIVE {
+			continue
+		}
+		if len(destinationSids) != 0 || len(destinationIdentities) != 0 {
+			if !containsString(destinationSids, string(op.ID())) && !containsString(destinationIdentities, string(op.Identity())) {
+				continue
+			}
+		}
+		if !op.SubscribesToDataTopic(topic) {
+			continue
+		}
+		if err := op.SendDataPacket(dp, data); err != nil && dp.Kind == tc.DataPacket_RELIABLE {
+			r.logger.Debugw("could not send data packet", "participant", op.Identity(), "error", err)
+		}
+	}
+}
+
+// subscribeToExistingTracks 新加入的参与者订阅房间中已经发布的音轨
+func (r *Room) subscribeToExistingTracks(p types.LocalParticipant) {
+	var trackIDs []tc.TrackID
+	for _, op := range r.GetParticipants() {
+		if op.ID() == p.ID() || op.Hidden() {
+			continue
+		}
+		for _, track := range op.GetPublishedTracks() {
+			trackIDs = append(trackIDs, track.ID())
+		}
+	}
+	if len(trackIDs) == 0 {
+		return
+	}
+
+	r.logger.Debugw("subscribing to existing tracks", "participant", p.Identity(), "trackIDs", trackIDs)
+	for _, trackID := range trackIDs {
+		p.SubscribeToTrack(trackID)
+	}
+}
+
+// ---------------- 广播 ----------------
+
+// broadcastParticipantState 广播参与者状态，隐藏的参与者和可以跳过广播的参与者只发送给自己
+func (r *Room) broadcastParticipantState(p types.LocalParticipant, opts broadcastOptions) {
+	pi := p.ToProto()
+
+	if p.Hidden() || (p.CanSkipBroadcast() && !opts.immediate) {
+		if !opts.skipSource {
+			if err := p.SendParticipantUpdate([]*tc.ParticipantInfo{pi}); err != nil {
+				r.logger.Errorw("could not send update to participant", err, "participant", p.Identity())
+			}
+		}
+		return
+	}
+
+	updates := r.pushAndDequeueUpdates(pi, opts.immediate)
+	r.sendParticipantUpdates(updates)
+}
+
+// pushAndDequeueUpdates 加入批量更新，immediate 为 true 时立即取出全部更新
+func (r *Room) pushAndDequeueUpdates(pi *tc.ParticipantInfo, immediate bool) []*tc.ParticipantInfo {
+	r.batchedUpdatesMu.Lock()
+	defer r.batchedUpdatesMu.Unlock()
+
+	identity := tc.ParticipantIdentity(pi.Identity)
+	if existing, ok := r.batchedUpdates[identity]; ok {
+		// 断开状态总是保留，否则只保留更新的版本
+		if existing.State == tc.ParticipantInfo_DISCONNECTED && existing.Sid == pi.Sid {
+			pi = existing
+		} else if existing.Sid == pi.Sid && existing.Version > pi.Version {
+			pi = existing
+		} else if existing.Sid != pi.Sid && existing.State == tc.ParticipantInfo_DISCONNECTED {
+			r.batchedDisconnects = append(r.batchedDisconnects, existing)
+		}
+	}
+	r.batchedUpdates[identity] = pi
+
+	if !immediate {
+		return nil
+	}
+	return r.dequeueUpdatesLocked()
+}
+
+func (r *Room) dequeueUpdatesLocked() []*tc.ParticipantInfo {
+	if len(r.batchedUpdates) == 0 && len(r.batchedDisconnects) == 0 {
+		return nil
+	}
+
+	updates := make([]*tc.ParticipantInfo, 0, len(r.batchedDisconnects)+len(r.batchedUpdates))
+	updates = append(updates, r.batchedDisconnects...)
+	for _, pi := range r.batchedUpdates {
+		updates = append(updates, pi)
+	}
+	r.batchedUpdates = make(map[tc.ParticipantIdentity]*tc.ParticipantInfo)
+	r.batchedDisconnects = nil
+	return updates
+}
+
+func (r *Room) sendParticipantUpdates(updates []*tc.ParticipantInfo) {
+	if len(updates) == 0 {
+		return
+	}
+
+	for _, op := range r.GetParticipants() {
+		// 还没有收到加入响应的参与者会在加入响应中拿到全部参与者
+		if op.State() == tc.ParticipantInfo_JOINING {
+			continue
+		}
+		if err := op.SendParticipantUpdate(updates); err != nil {
+			r.logger.Errorw("could not send update to participant", err, "participant", op.Identity())
+		}
+	}
+}
+
+// changeUpdateWorker 定时发送批量的参与者更新
+func (r *Room) changeUpdateWorker() {
+	ticker := time.NewTicker(updateBatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-ticker.C:
+			r.batchedUpdatesMu.Lock()
+			updates := r.dequeueUpdatesLocked()
+			r.batchedUpdatesMu.Unlock()
+
+			r.sendParticipantUpdates(updates)
+		}
+	}
+}
+
+// GetActiveSpeakers 当前的活跃发言者，按音量从大到小排序
+func (r *Room) GetActiveSpeakers() []*tc.SpeakerInfo {
+	r.speakersLock.Lock()
+	defer r.speakersLock.Unlock()
+
+	return r.speakers.activeSpeakers()
+}
+
+// GetDominantSpeaker 当前的主讲人，没有人发言时返回空
+func (r *Room) GetDominantSpeaker() tc.ParticipantID {
+	r.speakersLock.Lock()
+	defer r.speakersLock.Unlock()
+
+	return r.speakers.dominant
+}
+
+// audioUpdateWorker 按 UpdateInterval 采样参与者音量，计算活跃发言者并下发变化
+func (r *Room) audioUpdateWorker() {
+	interval := defaultAudioUpdateInterval
+	if r.audioConfig != nil && r.audioConfig.UpdateInterval != 0 {
+		interval = time.Duration(r.audioConfig.UpdateInterval) * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-ticker.C:
+			r.updateSpeakers()
+		}
+	}
+}
+
+func (r *Room) updateSpeakers() {
+	levels := make(map[tc.ParticipantID]float64)
+	for _, p := range r.GetParticipants() {
+		if p.Hidden() {
+			continue
+		}
+		if level, active := p.GetAudioLevel(); active {
+			levels[p.ID()] = level
+		}
+	}
+
+	r.speakersLock.Lock()
+	previousDominant := r.speakers.dominant
+	changed := r.speakers.update(levels, time.Now())
+	dominant := r.speakers.dominant
+	r.speakersLock.Unlock()
+
+	r.sendSpeakerChanges(changed)
+	if dominant != previousDominant {
+		r.logger.Debugw("dominant speaker changed", "participant", dominant, "previous", previousDominant)
+		r.broadcastDataPacket(nil, dominantSpeakerPacket(dominant))
+	}
+}
+
+// dominantSpeakerPacket 通知主讲人变化的数据包
+func dominantSpeakerPacket(dominant tc.ParticipantID) *tc.DataPacket {
+	payload, _ := json.Marshal(&DominantSpeakerUpdate{ParticipantSid: string(dominant)})
+	return &tc.DataPacket{
+		Kind: tc.DataPacket_RELIABLE,
+		Value: &tc.DataPacket_User{
+			User: &tc.UserPacket{
+				Payload: payload,
+				Topic:   DominantSpeakerTopic,
+			},
+		},
+	}
+}
+
+// sendSpeakerChanges 下发发言者变化，不支持增量的客户端由参与者转换为完整列表
+func (r *Room) sendSpeakerChanges(changed []*tc.SpeakerInfo) {
+	if len(changed) == 0 {
+		return
+	}
+
+	for _, op := range r.GetParticipants() {
+		if err := op.SendSpeakerUpdate(changed, false); err != nil {
+			r.logger.Debugw("could not send speaker update", "participant", op.Identity(), "error", err)
+		}
+	}
+}
+
+// connectionQualityWorker 周期性计算参与者的连接质量，只下发等级有变化的参与者
+func (r *Room) connectionQualityWorker() {
+	ticker := time.NewTicker(connectionquality.DefaultUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-ticker.C:
+			r.updateConnectionQuality()
+		}
+	}
+}
+
+func (r *Room) updateConnectionQuality() {
+	participants := r.GetParticipants()
+
+	var changed []*tc.ConnectionQualityInfo
+	r.qualityLock.Lock()
+	for _, p := range participants {
+		if p.State() != tc.ParticipantInfo_ACTIVE || p.Hidden() {
+			continue
+		}
+		info := p.GetConnectionQuality()
+		if sent, ok := r.connectionQualities[p.ID()]; ok && sent.Quality == info.Quality {
+			continue
+		}
+		r.connectionQualities[p.ID()] = info
+		changed = append(changed, info)
+	}
+	r.qualityLock.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+
+	update := &tc.ConnectionQualityUpdate{Updates: changed}
+	for _, op := range participants {
+		if op.State() != tc.ParticipantInfo_ACTIVE {
+			continue
+		}
+		if err := op.SendConnectionQualityUpdate(update); err != nil {
+			r.logger.Debugw("could not send connection quality update", "participant", op.Identity(), "error", err)
+		}
+	}
+}
+
+// connectionQualitySnapshot 上次下发的全部参与者连接质量，用于新加入的参与者
+func (r *Room) connectionQualitySnapshot() []*tc.ConnectionQualityInfo {
+	r.qualityLock.Lock()
+	defer r.qualityLock.Unlock()
+
+	qualities := make([]*tc.ConnectionQualityInfo, 0, len(r.connectionQualities))
+	for _, info := range r.connectionQualities {
+		qualities = append(qualities, info)
+	}
+	return qualities
+}
+
+// emptyTimeoutWorker 定时检查房间是否为空
+func (r *Room) emptyTimeoutWorker() {
+	ticker := time.NewTicker(emptyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-ticker.C:
+			r.CloseIfEmpty()
+		}
+	}
+}
+
+func (r *Room) notifyParticipantChanged(p types.LocalParticipant) {
+	r.lock.RLock()
+	onParticipantChanged := r.onParticipantChanged
+	r.lock.RUnlock()
+	if onParticipantChanged != nil {
+		onParticipantChanged(p)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,572 @@
+package rtc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+func newTestWebRTCConfig(t *testing.T) *WebRTCConfig {
+	conf := config.DefaultConfig
+	conf.RTC.NodeIP = "127.0.0.1"
+	conf.RTC.UseExternalIP = false
+	// 不监听 ICE/TCP 端口，测试之间不会冲突
+	conf.RTC.TCPPort = 0
+	conf.RTC.CongestionControl.Enabled = false
+	rtcConf, err := NewWebRTCConfig(&conf)
+	require.NoError(t, err)
+	return rtcConf
+}
+
+// fakeClient 记录服务端下发的信令消息
+type fakeClient struct {
+	lock     sync.Mutex
+	messages []*tc.SignalResponse
+}
+
+func (c *fakeClient) WriteMessage(msg proto.Message) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.messages = append(c.messages, msg.(*tc.SignalResponse))
+	return nil
+}
+
+func (c *fakeClient) IsClosed() bool { return false }
+
+func (c *fakeClient) Close() {}
+
+func (c *fakeClient) ConnectionID() tc.ConnectionID { return "CO_fake" }
+
+func (c *fakeClient) received() []*tc.SignalResponse {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]*tc.SignalResponse{}, c.messages...)
+}
+
+// newTestParticipant 创建使用本地连接的参与者，信令写到 fakeClient
+func newTestParticipant(t *testing.T, rtcConf *WebRTCConfig, identity tc.ParticipantIdentity, opts ...func(params *ParticipantParams)) (*ParticipantImpl, *fakeClient) {
+	client := &fakeClient{}
+	params := ParticipantParams{
+		Identity:        identity,
+		SID:             tc.ParticipantID(utils.NewGuid(utils.ParticipantPrefix)),
+		Config:          rtcConf,
+		Sink:            client,
+		ProtocolVersion: types.CurrentProtocol,
+		Logger:          logger.GetLogger(),
+		Grants: &auth.ClaimGrants{
+			Identity: string(identity),
+			Video:    &auth.VideoGrant{RoomJoin: true, Room: "room"},
+		},
+	}
+	for _, opt := range opts {
+		opt(&params)
+	}
+	p, err := NewParticipant(params)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = p.Close(false, types.ParticipantCloseReasonRoomClose, false)
+	})
+	return p, client
+}
+
+func newTestRoom(t *testing.T, room *tc.Room) *Room {
+	if room.Name == "" {
+		room.Name = "room"
+	}
+	if room.Sid == "" {
+		room.Sid = utils.NewGuid(utils.RoomPrefix)
+	}
+	r := NewRoom(room, &tc.ServerInfo{}, nil, nil, logger.GetLogger())
+	t.Cleanup(r.Close)
+	return r
+}
+
+func TestRoomJoin(t *testing.T) {
+	rtcConf := newTestWebRTCConfig(t)
+
+	t.Run("join response lists other participants", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		p1, _ := newTestParticipant(t, rtcConf, "p1")
+		p2, client2 := newTestParticipant(t, rtcConf, "p2")
+
+		var changed []tc.ParticipantIdentity
+		var lock sync.Mutex
+		r.OnParticipantChanged(func(p types.LocalParticipant) {
+			lock.Lock()
+			changed = append(changed, p.Identity())
+			lock.Unlock()
+		})
+
+		require.NoError(t, r.Join(p1, nil, nil))
+		require.NoError(t, r.Join(p2, nil, nil))
+		require.Equal(t, 2, r.GetParticipantCount())
+		require.Equal(t, tc.ParticipantInfo_JOINED, p2.State())
+		require.NotZero(t, r.FirstJoinedAt())
+
+		messages := client2.received()
+		require.NotEmpty(t, messages)
+		join := messages[0].GetJoin()
+		require.NotNil(t, join)
+		require.Equal(t, "room", join.Room.Name)
+		require.Equal(t, string(p2.ID()), join.Participant.Sid)
+		require.Len(t, join.OtherParticipants, 1)
+		require.Equal(t, "p1", join.OtherParticipants[0].Identity)
+
+		lock.Lock()
+		joined := append([]tc.ParticipantIdentity{}, changed...)
+		lock.Unlock()
+		// 加入和状态变化都会通知
+		require.Contains(t, joined, tc.ParticipantIdentity("p1"))
+		require.Contains(t, joined, tc.ParticipantIdentity("p2"))
+	})
+
+	t.Run("max participants", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{MaxParticipants: 1})
+		p1, _ := newTestParticipant(t, rtcConf, "p1")
+		p2, _ := newTestParticipant(t, rtcConf, "p2")
+
+		require.NoError(t, r.Join(p1, nil, nil))
+		require.ErrorIs(t, r.Join(p2, nil, nil), ErrMaxParticipantsExceeded)
+		require.Nil(t, r.GetParticipant("p2"))
+	})
+
+	t.Run("duplicate identity replaces the old session", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		old, oldClient := newTestParticipant(t, rtcConf, "p1")
+		replacement, _ := newTestParticipant(t, rtcConf, "p1")
+
+		require.NoError(t, r.Join(old, nil, nil))
+		require.NoError(t, r.Join(replacement, nil, nil))
+		require.Equal(t, 1, r.GetParticipantCount())
+		require.Equal(t, replacement.ID(), r.GetParticipant("p1").ID())
+		require.True(t, old.IsClosed())
+
+		var leave *tc.LeaveRequest
+		for _, msg := range oldClient.received() {
+			if l := msg.GetLeave(); l != nil {
+				leave = l
+			}
+		}
+		require.NotNil(t, leave)
+		require.Equal(t, tc.DisconnectReason_DUPLICATE_IDENTITY, leave.Reason)
+
+		// 同一个会话不能重复加入
+		require.ErrorIs(t, r.Join(replacement, nil, nil), ErrAlreadyJoined)
+	})
+
+	t.Run("closed room", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		r.Close()
+		p1, _ := newTestParticipant(t, rtcConf, "p1")
+		require.ErrorIs(t, r.Join(p1, nil, nil), ErrRoomClosed)
+	})
+}
+
+func TestRoomRemoveParticipant(t *testing.T) {
+	rtcConf := newTestWebRTCConfig(t)
+	r := newTestRoom(t, &tc.Room{})
+	p1, _ := newTestParticipant(t, rtcConf, "p1")
+	p2, client2 := newTestParticipant(t, rtcConf, "p2")
+	require.NoError(t, r.Join(p1, nil, nil))
+	require.NoError(t, r.Join(p2, nil, nil))
+	p2.updateState(tc.ParticipantInfo_ACTIVE)
+
+	// 会话ID不一致时忽略
+	r.RemoveParticipant("p1", "PA_other", types.ParticipantCloseReasonServiceRequestRemoveParticipant)
+	require.NotNil(t, r.GetParticipant("p1"))
+
+	r.RemoveParticipant("p1", p1.ID(), types.ParticipantCloseReasonServiceRequestRemoveParticipant)
+	require.Nil(t, r.GetParticipant("p1"))
+	require.True(t, p1.IsClosed())
+	require.Zero(t, r.LastLeftAt())
+
+	var disconnected bool
+	for _, msg := range client2.received() {
+		for _, pi := range msg.GetUpdate().GetParticipants() {
+			if pi.Identity == "p1" && pi.State == tc.ParticipantInfo_DISCONNECTED {
+				disconnected = true
+			}
+		}
+	}
+	require.True(t, disconnected)
+
+	// 参与者自己断开时同样从房间中移除
+	require.NoError(t, p2.Close(false, types.ParticipantCloseReasonClientRequestLeave, false))
+	require.Eventually(t, func() bool {
+		return r.GetParticipantCount() == 0
+	}, time.Second, 10*time.Millisecond)
+	require.NotZero(t, r.LastLeftAt())
+}
+
+func TestRoomCloseIfEmpty(t *testing.T) {
+	rtcConf := newTestWebRTCConfig(t)
+
+	t.Run("keeps room with participants", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{EmptyTimeout: 0})
+		p1, _ := newTestParticipant(t, rtcConf, "p1")
+		require.NoError(t, r.Join(p1, nil, nil))
+
+		r.CloseIfEmpty()
+		require.False(t, r.IsClosed())
+	})
+
+	t.Run("waits for empty timeout", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{EmptyTimeout: 60})
+		r.CloseIfEmpty()
+		require.False(t, r.IsClosed())
+	})
+
+	t.Run("closes empty room after timeout", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{EmptyTimeout: 0})
+		closed := make(chan struct{})
+		r.OnClose(func() {
+			close(closed)
+		})
+		p1, _ := newTestParticipant(t, rtcConf, "p1")
+		require.NoError(t, r.Join(p1, nil, nil))
+		r.RemoveParticipant("p1", p1.ID(), types.ParticipantCloseReasonClientRequestLeave)
+
+		r.CloseIfEmpty()
+		require.True(t, r.IsClosed())
+		<-closed
+	})
+}
+
+// permissionUpdates 客户端收到的指定音轨的订阅权限通知
+func TestRoomUpdateMetadata(t *testing.T) {
+	t.Run("expected version", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		p := addDataReceiver(r, "p", tc.ParticipantInfo_ACTIVE)
+
+		version, err := r.UpdateMetadata("a", nil)
+		require.NoError(t, err)
+		require.Equal(t, uint32(1), version)
+
+		stale := uint32(0)
+		version, err = r.UpdateMetadata("b", &stale)
+		require.ErrorIs(t, err, ErrRoomMetadataVersionConflict)
+		require.Equal(t, uint32(1), version)
+		require.Equal(t, "a", r.ToProto().Metadata)
+
+		current := uint32(1)
+		version, err = r.UpdateMetadata("c", &current)
+		require.NoError(t, err)
+		require.Equal(t, uint32(2), version)
+
+		rooms := p.rooms()
+		require.Len(t, rooms, 2)
+		require.Equal(t, "a", rooms[0].Metadata)
+		require.Equal(t, "c", rooms[1].Metadata)
+	})
+
+	t.Run("concurrent updates are broadcast in version order", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		p := addDataReceiver(r, "p", tc.ParticipantInfo_ACTIVE)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := r.UpdateMetadata(fmt.Sprintf("m%d", i), nil)
+				require.NoError(t, err)
+			}(i)
+		}
+		wg.Wait()
+
+		// 参与者最后收到的一定是最新的元数据
+		rooms := p.rooms()
+		require.Len(t, rooms, 20)
+		require.Equal(t, uint32(20), r.MetadataVersion())
+		require.Equal(t, r.ToProto().Metadata, rooms[len(rooms)-1].Metadata)
+	})
+}
+
+func TestRoomDominantSpeaker(t *testing.T) {
+	r := newTestRoom(t, &tc.Room{})
+	a := addDataReceiver(r, "a", tc.ParticipantInfo_ACTIVE)
+	b := addDataReceiver(r, "b", tc.ParticipantInfo_ACTIVE)
+
+	dominantUpdates := func(f *fakeDataReceiver) []string {
+		var sids []string
+		for _, dp := range f.packets() {
+			if up := dp.GetUser(); up != nil && up.Topic == DominantSpeakerTopic {
+				update := &DominantSpeakerUpdate{}
+				require.NoError(t, json.Unmarshal(up.Payload, update))
+				sids = append(sids, update.ParticipantSid)
+			}
+		}
+		return sids
+	}
+
+	a.setAudioLevel(0.5)
+	r.updateSpeakers()
+	require.Equal(t, a.sid, r.GetDominantSpeaker())
+	require.Equal(t, []string{string(a.sid)}, dominantUpdates(b))
+
+	// 主讲人没有变化时不重复下发
+	r.updateSpeakers()
+	require.Len(t, dominantUpdates(b), 1)
+
+	a.setAudioLevel(0)
+	b.setAudioLevel(0.9)
+	r.updateSpeakers()
+	require.Equal(t, b.sid, r.GetDominantSpeaker())
+	require.Equal(t, []string{string(a.sid), string(b.sid)}, dominantUpdates(a))
+}
+
+func permissionUpdates(client *fakeClient, trackID tc.TrackID) []bool {
+	var updates []bool
+	for _, msg := range client.received() {
+		if update := msg.GetSubscriptionPermissionUpdate(); update != nil && update.TrackSid == string(trackID) {
+			updates = append(updates, update.Allowed)
+		}
+	}
+	return updates
+}
+
+func TestRoomUpdateSubscriptionPermission(t *testing.T) {
+	rtcConf := newTestWebRTCConfig(t)
+
+	t.Run("local subscriber is notified when all participants are allowed again", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		publisher, _ := newTestParticipant(t, rtcConf, "publisher")
+		sub, client := newTestParticipant(t, rtcConf, "sub")
+		require.NoError(t, r.Join(publisher, nil, nil))
+		require.NoError(t, r.Join(sub, nil, nil))
+		publisher.UpTrackManager.AddPublishedTrack(newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO}))
+
+		require.NoError(t, r.UpdateSubscriptionPermission(publisher, &tc.SubscriptionPermission{AllParticipants: false}))
+		require.False(t, publisher.HasPermission("TR_video", "sub"))
+
+		require.NoError(t, r.UpdateSubscriptionPermission(publisher, &tc.SubscriptionPermission{AllParticipants: true}))
+		require.True(t, publisher.HasPermission("TR_video", "sub"))
+		require.Equal(t, []bool{true}, permissionUpdates(client, "TR_video"))
+
+		// 权限没有变化时不重复通知
+		require.NoError(t, r.UpdateSubscriptionPermission(publisher, &tc.SubscriptionPermission{AllParticipants: true}))
+		require.Equal(t, []bool{true}, permissionUpdates(client, "TR_video"))
+	})
+
+	t.Run("revocation is pushed to delisted remote subscribers", func(t *testing.T) {
+		router := routing.NewLocalRouter(routing.NewLocalNodeFromProto(&tc.Node{Id: "ND_test"}))
+		t.Cleanup(router.Stop)
+		messages := make(chan *tc.RTCNodeMessage, 10)
+		router.OnRTCMessage(func(_ context.Context, _ tc.RoomName, _ tc.ParticipantIdentity, msg *tc.RTCNodeMessage) {
+			messages <- msg
+		})
+		require.NoError(t, router.Start())
+
+		r := NewRoom(&tc.Room{Name: "room", Sid: utils.NewGuid(utils.RoomPrefix)}, &tc.ServerInfo{}, nil, router, logger.GetLogger())
+		t.Cleanup(r.Close)
+		publisher, _ := newTestParticipant(t, rtcConf, "publisher")
+		require.NoError(t, r.Join(publisher, nil, nil))
+		publisher.UpTrackManager.AddPublishedTrack(newTestMediaTrack(&tc.TrackInfo{Sid: "TR_audio", Type: tc.TrackType_AUDIO}))
+		publisher.UpTrackManager.AddPublishedTrack(newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO}))
+
+		require.NoError(t, r.UpdateSubscriptionPermission(publisher, &tc.SubscriptionPermission{
+			TrackPermissions: []*tc.TrackPermission{
+				{ParticipantIdentity: "remote", AllTracks: true},
+				{ParticipantIdentity: "kept", AllTracks: true},
+			},
+		}))
+		require.NoError(t, r.UpdateSubscriptionPermission(publisher, &tc.SubscriptionPermission{
+			TrackPermissions: []*tc.TrackPermission{
+				{ParticipantIdentity: "remote", TrackSids: []string{"TR_audio"}},
+				{ParticipantIdentity: "kept", AllTracks: true},
+			},
+		}))
+
+		select {
+		case msg := <-messages:
+			require.Equal(t, "remote", msg.Identity)
+			update := msg.GetUpdateSubscriptions()
+			require.NotNil(t, update)
+			require.False(t, update.Subscribe)
+			require.Equal(t, []string{"TR_video"}, update.TrackSids)
+		case <-time.After(time.Second):
+			t.Fatal("revocation was not pushed")
+		}
+		select {
+		case msg := <-messages:
+			t.Fatalf("unexpected message for %s", msg.Identity)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+func isSubscriptionDesired(p *ParticipantImpl, trackID tc.TrackID) bool {
+	p.SubscriptionManager.lock.RLock()
+	sub := p.SubscriptionManager.subscriptions[trackID]
+	p.SubscriptionManager.lock.RUnlock()
+	return sub != nil && sub.isDesired()
+}
+
+func TestRoomParticipantVisibility(t *testing.T) {
+	rtcConf := newTestWebRTCConfig(t)
+	publishing := func(params *ParticipantParams) {
+		params.Grants.Video.SetCanPublish(true)
+		params.Grants.Video.SetCanSubscribe(true)
+	}
+
+	t.Run("hidden publisher tracks are unsubscribed", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		publisher, _ := newTestParticipant(t, rtcConf, "publisher", publishing)
+		sub, client := newTestParticipant(t, rtcConf, "sub")
+		require.NoError(t, r.Join(publisher, nil, nil))
+		require.NoError(t, r.Join(sub, &ParticipantOptions{AutoSubscribe: true}, nil))
+		publisher.UpTrackManager.AddPublishedTrack(newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO}))
+		sub.SubscribeToTrack("TR_video")
+		require.True(t, isSubscriptionDesired(sub, "TR_video"))
+
+		require.True(t, publisher.SetPermission(&tc.ParticipantPermission{CanPublish: true, CanSubscribe: true, Hidden: true}))
+		require.False(t, isSubscriptionDesired(sub, "TR_video"))
+		require.Nil(t, r.ResolveMediaTrack(sub, "TR_video").Track)
+
+		var disconnected bool
+		for _, msg := range client.received() {
+			for _, pi := range msg.GetUpdate().GetParticipants() {
+				if pi.Identity == "publisher" && pi.State == tc.ParticipantInfo_DISCONNECTED {
+					disconnected = true
+				}
+			}
+		}
+		require.True(t, disconnected)
+
+		// 取消隐藏后自动订阅的参与者重新订阅
+		require.True(t, publisher.SetPermission(&tc.ParticipantPermission{CanPublish: true, CanSubscribe: true}))
+		require.True(t, isSubscriptionDesired(sub, "TR_video"))
+		require.NotNil(t, r.ResolveMediaTrack(sub, "TR_video").Track)
+	})
+
+	t.Run("recorder marks the room as recording", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		p1, client := newTestParticipant(t, rtcConf, "p1")
+		require.NoError(t, r.Join(p1, nil, nil))
+		recording := func() []bool {
+			var updates []bool
+			for _, msg := range client.received() {
+				if room := msg.GetRoomUpdate().GetRoom(); room != nil {
+					updates = append(updates, room.ActiveRecording)
+				}
+			}
+			return updates
+		}
+
+		recorder, _ := newTestParticipant(t, rtcConf, "recorder", func(params *ParticipantParams) {
+			params.Grants.Video.Recorder = true
+			params.Grants.Video.Hidden = true
+		})
+		require.NoError(t, r.Join(recorder, nil, nil))
+		require.True(t, r.ToProto().ActiveRecording)
+		require.Equal(t, []bool{true}, recording())
+
+		require.True(t, recorder.SetPermission(&tc.ParticipantPermission{Hidden: true}))
+		require.False(t, r.ToProto().ActiveRecording)
+		require.Equal(t, []bool{true, false}, recording())
+
+		require.True(t, p1.SetPermission(&tc.ParticipantPermission{CanSubscribe: true, Recorder: true}))
+		require.Equal(t, []bool{true, false, true}, recording())
+
+		r.RemoveParticipant("p1", p1.ID(), types.ParticipantCloseReasonClientRequestLeave)
+		require.False(t, r.ToProto().ActiveRecording)
+	})
+}
+
+func TestRoomMigration(t *testing.T) {
+	rtcConf := newTestWebRTCConfig(t)
+	asMigration := func(params *ParticipantParams) {
+		params.Migration = true
+	}
+
+	t.Run("same node", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		source, _ := newTestParticipant(t, rtcConf, "p1")
+		require.NoError(t, r.Join(source, nil, nil))
+		require.NoError(t, source.UpdateAttributes(map[string]string{"role": "host"}, nil))
+
+		target, _ := newTestParticipant(t, rtcConf, "p1", asMigration)
+		require.NoError(t, r.Join(target, nil, nil))
+		require.Equal(t, target, r.GetParticipant("p1"))
+		require.Equal(t, "host", target.GetAttributes()["role"])
+		require.False(t, source.IsClosed())
+
+		target.SetMigrateState(types.MigrateStateComplete)
+		require.Eventually(t, source.IsClosed, time.Second, 10*time.Millisecond)
+		require.False(t, target.IsClosed())
+	})
+
+	t.Run("state is fetched from the source node through the router", func(t *testing.T) {
+		router := routing.NewLocalRouter(routing.NewLocalNodeFromProto(&tc.Node{Id: "ND_test"}))
+		t.Cleanup(router.Stop)
+
+		// 源节点和目标节点上同名的房间
+		sourceRoom := NewRoom(&tc.Room{Name: "room", Sid: utils.NewGuid(utils.RoomPrefix)}, &tc.ServerInfo{}, nil, router, logger.GetLogger())
+		t.Cleanup(sourceRoom.Close)
+		targetRoom := NewRoom(&tc.Room{Name: "room", Sid: utils.NewGuid(utils.RoomPrefix)}, &tc.ServerInfo{}, nil, router, logger.GetLogger())
+		t.Cleanup(targetRoom.Close)
+
+		var exported []byte
+		router.OnNodeRequest(MigrationExportMethod, func(_ context.Context, roomName tc.RoomName, payload []byte) ([]byte, error) {
+			require.Equal(t, tc.RoomName("room"), roomName)
+			data, err := sourceRoom.ExportMigration(tc.ParticipantIdentity(payload))
+			exported = data
+			return data, err
+		})
+		router.OnNodeRequest(MigrationCompleteMethod, func(_ context.Context, _ tc.RoomName, payload []byte) ([]byte, error) {
+			sourceRoom.CompleteMigration(tc.ParticipantIdentity(payload))
+			return nil, nil
+		})
+
+		source, sourceClient := newTestParticipant(t, rtcConf, "p1")
+		require.NoError(t, sourceRoom.Join(source, nil, nil))
+		require.NoError(t, source.UpdateAttributes(map[string]string{"role": "host"}, nil))
+		require.True(t, sourceRoom.StartMigration("p1", true))
+		leave := sourceClient.received()[len(sourceClient.received())-1].GetLeave()
+		require.NotNil(t, leave)
+		require.True(t, leave.CanReconnect)
+
+		target, _ := newTestParticipant(t, rtcConf, "p1", asMigration)
+		require.NoError(t, targetRoom.Join(target, nil, nil))
+
+		// 状态以JSON传递，源会话从源房间中移出但保持连接
+		info := &types.MigrationInfo{}
+		require.NoError(t, json.Unmarshal(exported, info))
+		require.Equal(t, "host", info.Attributes["role"])
+		require.Equal(t, "host", target.GetAttributes()["role"])
+		require.Nil(t, sourceRoom.GetParticipant("p1"))
+		require.False(t, source.IsClosed())
+
+		// 迁移记录只能取出一次
+		_, err := router.TakeMigrationSource(context.Background(), "room", "p1")
+		require.ErrorIs(t, err, routing.ErrNotFound)
+
+		target.SetMigrateState(types.MigrateStateComplete)
+		require.Eventually(t, source.IsClosed, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("no migration source", func(t *testing.T) {
+		router := routing.NewLocalRouter(routing.NewLocalNodeFromProto(&tc.Node{Id: "ND_test"}))
+		t.Cleanup(router.Stop)
+		r := NewRoom(&tc.Room{Name: "room", Sid: utils.NewGuid(utils.RoomPrefix)}, &tc.ServerInfo{}, nil, router, logger.GetLogger())
+		t.Cleanup(r.Close)
+
+		target, _ := newTestParticipant(t, rtcConf, "p1", asMigration)
+		require.NoError(t, r.Join(target, nil, nil))
+		require.Equal(t, target, r.GetParticipant("p1"))
+	})
+}
@@ -0,0 +1,342 @@
+package rtc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+const (
+	// RpcRequestTopic RPC 请求使用的数据包主题
+	RpcRequestTopic = "tc.rpc.request"
+	// RpcResponseTopic RPC 响应使用的数据包主题
+	RpcResponseTopic = "tc.rpc.response"
+
+	// MaxRpcPayloadSize 请求和响应载荷的最大长度
+	MaxRpcPayloadSize = 15 * 1024
+
+	// defaultRpcResponseTimeout 请求没有指定超时时使用的默认值
+	defaultRpcResponseTimeout = 10 * time.Second
+	// maxRpcResponseTimeout 等待响应的最长时间
+	maxRpcResponseTimeout = time.Minute
+
+	rpcRequestIDPrefix = "RPC_"
+)
+
+// RPC 错误码，1400 段为调用方或被调用方的错误，1500 段为传输错误
+const (
+	RpcErrorApplicationError        uint32 = 1400
+	RpcErrorUnsupportedMethod       uint32 = 1401
+	RpcErrorRecipientNotFound       uint32 = 1402
+	RpcErrorRequestPayloadTooLarge  uint32 = 1403
+	RpcErrorInvalidRequest          uint32 = 1404
+	RpcErrorResponseTimeout         uint32 = 1501
+	RpcErrorRecipientDisconnected   uint32 = 1502
+	RpcErrorResponsePayloadTooLarge uint32 = 1503
+	RpcErrorSendFailed              uint32 = 1504
+)
+
+// RpcError RPC 调用失败的原因，既可以由服务端生成，也可以由被调用方返回
+type RpcError struct {
+	Code    uint32 `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// RpcRequest 通过数据包发送的 RPC 请求
+type RpcRequest struct {
+	ID                string `json:"id"`
+	Method            string `json:"method"`
+	Payload           string `json:"payload"`
+	ResponseTimeoutMs uint32 `json:"responseTimeoutMs,omitempty"`
+}
+
+// RpcResponse 通过数据包发送的 RPC 响应，Error 不为空时表示调用失败
+type RpcResponse struct {
+	RequestID string    `json:"requestId"`
+	Payload   string    `json:"payload,omitempty"`
+	Error     *RpcError `json:"error,omitempty"`
+}
+
+// pendingRpc 等待被调用方响应的请求，callerIdentity 为空表示由后端发起
+type pendingRpc struct {
+	requestID      string
+	callerIdentity tc.ParticipantIdentity
+	calleeIdentity tc.ParticipantIdentity
+	timer          *time.Timer
+	resultCh       chan *RpcResponse
+}
+
+func rpcKey(callerIdentity tc.ParticipantIdentity, requestID string) string {
+	return string(callerIdentity) + "/" + requestID
+}
+
+// RpcResponseTimeout 请求实际使用的等待响应时间，为 0 时使用默认值，超过上限时使用上限
+func RpcResponseTimeout(timeoutMs uint32) time.Duration {
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		return defaultRpcResponseTimeout
+	}
+	if timeout > maxRpcResponseTimeout {
+		return maxRpcResponseTimeout
+	}
+	return timeout
+}
+
+// PerformRpc 由后端调用参与者注册的方法，阻塞直到收到响应、超时或者 ctx 结束
+func (r *Room) PerformRpc(ctx context.Context, destinationIdentity tc.ParticipantIdentity, method string, payload string, timeout time.Duration) (string, error) {
+	req := &RpcRequest{
+		ID:                utils.NewGuid(rpcRequestIDPrefix),
+		Method:            method,
+		Payload:           payload,
+		ResponseTimeoutMs: uint32(timeout / time.Millisecond),
+	}
+	if rpcErr := validateRpcRequest(req); rpcErr != nil {
+		return "", rpcErr
+	}
+
+	pending, rpcErr := r.startRpc("", destinationIdentity, req)
+	if rpcErr != nil {
+		return "", rpcErr
+	}
+
+	select {
+	case res := <-pending.resultCh:
+		if res.Error != nil {
+			return "", res.Error
+		}
+		return res.Payload, nil
+	case <-ctx.Done():
+		r.removePendingRpc(rpcKey("", req.ID))
+		return "", ctx.Err()
+	}
+}
+
+// handleRpcRequest 参与者发起的调用，校验后转发给被调用方，失败时直接向调用方返回错误响应
+func (r *Room) handleRpcRequest(source types.LocalParticipant, up *tc.UserPacket) {
+	req := &RpcRequest{}
+	if err := json.Unmarshal(up.Payload, req); err != nil || req.ID == "" {
+		r.logger.Debugw("dropping invalid rpc request", "participant", source.Identity(), "error", err)
+		return
+	}
+	if rpcErr := validateRpcRequest(req); rpcErr != nil {
+		r.sendRpcResponse(source, "", &RpcResponse{RequestID: req.ID, Error: rpcErr})
+		return
+	}
+	if len(up.DestinationIdentities) != 1 {
+		r.sendRpcResponse(source, "", &RpcResponse{
+			RequestID: req.ID,
+			Error:     &RpcError{Code: RpcErrorInvalidRequest, Message: "rpc request requires exactly one destination"},
+		})
+		return
+	}
+
+	if _, rpcErr := r.startRpc(source.Identity(), tc.ParticipantIdentity(up.DestinationIdentities[0]), req); rpcErr != nil {
+		r.sendRpcResponse(source, tc.ParticipantIdentity(up.DestinationIdentities[0]), &RpcResponse{RequestID: req.ID, Error: rpcErr})
+	}
+}
+
+// handleRpcResponse 被调用方返回的响应，只接受来自本次调用被调用方的响应
+func (r *Room) handleRpcResponse(source types.LocalParticipant, up *tc.UserPacket) {
+	res := &RpcResponse{}
+	if err := json.Unmarshal(up.Payload, res); err != nil || res.RequestID == "" {
+		r.logger.Debugw("dropping invalid rpc response", "participant", source.Identity(), "error", err)
+		return
+	}
+
+	var callerIdentity tc.ParticipantIdentity
+	if len(up.DestinationIdentities) != 0 {
+		callerIdentity = tc.ParticipantIdentity(up.DestinationIdentities[0])
+	}
+	key := rpcKey(callerIdentity, res.RequestID)
+
+	r.rpcLock.Lock()
+	pending, ok := r.pendingRpcs[key]
+	if !ok || pending.calleeIdentity != source.Identity() {
+		r.rpcLock.Unlock()
+		return
+	}
+	r.rpcLock.Unlock()
+
+	if res.Error == nil && len(res.Payload) > MaxRpcPayloadSize {
+		res = &RpcResponse{
+			RequestID: res.RequestID,
+			Error:     &RpcError{Code: RpcErrorResponsePayloadTooLarge, Message: "response payload too large"},
+		}
+	}
+	r.completeRpc(key, res)
+}
+
+// failRpcsForParticipant 参与者离开时结束与其相关的调用，等待其响应的调用方收到断开错误
+func (r *Room) failRpcsForParticipant(identity tc.ParticipantIdentity) {
+	r.rpcLock.Lock()
+	var calleeGone []string
+	for key, pending := range r.pendingRpcs {
+		switch identity {
+		case pending.calleeIdentity:
+			calleeGone = append(calleeGone, key)
+		case pending.callerIdentity:
+			pending.timer.Stop()
+			delete(r.pendingRpcs, key)
+		}
+	}
+	r.rpcLock.Unlock()
+
+	for _, key := range calleeGone {
+		r.failRpc(key, &RpcError{Code: RpcErrorRecipientDisconnected, Message: "recipient disconnected"})
+	}
+}
+
+// failAllRpcs 房间关闭时结束所有调用
+func (r *Room) failAllRpcs() {
+	r.rpcLock.Lock()
+	keys := make([]string, 0, len(r.pendingRpcs))
+	for key := range r.pendingRpcs {
+		keys = append(keys, key)
+	}
+	r.rpcLock.Unlock()
+
+	for _, key := range keys {
+		r.failRpc(key, &RpcError{Code: RpcErrorRecipientDisconnected, Message: "room closed"})
+	}
+}
+
+func validateRpcRequest(req *RpcRequest) *RpcError {
+	if req.Method == "" {
+		return &RpcError{Code: RpcErrorInvalidRequest, Message: "rpc method cannot be empty"}
+	}
+	if len(req.Payload) > MaxRpcPayloadSize {
+		return &RpcError{Code: RpcErrorRequestPayloadTooLarge, Message: "request payload too large"}
+	}
+	return nil
+}
+
+// startRpc 登记调用并将请求转发给被调用方
+func (r *Room) startRpc(callerIdentity tc.ParticipantIdentity, calleeIdentity tc.ParticipantIdentity, req *RpcRequest) (*pendingRpc, *RpcError) {
+	callee := r.GetParticipant(calleeIdentity)
+	if callee == nil || callee.State() != tc.ParticipantInfo_ACTIVE {
+		return nil, &RpcError{Code: RpcErrorRecipientNotFound, Message: "recipient not found"}
+	}
+
+	key := rpcKey(callerIdentity, req.ID)
+	pending := &pendingRpc{
+		requestID:      req.ID,
+		callerIdentity: callerIdentity,
+		calleeIdentity: calleeIdentity,
+	}
+	if callerIdentity == "" {
+		pending.resultCh = make(chan *RpcResponse, 1)
+	}
+
+	r.rpcLock.Lock()
+	if _, ok := r.pendingRpcs[key]; ok {
+		r.rpcLock.Unlock()
+		return nil, &RpcError{Code: RpcErrorInvalidRequest, Message: "duplicate request id"}
+	}
+	pending.timer = time.AfterFunc(RpcResponseTimeout(req.ResponseTimeoutMs), func() {
+		r.failRpc(key, &RpcError{Code: RpcErrorResponseTimeout, Message: "response timeout"})
+	})
+	r.pendingRpcs[key] = pending
+	r.rpcLock.Unlock()
+
+	payload, err := json.Marshal(req)
+	if err == nil {
+		err = r.sendRpcPacket(callee, RpcRequestTopic, callerIdentity, "", payload)
+	}
+	if err != nil {
+		r.removePendingRpc(key)
+		r.logger.Warnw("could not send rpc request", err, "callee", calleeIdentity, "method", req.Method)
+		return nil, &RpcError{Code: RpcErrorSendFailed, Message: "failed to send request"}
+	}
+	return pending, nil
+}
+
+func (r *Room) failRpc(key string, rpcErr *RpcError) {
+	r.rpcLock.Lock()
+	pending, ok := r.pendingRpcs[key]
+	r.rpcLock.Unlock()
+	if !ok {
+		return
+	}
+	r.completeRpc(key, &RpcResponse{RequestID: pending.requestID, Error: rpcErr})
+}
+
+// completeRpc 结束调用，将响应交给后端或者发送给调用方
+func (r *Room) completeRpc(key string, res *RpcResponse) {
+	pending := r.removePendingRpc(key)
+	if pending == nil {
+		return
+	}
+
+	if pending.resultCh != nil {
+		pending.resultCh <- res
+		return
+	}
+
+	if caller := r.GetParticipant(pending.callerIdentity); caller != nil {
+		r.sendRpcResponse(caller, pending.calleeIdentity, res)
+	}
+}
+
+func (r *Room) removePendingRpc(key string) *pendingRpc {
+	r.rpcLock.Lock()
+	defer r.rpcLock.Unlock()
+
+	pending, ok := r.pendingRpcs[key]
+	if !ok {
+		return nil
+	}
+	pending.timer.Stop()
+	delete(r.pendingRpcs, key)
+	return pending
+}
+
+func (r *Room) sendRpcResponse(caller types.LocalParticipant, calleeIdentity tc.ParticipantIdentity, res *RpcResponse) {
+	payload, err := json.Marshal(res)
+	if err != nil {
+		r.logger.Errorw("could not marshal rpc response", err)
+		return
+	}
+	if err := r.sendRpcPacket(caller, RpcResponseTopic, calleeIdentity, caller.Identity(), payload); err != nil {
+		r.logger.Debugw("could not send rpc response", "participant", caller.Identity(), "error", err)
+	}
+}
+
+// sendRpcPacket 以可靠数据包发送 RPC 消息，sender 为空表示由服务端发出
+func (r *Room) sendRpcPacket(op types.LocalParticipant, topic string, sender tc.ParticipantIdentity, destination tc.ParticipantIdentity, payload []byte) error {
+	up := &tc.UserPacket{
+		ParticipantIdentity: string(sender),
+		Payload:             payload,
+		Topic:               topic,
+	}
+	if sender != "" {
+		if sp := r.GetParticipant(sender); sp != nil {
+			up.ParticipantSid = string(sp.ID())
+		}
+	}
+	if destination != "" {
+		up.DestinationIdentities = []string{string(destination)}
+	}
+
+	dp := &tc.DataPacket{
+		Kind: tc.DataPacket_RELIABLE,
+		Value: &tc.DataPacket_User{
+			User: up,
+		},
+	}
+	data, err := proto.Marshal(dp)
+	if err != nil {
+		return err
+	}
+	return op.SendDataPacket(dp, data)
+}
@@ -0,0 +1,187 @@
+package rtc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+// rpcResult 后端调用的结果
+type rpcResult struct {
+	payload string
+	err     error
+}
+
+func performRpcAsync(r *Room, destination tc.ParticipantIdentity, method string, payload string, timeout time.Duration) chan rpcResult {
+	resultCh := make(chan rpcResult, 1)
+	go func() {
+		payload, err := r.PerformRpc(context.Background(), destination, method, payload, timeout)
+		resultCh <- rpcResult{payload: payload, err: err}
+	}()
+	return resultCh
+}
+
+// waitForRpcPacket 等待参与者收到指定主题的数据包
+func waitForRpcPacket(t *testing.T, f *fakeDataReceiver, topic string) *tc.UserPacket {
+	var up *tc.UserPacket
+	require.Eventually(t, func() bool {
+		for _, dp := range f.packets() {
+			if user := dp.GetUser(); user != nil && user.Topic == topic {
+				up = user
+				return true
+			}
+		}
+		return false
+	}, time.Second, 5*time.Millisecond)
+	return up
+}
+
+func requireRpcErrorCode(t *testing.T, err error, code uint32) {
+	var rpcErr *RpcError
+	require.True(t, errors.As(err, &rpcErr), "not an rpc error: %v", err)
+	require.Equal(t, code, rpcErr.Code)
+}
+
+func rpcResponsePacket(t *testing.T, caller tc.ParticipantIdentity, res *RpcResponse) *tc.UserPacket {
+	payload, err := json.Marshal(res)
+	require.NoError(t, err)
+	up := &tc.UserPacket{Topic: RpcResponseTopic, Payload: payload}
+	if caller != "" {
+		up.DestinationIdentities = []string{string(caller)}
+	}
+	return up
+}
+
+func waitForRpcResult(t *testing.T, resultCh chan rpcResult) rpcResult {
+	select {
+	case res := <-resultCh:
+		return res
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "rpc did not complete")
+		return rpcResult{}
+	}
+}
+
+func TestRoomPerformRpc(t *testing.T) {
+	t.Run("callee responds", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		callee := addDataReceiver(r, "callee", tc.ParticipantInfo_ACTIVE)
+		other := addDataReceiver(r, "other", tc.ParticipantInfo_ACTIVE)
+
+		resultCh := performRpcAsync(r, "callee", "greet", "ping", 0)
+		up := waitForRpcPacket(t, callee, RpcRequestTopic)
+		require.Empty(t, up.ParticipantIdentity)
+		req := &RpcRequest{}
+		require.NoError(t, json.Unmarshal(up.Payload, req))
+		require.Equal(t, "greet", req.Method)
+		require.Equal(t, "ping", req.Payload)
+
+		// 只接受被调用方的响应
+		r.handleRpcResponse(other, rpcResponsePacket(t, "", &RpcResponse{RequestID: req.ID, Payload: "forged"}))
+		r.handleRpcResponse(callee, rpcResponsePacket(t, "", &RpcResponse{RequestID: req.ID, Payload: "pong"}))
+
+		res := waitForRpcResult(t, resultCh)
+		require.NoError(t, res.err)
+		require.Equal(t, "pong", res.payload)
+	})
+
+	t.Run("missing or inactive recipient", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		joining := addDataReceiver(r, "joining", tc.ParticipantInfo_JOINED)
+
+		_, err := r.PerformRpc(context.Background(), "nobody", "greet", "", 0)
+		requireRpcErrorCode(t, err, RpcErrorRecipientNotFound)
+		_, err = r.PerformRpc(context.Background(), "joining", "greet", "", 0)
+		requireRpcErrorCode(t, err, RpcErrorRecipientNotFound)
+		require.Empty(t, joining.packets())
+	})
+
+	t.Run("response timeout", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		addDataReceiver(r, "callee", tc.ParticipantInfo_ACTIVE)
+
+		start := time.Now()
+		_, err := r.PerformRpc(context.Background(), "callee", "greet", "", 50*time.Millisecond)
+		requireRpcErrorCode(t, err, RpcErrorResponseTimeout)
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+		r.rpcLock.Lock()
+		require.Empty(t, r.pendingRpcs)
+		r.rpcLock.Unlock()
+	})
+
+	t.Run("recipient disconnects mid-call", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		callee := addDataReceiver(r, "callee", tc.ParticipantInfo_ACTIVE)
+
+		resultCh := performRpcAsync(r, "callee", "greet", "", 0)
+		waitForRpcPacket(t, callee, RpcRequestTopic)
+		r.failRpcsForParticipant("callee")
+
+		res := waitForRpcResult(t, resultCh)
+		requireRpcErrorCode(t, res.err, RpcErrorRecipientDisconnected)
+	})
+
+	t.Run("payload limits", func(t *testing.T) {
+		r := newTestRoom(t, &tc.Room{})
+		callee := addDataReceiver(r, "callee", tc.ParticipantInfo_ACTIVE)
+
+		_, err := r.PerformRpc(context.Background(), "callee", "greet", strings.Repeat("a", MaxRpcPayloadSize+1), 0)
+		requireRpcErrorCode(t, err, RpcErrorRequestPayloadTooLarge)
+		require.Empty(t, callee.packets())
+
+		resultCh := performRpcAsync(r, "callee", "greet", strings.Repeat("a", MaxRpcPayloadSize), 0)
+		up := waitForRpcPacket(t, callee, RpcRequestTopic)
+		req := &RpcRequest{}
+		require.NoError(t, json.Unmarshal(up.Payload, req))
+		r.handleRpcResponse(callee, rpcResponsePacket(t, "", &RpcResponse{
+			RequestID: req.ID,
+			Payload:   strings.Repeat("b", MaxRpcPayloadSize+1),
+		}))
+
+		res := waitForRpcResult(t, resultCh)
+		requireRpcErrorCode(t, res.err, RpcErrorResponsePayloadTooLarge)
+	})
+}
+
+func TestRoomParticipantRpc(t *testing.T) {
+	r := newTestRoom(t, &tc.Room{})
+	caller := addDataReceiver(r, "caller", tc.ParticipantInfo_ACTIVE)
+	callee := addDataReceiver(r, "callee", tc.ParticipantInfo_ACTIVE)
+
+	payload, err := json.Marshal(&RpcRequest{ID: "1", Method: "greet", Payload: "ping"})
+	require.NoError(t, err)
+
+	// 没有目标时直接向调用方返回错误
+	r.handleRpcRequest(caller, &tc.UserPacket{Topic: RpcRequestTopic, Payload: payload})
+	up := waitForRpcPacket(t, caller, RpcResponseTopic)
+	res := &RpcResponse{}
+	require.NoError(t, json.Unmarshal(up.Payload, res))
+	require.Equal(t, "1", res.RequestID)
+	require.NotNil(t, res.Error)
+	require.Equal(t, RpcErrorInvalidRequest, res.Error.Code)
+
+	r.handleRpcRequest(caller, &tc.UserPacket{Topic: RpcRequestTopic, Payload: payload, DestinationIdentities: []string{"callee"}})
+	up = waitForRpcPacket(t, callee, RpcRequestTopic)
+	require.Equal(t, "caller", up.ParticipantIdentity)
+	require.Equal(t, string(caller.sid), up.ParticipantSid)
+
+	r.handleRpcResponse(callee, rpcResponsePacket(t, "caller", &RpcResponse{RequestID: "1", Payload: "pong"}))
+	require.Eventually(t, func() bool {
+		return len(caller.packets()) == 2
+	}, time.Second, 5*time.Millisecond)
+	up = caller.packets()[1].GetUser()
+	require.Equal(t, "callee", up.ParticipantIdentity)
+	require.Equal(t, []string{"caller"}, up.DestinationIdentities)
+	res = &RpcResponse{}
+	require.NoError(t, json.Unmarshal(up.Payload, res))
+	require.Nil(t, res.Error)
+	require.Equal(t, "pong", res.Payload)
+}
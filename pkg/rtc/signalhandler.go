@@ -0,0 +1,55 @@
+package rtc
+
+import (
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+// HandleParticipantSignal 将客户端的信令请求分发给参与者和房间
+func HandleParticipantSignal(room *Room, participant types.LocalParticipant, req *tc.SignalRequest, pLogger logger.Logger) {
+	participant.UpdateLastSeenSignal()
+
+	switch msg := req.GetMessage().(type) {
+	case *tc.SignalRequest_Offer:
+		participant.HandleOffer(FromProtoSessionDescription(msg.Offer))
+
+	case *tc.SignalRequest_Answer:
+		participant.HandleAnswer(FromProtoSessionDescription(msg.Answer))
+
+	case *tc.SignalRequest_Trickle:
+		candidate, err := FromProtoTrickle(msg.Trickle)
+		if err != nil {
+			pLogger.Warnw("could not decode trickle", err)
+			return
+		}
+		participant.AddICECandidate(candidate, msg.Trickle.Target)
+
+	case *tc.SignalRequest_AddTrack:
+		participant.AddTrack(msg.AddTrack)
+
+	case *tc.SignalRequest_Mute:
+		participant.SetTrackMuted(tc.TrackID(msg.Mute.Sid), msg.Mute.Muted, false)
+
+	case *tc.SignalRequest_Subscription:
+		trackIDs := make([]tc.TrackID, 0, len(msg.Subscription.TrackSids))
+		for _, sid := range msg.Subscription.TrackSids {
+			trackIDs = append(trackIDs, tc.TrackID(sid))
+		}
+		room.UpdateSubscriptions(participant, trackIDs, msg.Subscription.ParticipantTracks, msg.Subscription.Subscribe)
+
+	case *tc.SignalRequest_TrackSetting:
+		for _, sid := range msg.TrackSetting.TrackSids {
+			participant.UpdateSubscribedTrackSettings(tc.TrackID(sid), msg.TrackSetting)
+		}
+
+	case *tc.SignalRequest_SubscriptionPermission:
+		if err := room.UpdateSubscriptionPermission(participant, msg.SubscriptionPermission); err != nil {
+			pLogger.Warnw("could not update subscription permission", err)
+		}
+
+	case *tc.SignalRequest_Leave:
+		pLogger.Infow("client leaving room")
+		room.RemoveParticipant(participant.Identity(), participant.ID(), types.ParticipantCloseReasonClientRequestLeave)
+	}
+}
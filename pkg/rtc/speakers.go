@@ -0,0 +1,149 @@
+package rtc
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+const (
+	// defaultAudioUpdateInterval 没有配置 UpdateInterval 时计算发言者的间隔
+	defaultAudioUpdateInterval = 400 * time.Millisecond
+	// speakerReleaseDelay 发言者停止说话后仍然保持活跃的时间，避免句子停顿时反复切换
+	speakerReleaseDelay = time.Second
+	// speakerLevelChangeThreshold 活跃发言者的音量变化超过该值时才重新下发
+	speakerLevelChangeThreshold = 0.05
+	// dominantSpeakerSwitchRatio 其他发言者的音量超过主讲人该倍数时才切换主讲人
+	dominantSpeakerSwitchRatio = 1.2
+)
+
+// DominantSpeakerTopic 主讲人变化使用的数据包主题，协议中没有对应的信令
+const DominantSpeakerTopic = "tc.dominant_speaker"
+
+// DominantSpeakerUpdate 下发给客户端的主讲人，ParticipantSid 为空表示当前没有主讲人
+type DominantSpeakerUpdate struct {
+	ParticipantSid string `json:"participantSid"`
+}
+
+// speakerState 活跃发言者的状态
+type speakerState struct {
+	level      float64
+	sentLevel  float64
+	lastActive time.Time
+}
+
+// speakerAggregator 根据每次采样的音量计算房间的活跃发言者集合和主讲人，非线程安全
+type speakerAggregator struct {
+	speakers map[tc.ParticipantID]*speakerState
+	dominant tc.ParticipantID
+}
+
+func newSpeakerAggregator() *speakerAggregator {
+	return &speakerAggregator{
+		speakers: make(map[tc.ParticipantID]*speakerState),
+	}
+}
+
+// update 传入本次采样中正在说话的参与者及其音量，返回状态发生变化的发言者
+func (a *speakerAggregator) update(levels map[tc.ParticipantID]float64, now time.Time) []*tc.SpeakerInfo {
+	var changed []*tc.SpeakerInfo
+	for pID, level := range levels {
+		st, ok := a.speakers[pID]
+		if !ok {
+			a.speakers[pID] = &speakerState{
+				level:      level,
+				sentLevel:  level,
+				lastActive: now,
+			}
+			changed = append(changed, &tc.SpeakerInfo{Sid: string(pID), Level: float32(level), Active: true})
+			continue
+		}
+
+		st.level = level
+		st.lastActive = now
+		if math.Abs(level-st.sentLevel) >= speakerLevelChangeThreshold {
+			st.sentLevel = level
+			changed = append(changed, &tc.SpeakerInfo{Sid: string(pID), Level: float32(level), Active: true})
+		}
+	}
+
+	for pID, st := range a.speakers {
+		if _, ok := levels[pID]; ok || now.Sub(st.lastActive) < speakerReleaseDelay {
+			continue
+		}
+		delete(a.speakers, pID)
+		changed = append(changed, &tc.SpeakerInfo{Sid: string(pID), Active: false})
+	}
+
+	a.updateDominant(levels)
+
+	sortSpeakers(changed)
+	return changed
+}
+
+// remove 参与者离开时立即结束其发言状态，不是活跃发言者时返回 nil
+func (a *speakerAggregator) remove(pID tc.ParticipantID) *tc.SpeakerInfo {
+	if _, ok := a.speakers[pID]; !ok {
+		return nil
+	}
+	delete(a.speakers, pID)
+	if a.dominant == pID {
+		a.dominant = ""
+		a.updateDominant(nil)
+	}
+	return &tc.SpeakerInfo{Sid: string(pID), Active: false}
+}
+
+// activeSpeakers 当前全部活跃发言者，按音量从大到小排序
+func (a *speakerAggregator) activeSpeakers() []*tc.SpeakerInfo {
+	speakers := make([]*tc.SpeakerInfo, 0, len(a.speakers))
+	for pID, st := range a.speakers {
+		speakers = append(speakers, &tc.SpeakerInfo{Sid: string(pID), Level: float32(st.sentLevel), Active: true})
+	}
+	sortSpeakers(speakers)
+	return speakers
+}
+
+// updateDominant 主讲人停止发言或者被明显更大的声音盖过时才切换
+func (a *speakerAggregator) updateDominant(levels map[tc.ParticipantID]float64) {
+	if _, ok := a.speakers[a.dominant]; !ok {
+		a.dominant = ""
+	}
+
+	var loudest tc.ParticipantID
+	var loudestLevel float64
+	for pID, level := range levels {
+		if level > loudestLevel || (level == loudestLevel && pID < loudest) {
+			loudest, loudestLevel = pID, level
+		}
+	}
+
+	if a.dominant == "" {
+		if loudest == "" {
+			// 没有人正在说话时，从保持活跃的发言者中选择
+			for pID, st := range a.speakers {
+				if st.level > loudestLevel || (st.level == loudestLevel && pID < loudest) {
+					loudest, loudestLevel = pID, st.level
+				}
+			}
+		}
+		a.dominant = loudest
+		return
+	}
+
+	// 主讲人短暂停顿时仍然按照保持的音量比较，避免停顿期间被其他人立即替换
+	if loudest != "" && loudest != a.dominant && loudestLevel > a.speakers[a.dominant].level*dominantSpeakerSwitchRatio {
+		a.dominant = loudest
+	}
+}
+
+func sortSpeakers(speakers []*tc.SpeakerInfo) {
+	sort.Slice(speakers, func(i, j int) bool {
+		if speakers[i].Level != speakers[j].Level {
+			return speakers[i].Level > speakers[j].Level
+		}
+		return speakers[i].Sid < speakers[j].Sid
+	})
+}
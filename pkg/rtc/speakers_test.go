@@ -0,0 +1,85 @@
+package rtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+func TestSpeakerAggregator(t *testing.T) {
+	t.Run("speaker stays active through short pauses", func(t *testing.T) {
+		a := newSpeakerAggregator()
+		now := time.Now()
+
+		changed := a.update(map[tc.ParticipantID]float64{"PA_a": 0.5}, now)
+		require.Len(t, changed, 1)
+		require.True(t, changed[0].Active)
+
+		// 停顿不超过 speakerReleaseDelay 时没有变化
+		changed = a.update(nil, now.Add(speakerReleaseDelay/2))
+		require.Empty(t, changed)
+		require.Len(t, a.activeSpeakers(), 1)
+
+		changed = a.update(nil, now.Add(speakerReleaseDelay))
+		require.Len(t, changed, 1)
+		require.Equal(t, "PA_a", changed[0].Sid)
+		require.False(t, changed[0].Active)
+		require.Empty(t, a.activeSpeakers())
+	})
+
+	t.Run("small level changes are not sent", func(t *testing.T) {
+		a := newSpeakerAggregator()
+		now := time.Now()
+
+		a.update(map[tc.ParticipantID]float64{"PA_a": 0.5}, now)
+		require.Empty(t, a.update(map[tc.ParticipantID]float64{"PA_a": 0.52}, now.Add(time.Millisecond)))
+
+		changed := a.update(map[tc.ParticipantID]float64{"PA_a": 0.7}, now.Add(2*time.Millisecond))
+		require.Len(t, changed, 1)
+		require.InDelta(t, 0.7, changed[0].Level, 0.001)
+	})
+
+	t.Run("dominant speaker switches only when clearly louder", func(t *testing.T) {
+		a := newSpeakerAggregator()
+		now := time.Now()
+
+		a.update(map[tc.ParticipantID]float64{"PA_a": 0.5}, now)
+		require.Equal(t, tc.ParticipantID("PA_a"), a.dominant)
+
+		a.update(map[tc.ParticipantID]float64{"PA_a": 0.5, "PA_b": 0.55}, now.Add(time.Millisecond))
+		require.Equal(t, tc.ParticipantID("PA_a"), a.dominant)
+
+		a.update(map[tc.ParticipantID]float64{"PA_a": 0.5, "PA_b": 0.8}, now.Add(2*time.Millisecond))
+		require.Equal(t, tc.ParticipantID("PA_b"), a.dominant)
+	})
+
+	t.Run("paused dominant speaker keeps its level", func(t *testing.T) {
+		a := newSpeakerAggregator()
+		now := time.Now()
+
+		a.update(map[tc.ParticipantID]float64{"PA_a": 0.5}, now)
+		require.Equal(t, tc.ParticipantID("PA_a"), a.dominant)
+
+		// 主讲人停顿期间，音量没有明显更大的发言者不能接替
+		a.update(map[tc.ParticipantID]float64{"PA_b": 0.55}, now.Add(speakerReleaseDelay/2))
+		require.Equal(t, tc.ParticipantID("PA_a"), a.dominant)
+
+		a.update(map[tc.ParticipantID]float64{"PA_b": 0.7}, now.Add(speakerReleaseDelay/2+time.Millisecond))
+		require.Equal(t, tc.ParticipantID("PA_b"), a.dominant)
+	})
+
+	t.Run("removed participant is no longer active", func(t *testing.T) {
+		a := newSpeakerAggregator()
+		now := time.Now()
+
+		a.update(map[tc.ParticipantID]float64{"PA_a": 0.5, "PA_b": 0.3}, now)
+		speaker := a.remove("PA_a")
+		require.NotNil(t, speaker)
+		require.False(t, speaker.Active)
+		require.Equal(t, tc.ParticipantID("PA_b"), a.dominant)
+		require.Nil(t, a.remove("PA_a"))
+	})
+}
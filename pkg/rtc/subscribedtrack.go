@@ -0,0 +1,201 @@
+package rtc
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+	"github.com/liuhailove/tc-server/pkg/sfu"
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+)
+
+// SubscribedTrackParams 订阅音轨参数
+type SubscribedTrackParams struct {
+	MediaTrack     types.MediaTrack
+	Subscriber     types.LocalParticipant
+	DownTrack      *sfu.DownTrack
+	PublisherMuted bool
+	Logger         logger.Logger
+}
+
+// SubscribedTrack 参与者订阅的音轨
+type SubscribedTrack struct {
+	params SubscribedTrackParams
+	logger logger.Logger
+
+	lock     sync.RWMutex
+	settings *tc.UpdateTrackSettings
+	onClose  func(willBeResumed bool)
+	// closeDone 关闭回调已经触发或者错过，之后设置的回调立即执行
+	closeDone          bool
+	closeWillBeResumed bool
+
+	publisherMuted atomic.Bool
+	closed         atomic.Bool
+}
+
+// NewSubscribedTrack 创建订阅音轨
+func NewSubscribedTrack(params SubscribedTrackParams) *SubscribedTrack {
+	if params.Logger == nil {
+		params.Logger = logger.GetLogger()
+	}
+	s := &SubscribedTrack{
+		params: params,
+		logger: params.Logger.WithValues("trackID", params.MediaTrack.ID(), "publisherID", params.MediaTrack.PublisherID()),
+	}
+	s.publisherMuted.Store(params.PublisherMuted)
+	s.updateDownTrackMute()
+	prometheus.AddSubscribedTrack(params.MediaTrack.Kind())
+	return s
+}
+
+// ID 音轨ID
+func (s *SubscribedTrack) ID() tc.TrackID {
+	return s.params.MediaTrack.ID()
+}
+
+// PublisherID 发布者ID
+func (s *SubscribedTrack) PublisherID() tc.ParticipantID {
+	return s.params.MediaTrack.PublisherID()
+}
+
+// PublisherIdentity 发布者标识
+func (s *SubscribedTrack) PublisherIdentity() tc.ParticipantIdentity {
+	return s.params.MediaTrack.PublisherIdentity()
+}
+
+// SubscriberID 订阅者ID
+func (s *SubscribedTrack) SubscriberID() tc.ParticipantID {
+	return s.params.Subscriber.ID()
+}
+
+// SubscriberIdentity 订阅者标识
+func (s *SubscribedTrack) SubscriberIdentity() tc.ParticipantIdentity {
+	return s.params.Subscriber.Identity()
+}
+
+// Subscriber 订阅者
+func (s *SubscribedTrack) Subscriber() types.LocalParticipant {
+	return s.params.Subscriber
+}
+
+// DownTrack 下行音轨
+func (s *SubscribedTrack) DownTrack() *sfu.DownTrack {
+	return s.params.DownTrack
+}
+
+// MediaTrack 订阅的媒体音轨
+func (s *SubscribedTrack) MediaTrack() types.MediaTrack {
+	return s.params.MediaTrack
+}
+
+// IsMuted 订阅者是否禁用了该音轨
+func (s *SubscribedTrack) IsMuted() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.settings != nil && s.settings.Disabled
+}
+
+// IsPublisherMuted 发布者是否静音
+func (s *SubscribedTrack) IsPublisherMuted() bool {
+	return s.publisherMuted.Load()
+}
+
+// SetPublisherMuted 发布者静音状态变化
+func (s *SubscribedTrack) SetPublisherMuted(muted bool) {
+	s.publisherMuted.Store(muted)
+	s.updateDownTrackMute()
+}
+
+// UpdateSubscriberSettings 更新订阅者设置
+func (s *SubscribedTrack) UpdateSubscriberSettings(settings *tc.UpdateTrackSettings) {
+	s.lock.Lock()
+	s.settings = proto.Clone(settings).(*tc.UpdateTrackSettings)
+	s.lock.Unlock()
+	s.updateDownTrackMute()
+	if s.params.MediaTrack.Kind() == tc.TrackType_VIDEO && !settings.Disabled {
+		s.params.DownTrack.SetMaxSpatialLayer(spatialLayerForSettings(settings, s.params.MediaTrack.ToProto()))
+		s.params.DownTrack.SetPriority(settings.Priority)
+	}
+
+	s.logger.Debugw("updated subscriber settings", "settings", settings)
+}
+
+// Settings 获取订阅者设置
+func (s *SubscribedTrack) Settings() *tc.UpdateTrackSettings {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.settings == nil {
+		return nil
+	}
+	return proto.Clone(s.settings).(*tc.UpdateTrackSettings)
+}
+
+// OnClose 关闭事件，订阅在设置回调之前已经关闭时立即回调
+func (s *SubscribedTrack) OnClose(f func(willBeResumed bool)) {
+	s.lock.Lock()
+	s.onClose = f
+	closeDone, willBeResumed := s.closeDone, s.closeWillBeResumed
+	s.lock.Unlock()
+
+	if closeDone && f != nil {
+		f(willBeResumed)
+	}
+}
+
+// Close 关闭订阅
+func (s *SubscribedTrack) Close(willBeResumed bool) {
+	if s.closed.Swap(true) {
+		return
+	}
+
+	s.params.DownTrack.CloseWithFlush(!willBeResumed)
+	prometheus.SubSubscribedTrack(s.params.MediaTrack.Kind())
+
+	s.lock.Lock()
+	s.closeDone = true
+	s.closeWillBeResumed = willBeResumed
+	onClose := s.onClose
+	s.lock.Unlock()
+	if onClose != nil {
+		onClose(willBeResumed)
+	}
+}
+
+// updateDownTrackMute 发布者静音或者订阅者禁用时停止转发，没有数据也不计入连接质量
+func (s *SubscribedTrack) updateDownTrackMute() {
+	s.params.DownTrack.PubMute(s.IsPublisherMuted())
+	s.params.DownTrack.Mute(s.IsMuted())
+}
+
+// spatialLayerForSettings 订阅者需要的最大空间层，设置了尺寸时选择不小于该尺寸的最低层
+func spatialLayerForSettings(settings *tc.UpdateTrackSettings, trackInfo *tc.TrackInfo) int32 {
+	if settings.Width == 0 && settings.Height == 0 {
+		return buffer.VideoQualityToSpatialLayer(settings.Quality, trackInfo)
+	}
+
+	highest, fit := tc.VideoQuality_OFF, tc.VideoQuality_OFF
+	for _, layer := range trackInfo.GetLayers() {
+		if highest == tc.VideoQuality_OFF || layer.Quality > highest {
+			highest = layer.Quality
+		}
+		if layer.Width >= settings.Width && layer.Height >= settings.Height && (fit == tc.VideoQuality_OFF || layer.Quality < fit) {
+			fit = layer.Quality
+		}
+	}
+	switch {
+	case fit != tc.VideoQuality_OFF:
+		return buffer.VideoQualityToSpatialLayer(fit, trackInfo)
+	case highest != tc.VideoQuality_OFF:
+		return buffer.VideoQualityToSpatialLayer(highest, trackInfo)
+	default:
+		return buffer.DefaultMaxLayerSpatial
+	}
+}
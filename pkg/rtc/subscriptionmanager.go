@@ -0,0 +1,704 @@
+package rtc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+const (
+	// reconcileInterval 定时检查全部订阅的间隔
+	reconcileInterval = 3 * time.Second
+	// subscribeBackoffBase 订阅失败后重试的初始等待时间，每次失败翻倍
+	subscribeBackoffBase = 200 * time.Millisecond
+	// subscribeBackoffMax 订阅失败后重试的最大等待时间
+	subscribeBackoffMax = 5 * time.Second
+	// maxSubscribeAttempts 连续失败超过该次数后放弃订阅
+	maxSubscribeAttempts = 8
+	// trackNotFoundTimeout 找不到音轨超过该时间后放弃订阅，发布者可能在这段时间内重新发布
+	trackNotFoundTimeout = 20 * time.Second
+	// waitUntilSubscribedCheckInterval 等待订阅完成时的检查间隔
+	waitUntilSubscribedCheckInterval = 50 * time.Millisecond
+)
+
+// SubscriptionManagerParams 订阅管理器参数
+type SubscriptionManagerParams struct {
+	Participant   types.LocalParticipant
+	Logger        logger.Logger
+	TrackResolver types.MediaTrackResolver
+
+	// SubscriptionLimitVideo 最多订阅的视频数量，0 表示不限制
+	SubscriptionLimitVideo int32
+	// SubscriptionLimitAudio 最多订阅的音频数量，0 表示不限制
+	SubscriptionLimitAudio int32
+
+	OnTrackSubscribed   func(subTrack types.SubscribedTrack)
+	OnTrackUnsubscribed func(subTrack types.SubscribedTrack)
+	// OnSubscriptionError 订阅最终失败，fatal 为 true 表示多次重试后仍然失败
+	OnSubscriptionError func(trackID tc.TrackID, fatal bool, err error)
+}
+
+// SubscriptionManager 管理参与者的订阅，记录期望状态并不断调和实际状态
+type SubscriptionManager struct {
+	params SubscriptionManagerParams
+
+	lock          sync.RWMutex
+	subscriptions map[tc.TrackID]*trackSubscription
+	subscribedTo  map[tc.ParticipantID]map[tc.TrackID]struct{}
+
+	subscribedVideoCount atomic.Int32
+	subscribedAudioCount atomic.Int32
+
+	reconcileCh chan tc.TrackID
+	closeCh     chan struct{}
+	closeOnce   sync.Once
+
+	onSubscribeStatusChanged func(publisherID tc.ParticipantID, subscribed bool)
+}
+
+// NewSubscriptionManager 创建订阅管理器
+func NewSubscriptionManager(params SubscriptionManagerParams) *SubscriptionManager {
+	m := &SubscriptionManager{
+		params:        params,
+		subscriptions: make(map[tc.TrackID]*trackSubscription),
+		subscribedTo:  make(map[tc.ParticipantID]map[tc.TrackID]struct{}),
+		reconcileCh:   make(chan tc.TrackID, 50),
+		closeCh:       make(chan struct{}),
+	}
+
+	go m.reconcileWorker()
+	return m
+}
+
+// Close 关闭订阅管理器，取消全部订阅
+func (m *SubscriptionManager) Close(willBeResumed bool) {
+	m.closeOnce.Do(func() {
+		close(m.closeCh)
+	})
+
+	for _, st := range m.GetSubscribedTracks() {
+		st.MediaTrack().RemoveSubscriber(m.params.Participant.ID(), willBeResumed)
+	}
+}
+
+func (m *SubscriptionManager) isClosed() bool {
+	select {
+	case <-m.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// SubscribeToTrack 期望订阅音轨
+func (m *SubscriptionManager) SubscribeToTrack(trackID tc.TrackID) {
+	m.lock.Lock()
+	sub, ok := m.subscriptions[trackID]
+	if !ok {
+		sub = newTrackSubscription(trackID, m.params.Logger)
+		m.subscriptions[trackID] = sub
+	}
+	m.lock.Unlock()
+
+	if sub.setDesired(true) {
+		m.params.Logger.Debugw("queueing subscribe", "trackID", trackID)
+		m.queueReconcile(trackID)
+	}
+}
+
+// UnsubscribeFromTrack 期望取消订阅音轨
+func (m *SubscriptionManager) UnsubscribeFromTrack(trackID tc.TrackID) {
+	m.lock.RLock()
+	sub, ok := m.subscriptions[trackID]
+	m.lock.RUnlock()
+	if !ok {
+		return
+	}
+
+	if sub.setDesired(false) {
+		m.params.Logger.Debugw("queueing unsubscribe", "trackID", trackID)
+		m.queueReconcile(trackID)
+	}
+}
+
+// UnsubscribeFromAllTracks 取消全部订阅，用于订阅权限被收回
+func (m *SubscriptionManager) UnsubscribeFromAllTracks() {
+	m.lock.RLock()
+	subs := make([]*trackSubscription, 0, len(m.subscriptions))
+	for _, sub := range m.subscriptions {
+		subs = append(subs, sub)
+	}
+	m.lock.RUnlock()
+
+	for _, sub := range subs {
+		if sub.setDesired(false) {
+			m.queueReconcile(sub.trackID)
+		}
+	}
+}
+
+// UpdateSubscribedTrackSettings 更新订阅设置，订阅完成前的设置在订阅完成后生效
+func (m *SubscriptionManager) UpdateSubscribedTrackSettings(trackID tc.TrackID, settings *tc.UpdateTrackSettings) {
+	m.lock.Lock()
+	sub, ok := m.subscriptions[trackID]
+	if !ok {
+		sub = newTrackSubscription(trackID, m.params.Logger)
+		m.subscriptions[trackID] = sub
+	}
+	m.lock.Unlock()
+
+	sub.setSettings(settings)
+}
+
+// GetSubscribedTracks 获取已经订阅的音轨
+func (m *SubscriptionManager) GetSubscribedTracks() []types.SubscribedTrack {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	tracks := make([]types.SubscribedTrack, 0, len(m.subscriptions))
+	for _, sub := range m.subscriptions {
+		if st := sub.getSubscribedTrack(); st != nil {
+			tracks = append(tracks, st)
+		}
+	}
+	return tracks
+}
+
+// UpdateTrackPermission 记录订阅者对音轨的权限，权限状态没有变化时返回 false。
+// 重新获得权限后立即调和该音轨的订阅
+func (m *SubscriptionManager) UpdateTrackPermission(trackID tc.TrackID, allowed bool) bool {
+	m.lock.RLock()
+	sub, ok := m.subscriptions[trackID]
+	m.lock.RUnlock()
+	if !ok {
+		return true
+	}
+
+	if !sub.setHasPermission(allowed) {
+		return false
+	}
+	if allowed {
+		sub.resetAttempts()
+		m.queueReconcile(trackID)
+	}
+	return true
+}
+
+// IsTrackNameSubscribed 是否订阅了指定发布者的指定名称的音轨
+func (m *SubscriptionManager) IsTrackNameSubscribed(publisherIdentity tc.ParticipantIdentity, trackName string) bool {
+	for _, st := range m.GetSubscribedTracks() {
+		if st.PublisherIdentity() == publisherIdentity && st.MediaTrack().Name() == trackName {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSubscribedParticipants 获取订阅的参与者
+func (m *SubscriptionManager) GetSubscribedParticipants() []tc.ParticipantID {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	participantIDs := make([]tc.ParticipantID, 0, len(m.subscribedTo))
+	for pID := range m.subscribedTo {
+		participantIDs = append(participantIDs, pID)
+	}
+	return participantIDs
+}
+
+// IsSubscribedTo 是否订阅了该参与者
+func (m *SubscriptionManager) IsSubscribedTo(participantID tc.ParticipantID) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	_, ok := m.subscribedTo[participantID]
+	return ok
+}
+
+// HasPendingSubscriptions 是否存在期望订阅但还没有完成的音轨
+func (m *SubscriptionManager) HasPendingSubscriptions() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, sub := range m.subscriptions {
+		if sub.needsSubscribe() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSubscriptions 是否存在订阅或者期望的订阅
+func (m *SubscriptionManager) HasSubscriptions() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, sub := range m.subscriptions {
+		if sub.isDesired() || sub.getSubscribedTrack() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitUntilSubscribed 等待全部期望的订阅完成
+func (m *SubscriptionManager) WaitUntilSubscribed(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for m.HasPendingSubscriptions() {
+		if m.isClosed() {
+			return ErrSubscriberClosed
+		}
+		if time.Now().After(deadline) {
+			return context.DeadlineExceeded
+		}
+		time.Sleep(waitUntilSubscribedCheckInterval)
+	}
+	return nil
+}
+
+// OnSubscribeStatusChanged 订阅某个参与者的状态变化事件
+func (m *SubscriptionManager) OnSubscribeStatusChanged(fn func(publisherID tc.ParticipantID, subscribed bool)) {
+	m.lock.Lock()
+	m.onSubscribeStatusChanged = fn
+	m.lock.Unlock()
+}
+
+func (m *SubscriptionManager) queueReconcile(trackID tc.TrackID) {
+	select {
+	case m.reconcileCh <- trackID:
+	default:
+		// 队列已满，定时调和会处理
+	}
+}
+
+func (m *SubscriptionManager) reconcileWorker() {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.closeCh:
+			return
+		case <-ticker.C:
+			m.reconcileSubscriptions()
+		case trackID := <-m.reconcileCh:
+			m.lock.RLock()
+			sub := m.subscriptions[trackID]
+			m.lock.RUnlock()
+			if sub != nil {
+				m.reconcileSubscription(sub)
+			}
+		}
+	}
+}
+
+func (m *SubscriptionManager) reconcileSubscriptions() {
+	m.lock.RLock()
+	subs := make([]*trackSubscription, 0, len(m.subscriptions))
+	for _, sub := range m.subscriptions {
+		subs = append(subs, sub)
+	}
+	m.lock.RUnlock()
+
+	for _, sub := range subs {
+		m.reconcileSubscription(sub)
+	}
+}
+
+func (m *SubscriptionManager) reconcileSubscription(s *trackSubscription) {
+	if m.isClosed() {
+		return
+	}
+
+	switch {
+	case s.needsSubscribe():
+		if !s.canAttempt() {
+			return
+		}
+		err := m.subscribe(s)
+		if err == nil {
+			s.resetAttempts()
+			return
+		}
+		m.handleSubscribeError(s, err)
+
+	case s.needsUnsubscribe():
+		m.unsubscribe(s)
+
+	case s.needsCleanup():
+		m.lock.Lock()
+		if m.subscriptions[s.trackID] == s && s.needsCleanup() {
+			delete(m.subscriptions, s.trackID)
+		}
+		m.lock.Unlock()
+	}
+}
+
+func (m *SubscriptionManager) handleSubscribeError(s *trackSubscription, err error) {
+	switch {
+	case errors.Is(err, ErrTrackNotFound):
+		// 发布者可能暂时离开，超时之前继续等待
+		if s.notFoundDuration() < trackNotFoundTimeout {
+			m.scheduleRetry(s, false)
+			return
+		}
+		s.logger.Infow("track not found, giving up subscription", "timeout", trackNotFoundTimeout)
+		s.setDesired(false)
+		m.notifySubscriptionError(s.trackID, false, err)
+
+	case errors.Is(err, ErrSubscriptionLimitExceeded):
+		// 不再重试，客户端取消其他订阅后可以重新订阅
+		s.logger.Infow("subscription limit exceeded, giving up subscription")
+		s.setDesired(false)
+		m.notifySubscriptionError(s.trackID, false, err)
+
+	case errors.Is(err, ErrAlreadySubscribed):
+		// 同一订阅正在其他地方添加，稍后调和时挂上已有的订阅
+		m.scheduleRetry(s, false)
+
+	case errors.Is(err, ErrNoTrackPermission):
+		// 等待发布者授权，授权后会立即重新调和
+		s.logger.Debugw("no permission to subscribe to track")
+
+	default:
+		attempts := m.scheduleRetry(s, true)
+		s.logger.Warnw("could not subscribe to track", err, "attempts", attempts)
+		if attempts >= maxSubscribeAttempts {
+			s.setDesired(false)
+			m.notifySubscriptionError(s.trackID, true, err)
+		}
+	}
+}
+
+// scheduleRetry 退避时间到达后重新调和
+func (m *SubscriptionManager) scheduleRetry(s *trackSubscription, countAttempt bool) int {
+	attempts, backoff := s.scheduleRetry(countAttempt)
+	time.AfterFunc(backoff, func() {
+		m.queueReconcile(s.trackID)
+	})
+	return attempts
+}
+
+func (m *SubscriptionManager) notifySubscriptionError(trackID tc.TrackID, fatal bool, err error) {
+	if m.params.OnSubscriptionError != nil {
+		m.params.OnSubscriptionError(trackID, fatal, err)
+	}
+}
+
+func (m *SubscriptionManager) subscribe(s *trackSubscription) error {
+	if m.params.TrackResolver == nil {
+		return ErrTrackNotFound
+	}
+	res := m.params.TrackResolver(m.params.Participant, s.trackID)
+	track := res.Track
+	if track == nil || !track.IsOpen() {
+		s.markNotFound()
+		return ErrTrackNotFound
+	}
+	s.clearNotFound()
+
+	if !res.HasPermission {
+		m.params.Participant.SubscriptionPermissionUpdate(res.PublisherID, s.trackID, false)
+		return ErrNoTrackPermission
+	}
+	s.setHasPermission(true)
+
+	counter, limit := m.counterForKind(track.Kind())
+	if counter != nil {
+		if limit > 0 && counter.Inc() > limit {
+			counter.Dec()
+			return ErrSubscriptionLimitExceeded
+		}
+	}
+
+	st, err := track.AddSubscriber(m.params.Participant)
+	if err != nil && !(errors.Is(err, ErrAlreadySubscribed) && st != nil) {
+		if counter != nil {
+			counter.Dec()
+		}
+		return err
+	}
+	if err != nil {
+		// 已经订阅但是没有记录在订阅管理器中，挂上已有的订阅
+		s.logger.Debugw("attaching existing subscription")
+	}
+
+	s.setSubscribedTrack(st)
+	if settings := s.getSettings(); settings != nil {
+		st.UpdateSubscriberSettings(settings)
+	}
+
+	publisherID := track.PublisherID()
+	m.lock.Lock()
+	pTracks, ok := m.subscribedTo[publisherID]
+	if !ok {
+		pTracks = make(map[tc.TrackID]struct{})
+		m.subscribedTo[publisherID] = pTracks
+	}
+	pTracks[s.trackID] = struct{}{}
+	onSubscribeStatusChanged := m.onSubscribeStatusChanged
+	m.lock.Unlock()
+
+	s.logger.Debugw("subscribed to track", "publisher", track.PublisherIdentity())
+	if m.params.OnTrackSubscribed != nil {
+		m.params.OnTrackSubscribed(st)
+	}
+	if !ok && onSubscribeStatusChanged != nil {
+		onSubscribeStatusChanged(publisherID, true)
+	}
+
+	// 订阅的状态都记录之后再设置关闭回调，添加期间已经关闭的订阅会立即回调并清理
+	st.OnClose(func(willBeResumed bool) {
+		m.handleSubscribedTrackClose(s, st, willBeResumed)
+	})
+	return nil
+}
+
+func (m *SubscriptionManager) unsubscribe(s *trackSubscription) {
+	st := s.getSubscribedTrack()
+	if st == nil {
+		return
+	}
+
+	s.logger.Debugw("unsubscribing from track")
+	st.MediaTrack().RemoveSubscriber(m.params.Participant.ID(), false)
+}
+
+// handleSubscribedTrackClose 订阅的音轨关闭，仍然期望订阅时重新调和，发布者重新发布后可以恢复订阅
+func (m *SubscriptionManager) handleSubscribedTrackClose(s *trackSubscription, st types.SubscribedTrack, willBeResumed bool) {
+	if !s.clearSubscribedTrack(st) {
+		return
+	}
+
+	if counter, _ := m.counterForKind(st.MediaTrack().Kind()); counter != nil {
+		counter.Dec()
+	}
+
+	publisherID := st.PublisherID()
+	m.lock.Lock()
+	lastTrack := false
+	if pTracks, ok := m.subscribedTo[publisherID]; ok {
+		delete(pTracks, s.trackID)
+		if len(pTracks) == 0 {
+			delete(m.subscribedTo, publisherID)
+			lastTrack = true
+		}
+	}
+	onSubscribeStatusChanged := m.onSubscribeStatusChanged
+	m.lock.Unlock()
+
+	s.logger.Debugw("subscribed track closed", "willBeResumed", willBeResumed, "desired", s.isDesired())
+	if m.params.OnTrackUnsubscribed != nil {
+		m.params.OnTrackUnsubscribed(st)
+	}
+	if lastTrack && onSubscribeStatusChanged != nil {
+		onSubscribeStatusChanged(publisherID, false)
+	}
+
+	if !willBeResumed {
+		m.queueReconcile(s.trackID)
+	}
+}
+
+func (m *SubscriptionManager) counterForKind(kind tc.TrackType) (*atomic.Int32, int32) {
+	switch kind {
+	case tc.TrackType_VIDEO:
+		return &m.subscribedVideoCount, m.params.SubscriptionLimitVideo
+	case tc.TrackType_AUDIO:
+		return &m.subscribedAudioCount, m.params.SubscriptionLimitAudio
+	default:
+		return nil, 0
+	}
+}
+
+// ---------------------------------------------
+
+// trackSubscription 单个音轨的期望状态和实际状态
+type trackSubscription struct {
+	trackID tc.TrackID
+	logger  logger.Logger
+
+	lock            sync.RWMutex
+	desired         bool
+	settings        *tc.UpdateTrackSettings
+	subscribedTrack types.SubscribedTrack
+	hasPermission   bool
+	numAttempts     int
+	nextAttemptAt   time.Time
+	notFoundAt      time.Time
+}
+
+func newTrackSubscription(trackID tc.TrackID, l logger.Logger) *trackSubscription {
+	return &trackSubscription{
+		trackID:       trackID,
+		logger:        l.WithValues("trackID", trackID),
+		hasPermission: true,
+	}
+}
+
+// setDesired 设置期望状态，状态变化时返回 true
+func (s *trackSubscription) setDesired(desired bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.desired == desired {
+		return false
+	}
+	s.desired = desired
+	// 期望状态变化后立即尝试
+	s.numAttempts = 0
+	s.nextAttemptAt = time.Time{}
+	s.notFoundAt = time.Time{}
+	return true
+}
+
+func (s *trackSubscription) isDesired() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.desired
+}
+
+func (s *trackSubscription) setSettings(settings *tc.UpdateTrackSettings) {
+	s.lock.Lock()
+	s.settings = settings
+	st := s.subscribedTrack
+	s.lock.Unlock()
+
+	if st != nil {
+		st.UpdateSubscriberSettings(settings)
+	}
+}
+
+func (s *trackSubscription) getSettings() *tc.UpdateTrackSettings {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.settings
+}
+
+// setHasPermission 设置是否有订阅权限，状态变化时返回 true
+func (s *trackSubscription) setHasPermission(hasPermission bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.hasPermission == hasPermission {
+		return false
+	}
+	s.hasPermission = hasPermission
+	return true
+}
+
+func (s *trackSubscription) setSubscribedTrack(st types.SubscribedTrack) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.subscribedTrack = st
+}
+
+// clearSubscribedTrack 清除订阅的音轨，st 不是当前订阅时返回 false
+func (s *trackSubscription) clearSubscribedTrack(st types.SubscribedTrack) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.subscribedTrack != st {
+		return false
+	}
+	s.subscribedTrack = nil
+	return true
+}
+
+func (s *trackSubscription) getSubscribedTrack() types.SubscribedTrack {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.subscribedTrack
+}
+
+func (s *trackSubscription) needsSubscribe() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.desired && s.subscribedTrack == nil
+}
+
+func (s *trackSubscription) needsUnsubscribe() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return !s.desired && s.subscribedTrack != nil
+}
+
+func (s *trackSubscription) needsCleanup() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return !s.desired && s.subscribedTrack == nil
+}
+
+func (s *trackSubscription) canAttempt() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return !time.Now().Before(s.nextAttemptAt)
+}
+
+// scheduleRetry 安排下一次尝试，countAttempt 为 true 时计入失败次数并按指数退避，返回失败次数和等待时间
+func (s *trackSubscription) scheduleRetry(countAttempt bool) (int, time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	backoff := subscribeBackoffBase
+	if countAttempt {
+		s.numAttempts++
+		for i := 1; i < s.numAttempts && backoff < subscribeBackoffMax; i++ {
+			backoff *= 2
+		}
+		if backoff > subscribeBackoffMax {
+			backoff = subscribeBackoffMax
+		}
+	}
+	s.nextAttemptAt = time.Now().Add(backoff)
+	return s.numAttempts, backoff
+}
+
+func (s *trackSubscription) resetAttempts() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.numAttempts = 0
+	s.nextAttemptAt = time.Time{}
+}
+
+func (s *trackSubscription) markNotFound() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.notFoundAt.IsZero() {
+		s.notFoundAt = time.Now()
+	}
+}
+
+func (s *trackSubscription) clearNotFound() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.notFoundAt = time.Time{}
+}
+
+func (s *trackSubscription) notFoundDuration() time.Duration {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.notFoundAt.IsZero() {
+		return 0
+	}
+	return time.Since(s.notFoundAt)
+}
@@ -0,0 +1,189 @@
+package rtc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+// subscriptionError 订阅管理器上报的订阅错误
+type subscriptionError struct {
+	trackID tc.TrackID
+	fatal   bool
+	err     error
+}
+
+type subscriptionTestEnv struct {
+	sub    *fakeSubscriber
+	sm     *SubscriptionManager
+	tracks map[tc.TrackID]*MediaTrack
+
+	lock   sync.Mutex
+	errors []subscriptionError
+}
+
+func newSubscriptionTestEnv(t *testing.T, limitVideo int32) *subscriptionTestEnv {
+	env := &subscriptionTestEnv{
+		sub:    newFakeSubscriber(t, "PA_sub"),
+		tracks: make(map[tc.TrackID]*MediaTrack),
+	}
+	env.sm = NewSubscriptionManager(SubscriptionManagerParams{
+		Participant: env.sub,
+		Logger:      logger.GetLogger(),
+		TrackResolver: func(_ types.LocalParticipant, trackID tc.TrackID) types.MediaResolverResult {
+			env.lock.Lock()
+			mt := env.tracks[trackID]
+			env.lock.Unlock()
+			if mt == nil {
+				return types.MediaResolverResult{}
+			}
+			return types.MediaResolverResult{
+				Track:             mt,
+				HasPermission:     true,
+				PublisherID:       mt.PublisherID(),
+				PublisherIdentity: mt.PublisherIdentity(),
+			}
+		},
+		SubscriptionLimitVideo: limitVideo,
+		OnSubscriptionError: func(trackID tc.TrackID, fatal bool, err error) {
+			env.lock.Lock()
+			env.errors = append(env.errors, subscriptionError{trackID: trackID, fatal: fatal, err: err})
+			env.lock.Unlock()
+		},
+	})
+	t.Cleanup(func() {
+		env.sm.Close(false)
+	})
+	return env
+}
+
+func (e *subscriptionTestEnv) addVideoTrack(trackID tc.TrackID) *MediaTrack {
+	mt := newTestMediaTrack(&tc.TrackInfo{Sid: string(trackID), Type: tc.TrackType_VIDEO})
+	mt.AddReceiver(newFakeReceiver(trackID, webrtc.MimeTypeVP8))
+	e.lock.Lock()
+	e.tracks[trackID] = mt
+	e.lock.Unlock()
+	return mt
+}
+
+func (e *subscriptionTestEnv) removeTrack(trackID tc.TrackID) {
+	e.lock.Lock()
+	delete(e.tracks, trackID)
+	e.lock.Unlock()
+}
+
+func (e *subscriptionTestEnv) subscriptionErrors() []subscriptionError {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]subscriptionError{}, e.errors...)
+}
+
+func TestSubscriptionManager(t *testing.T) {
+	t.Run("subscribe and unsubscribe", func(t *testing.T) {
+		env := newSubscriptionTestEnv(t, 0)
+		mt := env.addVideoTrack("TR_video")
+
+		env.sm.SubscribeToTrack("TR_video")
+		require.NoError(t, env.sm.WaitUntilSubscribed(time.Second))
+		require.Len(t, env.sm.GetSubscribedTracks(), 1)
+		require.True(t, env.sm.IsSubscribedTo("PA_publisher"))
+		require.True(t, mt.IsSubscriber("PA_sub"))
+
+		env.sm.UnsubscribeFromTrack("TR_video")
+		require.Eventually(t, func() bool {
+			return len(env.sm.GetSubscribedTracks()) == 0 && !mt.IsSubscriber("PA_sub")
+		}, time.Second, 10*time.Millisecond)
+		require.False(t, env.sm.IsSubscribedTo("PA_publisher"))
+		require.False(t, env.sm.HasSubscriptions())
+	})
+
+	t.Run("attaches an existing subscription", func(t *testing.T) {
+		env := newSubscriptionTestEnv(t, 0)
+		mt := env.addVideoTrack("TR_video")
+		existing, err := mt.AddSubscriber(env.sub)
+		require.NoError(t, err)
+
+		env.sm.SubscribeToTrack("TR_video")
+		require.NoError(t, env.sm.WaitUntilSubscribed(time.Second))
+		subscribed := env.sm.GetSubscribedTracks()
+		require.Len(t, subscribed, 1)
+		require.Equal(t, existing, subscribed[0])
+		require.Equal(t, 1, mt.GetNumSubscribers())
+
+		// 挂上的订阅关闭时同样会被清理，仍然期望订阅时重新订阅
+		mt.RemoveSubscriber("PA_sub", false)
+		require.Eventually(t, func() bool {
+			subscribed := env.sm.GetSubscribedTracks()
+			return len(subscribed) == 1 && subscribed[0] != existing
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("subscription limit notifies without retrying", func(t *testing.T) {
+		env := newSubscriptionTestEnv(t, 1)
+		env.addVideoTrack("TR_video1")
+		second := env.addVideoTrack("TR_video2")
+
+		env.sm.SubscribeToTrack("TR_video1")
+		require.NoError(t, env.sm.WaitUntilSubscribed(time.Second))
+		env.sm.SubscribeToTrack("TR_video2")
+
+		require.Eventually(t, func() bool {
+			return len(env.subscriptionErrors()) == 1
+		}, time.Second, 10*time.Millisecond)
+		subErr := env.subscriptionErrors()[0]
+		require.Equal(t, tc.TrackID("TR_video2"), subErr.trackID)
+		require.False(t, subErr.fatal)
+		require.ErrorIs(t, subErr.err, ErrSubscriptionLimitExceeded)
+		require.False(t, env.sm.HasPendingSubscriptions())
+		require.False(t, second.IsSubscriber("PA_sub"))
+
+		// 取消其他订阅之后可以重新订阅
+		env.sm.UnsubscribeFromTrack("TR_video1")
+		require.Eventually(t, func() bool {
+			return len(env.sm.GetSubscribedTracks()) == 0
+		}, time.Second, 10*time.Millisecond)
+		env.sm.SubscribeToTrack("TR_video2")
+		require.NoError(t, env.sm.WaitUntilSubscribed(time.Second))
+		require.True(t, second.IsSubscriber("PA_sub"))
+		require.Len(t, env.subscriptionErrors(), 1)
+	})
+
+	t.Run("publisher closing the track clears the subscription", func(t *testing.T) {
+		env := newSubscriptionTestEnv(t, 1)
+		mt := env.addVideoTrack("TR_video")
+
+		env.sm.SubscribeToTrack("TR_video")
+		require.NoError(t, env.sm.WaitUntilSubscribed(time.Second))
+
+		env.removeTrack("TR_video")
+		mt.Close(false)
+		require.Eventually(t, func() bool {
+			return len(env.sm.GetSubscribedTracks()) == 0
+		}, time.Second, 10*time.Millisecond)
+		// 订阅数量释放之后可以订阅新的视频
+		require.True(t, env.sm.HasPendingSubscriptions())
+		env.addVideoTrack("TR_video")
+		require.NoError(t, env.sm.WaitUntilSubscribed(5*time.Second))
+	})
+}
+
+func TestSubscribedTrackOnCloseAfterClose(t *testing.T) {
+	mt := newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO})
+	mt.AddReceiver(newFakeReceiver("TR_video", webrtc.MimeTypeVP8))
+	st, err := mt.AddSubscriber(newFakeSubscriber(t, "PA_sub"))
+	require.NoError(t, err)
+
+	mt.RemoveSubscriber("PA_sub", true)
+	var calls []bool
+	st.OnClose(func(willBeResumed bool) {
+		calls = append(calls, willBeResumed)
+	})
+	require.Equal(t, []bool{true}, calls)
+}
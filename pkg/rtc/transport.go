@@ -0,0 +1,681 @@
+package rtc
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/atomic"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+	"github.com/liuhailove/tc-server/pkg/sfu"
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+)
+
+const (
+	// negotiationFrequency 协商的防抖间隔，短时间内的多次音轨变化合并为一次协商
+	negotiationFrequency = 150 * time.Millisecond
+
+	dataChannelReliable = "_reliable"
+	dataChannelLossy    = "_lossy"
+
+	// lossyDataChannelMaxBufferedAmount 有损数据通道允许积压的最大字节数
+	lossyDataChannelMaxBufferedAmount = 64 * 1024
+)
+
+var (
+	ErrTransportClosed          = errors.New("transport is closed")
+	ErrDataChannelUnavailable   = errors.New("data channel is not available")
+	ErrNoRemoteDescription      = errors.New("no remote description")
+	ErrUnexpectedOfferForTarget = errors.New("unexpected offer for target")
+)
+
+// negotiationState 协商状态
+type negotiationState int
+
+const (
+	// negotiationStateNone 没有进行中的协商
+	negotiationStateNone negotiationState = iota
+	// negotiationStateRemote 已经发出 offer，等待对端应答
+	negotiationStateRemote
+	// negotiationStateRetry 等待应答期间又需要协商，收到应答后重新发起
+	negotiationStateRetry
+)
+
+// TransportParams 传输参数
+type TransportParams struct {
+	ParticipantID       tc.ParticipantID
+	ParticipantIdentity tc.ParticipantIdentity
+	ProtocolVersion     types.ProtocolVersion
+	Config              *WebRTCConfig
+	// BufferFactory 发布连接接收到的包和订阅连接收到的 RTCP 写入参与者自己的缓冲
+	BufferFactory *buffer.Factory
+	Target        tc.SignalTarget
+	EnabledCodecs []*tc.Codec
+	// EnablePlayoutDelay 订阅连接协商视频的播放延迟扩展
+	EnablePlayoutDelay bool
+	Logger             logger.Logger
+}
+
+// PCTransport 对 pion PeerConnection 的封装，处理协商、ICE 候选和数据通道
+type PCTransport struct {
+	params TransportParams
+	pc     *webrtc.PeerConnection
+	me     *webrtc.MediaEngine
+
+	lock               sync.RWMutex
+	pendingCandidates  []webrtc.ICECandidateInit
+	negotiationState   negotiationState
+	debouncedNegotiate *time.Timer
+	restartAtNextOffer bool
+	reliableDC         *webrtc.DataChannel
+	lossyDC            *webrtc.DataChannel
+	previousOffer      *webrtc.SessionDescription
+	previousAnswer     *webrtc.SessionDescription
+
+	connectedAt      atomic.Value
+	fullyEstablished atomic.Bool
+	isClosed         atomic.Bool
+
+	cbLock             sync.RWMutex
+	onOffer            func(offer webrtc.SessionDescription) error
+	onICECandidate     func(c *webrtc.ICECandidate) error
+	onFullyEstablished func()
+	onFailed           func()
+	onTrack            func(track *webrtc.TrackRemote, rtpReceiver *webrtc.RTPReceiver)
+	onDataPacket       func(kind tc.DataPacket_Kind, data []byte)
+}
+
+// NewPCTransport 创建传输
+func NewPCTransport(params TransportParams) (*PCTransport, error) {
+	if params.Logger == nil {
+		params.Logger = logger.GetLogger()
+	}
+
+	me := &webrtc.MediaEngine{}
+	if err := registerCodecs(me, params.EnabledCodecs); err != nil {
+		return nil, err
+	}
+	if params.Target == tc.SignalTarget_SUBSCRIBER && params.EnablePlayoutDelay {
+		if err := me.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sfu.PlayoutDelayURI}, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, err
+		}
+	}
+
+	se := params.Config.SettingEngine
+	if params.BufferFactory != nil {
+		se.BufferFactory = params.BufferFactory.GetOrNew
+	}
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(me), webrtc.WithSettingEngine(se))
+	pc, err := api.NewPeerConnection(params.Config.Configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &PCTransport{
+		params: params,
+		pc:     pc,
+		me:     me,
+	}
+
+	pc.OnICECandidate(t.handleICECandidate)
+	pc.OnConnectionStateChange(t.handleConnectionStateChange)
+	pc.OnTrack(func(track *webrtc.TrackRemote, rtpReceiver *webrtc.RTPReceiver) {
+		if onTrack := t.getOnTrack(); onTrack != nil {
+			onTrack(track, rtpReceiver)
+		}
+	})
+	pc.OnDataChannel(t.handleDataChannel)
+
+	// 订阅连接由服务端发起，数据通道也由服务端创建
+	if params.Target == tc.SignalTarget_SUBSCRIBER {
+		if err := t.createDataChannels(); err != nil {
+			_ = pc.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// OnOffer 需要向客户端发送 offer 时的回调
+func (t *PCTransport) OnOffer(f func(offer webrtc.SessionDescription) error) {
+	t.cbLock.Lock()
+	defer t.cbLock.Unlock()
+
+	t.onOffer = f
+}
+
+// OnICECandidate 本地 ICE 候选回调
+func (t *PCTransport) OnICECandidate(f func(c *webrtc.ICECandidate) error) {
+	t.cbLock.Lock()
+	defer t.cbLock.Unlock()
+
+	t.onICECandidate = f
+}
+
+// OnFullyEstablished 连接建立完成回调
+func (t *PCTransport) OnFullyEstablished(f func()) {
+	t.cbLock.Lock()
+	defer t.cbLock.Unlock()
+
+	t.onFullyEstablished = f
+}
+
+// OnFailed 连接失败回调
+func (t *PCTransport) OnFailed(f func()) {
+	t.cbLock.Lock()
+	defer t.cbLock.Unlock()
+
+	t.onFailed = f
+}
+
+// OnTrack 收到远端音轨回调
+func (t *PCTransport) OnTrack(f func(track *webrtc.TrackRemote, rtpReceiver *webrtc.RTPReceiver)) {
+	t.cbLock.Lock()
+	defer t.cbLock.Unlock()
+
+	t.onTrack = f
+}
+
+// OnDataPacket 收到数据通道消息回调
+func (t *PCTransport) OnDataPacket(f func(kind tc.DataPacket_Kind, data []byte)) {
+	t.cbLock.Lock()
+	defer t.cbLock.Unlock()
+
+	t.onDataPacket = f
+}
+
+// IsEstablished 连接是否已经建立
+func (t *PCTransport) IsEstablished() bool {
+	return t.fullyEstablished.Load()
+}
+
+// ConnectedAt 连接建立的时间
+func (t *PCTransport) ConnectedAt() time.Time {
+	connectedAt, _ := t.connectedAt.Load().(time.Time)
+	return connectedAt
+}
+
+// HandleOffer 处理客户端的 offer 并返回应答，仅用于发布连接
+func (t *PCTransport) HandleOffer(offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if t.isClosed.Load() {
+		return webrtc.SessionDescription{}, ErrTransportClosed
+	}
+	if t.params.Target != tc.SignalTarget_PUBLISHER {
+		return webrtc.SessionDescription{}, ErrUnexpectedOfferForTarget
+	}
+
+	if err := t.setRemoteDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	answer, err := t.pc.CreateAnswer(nil)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	if err := t.pc.SetLocalDescription(answer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	t.lock.Lock()
+	t.previousOffer = &offer
+	t.previousAnswer = &answer
+	t.lock.Unlock()
+	return answer, nil
+}
+
+// HandleAnswer 处理客户端的应答，仅用于订阅连接
+func (t *PCTransport) HandleAnswer(answer webrtc.SessionDescription) error {
+	if t.isClosed.Load() {
+		return ErrTransportClosed
+	}
+
+	if err := t.setRemoteDescription(answer); err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	state := t.negotiationState
+	t.negotiationState = negotiationStateNone
+	t.previousAnswer = &answer
+	t.lock.Unlock()
+
+	if state == negotiationStateRetry {
+		t.Negotiate(true)
+	}
+	return nil
+}
+
+// AddICECandidate 添加远端 ICE 候选，远端描述设置之前的候选会先缓存
+func (t *PCTransport) AddICECandidate(candidate webrtc.ICECandidateInit) error {
+	t.lock.Lock()
+	if t.pc.RemoteDescription() == nil {
+		t.pendingCandidates = append(t.pendingCandidates, candidate)
+		t.lock.Unlock()
+		return nil
+	}
+	t.lock.Unlock()
+
+	return t.pc.AddICECandidate(candidate)
+}
+
+// Negotiate 发起协商，force 为 false 时会合并短时间内的多次请求
+func (t *PCTransport) Negotiate(force bool) {
+	if t.isClosed.Load() {
+		return
+	}
+
+	t.lock.Lock()
+	if t.debouncedNegotiate != nil {
+		t.debouncedNegotiate.Stop()
+		t.debouncedNegotiate = nil
+	}
+	if !force {
+		t.debouncedNegotiate = time.AfterFunc(negotiationFrequency, t.negotiate)
+		t.lock.Unlock()
+		return
+	}
+	t.lock.Unlock()
+
+	t.negotiate()
+}
+
+// ICERestart 在下一次 offer 中重启 ICE
+func (t *PCTransport) ICERestart() {
+	t.lock.Lock()
+	t.restartAtNextOffer = true
+	t.lock.Unlock()
+
+	t.Negotiate(true)
+}
+
+// AddTrack 添加发送音轨，复用空闲的收发器
+func (t *PCTransport) AddTrack(trackLocal webrtc.TrackLocal) (*webrtc.RTPSender, *webrtc.RTPTransceiver, error) {
+	sender, err := t.pc.AddTrack(trackLocal)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, tr := range t.pc.GetTransceivers() {
+		if tr.Sender() == sender {
+			return sender, tr, nil
+		}
+	}
+	return sender, nil, ErrNoTransceiver
+}
+
+// AddTransceiverFromTrack 总是为音轨创建新的收发器，用于不支持收发器复用的客户端
+func (t *PCTransport) AddTransceiverFromTrack(trackLocal webrtc.TrackLocal) (*webrtc.RTPSender, *webrtc.RTPTransceiver, error) {
+	transceiver, err := t.pc.AddTransceiverFromTrack(trackLocal, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return transceiver.Sender(), transceiver, nil
+}
+
+// RemoveTrack 移除发送音轨
+func (t *PCTransport) RemoveTrack(sender *webrtc.RTPSender) error {
+	return t.pc.RemoveTrack(sender)
+}
+
+// SendDataPacket 通过数据通道发送数据
+func (t *PCTransport) SendDataPacket(kind tc.DataPacket_Kind, data []byte) error {
+	t.lock.RLock()
+	dc := t.reliableDC
+	if kind == tc.DataPacket_LOSSY {
+		dc = t.lossyDC
+	}
+	t.lock.RUnlock()
+
+	if dc == nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return ErrDataChannelUnavailable
+	}
+	// 有损数据不排队，积压过多时直接丢弃
+	if kind == tc.DataPacket_LOSSY && dc.BufferedAmount() > lossyDataChannelMaxBufferedAmount {
+		return nil
+	}
+	return dc.Send(data)
+}
+
+// HasDataChannels 数据通道是否可用
+func (t *PCTransport) HasDataChannels() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.reliableDC != nil && t.reliableDC.ReadyState() == webrtc.DataChannelStateOpen
+}
+
+// GetICEConnectionType 根据选中的候选对判断连接类型
+func (t *PCTransport) GetICEConnectionType() types.ICEConnectionType {
+	var pair *webrtc.ICECandidatePair
+	if sctp := t.pc.SCTP(); sctp != nil && sctp.Transport() != nil && sctp.Transport().ICETransport() != nil {
+		pair, _ = sctp.Transport().ICETransport().GetSelectedCandidatePair()
+	}
+	if pair == nil {
+		for _, tr := range t.pc.GetTransceivers() {
+			if tr.Sender() == nil || tr.Sender().Transport() == nil || tr.Sender().Transport().ICETransport() == nil {
+				continue
+			}
+			if pair, _ = tr.Sender().Transport().ICETransport().GetSelectedCandidatePair(); pair != nil {
+				break
+			}
+		}
+	}
+	if pair == nil || pair.Local == nil || pair.Remote == nil {
+		return types.ICEConnectionTypeUnknown
+	}
+
+	switch {
+	case pair.Local.Typ == webrtc.ICECandidateTypeRelay || pair.Remote.Typ == webrtc.ICECandidateTypeRelay:
+		return types.ICEConnectionTypeTURN
+	case pair.Remote.Protocol == webrtc.ICEProtocolTCP:
+		return types.ICEConnectionTypeTCP
+	default:
+		return types.ICEConnectionTypeUDP
+	}
+}
+
+// GetPreviousNegotiation 获取最近一次完成的 offer/answer，用于会话迁移
+func (t *PCTransport) GetPreviousNegotiation() (offer, answer *webrtc.SessionDescription) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.previousOffer, t.previousAnswer
+}
+
+// SetPreviousNegotiation 迁移时导入源会话的 offer/answer，再次迁移之前没有新的协商时原样导出
+func (t *PCTransport) SetPreviousNegotiation(offer, answer *webrtc.SessionDescription) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.previousOffer = offer
+	t.previousAnswer = answer
+}
+
+// WriteRTCP 向对端发送 RTCP
+func (t *PCTransport) WriteRTCP(pkts []rtcp.Packet) error {
+	if t.isClosed.Load() {
+		return ErrTransportClosed
+	}
+	return t.pc.WriteRTCP(pkts)
+}
+
+// Close 关闭连接
+func (t *PCTransport) Close() {
+	if t.isClosed.Swap(true) {
+		return
+	}
+
+	t.lock.Lock()
+	if t.debouncedNegotiate != nil {
+		t.debouncedNegotiate.Stop()
+		t.debouncedNegotiate = nil
+	}
+	t.lock.Unlock()
+
+	if err := t.pc.Close(); err != nil {
+		t.params.Logger.Debugw("could not close peer connection", "error", err)
+	}
+}
+
+func (t *PCTransport) negotiate() {
+	if t.isClosed.Load() {
+		return
+	}
+
+	t.lock.Lock()
+	switch t.negotiationState {
+	case negotiationStateRemote:
+		t.negotiationState = negotiationStateRetry
+		t.lock.Unlock()
+		return
+	case negotiationStateRetry:
+		t.lock.Unlock()
+		return
+	}
+
+	iceRestart := t.restartAtNextOffer
+	t.restartAtNextOffer = false
+
+	offer, err := t.pc.CreateOffer(&webrtc.OfferOptions{ICERestart: iceRestart})
+	if err == nil {
+		err = t.pc.SetLocalDescription(offer)
+	}
+	if err != nil {
+		t.lock.Unlock()
+		t.params.Logger.Warnw("could not create offer", err, "iceRestart", iceRestart)
+		if onFailed := t.getOnFailed(); onFailed != nil {
+			onFailed()
+		}
+		return
+	}
+	t.negotiationState = negotiationStateRemote
+	t.previousOffer = &offer
+	t.lock.Unlock()
+
+	t.cbLock.RLock()
+	onOffer := t.onOffer
+	t.cbLock.RUnlock()
+	if onOffer != nil {
+		if err := onOffer(offer); err != nil {
+			t.params.Logger.Warnw("could not send offer", err)
+		}
+	}
+}
+
+func (t *PCTransport) setRemoteDescription(sd webrtc.SessionDescription) error {
+	if err := t.pc.SetRemoteDescription(sd); err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	pendingCandidates := t.pendingCandidates
+	t.pendingCandidates = nil
+	t.lock.Unlock()
+
+	for _, c := range pendingCandidates {
+		if err := t.pc.AddICECandidate(c); err != nil {
+			t.params.Logger.Warnw("could not add pending ICE candidate", err, "candidate", c.Candidate)
+		}
+	}
+	return nil
+}
+
+func (t *PCTransport) createDataChannels() error {
+	ordered := true
+	reliableDC, err := t.pc.CreateDataChannel(dataChannelReliable, &webrtc.DataChannelInit{
+		Ordered: &ordered,
+	})
+	if err != nil {
+		return err
+	}
+
+	unordered := false
+	maxRetransmits := uint16(0)
+	lossyDC, err := t.pc.CreateDataChannel(dataChannelLossy, &webrtc.DataChannelInit{
+		Ordered:        &unordered,
+		MaxRetransmits: &maxRetransmits,
+	})
+	if err != nil {
+		return err
+	}
+
+	t.handleDataChannel(reliableDC)
+	t.handleDataChannel(lossyDC)
+	return nil
+}
+
+func (t *PCTransport) handleDataChannel(dc *webrtc.DataChannel) {
+	var kind tc.DataPacket_Kind
+	switch dc.Label() {
+	case dataChannelReliable:
+		kind = tc.DataPacket_RELIABLE
+	case dataChannelLossy:
+		kind = tc.DataPacket_LOSSY
+	default:
+		t.params.Logger.Infow("unsupported data channel", "label", dc.Label())
+		return
+	}
+
+	t.lock.Lock()
+	if kind == tc.DataPacket_RELIABLE {
+		t.reliableDC = dc
+	} else {
+		t.lossyDC = dc
+	}
+	t.lock.Unlock()
+
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		t.cbLock.RLock()
+		onDataPacket := t.onDataPacket
+		t.cbLock.RUnlock()
+		if onDataPacket != nil {
+			onDataPacket(kind, msg.Data)
+		}
+	})
+}
+
+func (t *PCTransport) handleICECandidate(c *webrtc.ICECandidate) {
+	if c == nil || t.isClosed.Load() {
+		return
+	}
+
+	t.cbLock.RLock()
+	onICECandidate := t.onICECandidate
+	t.cbLock.RUnlock()
+	if onICECandidate != nil {
+		if err := onICECandidate(c); err != nil {
+			t.params.Logger.Warnw("could not send ICE candidate", err)
+		}
+	}
+}
+
+func (t *PCTransport) handleConnectionStateChange(state webrtc.PeerConnectionState) {
+	t.params.Logger.Debugw("peer connection state change", "state", state.String(), "target", t.params.Target)
+	switch state {
+	case webrtc.PeerConnectionStateConnected:
+		if t.fullyEstablished.Swap(true) {
+			return
+		}
+		t.connectedAt.Store(time.Now())
+
+		t.cbLock.RLock()
+		onFullyEstablished := t.onFullyEstablished
+		t.cbLock.RUnlock()
+		if onFullyEstablished != nil {
+			onFullyEstablished()
+		}
+	case webrtc.PeerConnectionStateFailed:
+		if onFailed := t.getOnFailed(); onFailed != nil {
+			onFailed()
+		}
+	}
+}
+
+func (t *PCTransport) getOnTrack() func(track *webrtc.TrackRemote, rtpReceiver *webrtc.RTPReceiver) {
+	t.cbLock.RLock()
+	defer t.cbLock.RUnlock()
+
+	return t.onTrack
+}
+
+func (t *PCTransport) getOnFailed() func() {
+	t.cbLock.RLock()
+	defer t.cbLock.RUnlock()
+
+	return t.onFailed
+}
+
+// registerCodecs 注册启用的编解码器，未配置时注册全部默认编解码器
+func registerCodecs(me *webrtc.MediaEngine, enabledCodecs []*tc.Codec) error {
+	isEnabled := func(mime string) bool {
+		if len(enabledCodecs) == 0 {
+			return true
+		}
+		for _, c := range enabledCodecs {
+			if strings.EqualFold(c.Mime, mime) {
+				return true
+			}
+		}
+		return false
+	}
+
+	videoFeedback := []webrtc.RTCPFeedback{
+		{Type: webrtc.TypeRTCPFBGoogREMB},
+		{Type: webrtc.TypeRTCPFBTransportCC},
+		{Type: webrtc.TypeRTCPFBCCM, Parameter: "fir"},
+		{Type: webrtc.TypeRTCPFBNACK},
+		{Type: webrtc.TypeRTCPFBNACK, Parameter: "pli"},
+	}
+
+	audioCodecs := []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+			PayloadType:        111,
+		},
+	}
+	videoCodecs := []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, RTCPFeedback: videoFeedback},
+			PayloadType:        96,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", RTCPFeedback: videoFeedback},
+			PayloadType:        125,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=0", RTCPFeedback: videoFeedback},
+			PayloadType:        98,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1, ClockRate: 90000, RTCPFeedback: videoFeedback},
+			PayloadType:        35,
+		},
+	}
+
+	for _, codec := range audioCodecs {
+		if !isEnabled(codec.MimeType) {
+			continue
+		}
+		if err := me.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+	for _, codec := range videoCodecs {
+		if !isEnabled(codec.MimeType) {
+			continue
+		}
+		if err := me.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+
+	for _, extension := range []string{
+		"urn:ietf:params:rtp-hdrext:sdes:mid",
+		"urn:ietf:params:rtp-hdrext:ssrc-audio-level",
+	} {
+		if err := me.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: extension}, webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+	for _, extension := range []string{
+		"urn:ietf:params:rtp-hdrext:sdes:mid",
+		"urn:ietf:params:rtp-hdrext:sdes:rtp-stream-id",
+		"urn:ietf:params:rtp-hdrext:sdes:repaired-rtp-stream-id",
+		"http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time",
+		"https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension",
+	} {
+		if err := me.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: extension}, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
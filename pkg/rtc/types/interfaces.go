@@ -12,6 +12,7 @@ import (
 	"github.com/liuhailove/tc-base-go/protocol/tc"
 	"github.com/liuhailove/tc-base-go/protocol/utils"
 	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/sfu"
 	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
 	"github.com/liuhailove/tc-server/pkg/sfu/pacer"
 )
@@ -57,6 +58,27 @@ func (m MigrateState) String() string {
 
 // ---------------------------------------------
 
+// MigrationInfo 会话迁移时从源会话导出的状态，序列化为JSON后通过路由发送到目标节点
+type MigrationInfo struct {
+	// PreviousOffer 订阅连接最近一次的 offer
+	PreviousOffer *webrtc.SessionDescription `json:"previousOffer,omitempty"`
+	// PreviousAnswer 订阅连接最近一次的 answer
+	PreviousAnswer *webrtc.SessionDescription `json:"previousAnswer,omitempty"`
+	// DownTrackStates 订阅音轨的下行状态，包括 RTPStats 和 ForwarderState
+	DownTrackStates map[tc.TrackID]sfu.DownTrackState `json:"downTrackStates,omitempty"`
+	// Attributes 参与者属性
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// ParticipantAttributesDelta 参与者属性的增量变化，Version 为变化后的参与者版本
+type ParticipantAttributesDelta struct {
+	Version uint32            `json:"version"`
+	Changed map[string]string `json:"changed,omitempty"`
+	Removed []string          `json:"removed,omitempty"`
+}
+
+// ---------------------------------------------
+
 type SubscribedCodecQuality struct {
 	CodecMime string
 	Quality   tc.VideoQuality
@@ -229,6 +251,10 @@ type Participant interface {
 	SetName(name string)
 	// SetMetadata 设置元数据
 	SetMetadata(metadata string)
+	// GetAttributes 获取参与者属性
+	GetAttributes() map[string]string
+	// UpdateAttributes 设置和删除部分属性，其余属性保持不变
+	UpdateAttributes(set map[string]string, remove []string) error
 
 	// IsPublisher 是否为发布者
 	IsPublisher() bool
@@ -410,12 +436,14 @@ type LocalParticipant interface {
 	SendSpeakerUpdate(speakers []*tc.SpeakerInfo, force bool) error
 	// SendDataPacket 发送数据包
 	SendDataPacket(packet *tc.DataPacket, data []byte) error
+	// SubscribesToDataTopic 是否接收指定主题的数据包
+	SubscribesToDataTopic(topic string) bool
 	// SendRoomUpdate 发送房间更新
 	SendRoomUpdate(room *tc.Room) error
 	// SendConnectionQualityUpdate 发送连接质量更新
 	SendConnectionQualityUpdate(update *tc.ConnectionQualityUpdate) error
 	// SubscriptionPermissionUpdate 订阅者权限更新
-	SubscriptionPermissionUpdate(publisherID tc.ParticipantIdentity, trackID tc.TrackID, allowed bool)
+	SubscriptionPermissionUpdate(publisherID tc.ParticipantID, trackID tc.TrackID, allowed bool)
 	// SendRefreshToken 刷新Token
 	SendRefreshToken(token string) error
 	// HandleReconnectAndSendResponse 处理重连并发送响应
@@ -439,6 +467,8 @@ type LocalParticipant interface {
 	OnParticipantUpdate(callback func(LocalParticipant))
 	// OnDataPacket 收到数据包事件
 	OnDataPacket(callback func(LocalParticipant, *tc.DataPacket))
+	// OnAttributesChanged 参与者属性变化事件
+	OnAttributesChanged(callback func(LocalParticipant, *ParticipantAttributesDelta))
 	// OnSubscribedStatusChanged 订阅者状态发生变化
 	OnSubscribedStatusChanged(fn func(publisherID tc.ParticipantID, subscribed bool))
 	// OnClose 参与者关闭事件
@@ -458,6 +488,10 @@ type LocalParticipant interface {
 	MigrateState() MigrateState
 	// SetMigrateInfo 设置迁移信息
 	SetMigrateInfo(previousOffer, previousAnswer *webrtc.SessionDescription)
+	// ExportMigrationInfo 导出迁移需要的状态，由源会话调用
+	ExportMigrationInfo() *MigrationInfo
+	// ImportMigrationInfo 导入源会话的状态，由目标会话在订阅之前调用
+	ImportMigrationInfo(info *MigrationInfo)
 
 	// UpdateMediaRTT 更新媒体的RTT
 	UpdateMediaRTT(rtt uint32)
@@ -467,6 +501,8 @@ type LocalParticipant interface {
 	CacheDownTrack(trackID tc.TrackID, rtpTransceiver *webrtc.RTPTransceiver, downTrackState sfu.DownTrackState)
 	// UncacheDownTrack 取消缓存下行音轨
 	UncacheDownTrack(rtpTransceiver *webrtc.RTPTransceiver)
+	// GetCachedDownTrack 取出缓存的下行音轨，取出后从缓存中移除
+	GetCachedDownTrack(trackID tc.TrackID) (*webrtc.RTPTransceiver, sfu.DownTrackState, bool)
 
 	// SetICEConfig 设置ICE配置
 	SetICEConfig(iceConfig *tc.ICEConfig)
@@ -487,6 +523,117 @@ type LocalParticipant interface {
 	GetPacer() pacer.Pacer
 }
 
-// MediaTrack 表示媒体曲目
+// MediaTrack 表示参与者发布的媒体音轨
 type MediaTrack interface {
+	// ID 音轨ID
+	ID() tc.TrackID
+	// Kind 音轨类型
+	Kind() tc.TrackType
+	// Name 音轨名称
+	Name() string
+	// Source 音轨来源
+	Source() tc.TrackSource
+	// Stream 音轨所属的流
+	Stream() string
+
+	// UpdateTrackInfo 更新音轨信息
+	UpdateTrackInfo(ti *tc.TrackInfo)
+	// ToProto 转化为协议对象
+	ToProto() *tc.TrackInfo
+
+	// PublisherID 发布者ID
+	PublisherID() tc.ParticipantID
+	// PublisherIdentity 发布者标识
+	PublisherIdentity() tc.ParticipantIdentity
+	// PublisherVersion 发布者的版本
+	PublisherVersion() uint32
+
+	// IsMuted 是否静音
+	IsMuted() bool
+	// SetMuted 设置静音，同时通知全部订阅者
+	SetMuted(muted bool)
+
+	// IsSimulcast 是否为联播
+	IsSimulcast() bool
+	// GetAudioLevel 获取音频级别
+	GetAudioLevel() (level float64, active bool)
+
+	// Receivers 按照编解码器优先级返回全部接收器
+	Receivers() []sfu.TrackReceiver
+	// Receiver 获取指定编解码器的接收器，mime 不区分大小写
+	Receiver(mime string) sfu.TrackReceiver
+
+	// Close 关闭音轨，willBeResumed 为 true 时订阅者的发送器会被保留以便恢复
+	Close(willBeResumed bool)
+	// IsOpen 音轨是否仍然可用
+	IsOpen() bool
+
+	// AddSubscriber 添加订阅者，已经订阅时同时返回已有的订阅和 ErrAlreadySubscribed
+	AddSubscriber(participant LocalParticipant) (SubscribedTrack, error)
+	// RemoveSubscriber 移除订阅者
+	RemoveSubscriber(participantID tc.ParticipantID, willBeResumed bool)
+	// IsSubscriber 是否为订阅者
+	IsSubscriber(subID tc.ParticipantID) bool
+	// GetAllSubscribers 获取全部订阅者
+	GetAllSubscribers() []tc.ParticipantID
+	// GetNumSubscribers 获取订阅者数量
+	GetNumSubscribers() int
+	//RevokeDisallowedSubscribers 移除不在 allowedSubscriberIdentities 中的订阅者，
+	//返回被移除的订阅者标识
+	RevokeDisallowedSubscribers(allowedSubscriberIdentities []tc.ParticipantIdentity) []tc.ParticipantIdentity
+
+	// GetQualityForDimension 根据订阅者的显示尺寸选择合适的视频质量，用于自适应流
+	GetQualityForDimension(width, height uint32) tc.VideoQuality
+
+	// OnTrackSubscribed 音轨被订阅事件
+	OnTrackSubscribed(f func())
+}
+
+// SubscribedTrack 表示参与者订阅的音轨
+type SubscribedTrack interface {
+	// ID 音轨ID
+	ID() tc.TrackID
+	// PublisherID 发布者ID
+	PublisherID() tc.ParticipantID
+	// PublisherIdentity 发布者标识
+	PublisherIdentity() tc.ParticipantIdentity
+	// SubscriberID 订阅者ID
+	SubscriberID() tc.ParticipantID
+	// SubscriberIdentity 订阅者标识
+	SubscriberIdentity() tc.ParticipantIdentity
+	// Subscriber 订阅者
+	Subscriber() LocalParticipant
+	// DownTrack 下行音轨
+	DownTrack() *sfu.DownTrack
+	// MediaTrack 订阅的媒体音轨
+	MediaTrack() MediaTrack
+
+	// IsMuted 订阅者是否禁用了该音轨
+	IsMuted() bool
+	// SetPublisherMuted 发布者静音状态变化
+	SetPublisherMuted(muted bool)
+	// UpdateSubscriberSettings 更新订阅者设置
+	UpdateSubscriberSettings(settings *tc.UpdateTrackSettings)
+	// Settings 获取订阅者设置
+	Settings() *tc.UpdateTrackSettings
+
+	// OnClose 关闭事件
+	OnClose(f func(willBeResumed bool))
+	// Close 关闭订阅
+	Close(willBeResumed bool)
 }
+
+// MediaResolverResult 音轨查找结果
+type MediaResolverResult struct {
+	// Track 找到的音轨，找不到时为 nil
+	Track MediaTrack
+	// HasPermission 订阅者是否有权限订阅该音轨
+	HasPermission bool
+	// PublisherID 发布者ID
+	PublisherID tc.ParticipantID
+	// PublisherIdentity 发布者标识
+	PublisherIdentity tc.ParticipantIdentity
+}
+
+// MediaTrackResolver 根据音轨ID查找可以订阅的媒体音轨
+type MediaTrackResolver func(subscriber LocalParticipant, trackID tc.TrackID) MediaResolverResult
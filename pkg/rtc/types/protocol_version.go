@@ -1,17 +1,21 @@
 package types
 
+// ProtocolVersion 客户端信令协议版本，服务端根据版本决定启用哪些能力
 type ProtocolVersion int
 
 const CurrentProtocol = 10
 
+// SupportsPackedStreamId 流ID中同时包含参与者ID和音轨ID
 func (v ProtocolVersion) SupportsPackedStreamId() bool {
 	return v > 0
 }
 
+// SupportsProtobuf 信令使用 protobuf 编码
 func (v ProtocolVersion) SupportsProtobuf() bool {
 	return v > 0
 }
 
+// HandlesDataPackets 客户端能够处理数据包
 func (v ProtocolVersion) HandlesDataPackets() bool {
 	return v > 1
 }
@@ -41,6 +45,7 @@ func (v ProtocolVersion) SupportsSessionMigrate() bool {
 	return v > 5
 }
 
+// SupportsICELite 是否支持 ICE lite
 func (v ProtocolVersion) SupportsICELite() bool {
 	return v > 5
 }
@@ -49,3 +54,18 @@ func (v ProtocolVersion) SupportsICELite() bool {
 func (v ProtocolVersion) SupportsUnpublish() bool {
 	return v > 6
 }
+
+// SupportsFastStart 订阅者作为主连接时，服务端在加入响应之后立即发起订阅连接协商，不等待第一个订阅的音轨
+func (v ProtocolVersion) SupportsFastStart() bool {
+	return v > 7
+}
+
+// SupportHandlesDisconnectedUpdate 客户端以参与者ID区分会话，能够处理同一身份旧会话的断开更新
+func (v ProtocolVersion) SupportHandlesDisconnectedUpdate() bool {
+	return v > 8
+}
+
+// SupportSyncStreamID 客户端支持按流同步播放，同一个流中的音轨使用相同的流ID
+func (v ProtocolVersion) SupportSyncStreamID() bool {
+	return v > 9
+}
@@ -0,0 +1,380 @@
+package rtc
+
+import (
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+// UpTrackManagerParams 上行音轨管理参数
+type UpTrackManagerParams struct {
+	SID              tc.ParticipantID
+	Logger           logger.Logger
+	VersionGenerator utils.TimedVersionGenerator
+}
+
+// UpTrackManager 管理参与者发布的音轨
+type UpTrackManager struct {
+	params UpTrackManagerParams
+
+	lock            sync.RWMutex
+	publishedTracks map[tc.TrackID]types.MediaTrack
+	closed          bool
+
+	// 订阅权限，零值表示允许全部参与者订阅
+	subscriptionPermission        *tc.SubscriptionPermission
+	subscriberPermissions         subscriberPermissions
+	subscriptionPermissionVersion utils.TimedVersion
+
+	onTrackUpdated func(track types.MediaTrack)
+}
+
+// NewUpTrackManager 创建上行音轨管理
+func NewUpTrackManager(params UpTrackManagerParams) *UpTrackManager {
+	return &UpTrackManager{
+		params:          params,
+		publishedTracks: make(map[tc.TrackID]types.MediaTrack),
+	}
+}
+
+// Close 关闭全部发布的音轨
+func (u *UpTrackManager) Close(willBeResumed bool) {
+	u.lock.Lock()
+	u.closed = true
+	tracks := u.getPublishedTracksLocked()
+	u.publishedTracks = make(map[tc.TrackID]types.MediaTrack)
+	u.lock.Unlock()
+
+	for _, t := range tracks {
+		t.Close(willBeResumed)
+	}
+}
+
+// OnPublishedTrackUpdated 发布的音轨发生变化事件
+func (u *UpTrackManager) OnPublishedTrackUpdated(f func(track types.MediaTrack)) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.onTrackUpdated = f
+}
+
+// AddPublishedTrack 添加发布的音轨
+func (u *UpTrackManager) AddPublishedTrack(track types.MediaTrack) {
+	u.lock.Lock()
+	if u.closed {
+		u.lock.Unlock()
+		track.Close(false)
+		return
+	}
+	u.publishedTracks[track.ID()] = track
+	u.lock.Unlock()
+
+	u.params.Logger.Debugw("added published track", "trackID", track.ID(), "kind", track.Kind())
+}
+
+// GetPublishedTrack 获取发布的音轨
+func (u *UpTrackManager) GetPublishedTrack(trackID tc.TrackID) types.MediaTrack {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return u.publishedTracks[trackID]
+}
+
+// GetPublishedTracks 获取全部发布的音轨
+func (u *UpTrackManager) GetPublishedTracks() []types.MediaTrack {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return u.getPublishedTracksLocked()
+}
+
+// RemovePublishedTrack 移除发布的音轨，shouldClose 为 true 时同时关闭音轨，音轨不存在时返回 false
+func (u *UpTrackManager) RemovePublishedTrack(track types.MediaTrack, willBeResumed bool, shouldClose bool) bool {
+	u.lock.Lock()
+	if existing, ok := u.publishedTracks[track.ID()]; !ok || existing != track {
+		u.lock.Unlock()
+		return false
+	}
+	delete(u.publishedTracks, track.ID())
+	u.lock.Unlock()
+
+	if shouldClose {
+		track.Close(willBeResumed)
+	}
+	u.params.Logger.Debugw("removed published track", "trackID", track.ID(), "willBeResumed", willBeResumed)
+	return true
+}
+
+// SetPublishedTrackMuted 设置发布音轨的静音状态，返回被修改的音轨
+func (u *UpTrackManager) SetPublishedTrackMuted(trackID tc.TrackID, muted bool) types.MediaTrack {
+	track := u.GetPublishedTrack(trackID)
+	if track == nil {
+		return nil
+	}
+
+	if track.IsMuted() != muted {
+		track.SetMuted(muted)
+		u.notifyTrackUpdated(track)
+	}
+	return track
+}
+
+// UpdateVideoLayers 更新视频层信息
+func (u *UpTrackManager) UpdateVideoLayers(updateVideoLayers *tc.UpdateVideoLayers) error {
+	track := u.GetPublishedTrack(tc.TrackID(updateVideoLayers.TrackSid))
+	if track == nil {
+		u.params.Logger.Warnw("could not find track", nil, "trackID", updateVideoLayers.TrackSid)
+		return ErrTrackNotFound
+	}
+
+	ti := track.ToProto()
+	ti.Layers = updateVideoLayers.Layers
+	track.UpdateTrackInfo(ti)
+	u.notifyTrackUpdated(track)
+	return nil
+}
+
+// ToProto 全部发布音轨的协议对象
+func (u *UpTrackManager) ToProto() []*tc.TrackInfo {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	trackInfos := make([]*tc.TrackInfo, 0, len(u.publishedTracks))
+	for _, t := range u.publishedTracks {
+		trackInfos = append(trackInfos, t.ToProto())
+	}
+	return trackInfos
+}
+
+// HasPermission 订阅者是否可以订阅该音轨
+func (u *UpTrackManager) HasPermission(trackID tc.TrackID, subIdentity tc.ParticipantIdentity) bool {
+	u.lock.RLock()
+	permissions := u.subscriberPermissions
+	u.lock.RUnlock()
+
+	// 按参与者ID的授权需要通过房间解析，不能持有锁
+	return permissions.allows(trackID, subIdentity)
+}
+
+// SubscriptionPermission 当前的订阅权限以及版本
+func (u *UpTrackManager) SubscriptionPermission() (*tc.SubscriptionPermission, utils.TimedVersion) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.subscriptionPermission == nil {
+		return nil, u.subscriptionPermissionVersion.Load()
+	}
+	return proto.Clone(u.subscriptionPermission).(*tc.SubscriptionPermission), u.subscriptionPermissionVersion.Load()
+}
+
+// UpdateSubscriptionPermission 更新订阅权限，版本不比当前新的更新会被忽略，
+// 失去权限的订阅会被关闭并通知订阅者，权限中新旧两次列出的订阅者获得权限时也会收到通知。
+// timedVersion 为零值表示来自本节点客户端的请求，使用本地生成的版本
+func (u *UpTrackManager) UpdateSubscriptionPermission(
+	subscriptionPermission *tc.SubscriptionPermission,
+	timedVersion utils.TimedVersion,
+	resolverByIdentity func(participantIdentity tc.ParticipantIdentity) types.LocalParticipant,
+	resolverBySid func(participantID tc.ParticipantID) types.LocalParticipant,
+) error {
+	u.lock.Lock()
+	if timedVersion.IsZero() {
+		tv := u.params.VersionGenerator.Next()
+		u.subscriptionPermissionVersion.Update(&tv)
+	} else {
+		if !timedVersion.After(&u.subscriptionPermissionVersion) {
+			current := u.subscriptionPermissionVersion.Load()
+			u.lock.Unlock()
+			u.params.Logger.Debugw("skipping stale subscription permission update",
+				"version", timedVersion.String(),
+				"currentVersion", current.String(),
+			)
+			return nil
+		}
+		u.subscriptionPermissionVersion.Update(&timedVersion)
+	}
+
+	tracks := u.getPublishedTracksLocked()
+	before := u.subscriberPermissions
+	u.subscriptionPermission = subscriptionPermission
+	u.subscriberPermissions = parseSubscriberPermissions(subscriptionPermission, resolverByIdentity)
+	after := u.subscriberPermissions
+	u.lock.Unlock()
+
+	u.params.Logger.Debugw("updated subscription permission", "permission", subscriptionPermission)
+
+	u.revokeDisallowedSubscriptions(tracks, resolverByIdentity, resolverBySid)
+
+	// 通知新获得权限的订阅者，订阅者会重新尝试订阅
+	identities := append(before.identities(resolverBySid), after.identities(resolverBySid)...)
+	notified := make(map[tc.ParticipantIdentity]bool, len(identities))
+	for _, identity := range identities {
+		if notified[identity] {
+			continue
+		}
+		notified[identity] = true
+
+		sub := resolverByIdentity(identity)
+		if sub == nil {
+			continue
+		}
+		for _, track := range tracks {
+			if !before.allows(track.ID(), identity) && after.allows(track.ID(), identity) {
+				sub.SubscriptionPermissionUpdate(u.params.SID, track.ID(), true)
+			}
+		}
+	}
+	return nil
+}
+
+// revokeDisallowedSubscriptions 关闭失去权限的订阅，并通知订阅者
+func (u *UpTrackManager) revokeDisallowedSubscriptions(
+	tracks []types.MediaTrack,
+	resolverByIdentity func(participantIdentity tc.ParticipantIdentity) types.LocalParticipant,
+	resolverBySid func(participantID tc.ParticipantID) types.LocalParticipant,
+) {
+	for _, track := range tracks {
+		var allowed []tc.ParticipantIdentity
+		for _, subID := range track.GetAllSubscribers() {
+			sub := resolverBySid(subID)
+			if sub == nil {
+				continue
+			}
+			if u.HasPermission(track.ID(), sub.Identity()) {
+				allowed = append(allowed, sub.Identity())
+			}
+		}
+
+		for _, identity := range track.RevokeDisallowedSubscribers(allowed) {
+			if sub := resolverByIdentity(identity); sub != nil {
+				sub.SubscriptionPermissionUpdate(u.params.SID, track.ID(), false)
+			}
+		}
+	}
+}
+
+func (u *UpTrackManager) notifyTrackUpdated(track types.MediaTrack) {
+	u.lock.RLock()
+	onTrackUpdated := u.onTrackUpdated
+	u.lock.RUnlock()
+
+	if onTrackUpdated != nil {
+		onTrackUpdated(track)
+	}
+}
+
+func (u *UpTrackManager) getPublishedTracksLocked() []types.MediaTrack {
+	tracks := make([]types.MediaTrack, 0, len(u.publishedTracks))
+	for _, t := range u.publishedTracks {
+		tracks = append(tracks, t)
+	}
+	return tracks
+}
+
+// subscriberPermissions 解析后的订阅权限，byIdentity 为 nil 表示允许全部参与者订阅。
+// 创建之后不再修改，可以在锁外读取
+type subscriberPermissions struct {
+	byIdentity map[tc.ParticipantIdentity]*tc.TrackPermission
+	// bySid 按参与者ID授权，检查时再解析为标识，授权之后才加入的参与者同样生效
+	bySid              map[tc.ParticipantID]*tc.TrackPermission
+	resolverByIdentity func(participantIdentity tc.ParticipantIdentity) types.LocalParticipant
+}
+
+func parseSubscriberPermissions(
+	subscriptionPermission *tc.SubscriptionPermission,
+	resolverByIdentity func(participantIdentity tc.ParticipantIdentity) types.LocalParticipant,
+) subscriberPermissions {
+	if subscriptionPermission == nil || subscriptionPermission.AllParticipants {
+		return subscriberPermissions{}
+	}
+
+	permissions := subscriberPermissions{
+		byIdentity:         make(map[tc.ParticipantIdentity]*tc.TrackPermission),
+		bySid:              make(map[tc.ParticipantID]*tc.TrackPermission),
+		resolverByIdentity: resolverByIdentity,
+	}
+	for _, trackPerms := range subscriptionPermission.TrackPermissions {
+		switch {
+		case trackPerms.ParticipantIdentity != "":
+			permissions.byIdentity[tc.ParticipantIdentity(trackPerms.ParticipantIdentity)] = trackPerms
+		case trackPerms.ParticipantSid != "":
+			permissions.bySid[tc.ParticipantID(trackPerms.ParticipantSid)] = trackPerms
+		}
+	}
+	return permissions
+}
+
+// allows 订阅者是否可以订阅该音轨，按标识的授权优先于按参与者ID的授权
+func (s subscriberPermissions) allows(trackID tc.TrackID, subIdentity tc.ParticipantIdentity) bool {
+	if s.byIdentity == nil {
+		return true
+	}
+	if perm, ok := s.byIdentity[subIdentity]; ok {
+		return trackPermissionAllows(perm, trackID)
+	}
+	if len(s.bySid) == 0 || s.resolverByIdentity == nil {
+		return false
+	}
+	sub := s.resolverByIdentity(subIdentity)
+	if sub == nil {
+		return false
+	}
+	perm, ok := s.bySid[sub.ID()]
+	return ok && trackPermissionAllows(perm, trackID)
+}
+
+// identities 权限中列出的订阅者标识，按参与者ID授权的订阅者只包括当前能够解析的
+func (s subscriberPermissions) identities(resolverBySid func(participantID tc.ParticipantID) types.LocalParticipant) []tc.ParticipantIdentity {
+	identities := make([]tc.ParticipantIdentity, 0, len(s.byIdentity)+len(s.bySid))
+	for identity := range s.byIdentity {
+		identities = append(identities, identity)
+	}
+	for sid := range s.bySid {
+		if sub := resolverBySid(sid); sub != nil {
+			identities = append(identities, sub.Identity())
+		}
+	}
+	return identities
+}
+
+func trackPermissionAllows(perm *tc.TrackPermission, trackID tc.TrackID) bool {
+	if perm == nil {
+		return false
+	}
+	if perm.AllTracks {
+		return true
+	}
+	for _, sid := range perm.TrackSids {
+		if sid == string(trackID) {
+			return true
+		}
+	}
+	return false
+}
+
+// permissionIdentities 权限中明确列出的订阅者标识，按参与者ID授权的订阅者通过 resolverBySid 解析
+func permissionIdentities(
+	subscriptionPermission *tc.SubscriptionPermission,
+	resolverBySid func(participantID tc.ParticipantID) types.LocalParticipant,
+) []tc.ParticipantIdentity {
+	if subscriptionPermission == nil {
+		return nil
+	}
+
+	identities := make([]tc.ParticipantIdentity, 0, len(subscriptionPermission.TrackPermissions))
+	for _, trackPerms := range subscriptionPermission.TrackPermissions {
+		switch {
+		case trackPerms.ParticipantIdentity != "":
+			identities = append(identities, tc.ParticipantIdentity(trackPerms.ParticipantIdentity))
+		case trackPerms.ParticipantSid != "":
+			if sub := resolverBySid(tc.ParticipantID(trackPerms.ParticipantSid)); sub != nil {
+				identities = append(identities, sub.Identity())
+			}
+		}
+	}
+	return identities
+}
@@ -0,0 +1,92 @@
+package rtc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+func newTestUpTrackManager() *UpTrackManager {
+	u := NewUpTrackManager(UpTrackManagerParams{
+		SID:              "PA_publisher",
+		Logger:           logger.GetLogger(),
+		VersionGenerator: utils.NewDefaultTimedVersionGenerator(),
+	})
+	u.AddPublishedTrack(newTestMediaTrack(&tc.TrackInfo{Sid: "TR_audio", Type: tc.TrackType_AUDIO}))
+	u.AddPublishedTrack(newTestMediaTrack(&tc.TrackInfo{Sid: "TR_video", Type: tc.TrackType_VIDEO}))
+	return u
+}
+
+func TestUpTrackManagerSubscriptionPermission(t *testing.T) {
+	participants := make(map[tc.ParticipantIdentity]types.LocalParticipant)
+	byIdentity := func(identity tc.ParticipantIdentity) types.LocalParticipant {
+		return participants[identity]
+	}
+	bySid := func(sid tc.ParticipantID) types.LocalParticipant {
+		for _, p := range participants {
+			if p.ID() == sid {
+				return p
+			}
+		}
+		return nil
+	}
+
+	t.Run("allows everyone by default", func(t *testing.T) {
+		u := newTestUpTrackManager()
+		require.True(t, u.HasPermission("TR_video", "anyone"))
+	})
+
+	t.Run("per identity and per track", func(t *testing.T) {
+		u := newTestUpTrackManager()
+		require.NoError(t, u.UpdateSubscriptionPermission(&tc.SubscriptionPermission{
+			TrackPermissions: []*tc.TrackPermission{
+				{ParticipantIdentity: "all", AllTracks: true},
+				{ParticipantIdentity: "audio", TrackSids: []string{"TR_audio"}},
+			},
+		}, utils.TimedVersion{}, byIdentity, bySid))
+
+		require.True(t, u.HasPermission("TR_video", "all"))
+		require.True(t, u.HasPermission("TR_audio", "audio"))
+		require.False(t, u.HasPermission("TR_video", "audio"))
+		require.False(t, u.HasPermission("TR_audio", "other"))
+	})
+
+	t.Run("sid grants resolve when checked", func(t *testing.T) {
+		u := newTestUpTrackManager()
+		require.NoError(t, u.UpdateSubscriptionPermission(&tc.SubscriptionPermission{
+			TrackPermissions: []*tc.TrackPermission{
+				{ParticipantSid: "PA_late", AllTracks: true},
+			},
+		}, utils.TimedVersion{}, byIdentity, bySid))
+		require.False(t, u.HasPermission("TR_video", "late"))
+
+		// 授权之后才加入的参与者
+		participants["late"] = &ParticipantImpl{
+			params: ParticipantParams{Identity: "late", SID: "PA_late"},
+		}
+		defer delete(participants, "late")
+		require.True(t, u.HasPermission("TR_video", "late"))
+	})
+
+	t.Run("stale versions are ignored", func(t *testing.T) {
+		u := newTestUpTrackManager()
+		gen := utils.NewDefaultTimedVersionGenerator()
+		stale := gen.New()
+		current := gen.New()
+
+		require.NoError(t, u.UpdateSubscriptionPermission(&tc.SubscriptionPermission{
+			TrackPermissions: []*tc.TrackPermission{{ParticipantIdentity: "current", AllTracks: true}},
+		}, *current, byIdentity, bySid))
+		require.NoError(t, u.UpdateSubscriptionPermission(&tc.SubscriptionPermission{
+			AllParticipants: true,
+		}, *stale, byIdentity, bySid))
+
+		require.True(t, u.HasPermission("TR_video", "current"))
+		require.False(t, u.HasPermission("TR_video", "other"))
+	})
+}
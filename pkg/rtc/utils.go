@@ -2,10 +2,35 @@ package rtc
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
 )
 
+const (
+	trackIdSeparator = "|"
+)
+
+// UnpackStreamID 从流ID中解析出参与者ID和音轨ID
+func UnpackStreamID(packed string) (participantID tc.ParticipantID, trackID tc.TrackID) {
+	parts := strings.Split(packed, trackIdSeparator)
+	if len(parts) > 1 {
+		return tc.ParticipantID(parts[0]), tc.TrackID(packed[len(parts[0])+1:])
+	}
+	return tc.ParticipantID(packed), ""
+}
+
+// PackStreamID 将参与者ID和音轨ID打包为流ID
+func PackStreamID(participantID tc.ParticipantID, trackID tc.TrackID) string {
+	return string(participantID) + trackIdSeparator + string(trackID)
+}
+
+// PackSyncStreamID 将参与者ID和流打包为流ID，同一个流中的音轨在客户端同步播放
+func PackSyncStreamID(participantID tc.ParticipantID, stream string) string {
+	return string(participantID) + trackIdSeparator + stream
+}
+
 func Recover(l logger.Logger) any {
 	if l == nil {
 		l = logger.GetLogger()
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/twitchtv/twirp"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+)
+
+const (
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+	accessTokenParam    = "access_token"
+)
+
+var (
+	ErrPermissionDenied     = twirp.NewError(twirp.PermissionDenied, "permissions denied")
+	ErrMissingAuthorization = twirp.NewError(twirp.Unauthenticated, "invalid authorization header. Must start with "+bearerPrefix)
+)
+
+type grantsKey struct{}
+
+type tokenClaimsKey struct{}
+
+// TokenVerifier 校验 access token，RotatingKeyProvider 在宽限期内会同时尝试被轮换掉的密钥
+type TokenVerifier interface {
+	VerifyToken(token string) (*auth.ClaimGrants, error)
+}
+
+// APIKeyAuthHandler 校验请求头或者 access_token 参数中的token，并将授权放入上下文。
+// 没有token的请求原样放行，由各个服务自行检查权限
+func APIKeyAuthHandler(verifier TokenVerifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get(authorizationHeader)
+		var authToken string
+		if authHeader != "" {
+			if !strings.HasPrefix(authHeader, bearerPrefix) {
+				handleError(w, ErrMissingAuthorization)
+				return
+			}
+			authToken = authHeader[len(bearerPrefix):]
+		} else {
+			// 无法设置请求头的客户端（如 websocket）使用参数传递
+			authToken = r.FormValue(accessTokenParam)
+		}
+
+		if authToken != "" {
+			grants, err := verifier.VerifyToken(authToken)
+			if err != nil {
+				handleError(w, twirp.NewError(twirp.Unauthenticated, "invalid token: "+err.Error()))
+				return
+			}
+			ctx := WithGrants(r.Context(), grants)
+			// 签名已经校验过，标准声明用于刷新 token 时确定 API key 和过期时间
+			if claims, err := parseTokenClaims(authToken); err == nil {
+				ctx = context.WithValue(ctx, tokenClaimsKey{}, claims)
+			}
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseTokenClaims 不校验签名读取token的标准声明，用于确定签名使用的 API key
+func parseTokenClaims(token string) (*jwt.Claims, error) {
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+	claims := &jwt.Claims{}
+	if err = tok.UnsafeClaimsWithoutVerification(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// WithGrants 将授权放入上下文
+func WithGrants(ctx context.Context, grants *auth.ClaimGrants) context.Context {
+	return context.WithValue(ctx, grantsKey{}, grants)
+}
+
+// GetGrants 获取上下文中的授权，未授权时返回 nil
+func GetGrants(ctx context.Context) *auth.ClaimGrants {
+	grants, _ := ctx.Value(grantsKey{}).(*auth.ClaimGrants)
+	return grants
+}
+
+// getTokenClaims 获取上下文中 token 的标准声明，未授权时返回 nil
+func getTokenClaims(ctx context.Context) *jwt.Claims {
+	claims, _ := ctx.Value(tokenClaimsKey{}).(*jwt.Claims)
+	return claims
+}
+
+// EnsureAdminPermission 检查是否拥有房间的管理权限
+func EnsureAdminPermission(ctx context.Context, room string) error {
+	grants := GetGrants(ctx)
+	if grants == nil || grants.Video == nil {
+		return ErrPermissionDenied
+	}
+	if !grants.Video.RoomAdmin || grants.Video.Room != room {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// EnsureListPermission 检查是否拥有列举房间的权限
+func EnsureListPermission(ctx context.Context) error {
+	grants := GetGrants(ctx)
+	if grants == nil || grants.Video == nil || !grants.Video.RoomList {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// handleError 以 twirp 的格式返回错误，状态码由错误码决定
+func handleError(w http.ResponseWriter, err twirp.Error) {
+	logger.Debugw("request rejected", "error", err.Msg())
+	if writeErr := twirp.WriteError(w, err); writeErr != nil {
+		logger.Warnw("could not write error response", writeErr)
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+)
+
+func TestAPIKeyAuthHandler(t *testing.T) {
+	p, keyFile := newTestKeyProvider(t, nil, map[string]string{"key1": testSecret1}, time.Hour)
+
+	var grants *auth.ClaimGrants
+	var claims *jwt.Claims
+	handler := APIKeyAuthHandler(p, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grants = GetGrants(r.Context())
+		claims = getTokenClaims(r.Context())
+	}))
+	serve := func(header string) int {
+		grants = nil
+		claims = nil
+		req := httptest.NewRequest(http.MethodPost, "/twirp/tc.RoomService/ListRooms", nil)
+		if header != "" {
+			req.Header.Set(authorizationHeader, header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	newToken := func(secret string) string {
+		token, err := auth.NewAccessToken("key1", secret).
+			AddGrant(&auth.VideoGrant{RoomList: true}).
+			SetValidFor(time.Hour).
+			ToJWT()
+		require.NoError(t, err)
+		return token
+	}
+
+	// 没有token时放行，由服务检查权限
+	require.Equal(t, http.StatusOK, serve(""))
+	require.Nil(t, grants)
+	require.Nil(t, claims)
+
+	require.Equal(t, http.StatusOK, serve(bearerPrefix+newToken(testSecret1)))
+	require.NotNil(t, grants)
+	require.True(t, grants.Video.RoomList)
+	require.Equal(t, "key1", claims.Issuer)
+	require.WithinDuration(t, time.Now().Add(time.Hour), claims.Expiry.Time(), time.Minute)
+
+	require.Equal(t, http.StatusUnauthorized, serve(newToken(testSecret1)))
+	require.Equal(t, http.StatusUnauthorized, serve(bearerPrefix+"invalid"))
+	require.Equal(t, http.StatusUnauthorized, serve(bearerPrefix+newToken(testSecret2)))
+
+	// 轮换之后旧密钥签发的token在宽限期内仍然有效
+	writeKeyFile(t, keyFile, map[string]string{"key1": testSecret2})
+	p.checkKeyFile()
+	require.Equal(t, http.StatusOK, serve(bearerPrefix+newToken(testSecret1)))
+	require.Equal(t, http.StatusOK, serve(bearerPrefix+newToken(testSecret2)))
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+
+	redisPingTimeout = time.Second
+)
+
+// HealthStatus 健康检查的响应
+type HealthStatus struct {
+	Status  string   `json:"status"`
+	NodeID  string   `json:"node_id,omitempty"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// HealthService 提供给 Kubernetes 使用的存活和就绪检查
+type HealthService struct {
+	conf        *config.Config
+	router      routing.Router
+	currentNode *routing.LocalNode
+	rc          redis.UniversalClient
+}
+
+// NewHealthService 创建健康检查服务，单节点模式下 rc 为 nil
+func NewHealthService(conf *config.Config, router routing.Router, currentNode *routing.LocalNode, rc redis.UniversalClient) *HealthService {
+	return &HealthService{
+		conf:        conf,
+		router:      router,
+		currentNode: currentNode,
+		rc:          rc,
+	}
+}
+
+// RegisterHandlers 注册 /healthz 和 /readyz
+func (s *HealthService) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc(healthzPath, s.ServeLiveness)
+	mux.HandleFunc(readyzPath, s.ServeReadiness)
+}
+
+// ServeLiveness 存活检查，进程能够处理请求即认为存活
+func (s *HealthService) ServeLiveness(w http.ResponseWriter, _ *http.Request) {
+	s.writeStatus(w, http.StatusOK, &HealthStatus{
+		Status: "ok",
+		NodeID: string(s.currentNode.NodeID()),
+	})
+}
+
+// ServeReadiness 就绪检查，路由未启动、Redis不可达、节点正在下线或者CPU负载超过限制时返回 503
+func (s *HealthService) ServeReadiness(w http.ResponseWriter, r *http.Request) {
+	reasons := s.notReadyReasons(r.Context())
+	if len(reasons) != 0 {
+		s.writeStatus(w, http.StatusServiceUnavailable, &HealthStatus{
+			Status:  "not ready",
+			NodeID:  string(s.currentNode.NodeID()),
+			Reasons: reasons,
+		})
+		return
+	}
+
+	s.writeStatus(w, http.StatusOK, &HealthStatus{
+		Status: "ok",
+		NodeID: string(s.currentNode.NodeID()),
+	})
+}
+
+func (s *HealthService) notReadyReasons(ctx context.Context) []string {
+	var reasons []string
+	if !s.router.IsStarted() {
+		reasons = append(reasons, "router not started")
+	}
+
+	if s.rc != nil {
+		pingCtx, cancel := context.WithTimeout(ctx, redisPingTimeout)
+		err := s.rc.Ping(pingCtx).Err()
+		cancel()
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("redis unreachable: %v", err))
+		}
+	}
+
+	if s.currentNode.State() == tc.NodeState_SHUTTING_DOWN {
+		reasons = append(reasons, "node is draining")
+	}
+
+	cpuLoadLimit := s.conf.NodeSelector.CPULoadLimit
+	if cpuLoad := s.currentNode.Stats().GetCpuLoad(); cpuLoadLimit > 0 && cpuLoad > cpuLoadLimit {
+		reasons = append(reasons, fmt.Sprintf("cpu load %.2f above limit %.2f", cpuLoad, cpuLoadLimit))
+	}
+	return reasons
+}
+
+func (s *HealthService) writeStatus(w http.ResponseWriter, code int, status *HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logger.Warnw("could not write health status", err)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+)
+
+func newTestHealthService(t *testing.T) (*HealthService, *routing.LocalRouter, *routing.LocalNode) {
+	currentNode := routing.NewLocalNodeFromProto(&tc.Node{
+		Id:    "ND_test",
+		State: tc.NodeState_SERVING,
+	})
+	router := routing.NewLocalRouter(currentNode)
+	t.Cleanup(router.Stop)
+
+	conf := &config.Config{
+		NodeSelector: config.NodeSelectorConfig{CPULoadLimit: 0.8},
+	}
+	return NewHealthService(conf, router, currentNode, nil), router, currentNode
+}
+
+func serveHealth(t *testing.T, s *HealthService, path string) (int, *HealthStatus) {
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	status := &HealthStatus{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), status))
+	return w.Code, status
+}
+
+func TestHealthLiveness(t *testing.T) {
+	s, _, _ := newTestHealthService(t)
+
+	// 路由未启动也认为存活
+	code, status := serveHealth(t, s, healthzPath)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ND_test", status.NodeID)
+}
+
+func TestHealthReadiness(t *testing.T) {
+	s, router, currentNode := newTestHealthService(t)
+
+	code, status := serveHealth(t, s, readyzPath)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, []string{"router not started"}, status.Reasons)
+
+	require.NoError(t, router.Start())
+	code, status = serveHealth(t, s, readyzPath)
+	require.Equal(t, http.StatusOK, code)
+	require.Empty(t, status.Reasons)
+
+	// 统计更新之后按照新的CPU负载判断
+	currentNode.SetStats(&tc.NodeStats{CpuLoad: 0.95})
+	code, status = serveHealth(t, s, readyzPath)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Len(t, status.Reasons, 1)
+	require.Contains(t, status.Reasons[0], "cpu load")
+
+	currentNode.SetStats(&tc.NodeStats{CpuLoad: 0.5})
+	router.Drain()
+	code, status = serveHealth(t, s, readyzPath)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, []string{"node is draining"}, status.Reasons)
+}
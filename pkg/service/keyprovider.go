@@ -0,0 +1,266 @@
+package service
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-server/pkg/config"
+)
+
+// retiredSecret 被轮换掉的密钥，在过期之前仍然可以用于校验token
+type retiredSecret struct {
+	secret    string
+	expiresAt time.Time
+}
+
+// RotatingKeyProvider 实现 auth.KeyProvider，监听密钥文件并在文件变化时原子地替换密钥。
+// 被移除或者被替换的密钥在宽限期内仍然可以校验已签发的token
+type RotatingKeyProvider struct {
+	keyFile       string
+	watchInterval time.Duration
+	gracePeriod   time.Duration
+	development   bool
+
+	// inlineKeys 配置中直接写入的密钥，每次重新加载时与密钥文件合并
+	inlineKeys map[string]string
+
+	lock    sync.RWMutex
+	keys    map[string]string
+	retired map[string][]retiredSecret
+
+	modTime time.Time
+	size    int64
+
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewRotatingKeyProvider 根据配置创建密钥提供者，conf.ValidateKeys 需要已经执行过
+func NewRotatingKeyProvider(conf *config.Config) *RotatingKeyProvider {
+	keys := make(map[string]string, len(conf.Keys))
+	for key, secret := range conf.Keys {
+		keys[key] = secret
+	}
+
+	p := &RotatingKeyProvider{
+		keyFile:       conf.KeyFile,
+		watchInterval: conf.KeyRotation.WatchInterval,
+		gracePeriod:   conf.KeyRotation.GracePeriod,
+		development:   conf.Development,
+		inlineKeys:    conf.InlineKeys(),
+		keys:          keys,
+		retired:       make(map[string][]retiredSecret),
+		done:          make(chan struct{}),
+	}
+	if st, err := os.Stat(p.keyFile); err == nil {
+		p.modTime = st.ModTime()
+		p.size = st.Size()
+	}
+	return p
+}
+
+// Start 开始监听密钥文件，未配置密钥文件或者监听周期时不做任何事情
+func (p *RotatingKeyProvider) Start() {
+	if p.keyFile == "" || p.watchInterval <= 0 {
+		return
+	}
+
+	go p.watchWorker()
+}
+
+// Stop 停止监听
+func (p *RotatingKeyProvider) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+// GetSecret 返回密钥，当前密钥优先，其次为宽限期内最近被轮换掉的密钥
+func (p *RotatingKeyProvider) GetSecret(key string) string {
+	secrets := p.GetSecrets(key)
+	if len(secrets) == 0 {
+		return ""
+	}
+	return secrets[0]
+}
+
+// GetSecrets 返回可以用于校验key签发的token的全部密钥，当前密钥在前，
+// 被轮换掉的密钥按从新到旧的顺序排列
+func (p *RotatingKeyProvider) GetSecrets(key string) []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	var secrets []string
+	if secret, ok := p.keys[key]; ok {
+		secrets = append(secrets, secret)
+	}
+
+	now := time.Now()
+	retired := p.retired[key]
+	for i := len(retired) - 1; i >= 0; i-- {
+		if now.Before(retired[i].expiresAt) {
+			secrets = append(secrets, retired[i].secret)
+		}
+	}
+	return secrets
+}
+
+// NumKeys 当前有效的密钥数量，不包括宽限期内的密钥
+func (p *RotatingKeyProvider) NumKeys() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return len(p.keys)
+}
+
+// VerifyToken 依次使用当前密钥和宽限期内的密钥校验token
+func (p *RotatingKeyProvider) VerifyToken(token string) (*auth.ClaimGrants, error) {
+	v, err := auth.ParseAPIToken(token)
+	if err != nil {
+		return nil, err
+	}
+	// auth.ParseAPIToken 不会解析出签发者和身份，需要单独读取
+	claims, err := parseTokenClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	secrets := p.GetSecrets(claims.Issuer)
+	if len(secrets) == 0 {
+		return nil, auth.ErrKeysMissing
+	}
+
+	for _, secret := range secrets {
+		var grants *auth.ClaimGrants
+		if grants, err = v.Verify(secret); err == nil {
+			grants.Identity = claims.Subject
+			if grants.Identity == "" {
+				grants.Identity = claims.ID
+			}
+			return grants, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *RotatingKeyProvider) watchWorker() {
+	ticker := time.NewTicker(p.watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.checkKeyFile()
+			p.pruneRetired()
+		}
+	}
+}
+
+// checkKeyFile 文件变化时重新校验权限并加载，失败时保留原有密钥
+func (p *RotatingKeyProvider) checkKeyFile() {
+	st, err := os.Stat(p.keyFile)
+	if err != nil {
+		logger.Warnw("could not stat key file", err, "keyFile", p.keyFile)
+		return
+	}
+	if st.ModTime().Equal(p.modTime) && st.Size() == p.size {
+		return
+	}
+
+	fileKeys, err := config.ReadKeyFile(p.keyFile)
+	if err != nil {
+		logger.Errorw("could not reload key file, keeping existing keys", err, "keyFile", p.keyFile)
+		return
+	}
+	if len(fileKeys) == 0 {
+		logger.Errorw("reloaded key file has no keys, keeping existing keys", config.ErrKeysNotSet, "keyFile", p.keyFile)
+		return
+	}
+	p.modTime = st.ModTime()
+	p.size = st.Size()
+
+	// 与 ValidateKeys 一致，密钥文件中的密钥覆盖配置中的同名密钥
+	keys := make(map[string]string, len(p.inlineKeys)+len(fileKeys))
+	for key, secret := range p.inlineKeys {
+		keys[key] = secret
+	}
+	for key, secret := range fileKeys {
+		keys[key] = secret
+	}
+	p.rotate(keys)
+}
+
+// rotate 原子地替换密钥，被移除或替换的旧密钥进入宽限期。只记录 API key，不记录密钥内容
+func (p *RotatingKeyProvider) rotate(keys map[string]string) {
+	var added, rotated, removed []string
+	expiresAt := time.Now().Add(p.gracePeriod)
+
+	p.lock.Lock()
+	for key, secret := range p.keys {
+		newSecret, ok := keys[key]
+		switch {
+		case !ok:
+			removed = append(removed, key)
+		case newSecret != secret:
+			rotated = append(rotated, key)
+		default:
+			continue
+		}
+		if p.gracePeriod > 0 {
+			p.retired[key] = append(p.retired[key], retiredSecret{secret: secret, expiresAt: expiresAt})
+		}
+	}
+	for key := range keys {
+		if _, ok := p.keys[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	p.keys = keys
+	p.lock.Unlock()
+
+	if len(added) == 0 && len(rotated) == 0 && len(removed) == 0 {
+		return
+	}
+	if !p.development {
+		for key, secret := range keys {
+			if len(secret) < 32 {
+				logger.Errorw("secret is too short, should be at least 32 characters for security", nil, "apiKey", key)
+			}
+		}
+	}
+	logger.Infow("api keys reloaded",
+		"keyFile", p.keyFile,
+		"added", added,
+		"rotated", rotated,
+		"removed", removed,
+		"gracePeriod", p.gracePeriod,
+		"numKeys", len(keys),
+	)
+}
+
+func (p *RotatingKeyProvider) pruneRetired() {
+	now := time.Now()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for key, retired := range p.retired {
+		valid := retired[:0]
+		for _, r := range retired {
+			if now.Before(r.expiresAt) {
+				valid = append(valid, r)
+			} else {
+				logger.Infow("retired api key expired", "apiKey", key)
+			}
+		}
+		if len(valid) == 0 {
+			delete(p.retired, key)
+		} else {
+			p.retired[key] = valid
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-server/pkg/config"
+)
+
+const (
+	testSecret1 = "secret1-abcdefghijklmnopqrstuvwxyz"
+	testSecret2 = "secret2-abcdefghijklmnopqrstuvwxyz"
+	testSecret3 = "secret3-abcdefghijklmnopqrstuvwxyz"
+)
+
+func writeKeyFile(t *testing.T, path string, keys map[string]string) {
+	var content string
+	for key, secret := range keys {
+		content += fmt.Sprintf("%s: %s\n", key, secret)
+	}
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	// 保证修改时间变化，避免被当作未修改
+	modTime := time.Now().Add(time.Duration(len(content)) * time.Second)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func newTestKeyProvider(t *testing.T, inline map[string]string, fileKeys map[string]string, gracePeriod time.Duration) (*RotatingKeyProvider, string) {
+	keyFile := filepath.Join(t.TempDir(), "keys.yaml")
+	writeKeyFile(t, keyFile, fileKeys)
+
+	conf := &config.Config{
+		KeyFile:     keyFile,
+		Keys:        inline,
+		Development: true,
+		KeyRotation: config.KeyRotationConfig{
+			GracePeriod: gracePeriod,
+		},
+	}
+	require.NoError(t, conf.ValidateKeys())
+	return NewRotatingKeyProvider(conf), keyFile
+}
+
+func TestRotatingKeyProviderAddKey(t *testing.T) {
+	p, keyFile := newTestKeyProvider(t, nil, map[string]string{"key1": testSecret1}, time.Hour)
+	require.Equal(t, testSecret1, p.GetSecret("key1"))
+	require.Empty(t, p.GetSecret("key2"))
+
+	writeKeyFile(t, keyFile, map[string]string{"key1": testSecret1, "key2": testSecret2})
+	p.checkKeyFile()
+	require.Equal(t, testSecret1, p.GetSecret("key1"))
+	require.Equal(t, testSecret2, p.GetSecret("key2"))
+	require.Equal(t, 2, p.NumKeys())
+	// 没有变化的密钥不进入宽限期
+	require.Equal(t, []string{testSecret1}, p.GetSecrets("key1"))
+}
+
+func TestRotatingKeyProviderRemoveKey(t *testing.T) {
+	t.Run("within grace period", func(t *testing.T) {
+		p, keyFile := newTestKeyProvider(t, nil, map[string]string{"key1": testSecret1, "key2": testSecret2}, time.Hour)
+
+		writeKeyFile(t, keyFile, map[string]string{"key1": testSecret1})
+		p.checkKeyFile()
+		require.Equal(t, 1, p.NumKeys())
+		require.Equal(t, []string{testSecret2}, p.GetSecrets("key2"))
+
+		token, err := auth.NewAccessToken("key2", testSecret2).SetIdentity("p1").ToJWT()
+		require.NoError(t, err)
+		grants, err := p.VerifyToken(token)
+		require.NoError(t, err)
+		require.Equal(t, "p1", grants.Identity)
+	})
+
+	t.Run("without grace period", func(t *testing.T) {
+		p, keyFile := newTestKeyProvider(t, nil, map[string]string{"key1": testSecret1, "key2": testSecret2}, 0)
+
+		writeKeyFile(t, keyFile, map[string]string{"key1": testSecret1})
+		p.checkKeyFile()
+		require.Empty(t, p.GetSecrets("key2"))
+
+		token, err := auth.NewAccessToken("key2", testSecret2).SetIdentity("p1").ToJWT()
+		require.NoError(t, err)
+		_, err = p.VerifyToken(token)
+		require.ErrorIs(t, err, auth.ErrKeysMissing)
+	})
+}
+
+func TestRotatingKeyProviderGracePeriod(t *testing.T) {
+	p, keyFile := newTestKeyProvider(t, nil, map[string]string{"key1": testSecret1}, 50*time.Millisecond)
+
+	writeKeyFile(t, keyFile, map[string]string{"key1": testSecret2})
+	p.checkKeyFile()
+	// 当前密钥在前，被轮换掉的密钥在后
+	require.Equal(t, []string{testSecret2, testSecret1}, p.GetSecrets("key1"))
+	require.Equal(t, testSecret2, p.GetSecret("key1"))
+
+	oldToken, err := auth.NewAccessToken("key1", testSecret1).SetIdentity("p1").ToJWT()
+	require.NoError(t, err)
+	_, err = p.VerifyToken(oldToken)
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, []string{testSecret2}, p.GetSecrets("key1"))
+	_, err = p.VerifyToken(oldToken)
+	require.Error(t, err)
+
+	p.pruneRetired()
+	p.lock.RLock()
+	require.Empty(t, p.retired)
+	p.lock.RUnlock()
+}
+
+func TestRotatingKeyProviderKeepsInlineKeys(t *testing.T) {
+	p, keyFile := newTestKeyProvider(t,
+		map[string]string{"inline": testSecret3, "shared": testSecret3},
+		map[string]string{"key1": testSecret1, "shared": testSecret1},
+		time.Hour,
+	)
+	// 密钥文件覆盖配置中的同名密钥
+	require.Equal(t, testSecret1, p.GetSecret("shared"))
+	require.Equal(t, testSecret3, p.GetSecret("inline"))
+
+	writeKeyFile(t, keyFile, map[string]string{"key2": testSecret2})
+	p.checkKeyFile()
+	require.Equal(t, testSecret3, p.GetSecret("inline"))
+	require.Equal(t, testSecret2, p.GetSecret("key2"))
+	// 密钥文件中不再有同名密钥后恢复为配置中的密钥
+	require.Equal(t, []string{testSecret3, testSecret1}, p.GetSecrets("shared"))
+	require.Equal(t, 3, p.NumKeys())
+}
+
+func TestRotatingKeyProviderKeepsKeysOnInvalidFile(t *testing.T) {
+	p, keyFile := newTestKeyProvider(t, nil, map[string]string{"key1": testSecret1}, time.Hour)
+
+	require.NoError(t, os.WriteFile(keyFile, []byte("key1: ["), 0600))
+	modTime := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	p.checkKeyFile()
+	require.Equal(t, testSecret1, p.GetSecret("key1"))
+}
@@ -0,0 +1,415 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+const (
+	// sessionCheckInterval 信令循环检查参与者是否已经关闭的间隔
+	sessionCheckInterval = time.Second
+)
+
+var (
+	ErrRoomNotFound     = errors.New("room not found on this node")
+	ErrRTCNotConfigured = errors.New("node is not configured to host participants")
+)
+
+// RoomManager 管理当前节点上运行的房间，处理路由转发到当前节点的新参与者、消息和请求
+type RoomManager struct {
+	lock        sync.RWMutex
+	config      *config.Config
+	router      routing.Router
+	currentNode *routing.LocalNode
+	rtcConfig   *rtc.WebRTCConfig
+	keyProvider auth.KeyProvider
+	rooms       map[tc.RoomName]*rtc.Room
+}
+
+// NewRoomManager 创建房间管理，rtcConfig 为 nil 时当前节点不接受参与者加入，keyProvider 用于刷新参与者的 token
+func NewRoomManager(conf *config.Config, router routing.Router, currentNode *routing.LocalNode, rtcConfig *rtc.WebRTCConfig, keyProvider auth.KeyProvider) *RoomManager {
+	return &RoomManager{
+		config:      conf,
+		router:      router,
+		currentNode: currentNode,
+		rtcConfig:   rtcConfig,
+		keyProvider: keyProvider,
+		rooms:       make(map[tc.RoomName]*rtc.Room),
+	}
+}
+
+// GetRoom 获取当前节点上运行的房间，房间不在当前节点时返回 nil
+func (m *RoomManager) GetRoom(roomName tc.RoomName) *rtc.Room {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.rooms[roomName]
+}
+
+// Start 开始处理路由转发到当前节点的新参与者、消息和其他节点发来的请求
+func (m *RoomManager) Start() {
+	m.router.OnNewParticipantRTC(m.StartSession)
+	m.router.OnRTCMessage(m.handleRTCMessage)
+	m.router.OnNodeRequest(rtc.MigrationExportMethod, m.handleMigrationExport)
+	m.router.OnNodeRequest(rtc.MigrationCompleteMethod, m.handleMigrationComplete)
+	m.router.OnNodeRequest(updateRoomMetadataMethod, m.handleUpdateRoomMetadata)
+	m.router.OnNodeRequest(updateParticipantMethod, m.handleUpdateParticipant)
+	m.router.OnNodeRequest(updateParticipantAttributesMethod, m.handleUpdateParticipantAttributes)
+	m.router.OnNodeRequest(performRpcMethod, m.handlePerformRpc)
+}
+
+// Stop 停止处理新参与者、消息和请求并关闭当前节点上的房间
+func (m *RoomManager) Stop() {
+	m.router.OnNewParticipantRTC(nil)
+	m.router.OnRTCMessage(nil)
+	m.router.OnNodeRequest(rtc.MigrationExportMethod, nil)
+	m.router.OnNodeRequest(rtc.MigrationCompleteMethod, nil)
+	m.router.OnNodeRequest(updateRoomMetadataMethod, nil)
+	m.router.OnNodeRequest(updateParticipantMethod, nil)
+	m.router.OnNodeRequest(updateParticipantAttributesMethod, nil)
+	m.router.OnNodeRequest(performRpcMethod, nil)
+
+	m.lock.Lock()
+	rooms := make([]*rtc.Room, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	m.rooms = make(map[tc.RoomName]*rtc.Room)
+	m.lock.Unlock()
+
+	for _, room := range rooms {
+		room.Close()
+	}
+}
+
+// addRoom 记录当前节点上运行的房间，房间关闭后自动移除
+func (m *RoomManager) addRoom(room *rtc.Room) {
+	m.lock.Lock()
+	m.rooms[room.Name()] = room
+	m.lock.Unlock()
+
+	m.removeOnClose(room)
+}
+
+func (m *RoomManager) removeOnClose(room *rtc.Room) {
+	room.OnClose(func() {
+		m.lock.Lock()
+		if m.rooms[room.Name()] == room {
+			delete(m.rooms, room.Name())
+		}
+		m.lock.Unlock()
+	})
+}
+
+// getOrCreateRoom 获取当前节点上的房间，不存在时创建并把房间分配到当前节点
+func (m *RoomManager) getOrCreateRoom(ctx context.Context, roomName tc.RoomName) (*rtc.Room, error) {
+	m.lock.Lock()
+	if room := m.rooms[roomName]; room != nil && !room.IsClosed() {
+		m.lock.Unlock()
+		return room, nil
+	}
+
+	enabledCodecs := make([]*tc.Codec, 0, len(m.config.Room.EnabledCodecs))
+	for _, codec := range m.config.Room.EnabledCodecs {
+		enabledCodecs = append(enabledCodecs, &tc.Codec{Mime: codec.Mime, FmtpLine: codec.FmtpLine})
+	}
+	room := rtc.NewRoom(&tc.Room{
+		Sid:             utils.NewGuid(utils.RoomPrefix),
+		Name:            string(roomName),
+		EmptyTimeout:    m.config.Room.EmptyTimeout,
+		MaxParticipants: m.config.Room.MaxParticipants,
+		EnabledCodecs:   enabledCodecs,
+	}, &tc.ServerInfo{
+		Edition:  tc.ServerInfo_Standard,
+		Protocol: types.CurrentProtocol,
+		Region:   m.currentNode.Region(),
+		NodeId:   string(m.currentNode.NodeID()),
+	}, &m.config.Audio, m.router, logger.GetLogger())
+	m.rooms[roomName] = room
+	m.lock.Unlock()
+
+	m.removeOnClose(room)
+	if err := m.router.SetNodeForRoom(ctx, roomName, m.currentNode.NodeID()); err != nil {
+		room.Close()
+		return nil, err
+	}
+	room.Logger().Infow("created room")
+	return room, nil
+}
+
+// StartSession 参与者的信令到达房间所在的节点，创建参与者加入房间并开始处理信令请求。
+// 节点已经达到限制时拒绝加入，错误返回给信令连接
+func (m *RoomManager) StartSession(
+	ctx context.Context,
+	roomName tc.RoomName,
+	pi routing.ParticipantInit,
+	requestSource routing.MessageSource,
+	responseSink routing.MessageSink,
+) error {
+	if m.rtcConfig == nil {
+		return ErrRTCNotConfigured
+	}
+	if err := rtc.CheckLimits(m.config.Limit); err != nil {
+		logger.Infow("rejecting participant, node limit reached", "room", roomName, "participant", pi.Identity, "reason", err)
+		return err
+	}
+
+	room, err := m.getOrCreateRoom(ctx, roomName)
+	if err != nil {
+		return err
+	}
+
+	participant, err := rtc.NewParticipant(m.participantParams(room, pi, responseSink))
+	if err != nil {
+		return err
+	}
+
+	if err = room.Join(participant, &rtc.ParticipantOptions{AutoSubscribe: pi.AutoSubscribe}, nil); err != nil {
+		participant.GetLogger().Warnw("could not join room", err)
+		_ = participant.Close(false, types.ParticipantCloseReasonJoinFailed, false)
+		return err
+	}
+
+	go m.rtcSessionWorker(room, participant, requestSource)
+	return nil
+}
+
+// participantParams 生成新加入参与者的参数，订阅数量限制和订阅失败时是否重连取自节点配置
+func (m *RoomManager) participantParams(room *rtc.Room, pi routing.ParticipantInit, responseSink routing.MessageSink) rtc.ParticipantParams {
+	pID := pi.ID
+	if pID == "" {
+		pID = tc.ParticipantID(utils.NewGuid(utils.ParticipantPrefix))
+	}
+	protocolVersion := types.ProtocolVersion(types.CurrentProtocol)
+	if pi.Client != nil {
+		protocolVersion = types.ProtocolVersion(pi.Client.Protocol)
+	}
+	var subscriberAllowPause bool
+	if pi.SubscriberAllowPause != nil {
+		subscriberAllowPause = *pi.SubscriberAllowPause
+	}
+	var reconnectOnSubscriptionError bool
+	if m.config.RTC.ReconnectOnSubscriptionError != nil {
+		reconnectOnSubscriptionError = *m.config.RTC.ReconnectOnSubscriptionError
+	}
+	pLogger := room.Logger().WithValues("participant", pi.Identity, "pID", pID)
+
+	return rtc.ParticipantParams{
+		Identity:        pi.Identity,
+		Name:            pi.Name,
+		SID:             pID,
+		Config:          m.rtcConfig,
+		Sink:            responseSink,
+		AudioConfig:     m.config.Audio,
+		VideoConfig:     m.config.Video,
+		ProtocolVersion: protocolVersion,
+		EnabledCodecs:   room.ToProto().EnabledCodecs,
+		Logger:          pLogger,
+		Grants:          pi.Grants,
+		APIKey:          pi.APIKey,
+		KeyProvider:     m.keyProvider,
+		TokenExpiry:     pi.TokenExpiry,
+		ClientInfo:      pi.Client,
+		Region:          pi.Region,
+		AdaptiveStream:  pi.AdaptiveStream,
+		PlayoutDelay: &tc.PlayoutDelay{
+			Enabled: m.config.Room.PlayoutDelay.Enabled,
+			Min:     uint32(m.config.Room.PlayoutDelay.Min),
+			Max:     uint32(m.config.Room.PlayoutDelay.Max),
+		},
+		SyncStreams:                  m.config.Room.SyncStreams,
+		SubscriberAllowPause:         subscriberAllowPause,
+		ReconnectOnSubscriptionError: reconnectOnSubscriptionError,
+		SubscriptionLimitVideo:       m.config.Limit.SubscriptionLimitVideo,
+		SubscriptionLimitAudio:       m.config.Limit.SubscriptionLimitAudio,
+		Limit:                        m.config.Limit,
+		TrackResolver:                room.ResolveMediaTrack,
+		GetParticipantInfo: func(pID tc.ParticipantID) *tc.ParticipantInfo {
+			if p := room.GetParticipantByID(pID); p != nil {
+				return p.ToProto()
+			}
+			return nil
+		},
+	}
+}
+
+// rtcSessionWorker 处理参与者的信令请求，直到信令连接或者参与者关闭
+func (m *RoomManager) rtcSessionWorker(room *rtc.Room, participant types.LocalParticipant, requestSource routing.MessageSource) {
+	pLogger := participant.GetLogger()
+	defer func() {
+		pLogger.Debugw("rtc session worker stopped")
+		requestSource.Close()
+		participant.HandleSignalSourceClose()
+	}()
+
+	ticker := time.NewTicker(sessionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if participant.IsClosed() {
+				return
+			}
+		case msg, ok := <-requestSource.ReadChan():
+			if !ok {
+				return
+			}
+			req, ok := msg.(*tc.SignalRequest)
+			if !ok {
+				pLogger.Warnw("unexpected signal message", nil, "type", msg.ProtoReflect().Descriptor().FullName())
+				continue
+			}
+			rtc.HandleParticipantSignal(room, participant, req, pLogger)
+		}
+	}
+}
+
+// handleRTCMessage 处理其他节点或者服务接口发往当前节点上房间的消息
+func (m *RoomManager) handleRTCMessage(_ context.Context, roomName tc.RoomName, identity tc.ParticipantIdentity, msg *tc.RTCNodeMessage) {
+	room := m.GetRoom(roomName)
+	if room == nil {
+		logger.Warnw("could not handle rtc message", ErrRoomNotFound, "room", roomName, "participant", identity)
+		return
+	}
+
+	switch rm := msg.Message.(type) {
+	case *tc.RTCNodeMessage_SendData:
+		if err := rtc.ValidateDataPacketSize(rm.SendData.Kind, len(rm.SendData.Data)); err != nil {
+			logger.Warnw("dropping data packet", err, "room", roomName, "kind", rm.SendData.Kind)
+			return
+		}
+		room.SendDataPacket(&tc.UserPacket{
+			Payload:               rm.SendData.Data,
+			DestinationSids:       rm.SendData.DestinationSids,
+			DestinationIdentities: rm.SendData.DestinationIdentities,
+			Topic:                 rm.SendData.Topic,
+		}, rm.SendData.Kind)
+	case *tc.RTCNodeMessage_UpdateSubscriptions:
+		// 发布者所在节点推送的订阅变化，例如收回订阅权限
+		participant := room.GetParticipant(identity)
+		if participant == nil {
+			logger.Debugw("participant not found for subscription update", "room", roomName, "participant", identity)
+			return
+		}
+		trackIDs := make([]tc.TrackID, 0, len(rm.UpdateSubscriptions.TrackSids))
+		for _, trackID := range rm.UpdateSubscriptions.TrackSids {
+			trackIDs = append(trackIDs, tc.TrackID(trackID))
+		}
+		room.UpdateSubscriptions(participant, trackIDs, rm.UpdateSubscriptions.ParticipantTracks, rm.UpdateSubscriptions.Subscribe)
+	default:
+		logger.Debugw("unsupported rtc message", "room", roomName, "participant", identity, "message", msg.Message)
+	}
+}
+
+// handleMigrationExport 迁移的目标节点取回参与者的会话状态
+func (m *RoomManager) handleMigrationExport(_ context.Context, roomName tc.RoomName, payload []byte) ([]byte, error) {
+	room := m.GetRoom(roomName)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	logger.Infow("exporting participant for migration", "room", roomName, "participant", string(payload))
+	return room.ExportMigration(tc.ParticipantIdentity(payload))
+}
+
+// handleMigrationComplete 迁移的目标会话连接建立后关闭源会话
+func (m *RoomManager) handleMigrationComplete(_ context.Context, roomName tc.RoomName, payload []byte) ([]byte, error) {
+	room := m.GetRoom(roomName)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	room.CompleteMigration(tc.ParticipantIdentity(payload))
+	return nil, nil
+}
+
+// handleUpdateRoomMetadata 其他节点的服务接口请求更新房间元数据
+func (m *RoomManager) handleUpdateRoomMetadata(_ context.Context, roomName tc.RoomName, payload []byte) ([]byte, error) {
+	room := m.GetRoom(roomName)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	update := &roomMetadataUpdate{}
+	if err := json.Unmarshal(payload, update); err != nil {
+		return nil, err
+	}
+
+	result, err := updateRoomMetadata(room, update)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// handleUpdateParticipant 其他节点的服务接口请求更新参与者
+func (m *RoomManager) handleUpdateParticipant(_ context.Context, roomName tc.RoomName, payload []byte) ([]byte, error) {
+	room := m.GetRoom(roomName)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	request := &tc.UpdateParticipantRequest{}
+	if err := proto.Unmarshal(payload, request); err != nil {
+		return nil, err
+	}
+
+	result, err := updateParticipant(room, request)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// handleUpdateParticipantAttributes 其他节点的服务接口请求修改参与者属性
+func (m *RoomManager) handleUpdateParticipantAttributes(_ context.Context, roomName tc.RoomName, payload []byte) ([]byte, error) {
+	room := m.GetRoom(roomName)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	request := &UpdateParticipantAttributesRequest{}
+	if err := json.Unmarshal(payload, request); err != nil {
+		return nil, err
+	}
+
+	result, err := updateParticipantAttributes(room, request)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// handlePerformRpc 其他节点的服务接口请求调用房间中参与者的方法，调用失败的原因放在响应中返回
+func (m *RoomManager) handlePerformRpc(ctx context.Context, roomName tc.RoomName, payload []byte) ([]byte, error) {
+	room := m.GetRoom(roomName)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	request := &PerformRpcRequest{}
+	if err := json.Unmarshal(payload, request); err != nil {
+		return nil, err
+	}
+
+	res := &rtc.RpcResponse{}
+	result, err := performRpc(ctx, room, request)
+	var rpcErr *rtc.RpcError
+	switch {
+	case err == nil:
+		res.Payload = result
+	case errors.As(err, &rpcErr):
+		res.Error = rpcErr
+	default:
+		return nil, err
+	}
+	return json.Marshal(res)
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-base-go/protocol/utils"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+func newTestRoomManager(t *testing.T) (*RoomManager, *routing.LocalRouter) {
+	currentNode := routing.NewLocalNodeFromProto(&tc.Node{Id: "ND_test"})
+	router := routing.NewLocalRouter(currentNode)
+	t.Cleanup(router.Stop)
+	conf := newTestConfig()
+	rtcConf, err := rtc.NewWebRTCConfig(conf)
+	require.NoError(t, err)
+	m := NewRoomManager(conf, router, currentNode, rtcConf, nil)
+	m.Start()
+	t.Cleanup(m.Stop)
+	require.NoError(t, router.Start())
+	return m, router
+}
+
+func TestRoomManagerMigrationRequests(t *testing.T) {
+	m, router := newTestRoomManager(t)
+	ctx := context.Background()
+
+	_, err := router.RequestRoomNode(ctx, "room", rtc.MigrationExportMethod, []byte("p1"))
+	require.ErrorIs(t, err, ErrRoomNotFound)
+
+	room := rtc.NewRoom(&tc.Room{Name: "room", Sid: "RM_test"}, &tc.ServerInfo{}, nil, router, logger.GetLogger())
+	m.addRoom(room)
+	require.Equal(t, room, m.GetRoom("room"))
+
+	_, err = router.RequestRoomNode(ctx, "room", rtc.MigrationExportMethod, []byte("p1"))
+	require.ErrorIs(t, err, rtc.ErrParticipantNotFound)
+	_, err = router.RequestRoomNode(ctx, "room", rtc.MigrationCompleteMethod, []byte("p1"))
+	require.NoError(t, err)
+
+	// 房间关闭后不再由当前节点处理
+	room.Close()
+	require.Nil(t, m.GetRoom("room"))
+
+	m.Stop()
+	_, err = router.RequestRoomNode(ctx, "room", rtc.MigrationExportMethod, []byte("p1"))
+	require.ErrorIs(t, err, routing.ErrHandlerNotDefined)
+}
+
+func TestRoomManagerParticipantParams(t *testing.T) {
+	m, router := newTestRoomManager(t)
+	room := rtc.NewRoom(&tc.Room{Name: "room", Sid: "RM_test"}, &tc.ServerInfo{}, nil, router, logger.GetLogger())
+	sink := routing.NewMessageChannel("CO_p1", 10)
+
+	params := m.participantParams(room, newTestParticipantInit("p1"), sink)
+	require.NotEmpty(t, params.SID)
+	require.Equal(t, types.ProtocolVersion(8), params.ProtocolVersion)
+	require.False(t, params.ReconnectOnSubscriptionError)
+	require.Zero(t, params.SubscriptionLimitVideo)
+	require.Zero(t, params.SubscriptionLimitAudio)
+	require.False(t, params.SyncStreams)
+
+	// 订阅数量限制和订阅失败时是否重连取自节点配置
+	reconnect := true
+	m.config.RTC.ReconnectOnSubscriptionError = &reconnect
+	m.config.Limit.SubscriptionLimitVideo = 2
+	m.config.Limit.SubscriptionLimitAudio = 3
+	params = m.participantParams(room, newTestParticipantInit("p1"), sink)
+	require.True(t, params.ReconnectOnSubscriptionError)
+	require.Equal(t, int32(2), params.SubscriptionLimitVideo)
+	require.Equal(t, int32(3), params.SubscriptionLimitAudio)
+	require.Equal(t, m.config.Limit, params.Limit)
+
+	m.config.Room.SyncStreams = true
+	m.config.Room.PlayoutDelay = config.PlayoutDelayConfig{Enabled: true, Min: 100, Max: 500}
+	params = m.participantParams(room, newTestParticipantInit("p1"), sink)
+	require.True(t, params.SyncStreams)
+	require.Equal(t, &tc.PlayoutDelay{Enabled: true, Min: 100, Max: 500}, params.PlayoutDelay)
+}
+
+// newTestConfig 只使用本地地址的节点配置
+func newTestConfig() *config.Config {
+	conf := config.DefaultConfig
+	conf.RTC.NodeIP = "127.0.0.1"
+	conf.RTC.UseExternalIP = false
+	conf.RTC.TCPPort = 0
+	conf.RTC.CongestionControl.Enabled = false
+	return &conf
+}
+
+func newTestParticipant(t *testing.T, identity tc.ParticipantIdentity) *rtc.ParticipantImpl {
+	rtcConf, err := rtc.NewWebRTCConfig(newTestConfig())
+	require.NoError(t, err)
+
+	p, err := rtc.NewParticipant(rtc.ParticipantParams{
+		Identity:        identity,
+		SID:             tc.ParticipantID(utils.NewGuid(utils.ParticipantPrefix)),
+		Config:          rtcConf,
+		Sink:            routing.NewMessageChannel(tc.ConnectionID(identity), 100),
+		ProtocolVersion: types.CurrentProtocol,
+		Logger:          logger.GetLogger(),
+		Grants: &auth.ClaimGrants{
+			Identity: string(identity),
+			Video:    &auth.VideoGrant{RoomJoin: true, Room: "room"},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = p.Close(false, types.ParticipantCloseReasonRoomClose, false)
+	})
+	return p
+}
+
+func TestRoomManagerUpdateSubscriptions(t *testing.T) {
+	m, router := newTestRoomManager(t)
+	ctx := context.Background()
+
+	room := rtc.NewRoom(&tc.Room{Name: "room", Sid: "RM_test"}, &tc.ServerInfo{}, nil, router, logger.GetLogger())
+	m.addRoom(room)
+	p := newTestParticipant(t, "sub")
+	require.NoError(t, room.Join(p, nil, nil))
+
+	update := func(subscribe bool) {
+		err := router.WriteParticipantRTC(ctx, "room", "sub", &tc.RTCNodeMessage{
+			Message: &tc.RTCNodeMessage_UpdateSubscriptions{
+				UpdateSubscriptions: &tc.UpdateSubscriptionsRequest{
+					Room:      "room",
+					Identity:  "sub",
+					TrackSids: []string{"TR_video"},
+					Subscribe: subscribe,
+				},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	update(true)
+	require.Eventually(t, p.HasPendingSubscriptions, time.Second, 10*time.Millisecond)
+
+	// 发布者节点推送的收回订阅在订阅者节点上生效
+	update(false)
+	require.Eventually(t, func() bool {
+		return !p.HasPendingSubscriptions()
+	}, time.Second, 10*time.Millisecond)
+}
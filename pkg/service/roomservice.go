@@ -2,13 +2,136 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/twitchtv/twirp"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
 )
 
+const (
+	// metadataVersionRequestHeader 更新房间元数据时期望的当前版本，不设置时无条件更新
+	metadataVersionRequestHeader = "If-Match"
+	// metadataVersionResponseHeader 更新成功后房间元数据的版本
+	metadataVersionResponseHeader = "ETag"
+
+	// updateRoomMetadataMethod 请求房间所在的节点更新元数据
+	updateRoomMetadataMethod = "room.update_metadata"
+	// updateParticipantMethod 请求房间所在的节点更新参与者
+	updateParticipantMethod = "participant.update"
+	// updateParticipantAttributesMethod 请求房间所在的节点修改参与者属性
+	updateParticipantAttributesMethod = "participant.update_attributes"
+	// performRpcMethod 请求房间所在的节点调用参与者方法
+	performRpcMethod = "rpc.perform"
+	// rpcForwardTimeout 跨节点调用时在被调用方的超时之外等待的时间
+	rpcForwardTimeout = 2 * time.Second
+)
+
+type expectedMetadataVersionKey struct{}
+
+// RoomProvider 获取当前节点上运行的房间，房间不在当前节点时返回 nil
+type RoomProvider interface {
+	GetRoom(roomName tc.RoomName) *rtc.Room
+}
+
+// UpdateParticipantAttributesRequest 修改参与者部分属性的请求
+type UpdateParticipantAttributesRequest struct {
+	Room     string `json:"room"`
+	Identity string `json:"identity"`
+	// Set 新增或者修改的属性
+	Set map[string]string `json:"set,omitempty"`
+	// Remove 删除的属性键
+	Remove []string `json:"remove,omitempty"`
+}
+
+// participantUpdateResult 房间所在节点修改参与者的结果，参与者不存在或者修改不合法时没有参与者信息
+type participantUpdateResult struct {
+	// Participant proto 编码的修改后的参与者信息
+	Participant []byte `json:"participant,omitempty"`
+	NotFound    bool   `json:"notFound,omitempty"`
+	Invalid     string `json:"invalid,omitempty"`
+}
+
+// roomMetadataUpdate 请求房间所在的节点更新元数据，ExpectedVersion 为空时无条件更新
+type roomMetadataUpdate struct {
+	Metadata        string  `json:"metadata"`
+	ExpectedVersion *uint32 `json:"expectedVersion,omitempty"`
+}
+
+// roomMetadataResult 房间元数据的更新结果，Conflict 为 true 时 Version 为当前版本
+type roomMetadataResult struct {
+	// Room proto 编码的更新后的房间信息
+	Room     []byte `json:"room,omitempty"`
+	Version  uint32 `json:"version"`
+	Conflict bool   `json:"conflict,omitempty"`
+}
+
+// PerformRpcRequest 后端调用参与者方法的请求
+type PerformRpcRequest struct {
+	Room                string `json:"room"`
+	DestinationIdentity string `json:"destination_identity"`
+	Method              string `json:"method"`
+	Payload             string `json:"payload"`
+	// ResponseTimeoutMs 为 0 时使用默认超时
+	ResponseTimeoutMs uint32 `json:"response_timeout_ms,omitempty"`
+}
+
+// PerformRpcResponse 参与者方法的返回
+type PerformRpcResponse struct {
+	Payload string `json:"payload"`
+}
+
 // RoomService 单节点的room服务，通过twirp协议提供Room的增删改查功能
 // 此服务注册到http server上提供服务
 type RoomService struct {
+	roomConf     config.RoomConfig
+	router       routing.MessageRouter
+	roomProvider RoomProvider
+}
+
+// NewRoomService 创建房间服务，对房间的操作通过路由转发到房间所在的节点
+func NewRoomService(roomConf config.RoomConfig, router routing.MessageRouter, roomProvider RoomProvider) *RoomService {
+	return &RoomService{
+		roomConf:     roomConf,
+		router:       router,
+		roomProvider: roomProvider,
+	}
+}
+
+// MetadataVersionHandler 将请求头中期望的房间元数据版本放入上下文，需要包裹在 RoomService 的 twirp handler 外层
+func MetadataVersionHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if v := req.Header.Get(metadataVersionRequestHeader); v != "" {
+			version, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				http.Error(w, "invalid "+metadataVersionRequestHeader+" header", http.StatusBadRequest)
+				return
+			}
+			req = req.WithContext(WithExpectedMetadataVersion(req.Context(), uint32(version)))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// WithExpectedMetadataVersion 设置更新房间元数据时期望的当前版本
+func WithExpectedMetadataVersion(ctx context.Context, version uint32) context.Context {
+	return context.WithValue(ctx, expectedMetadataVersionKey{}, version)
+}
+
+func expectedMetadataVersion(ctx context.Context) *uint32 {
+	if version, ok := ctx.Value(expectedMetadataVersionKey{}).(uint32); ok {
+		return &version
+	}
+	return nil
 }
 
 // CreateRoom 创建房间
@@ -53,10 +176,134 @@ func (r RoomService) MutePublishedTrack(ctx context.Context, request *tc.MuteRoo
 	panic("implement me")
 }
 
-// UpdateParticipant 更新参与者
+// UpdateParticipant 更新参与者的名称、元数据和权限，房间在其他节点上时由房间所在的节点更新
 func (r RoomService) UpdateParticipant(ctx context.Context, request *tc.UpdateParticipantRequest) (*tc.ParticipantInfo, error) {
-	//TODO implement me
-	panic("implement me")
+	if request.Room == "" || request.Identity == "" {
+		return nil, twirp.RequiredArgumentError("room and identity")
+	}
+	if err := EnsureAdminPermission(ctx, request.Room); err != nil {
+		return nil, err
+	}
+	if r.roomConf.MaxMetadataSize > 0 && len(request.Metadata) > int(r.roomConf.MaxMetadataSize) {
+		return nil, twirp.InvalidArgumentError("metadata", "exceeds the max metadata size")
+	}
+
+	if room := r.localRoom(request.Room); room != nil {
+		return participantUpdateTwirp(updateParticipant(room, request))
+	}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return participantUpdateTwirp(r.updateRemoteParticipant(ctx, request.Room, updateParticipantMethod, data))
+}
+
+// UpdateParticipantAttributes 修改参与者的部分属性，未涉及的属性保持不变，房间在其他节点上时由房间所在的节点修改
+func (r RoomService) UpdateParticipantAttributes(ctx context.Context, request *UpdateParticipantAttributesRequest) (*tc.ParticipantInfo, error) {
+	if request.Room == "" || request.Identity == "" {
+		return nil, twirp.RequiredArgumentError("room and identity")
+	}
+	if err := EnsureAdminPermission(ctx, request.Room); err != nil {
+		return nil, err
+	}
+
+	if room := r.localRoom(request.Room); room != nil {
+		return participantUpdateTwirp(updateParticipantAttributes(room, request))
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return participantUpdateTwirp(r.updateRemoteParticipant(ctx, request.Room, updateParticipantAttributesMethod, data))
+}
+
+func (r RoomService) updateRemoteParticipant(ctx context.Context, roomName string, method string, payload []byte) (*participantUpdateResult, error) {
+	data, err := r.router.RequestRoomNode(ctx, tc.RoomName(roomName), method, payload)
+	if err != nil {
+		return nil, err
+	}
+	result := &participantUpdateResult{}
+	if err = json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// updateParticipant 在房间所在的节点上更新参与者
+func updateParticipant(room *rtc.Room, request *tc.UpdateParticipantRequest) (*participantUpdateResult, error) {
+	participant := room.GetParticipant(tc.ParticipantIdentity(request.Identity))
+	if participant == nil {
+		return &participantUpdateResult{NotFound: true}, nil
+	}
+
+	if request.Name != "" {
+		participant.SetName(request.Name)
+	}
+	if request.Metadata != "" {
+		participant.SetMetadata(request.Metadata)
+	}
+	if request.Permission != nil {
+		participant.SetPermission(request.Permission)
+	}
+	return newParticipantUpdateResult(participant)
+}
+
+// updateParticipantAttributes 在房间所在的节点上修改参与者属性
+func updateParticipantAttributes(room *rtc.Room, request *UpdateParticipantAttributesRequest) (*participantUpdateResult, error) {
+	participant := room.GetParticipant(tc.ParticipantIdentity(request.Identity))
+	if participant == nil {
+		return &participantUpdateResult{NotFound: true}, nil
+	}
+	if err := participant.UpdateAttributes(request.Set, request.Remove); err != nil {
+		return &participantUpdateResult{Invalid: err.Error()}, nil
+	}
+	return newParticipantUpdateResult(participant)
+}
+
+func newParticipantUpdateResult(participant types.LocalParticipant) (*participantUpdateResult, error) {
+	data, err := proto.Marshal(participant.ToProto())
+	if err != nil {
+		return nil, err
+	}
+	return &participantUpdateResult{Participant: data}, nil
+}
+
+// participantUpdateTwirp 将更新结果转换为接口的返回
+func participantUpdateTwirp(result *participantUpdateResult, err error) (*tc.ParticipantInfo, error) {
+	if err != nil {
+		if errors.Is(err, ErrRoomNotFound) || errors.Is(err, routing.ErrNotFound) || errors.Is(err, routing.ErrNodeNotFound) {
+			return nil, twirp.NotFoundError("room not found")
+		}
+		return nil, twirp.NewError(twirp.Unavailable, err.Error())
+	}
+	switch {
+	case result.NotFound:
+		return nil, twirp.NotFoundError("participant not found")
+	case result.Invalid != "":
+		return nil, twirp.InvalidArgumentError("attributes", result.Invalid)
+	}
+
+	pi := &tc.ParticipantInfo{}
+	if err = proto.Unmarshal(result.Participant, pi); err != nil {
+		return nil, err
+	}
+	return pi, nil
+}
+
+// localRoom 当前节点上运行的房间，房间在其他节点上时返回 nil
+func (r RoomService) localRoom(roomName string) *rtc.Room {
+	if r.roomProvider == nil {
+		return nil
+	}
+	return r.roomProvider.GetRoom(tc.RoomName(roomName))
+}
+
+func (r RoomService) localParticipant(roomName string, identity string) types.LocalParticipant {
+	room := r.localRoom(roomName)
+	if room == nil {
+		return nil
+	}
+	return room.GetParticipant(tc.ParticipantIdentity(identity))
 }
 
 // UpdateSubscriptions 更新订阅者
@@ -65,14 +312,184 @@ func (r RoomService) UpdateSubscriptions(ctx context.Context, request *tc.Update
 	panic("implement me")
 }
 
-// SendData 发送数据
+// SendData 向房间中的参与者发送数据，由房间所在的节点转发
 func (r RoomService) SendData(ctx context.Context, request *tc.SendDataRequest) (*tc.SendDataResponse, error) {
-	//TODO implement me
-	panic("implement me")
+	if request.Room == "" {
+		return nil, twirp.RequiredArgumentError("room")
+	}
+	if err := EnsureAdminPermission(ctx, request.Room); err != nil {
+		return nil, err
+	}
+	if err := rtc.ValidateDataPacketSize(request.Kind, len(request.Data)); err != nil {
+		return nil, twirp.InvalidArgumentError("data", err.Error())
+	}
+
+	err := r.router.WriteRoomRTC(ctx, tc.RoomName(request.Room), &tc.RTCNodeMessage{
+		Message: &tc.RTCNodeMessage_SendData{
+			SendData: request,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tc.SendDataResponse{}, nil
 }
 
-// UpdateRoomMetadata 更新房间原数据
+// UpdateRoomMetadata 更新房间元数据，上下文中带有期望版本时只有版本一致才会更新，
+// 房间在其他节点上时连同期望版本一起交给房间所在的节点更新
 func (r RoomService) UpdateRoomMetadata(ctx context.Context, request *tc.UpdateRoomMetadataRequest) (*tc.Room, error) {
-	//TODO implement me
-	panic("implement me")
+	if request.Room == "" {
+		return nil, twirp.RequiredArgumentError("room")
+	}
+	if err := EnsureAdminPermission(ctx, request.Room); err != nil {
+		return nil, err
+	}
+	if r.roomConf.MaxMetadataSize > 0 && len(request.Metadata) > int(r.roomConf.MaxMetadataSize) {
+		return nil, twirp.InvalidArgumentError("metadata", "exceeds the max metadata size")
+	}
+
+	update := &roomMetadataUpdate{
+		Metadata:        request.Metadata,
+		ExpectedVersion: expectedMetadataVersion(ctx),
+	}
+	var result *roomMetadataResult
+	var err error
+	if room := r.localRoom(request.Room); room != nil {
+		result, err = updateRoomMetadata(room, update)
+	} else {
+		result, err = r.updateRemoteRoomMetadata(ctx, request.Room, update)
+	}
+	if err != nil {
+		if errors.Is(err, ErrRoomNotFound) || errors.Is(err, routing.ErrNotFound) || errors.Is(err, routing.ErrNodeNotFound) {
+			return nil, twirp.NotFoundError("room not found")
+		}
+		return nil, twirp.NewError(twirp.Unavailable, err.Error())
+	}
+
+	version := strconv.FormatUint(uint64(result.Version), 10)
+	if result.Conflict {
+		return nil, twirp.NewError(twirp.Aborted, rtc.ErrRoomMetadataVersionConflict.Error()).
+			WithMeta("current_version", version)
+	}
+	room := &tc.Room{}
+	if err = proto.Unmarshal(result.Room, room); err != nil {
+		return nil, err
+	}
+	_ = twirp.SetHTTPResponseHeader(ctx, metadataVersionResponseHeader, version)
+	return room, nil
+}
+
+func (r RoomService) updateRemoteRoomMetadata(ctx context.Context, roomName string, update *roomMetadataUpdate) (*roomMetadataResult, error) {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	data, err = r.router.RequestRoomNode(ctx, tc.RoomName(roomName), updateRoomMetadataMethod, data)
+	if err != nil {
+		return nil, err
+	}
+	result := &roomMetadataResult{}
+	if err = json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// updateRoomMetadata 在房间所在的节点上更新，版本冲突不作为错误返回，结果中带有当前版本
+func updateRoomMetadata(room *rtc.Room, update *roomMetadataUpdate) (*roomMetadataResult, error) {
+	version, err := room.UpdateMetadata(update.Metadata, update.ExpectedVersion)
+	if errors.Is(err, rtc.ErrRoomMetadataVersionConflict) {
+		return &roomMetadataResult{Version: version, Conflict: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.Marshal(room.ToProto())
+	if err != nil {
+		return nil, err
+	}
+	return &roomMetadataResult{Room: data, Version: version}, nil
+}
+
+// PerformRpc 调用参与者注册的方法并等待响应，房间在其他节点上时由房间所在的节点调用
+func (r RoomService) PerformRpc(ctx context.Context, request *PerformRpcRequest) (*PerformRpcResponse, error) {
+	if request.Room == "" {
+		return nil, twirp.RequiredArgumentError("room")
+	}
+	if err := EnsureAdminPermission(ctx, request.Room); err != nil {
+		return nil, err
+	}
+	if request.DestinationIdentity == "" {
+		return nil, twirp.RequiredArgumentError("destination_identity")
+	}
+
+	var payload string
+	var err error
+	if room := r.localRoom(request.Room); room != nil {
+		payload, err = performRpc(ctx, room, request)
+	} else {
+		payload, err = r.performRemoteRpc(ctx, request)
+	}
+	if err != nil {
+		return nil, rpcTwirpError(err)
+	}
+	return &PerformRpcResponse{Payload: payload}, nil
+}
+
+// performRemoteRpc 通过路由请求房间所在的节点调用，远端的 RpcError 放在响应中原样带回
+func (r RoomService) performRemoteRpc(ctx context.Context, request *PerformRpcRequest) (string, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		// 比被调用方的超时多留出转发的时间，超时错误由房间所在的节点返回
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rtc.RpcResponseTimeout(request.ResponseTimeoutMs)+rpcForwardTimeout)
+		defer cancel()
+	}
+
+	data, err = r.router.RequestRoomNode(ctx, tc.RoomName(request.Room), performRpcMethod, data)
+	if err != nil {
+		return "", err
+	}
+	res := &rtc.RpcResponse{}
+	if err = json.Unmarshal(data, res); err != nil {
+		return "", err
+	}
+	if res.Error != nil {
+		return "", res.Error
+	}
+	return res.Payload, nil
+}
+
+// performRpc 在房间所在的节点上调用
+func performRpc(ctx context.Context, room *rtc.Room, request *PerformRpcRequest) (string, error) {
+	timeout := time.Duration(request.ResponseTimeoutMs) * time.Millisecond
+	return room.PerformRpc(ctx, tc.ParticipantIdentity(request.DestinationIdentity), request.Method, request.Payload, timeout)
+}
+
+// rpcTwirpError 将调用失败的原因转换为对应的 twirp 错误
+func rpcTwirpError(err error) error {
+	var rpcErr *rtc.RpcError
+	switch {
+	case errors.As(err, &rpcErr):
+		switch rpcErr.Code {
+		case rtc.RpcErrorRecipientNotFound:
+			return twirp.NotFoundError(rpcErr.Message)
+		case rtc.RpcErrorInvalidRequest, rtc.RpcErrorRequestPayloadTooLarge:
+			return twirp.InvalidArgumentError("request", rpcErr.Message)
+		case rtc.RpcErrorResponseTimeout:
+			return twirp.NewError(twirp.DeadlineExceeded, rpcErr.Message)
+		case rtc.RpcErrorRecipientDisconnected, rtc.RpcErrorSendFailed:
+			return twirp.NewError(twirp.Unavailable, rpcErr.Message)
+		}
+		// 被调用方返回的错误原样带回
+		return twirp.NewError(twirp.Internal, rpcErr.Error())
+	case errors.Is(err, ErrRoomNotFound), errors.Is(err, routing.ErrNotFound), errors.Is(err, routing.ErrNodeNotFound):
+		return twirp.NotFoundError("room not found")
+	case errors.Is(err, context.DeadlineExceeded):
+		return twirp.NewError(twirp.DeadlineExceeded, err.Error())
+	}
+	return err
 }
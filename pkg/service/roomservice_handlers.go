@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/twitchtv/twirp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+)
+
+// RegisterHandlers 注册生成的 twirp 接口中没有的房间服务方法，路径、JSON 编码和错误格式与 twirp 一致，
+// prefix 为 twirp RoomService 的路径前缀
+func (r RoomService) RegisterHandlers(mux *http.ServeMux, prefix string) {
+	mux.Handle(prefix+"UpdateParticipantAttributes", twirpJSONHandler(r.UpdateParticipantAttributes))
+	mux.Handle(prefix+"PerformRpc", twirpJSONHandler(r.PerformRpc))
+}
+
+// twirpJSONHandler 按照 twirp 的 JSON 请求格式调用服务方法
+func twirpJSONHandler[Req any, Res any](method func(context.Context, *Req) (*Res, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			handleError(w, twirp.NewError(twirp.BadRoute, "unsupported method "+r.Method))
+			return
+		}
+
+		req := new(Req)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			handleError(w, twirp.NewError(twirp.Malformed, "could not decode request: "+err.Error()))
+			return
+		}
+
+		res, err := method(r.Context(), req)
+		if err != nil {
+			twerr, ok := err.(twirp.Error)
+			if !ok {
+				twerr = twirp.InternalErrorWith(err)
+			}
+			handleError(w, twerr)
+			return
+		}
+
+		// proto 消息与生成的 twirp 接口使用相同的 JSON 编码
+		var data []byte
+		if msg, ok := any(res).(proto.Message); ok {
+			data, err = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(msg)
+		} else {
+			data, err = json.Marshal(res)
+		}
+		if err != nil {
+			handleError(w, twirp.InternalErrorWith(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err = w.Write(data); err != nil {
+			logger.Warnw("could not write response", err)
+		}
+	})
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/twitchtv/twirp"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/rtc"
+)
+
+func newTestRoomService(t *testing.T) (*RoomService, context.Context) {
+	m, router := newTestRoomManager(t)
+	return NewRoomService(config.DefaultConfig.Room, router, m), newAdminContext()
+}
+
+func newAdminContext() context.Context {
+	return WithGrants(context.Background(), &auth.ClaimGrants{
+		Video: &auth.VideoGrant{RoomAdmin: true, Room: "room"},
+	})
+}
+
+func requireTwirpCode(t *testing.T, err error, code twirp.ErrorCode) {
+	var twerr twirp.Error
+	require.True(t, errors.As(err, &twerr), "not a twirp error: %v", err)
+	require.Equal(t, code, twerr.Code())
+}
+
+func TestRoomServiceSendData(t *testing.T) {
+	s, ctx := newTestRoomService(t)
+
+	_, err := s.SendData(ctx, &tc.SendDataRequest{Data: []byte("hi")})
+	requireTwirpCode(t, err, twirp.InvalidArgument)
+
+	_, err = s.SendData(context.Background(), &tc.SendDataRequest{Room: "room", Data: []byte("hi")})
+	require.ErrorIs(t, err, ErrPermissionDenied)
+
+	// 大小限制取决于数据包类型
+	data := make([]byte, rtc.MaxLossyDataPacketSize+1)
+	_, err = s.SendData(ctx, &tc.SendDataRequest{Room: "room", Data: data, Kind: tc.DataPacket_LOSSY})
+	requireTwirpCode(t, err, twirp.InvalidArgument)
+
+	_, err = s.SendData(ctx, &tc.SendDataRequest{Room: "room", Data: data, Kind: tc.DataPacket_RELIABLE})
+	require.NoError(t, err)
+
+	_, err = s.SendData(ctx, &tc.SendDataRequest{Room: "room", Data: make([]byte, rtc.MaxReliableDataPacketSize+1), Kind: tc.DataPacket_RELIABLE})
+	requireTwirpCode(t, err, twirp.InvalidArgument)
+}
+
+func TestRoomServicePerformRpc(t *testing.T) {
+	m, router := newTestRoomManager(t)
+	ctx := newAdminContext()
+	// 不提供当前节点上的房间，调用总是通过路由请求房间所在的节点
+	s := NewRoomService(config.DefaultConfig.Room, router, nil)
+
+	_, err := s.PerformRpc(ctx, &PerformRpcRequest{Room: "room", Method: "greet"})
+	requireTwirpCode(t, err, twirp.InvalidArgument)
+
+	_, err = s.PerformRpc(ctx, &PerformRpcRequest{Room: "room", DestinationIdentity: "p1", Method: "greet"})
+	requireTwirpCode(t, err, twirp.NotFound)
+
+	room := rtc.NewRoom(&tc.Room{Name: "room", Sid: "RM_test"}, &tc.ServerInfo{}, nil, router, logger.GetLogger())
+	m.addRoom(room)
+	require.NoError(t, room.Join(newTestParticipant(t, "p1"), nil, nil))
+
+	// 房间所在节点返回的调用错误原样带回，参与者还没有连接成功
+	_, err = s.PerformRpc(ctx, &PerformRpcRequest{Room: "room", DestinationIdentity: "p1", Method: "greet"})
+	requireTwirpCode(t, err, twirp.NotFound)
+
+	_, err = s.PerformRpc(ctx, &PerformRpcRequest{Room: "room", DestinationIdentity: "p1", Method: "greet", Payload: strings.Repeat("a", rtc.MaxRpcPayloadSize+1)})
+	requireTwirpCode(t, err, twirp.InvalidArgument)
+}
+
+func TestRoomServiceHandlers(t *testing.T) {
+	s, _ := newTestRoomService(t)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux, tc.RoomServicePathPrefix)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, tc.RoomServicePathPrefix+"PerformRpc", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(newAdminContext())
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	errorCode := func(w *httptest.ResponseRecorder) string {
+		res := struct {
+			Code string `json:"code"`
+		}{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Code
+	}
+
+	w := post(`{"room":"room","destination_identity":"p1","method":"greet"}`)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, string(twirp.NotFound), errorCode(w))
+
+	w = post(`{"room":`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, string(twirp.Malformed), errorCode(w))
+
+	req := httptest.NewRequest(http.MethodGet, tc.RoomServicePathPrefix+"PerformRpc", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	require.Equal(t, string(twirp.BadRoute), errorCode(w))
+}
+
+func TestRoomServiceUpdateRoomMetadata(t *testing.T) {
+	m, router := newTestRoomManager(t)
+	room := rtc.NewRoom(&tc.Room{Name: "room", Sid: "RM_test"}, &tc.ServerInfo{}, nil, router, logger.GetLogger())
+	m.addRoom(room)
+
+	for _, tt := range []struct {
+		name         string
+		roomProvider RoomProvider
+	}{
+		{name: "room on this node", roomProvider: m},
+		// 不提供当前节点上的房间，更新总是通过路由请求房间所在的节点
+		{name: "room on another node", roomProvider: nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewRoomService(config.DefaultConfig.Room, router, tt.roomProvider)
+			version := room.MetadataVersion()
+
+			res, err := s.UpdateRoomMetadata(newAdminContext(), &tc.UpdateRoomMetadataRequest{Room: "room", Metadata: "unconditional"})
+			require.NoError(t, err)
+			require.Equal(t, "unconditional", res.Metadata)
+			require.Equal(t, "RM_test", res.Sid)
+			version++
+
+			stale := WithExpectedMetadataVersion(newAdminContext(), version-1)
+			_, err = s.UpdateRoomMetadata(stale, &tc.UpdateRoomMetadataRequest{Room: "room", Metadata: "stale"})
+			requireTwirpCode(t, err, twirp.Aborted)
+			var twerr twirp.Error
+			require.True(t, errors.As(err, &twerr))
+			require.Equal(t, strconv.FormatUint(uint64(version), 10), twerr.Meta("current_version"))
+			require.Equal(t, "unconditional", room.ToProto().Metadata)
+
+			current := WithExpectedMetadataVersion(newAdminContext(), version)
+			res, err = s.UpdateRoomMetadata(current, &tc.UpdateRoomMetadataRequest{Room: "room", Metadata: "conditional"})
+			require.NoError(t, err)
+			require.Equal(t, "conditional", res.Metadata)
+			require.Equal(t, version+1, room.MetadataVersion())
+		})
+	}
+
+	s := NewRoomService(config.DefaultConfig.Room, router, nil)
+	ctx := WithGrants(context.Background(), &auth.ClaimGrants{
+		Video: &auth.VideoGrant{RoomAdmin: true, Room: "missing"},
+	})
+	_, err := s.UpdateRoomMetadata(ctx, &tc.UpdateRoomMetadataRequest{Room: "missing", Metadata: "m"})
+	requireTwirpCode(t, err, twirp.NotFound)
+}
+
+func TestMetadataVersionHandler(t *testing.T) {
+	var version *uint32
+	handler := MetadataVersionHandler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		version = expectedMetadataVersion(r.Context())
+	}))
+
+	serve := func(ifMatch string) int {
+		version = nil
+		req := httptest.NewRequest(http.MethodPost, tc.RoomServicePathPrefix+"UpdateRoomMetadata", nil)
+		if ifMatch != "" {
+			req.Header.Set(metadataVersionRequestHeader, ifMatch)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, serve(""))
+	require.Nil(t, version)
+
+	require.Equal(t, http.StatusOK, serve("3"))
+	require.NotNil(t, version)
+	require.Equal(t, uint32(3), *version)
+
+	require.Equal(t, http.StatusBadRequest, serve("abc"))
+	require.Nil(t, version)
+}
+
+func TestRoomServiceUpdateParticipant(t *testing.T) {
+	m, router := newTestRoomManager(t)
+	room := rtc.NewRoom(&tc.Room{Name: "room", Sid: "RM_test"}, &tc.ServerInfo{}, nil, router, logger.GetLogger())
+	m.addRoom(room)
+	p := newTestParticipant(t, "p1")
+	require.NoError(t, room.Join(p, nil, nil))
+
+	for _, tt := range []struct {
+		name         string
+		roomProvider RoomProvider
+	}{
+		{name: "room on this node", roomProvider: m},
+		// 不提供当前节点上的房间，修改总是通过路由请求房间所在的节点
+		{name: "room on another node", roomProvider: nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewRoomService(config.DefaultConfig.Room, router, tt.roomProvider)
+			ctx := newAdminContext()
+
+			// 返回房间所在节点上参与者的真实信息
+			info, err := s.UpdateParticipant(ctx, &tc.UpdateParticipantRequest{Room: "room", Identity: "p1", Metadata: tt.name})
+			require.NoError(t, err)
+			require.Equal(t, string(p.ID()), info.Sid)
+			require.Equal(t, tt.name, info.Metadata)
+			require.Equal(t, tt.name, p.ToProto().Metadata)
+
+			_, err = s.UpdateParticipant(ctx, &tc.UpdateParticipantRequest{Room: "room", Identity: "nobody", Metadata: "m"})
+			requireTwirpCode(t, err, twirp.NotFound)
+
+			info, err = s.UpdateParticipantAttributes(ctx, &UpdateParticipantAttributesRequest{
+				Room: "room", Identity: "p1", Set: map[string]string{"a": "1", "b": "2"},
+			})
+			require.NoError(t, err)
+			require.Equal(t, string(p.ID()), info.Sid)
+			_, err = s.UpdateParticipantAttributes(ctx, &UpdateParticipantAttributesRequest{
+				Room: "room", Identity: "p1", Remove: []string{"a"},
+			})
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{"b": "2"}, p.GetAttributes())
+
+			_, err = s.UpdateParticipantAttributes(ctx, &UpdateParticipantAttributesRequest{
+				Room: "room", Identity: "p1", Set: map[string]string{"big": strings.Repeat("v", rtc.MaxParticipantAttributesSize)},
+			})
+			requireTwirpCode(t, err, twirp.InvalidArgument)
+			require.Equal(t, map[string]string{"b": "2"}, p.GetAttributes())
+
+			_, err = s.UpdateParticipantAttributes(ctx, &UpdateParticipantAttributesRequest{
+				Room: "room", Identity: "nobody", Set: map[string]string{"a": "1"},
+			})
+			requireTwirpCode(t, err, twirp.NotFound)
+
+			require.NoError(t, p.UpdateAttributes(nil, []string{"b"}))
+		})
+	}
+}
+
+func TestRoomServiceUpdateParticipantAttributesHandler(t *testing.T) {
+	s, _ := newTestRoomService(t)
+	room := rtc.NewRoom(&tc.Room{Name: "room", Sid: "RM_test"}, &tc.ServerInfo{}, nil, nil, logger.GetLogger())
+	s.roomProvider.(*RoomManager).addRoom(room)
+	p := newTestParticipant(t, "p1")
+	require.NoError(t, room.Join(p, nil, nil))
+
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux, tc.RoomServicePathPrefix)
+	req := httptest.NewRequest(http.MethodPost, tc.RoomServicePathPrefix+"UpdateParticipantAttributes",
+		strings.NewReader(`{"room":"room","identity":"p1","set":{"a":"1"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(newAdminContext())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	res := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.Equal(t, "p1", res["identity"])
+	require.Equal(t, string(p.ID()), res["sid"])
+	require.Equal(t, map[string]string{"a": "1"}, p.GetAttributes())
+}
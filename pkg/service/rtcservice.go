@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/twitchtv/twirp"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc"
+	"github.com/liuhailove/tc-server/pkg/rtc/types"
+)
+
+// RTCService 客户端的信令入口，校验加入房间的授权后通过路由把信令连接到房间所在的节点
+type RTCService struct {
+	router      routing.MessageRouter
+	currentNode *routing.LocalNode
+	upgrader    websocket.Upgrader
+}
+
+// NewRTCService 创建信令服务
+func NewRTCService(router routing.MessageRouter, currentNode *routing.LocalNode) *RTCService {
+	return &RTCService{
+		router:      router,
+		currentNode: currentNode,
+		upgrader: websocket.Upgrader{
+			// 客户端来自任意来源，授权由 token 保证
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// RegisterHandlers 注册信令的 websocket 入口
+func (s *RTCService) RegisterHandlers(mux *http.ServeMux) {
+	mux.Handle("/rtc", s)
+}
+
+// ServeHTTP 开始参与者的信令会话，加入失败时在升级为 websocket 之前返回错误
+func (s *RTCService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	roomName, pi, twErr := s.validate(r)
+	if twErr != nil {
+		handleError(w, twErr)
+		return
+	}
+
+	connectionID, reqSink, resSource, err := s.router.StartParticipantSignal(r.Context(), roomName, *pi)
+	if err != nil {
+		handleError(w, joinTwirpError(err))
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warnw("could not upgrade to websocket", err, "room", roomName, "participant", pi.Identity)
+		reqSink.Close()
+		resSource.Close()
+		return
+	}
+	sigConn := NewWSSignalConnection(conn)
+	pLogger := logger.GetLogger().WithValues("room", roomName, "participant", pi.Identity, "connID", connectionID)
+	pLogger.Debugw("signal connection started")
+
+	// 响应通道关闭说明参与者已经离开，关闭 websocket 结束读取
+	go func() {
+		defer func() {
+			_ = conn.Close()
+		}()
+		for msg := range resSource.ReadChan() {
+			res, ok := msg.(*tc.SignalResponse)
+			if !ok {
+				continue
+			}
+			if _, err := sigConn.WriteResponse(res); err != nil {
+				pLogger.Debugw("could not write signal response", "error", err)
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		reqSink.Close()
+		resSource.Close()
+		pLogger.Debugw("signal connection closed")
+	}()
+	for {
+		req, _, err := sigConn.ReadRequest()
+		if err != nil {
+			return
+		}
+		if err = reqSink.WriteMessage(req); err != nil {
+			pLogger.Debugw("could not forward signal request", "error", err)
+			return
+		}
+	}
+}
+
+// validate 从授权和请求参数中得到房间和参与者的初始信息
+func (s *RTCService) validate(r *http.Request) (tc.RoomName, *routing.ParticipantInit, twirp.Error) {
+	grants := GetGrants(r.Context())
+	if grants == nil || grants.Video == nil {
+		return "", nil, ErrMissingAuthorization
+	}
+	if !grants.Video.RoomJoin || grants.Video.Room == "" || grants.Identity == "" {
+		return "", nil, ErrPermissionDenied
+	}
+
+	protocol := types.CurrentProtocol
+	if v := r.FormValue("protocol"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return "", nil, twirp.InvalidArgumentError("protocol", "must be an integer")
+		}
+		protocol = p
+	}
+
+	pi := &routing.ParticipantInit{
+		Identity:       tc.ParticipantIdentity(grants.Identity),
+		Name:           tc.ParticipantName(grants.Name),
+		AutoSubscribe:  r.FormValue("auto_subscribe") != "0",
+		AdaptiveStream: r.FormValue("adaptive_stream") == "1",
+		Reconnect:      r.FormValue("reconnect") == "1",
+		Client: &tc.ClientInfo{
+			Protocol: int32(protocol),
+			Version:  r.FormValue("version"),
+		},
+		Grants: grants,
+		Region: s.currentNode.Region(),
+	}
+	if claims := getTokenClaims(r.Context()); claims != nil {
+		pi.APIKey = claims.Issuer
+		if claims.Expiry != nil {
+			pi.TokenExpiry = claims.Expiry.Time()
+		}
+	}
+	return tc.RoomName(grants.Video.Room), pi, nil
+}
+
+// joinTwirpError 加入房间的错误转换为 twirp 错误，节点达到限制时客户端可以稍后重试
+func joinTwirpError(err error) twirp.Error {
+	switch {
+	case errors.Is(err, rtc.ErrNumTracksExceeded),
+		errors.Is(err, rtc.ErrBytesPerSecExceeded),
+		errors.Is(err, rtc.ErrMaxParticipantsExceeded):
+		return twirp.NewError(twirp.ResourceExhausted, err.Error())
+	case errors.Is(err, rtc.ErrRoomClosed):
+		return twirp.NewError(twirp.Unavailable, err.Error())
+	default:
+		return twirp.InternalErrorWith(err)
+	}
+}
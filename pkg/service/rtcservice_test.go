@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/twitchtv/twirp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuhailove/tc-base-go/protocol/auth"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+)
+
+func newTestParticipantInit(identity tc.ParticipantIdentity) routing.ParticipantInit {
+	return routing.ParticipantInit{
+		Identity:      identity,
+		AutoSubscribe: true,
+		Client:        &tc.ClientInfo{Protocol: 8},
+		Grants: &auth.ClaimGrants{
+			Identity: string(identity),
+			Video:    &auth.VideoGrant{RoomJoin: true, Room: "room"},
+		},
+	}
+}
+
+func readJoinResponse(t *testing.T, source routing.MessageSource) *tc.JoinResponse {
+	for {
+		select {
+		case msg, ok := <-source.ReadChan():
+			require.True(t, ok, "response channel closed")
+			if res, ok := msg.(*tc.SignalResponse); ok && res.GetJoin() != nil {
+				return res.GetJoin()
+			}
+		case <-time.After(time.Second):
+			require.Fail(t, "no join response")
+			return nil
+		}
+	}
+}
+
+func TestRoomManagerStartSession(t *testing.T) {
+	m, router := newTestRoomManager(t)
+	ctx := context.Background()
+
+	_, reqSink, resSource, err := router.StartParticipantSignal(ctx, "room", newTestParticipantInit("p1"))
+	require.NoError(t, err)
+	join := readJoinResponse(t, resSource)
+	require.Equal(t, "room", join.Room.Name)
+	require.Equal(t, "p1", join.Participant.Identity)
+
+	room := m.GetRoom("room")
+	require.NotNil(t, room)
+	require.NotNil(t, room.GetParticipant("p1"))
+
+	// 客户端请求离开后参与者从房间中移除
+	require.NoError(t, reqSink.WriteMessage(&tc.SignalRequest{
+		Message: &tc.SignalRequest_Leave{Leave: &tc.LeaveRequest{}},
+	}))
+	require.Eventually(t, func() bool {
+		return room.GetParticipant("p1") == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// 节点音轨数量达到限制时拒绝加入
+	m.config.Limit.NumTracks = 1
+	prometheus.AddPublishedTrack(tc.TrackType_AUDIO)
+	defer prometheus.SubPublishedTrack(tc.TrackType_AUDIO)
+	_, _, _, err = router.StartParticipantSignal(ctx, "room", newTestParticipantInit("p2"))
+	require.ErrorIs(t, err, rtc.ErrNumTracksExceeded)
+	require.Nil(t, room.GetParticipant("p2"))
+}
+
+func TestRTCServiceJoin(t *testing.T) {
+	m, router := newTestRoomManager(t)
+	s := NewRTCService(router, routing.NewLocalNodeFromProto(&tc.Node{Id: "ND_test"}))
+
+	var grants *auth.ClaimGrants
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if grants != nil {
+			r = r.WithContext(WithGrants(r.Context(), grants))
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/rtc?protocol=8"
+
+	t.Run("requires authorization", func(t *testing.T) {
+		_, res, err := websocket.DefaultDialer.Dial(url, nil)
+		require.Error(t, err)
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		grants = &auth.ClaimGrants{Identity: "p1", Video: &auth.VideoGrant{Room: "room"}}
+		_, res, err = websocket.DefaultDialer.Dial(url, nil)
+		require.Error(t, err)
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("joins over websocket", func(t *testing.T) {
+		grants = &auth.ClaimGrants{Identity: "p1", Video: &auth.VideoGrant{RoomJoin: true, Room: "room"}}
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		for {
+			messageType, payload, err := conn.ReadMessage()
+			require.NoError(t, err)
+			res := &tc.SignalResponse{}
+			if messageType == websocket.TextMessage {
+				require.NoError(t, protojson.Unmarshal(payload, res))
+			} else {
+				require.NoError(t, proto.Unmarshal(payload, res))
+			}
+			if join := res.GetJoin(); join != nil {
+				require.Equal(t, "p1", join.Participant.Identity)
+				break
+			}
+		}
+		require.NotNil(t, m.GetRoom("room").GetParticipant("p1"))
+	})
+
+	t.Run("rejects over node limits before upgrading", func(t *testing.T) {
+		m.config.Limit.NumTracks = 1
+		defer func() {
+			m.config.Limit.NumTracks = 0
+		}()
+		prometheus.AddPublishedTrack(tc.TrackType_VIDEO)
+		defer prometheus.SubPublishedTrack(tc.TrackType_VIDEO)
+
+		grants = &auth.ClaimGrants{Identity: "p2", Video: &auth.VideoGrant{RoomJoin: true, Room: "room"}}
+		_, res, err := websocket.DefaultDialer.Dial(url, nil)
+		require.Error(t, err)
+		require.Equal(t, twirp.ServerHTTPStatusFromErrorCode(twirp.ResourceExhausted), res.StatusCode)
+	})
+}
+
+func TestRTCServiceValidate(t *testing.T) {
+	s := NewRTCService(nil, routing.NewLocalNodeFromProto(&tc.Node{Id: "ND_test", Region: "region"}))
+	expiry := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+
+	req := httptest.NewRequest(http.MethodGet, "/rtc?auto_subscribe=0&adaptive_stream=1", nil)
+	ctx := WithGrants(req.Context(), &auth.ClaimGrants{
+		Identity: "p1",
+		Video:    &auth.VideoGrant{RoomJoin: true, Room: "room"},
+	})
+	ctx = context.WithValue(ctx, tokenClaimsKey{}, &jwt.Claims{Issuer: "APIkey", Expiry: jwt.NewNumericDate(expiry)})
+
+	roomName, pi, err := s.validate(req.WithContext(ctx))
+	require.Nil(t, err)
+	require.Equal(t, tc.RoomName("room"), roomName)
+	require.Equal(t, tc.ParticipantIdentity("p1"), pi.Identity)
+	require.False(t, pi.AutoSubscribe)
+	require.True(t, pi.AdaptiveStream)
+	require.Equal(t, "region", pi.Region)
+	// 刷新 token 使用加入时的 API key 和过期时间
+	require.Equal(t, "APIkey", pi.APIKey)
+	require.True(t, expiry.Equal(pi.TokenExpiry))
+}
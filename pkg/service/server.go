@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/atomic"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/routing"
+	"github.com/liuhailove/tc-server/pkg/rtc"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+)
+
+const (
+	// shutdownTimeout 停止时等待处理中的HTTP请求结束的时间
+	shutdownTimeout = 5 * time.Second
+	// nodeStatsInterval 更新节点统计的周期
+	nodeStatsInterval = 2 * time.Second
+)
+
+// TCServer 组装节点上的服务并对外提供HTTP接口
+type TCServer struct {
+	config      *config.Config
+	router      routing.Router
+	currentNode *routing.LocalNode
+	keyProvider *RotatingKeyProvider
+	roomManager *RoomManager
+	roomService *RoomService
+	httpServer  *http.Server
+	promServer  *http.Server
+
+	running    atomic.Bool
+	doneChan   chan struct{}
+	closedChan chan struct{}
+}
+
+// NewTCServer 创建服务，conf.ValidateKeys 需要已经执行过，单节点模式下 rc 为 nil
+func NewTCServer(conf *config.Config, router routing.Router, currentNode *routing.LocalNode, rc redis.UniversalClient) (*TCServer, error) {
+	if len(conf.Keys) == 0 {
+		return nil, config.ErrKeysNotSet
+	}
+
+	prometheus.Init(string(currentNode.NodeID()), tc.NodeType_SERVER)
+
+	rtcConf, err := rtc.NewWebRTCConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	keyProvider := NewRotatingKeyProvider(conf)
+	roomManager := NewRoomManager(conf, router, currentNode, rtcConf, keyProvider)
+	s := &TCServer{
+		config:      conf,
+		router:      router,
+		currentNode: currentNode,
+		keyProvider: keyProvider,
+		roomManager: roomManager,
+		roomService: NewRoomService(conf.Room, router, roomManager),
+		promServer:  prometheus.NewServer(conf.PrometheusPort),
+		closedChan:  make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	roomServer := tc.NewRoomServiceServer(s.roomService)
+	mux.Handle(roomServer.PathPrefix(), MetadataVersionHandler(roomServer))
+	s.roomService.RegisterHandlers(mux, roomServer.PathPrefix())
+	NewRTCService(router, currentNode).RegisterHandlers(mux)
+	NewHealthService(conf, router, currentNode, rc).RegisterHandlers(mux)
+
+	s.httpServer = &http.Server{
+		Handler: APIKeyAuthHandler(s.keyProvider, mux),
+	}
+	return s, nil
+}
+
+// Node 当前节点
+func (s *TCServer) Node() *tc.Node {
+	return s.currentNode.Clone()
+}
+
+// KeyProvider 校验token使用的密钥
+func (s *TCServer) KeyProvider() *RotatingKeyProvider {
+	return s.keyProvider
+}
+
+// IsRunning 是否正在运行
+func (s *TCServer) IsRunning() bool {
+	return s.running.Load()
+}
+
+// Start 启动路由和HTTP服务，阻塞直到 Stop 被调用
+func (s *TCServer) Start() error {
+	if s.running.Load() {
+		return errors.New("already running")
+	}
+
+	addresses := s.config.BindAddresses
+	if len(addresses) == 0 {
+		addresses = []string{""}
+	}
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, addr := range addresses {
+		ln, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(int(s.config.Port))))
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, ln)
+	}
+	var promListener net.Listener
+	if s.promServer != nil {
+		ln, err := net.Listen("tcp", s.promServer.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return err
+		}
+		promListener = ln
+	}
+
+	s.roomManager.Start()
+	if err := s.router.Start(); err != nil {
+		s.roomManager.Stop()
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+		if promListener != nil {
+			_ = promListener.Close()
+		}
+		return err
+	}
+	s.keyProvider.Start()
+
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if err := s.httpServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Errorw("could not serve http", err, "addr", ln.Addr().String())
+			}
+		}(ln)
+	}
+	if promListener != nil {
+		go func() {
+			if err := s.promServer.Serve(promListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Errorw("could not serve prometheus", err)
+			}
+		}()
+	}
+
+	logger.Infow("starting tc-server",
+		"portHttp", s.config.Port,
+		"portPrometheus", s.config.PrometheusPort,
+		"nodeID", s.currentNode.NodeID(),
+		"nodeIP", s.currentNode.NodeIP(),
+		"bindAddresses", addresses,
+		"numKeys", s.keyProvider.NumKeys(),
+	)
+
+	s.doneChan = make(chan struct{})
+	s.running.Store(true)
+	go s.nodeStatsWorker(s.doneChan)
+	<-s.doneChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	_ = s.httpServer.Shutdown(ctx)
+	if s.promServer != nil {
+		_ = s.promServer.Shutdown(ctx)
+	}
+
+	s.keyProvider.Stop()
+	s.roomManager.Stop()
+	s.router.Stop()
+	close(s.closedChan)
+	return nil
+}
+
+// Stop 停止服务，force 为 false 时先将节点标记为下线，不再接收新的房间
+func (s *TCServer) Stop(force bool) {
+	if !s.running.Swap(false) {
+		return
+	}
+	if !force {
+		s.router.Drain()
+	}
+	close(s.doneChan)
+
+	// 等待 Start 中的清理完成
+	<-s.closedChan
+}
+
+// nodeStatsWorker 定期更新当前节点的统计，健康检查和节点选择依赖其中的CPU负载
+func (s *TCServer) nodeStatsWorker(done <-chan struct{}) {
+	ticker := time.NewTicker(nodeStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			stats, err := prometheus.GetUpdatedNodeStats(s.currentNode.Stats())
+			if err != nil {
+				logger.Warnw("could not update node stats", err)
+				continue
+			}
+			s.currentNode.SetStats(stats)
+		}
+	}
+}
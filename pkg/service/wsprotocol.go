@@ -12,6 +12,7 @@ import (
 	"github.com/liuhailove/tc-base-go/protocol/logger"
 	"github.com/liuhailove/tc-base-go/protocol/tc"
 	"github.com/liuhailove/tc-server/pkg/rtc/types"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
 )
 
 const (
@@ -57,6 +58,9 @@ func (c *WSSignalConnection) ReadRequest() (*tc.SignalRequest, int, error) {
 			}
 			// protobuf 编码
 			err := proto.Unmarshal(payload, msg)
+			if err == nil {
+				prometheus.RecordSignalRequest(msg)
+			}
 			return msg, len(payload), err
 		case websocket.TextMessage:
 			c.mu.Lock()
@@ -64,6 +68,9 @@ func (c *WSSignalConnection) ReadRequest() (*tc.SignalRequest, int, error) {
 			c.useJSON = true
 			c.mu.Unlock()
 			err := protojson.Unmarshal(payload, msg)
+			if err == nil {
+				prometheus.RecordSignalRequest(msg)
+			}
 			return msg, len(payload), err
 		default:
 			logger.Debugw("unsupported message", "messageType", messageType)
@@ -92,7 +99,11 @@ func (c *WSSignalConnection) WriteResponse(msg *tc.SignalResponse) (int, error)
 		return 0, err
 	}
 
-	return len(payload), c.conn.WriteMessage(msgType, payload)
+	if err = c.conn.WriteMessage(msgType, payload); err != nil {
+		return 0, err
+	}
+	prometheus.RecordSignalResponse(msg)
+	return len(payload), nil
 }
 
 // pingWorker 定时ping
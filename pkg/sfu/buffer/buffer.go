@@ -1,20 +1,29 @@
 package buffer
 
 import (
-	"github.com/gammazero/deque"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/gammazero/deque"
+
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 	"go.uber.org/atomic"
 
+	"github.com/liuhailove/tc-base-go/mediatransportutil"
 	"github.com/liuhailove/tc-base-go/mediatransportutil/pkg/bucket"
 	"github.com/liuhailove/tc-base-go/mediatransportutil/pkg/nack"
 	"github.com/liuhailove/tc-base-go/mediatransportutil/pkg/twcc"
 	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
 	"github.com/liuhailove/tc-server/pkg/sfu/audio"
+	dd "github.com/liuhailove/tc-server/pkg/sfu/dependencydescriptor"
 	sutils "github.com/liuhailove/tc-server/pkg/utils"
 )
 
@@ -28,6 +37,14 @@ import (
 
 const (
 	ReportDelta = time.Second
+
+	// InitPacketBufferSize 绑定之前缓存的包数
+	InitPacketBufferSize = 50
+)
+
+var (
+	ErrBufferTooSmall = errors.New("buffer too small")
+	ErrPacketNotFound = errors.New("packet not found in cache")
 )
 
 // pendingPacket 待处理的数据包
@@ -82,6 +99,8 @@ type Buffer struct {
 	rtpStats             *RTPStats
 	rrSnapshotId         uint32
 	deltaStatsSnapshotId uint32
+	// qualitySnapshotId 连接质量评分使用独立的快照，不影响遥测统计
+	qualitySnapshotId uint32
 
 	lastFractionLostToReport uint8 // 订阅者丢失的最后一部分，应向发布者报告；仅限音频
 
@@ -99,6 +118,8 @@ type Buffer struct {
 	ddExt    uint8
 	ddParser *DependencyDescriptorParser
 
+	readCond *sync.Cond
+
 	paused              bool
 	frameRateCalculator [DefaultMaxLayerSpatial + 1]FrameRateCalculator
 	frameRateCalculated bool
@@ -115,6 +136,7 @@ func NewBuffer(ssrc uint32, vp, ap *sync.Pool) *Buffer {
 		pliThrottle: int64(500 * time.Millisecond),
 		logger:      l.WithComponent(sutils.ComponentPub).WithComponent(sutils.ComponentSFU),
 	}
+	b.readCond = sync.NewCond(&b.RWMutex)
 	b.extPackets.SetMinCapacity(7)
 	return b
 }
@@ -128,3 +150,525 @@ func (b *Buffer) SetLogger(logger logger.Logger) {
 		b.rtpStats.SetLogger(b.logger)
 	}
 }
+
+// SetAudioLevelParams 音频级别参数，需要在 Bind 之前设置
+func (b *Buffer) SetAudioLevelParams(audioLevelParams audio.AudioLevelParams) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.audioLevelParams = audioLevelParams
+}
+
+// SetPaused 发布者静音时暂停，暂停期间收到的包不再转发
+func (b *Buffer) SetPaused(paused bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.paused = paused
+}
+
+// Bind 协商完成后绑定编解码器和头扩展，之前缓存的包在这里处理
+func (b *Buffer) Bind(params webrtc.RTPParameters, codec webrtc.RTPCodecCapability) {
+	b.Lock()
+	defer b.Unlock()
+	if b.bound {
+		return
+	}
+
+	b.rtpStats = NewRTPStats(RTPStatsParams{
+		ClockRate: codec.ClockRate,
+		Logger:    b.logger,
+	})
+	b.rrSnapshotId = b.rtpStats.NewSnapshotId()
+	b.deltaStatsSnapshotId = b.rtpStats.NewSnapshotId()
+	b.qualitySnapshotId = b.rtpStats.NewSnapshotId()
+
+	b.clockRate = codec.ClockRate
+	b.lastReport = time.Now()
+	b.mime = strings.ToLower(codec.MimeType)
+	for _, ext := range params.HeaderExtensions {
+		switch ext.URI {
+		case dd.ExtensionURI:
+			b.ddExt = uint8(ext.ID)
+		case sdp.TransportCCURI:
+			b.twccExt = uint8(ext.ID)
+		case sdp.AudioLevelURI:
+			b.audioLevelExt = uint8(ext.ID)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(b.mime, "audio/"):
+		b.codecType = webrtc.RTPCodecTypeAudio
+		b.bucket = bucket.NewBucket(b.audioPool.Get().(*[]byte))
+		if b.audioLevelExt != 0 {
+			b.audioLevel = audio.NewAudioLevel(b.audioLevelParams)
+		}
+	case strings.HasPrefix(b.mime, "video/"):
+		b.codecType = webrtc.RTPCodecTypeVideo
+		b.bucket = bucket.NewBucket(b.videoPool.Get().(*[]byte))
+		if b.ddExt != 0 {
+			// 最大层由接收器按收到的层统计，这里不需要回调
+			b.ddParser = NewDependencyDescriptorParser(b.ddExt, b.logger, func(int32, int32) {})
+		}
+	default:
+		b.codecType = webrtc.RTPCodecType(0)
+	}
+
+	for _, fb := range codec.RTCPFeedback {
+		switch fb.Type {
+		case webrtc.TypeRTCPFBTransportCC:
+			if b.twccExt != 0 {
+				b.twcc = twcc.NewTransportWideCCResponder(b.mediaSSRC)
+				b.twcc.OnFeedback(func(p rtcp.RawPacket) {
+					b.sendRTCP([]rtcp.Packet{&p})
+				})
+			}
+		case webrtc.TypeRTCPFBNACK:
+			if fb.Parameter == "" && b.codecType == webrtc.RTPCodecTypeVideo {
+				b.nacker = nack.NewNACKQueue()
+			}
+		}
+	}
+
+	for _, pp := range b.pPackets {
+		b.calc(pp.packet, pp.arrivalTime)
+	}
+	b.pPackets = nil
+	b.bound = true
+
+	b.logger.Debugw("bound buffer", "mime", b.mime, "ssrc", b.mediaSSRC)
+}
+
+// Write 由 pion 写入解密后的 RTP 包，绑定之前先缓存
+func (b *Buffer) Write(pkt []byte) (n int, err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed.Load() {
+		err = io.EOF
+		return
+	}
+
+	if !b.bound {
+		packet := make([]byte, len(pkt))
+		copy(packet, pkt)
+		b.pPackets = append(b.pPackets, pendingPacket{
+			packet:      packet,
+			arrivalTime: time.Now(),
+		})
+		if len(b.pPackets) > InitPacketBufferSize {
+			b.pPackets = b.pPackets[len(b.pPackets)-InitPacketBufferSize:]
+			b.lastPacketRead = 0
+		}
+		b.readCond.Broadcast()
+		return len(pkt), nil
+	}
+
+	b.calc(pkt, time.Now())
+	return len(pkt), nil
+}
+
+// Read 供 pion 读取绑定之前缓存的包，用于识别 RID 等信息，媒体数据通过 ReadExtended 读取
+func (b *Buffer) Read(buff []byte) (n int, err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	for {
+		if b.closed.Load() {
+			return 0, io.EOF
+		}
+		if len(b.pPackets) > b.lastPacketRead {
+			if len(buff) < len(b.pPackets[b.lastPacketRead].packet) {
+				return 0, ErrBufferTooSmall
+			}
+			n = copy(buff, b.pPackets[b.lastPacketRead].packet)
+			b.lastPacketRead++
+			return n, nil
+		}
+		// 绑定之后 pion 不再需要读取，一直等到关闭
+		b.readCond.Wait()
+	}
+}
+
+// ReadExtended 读取下一个解析后的包，没有包时阻塞，关闭后返回 io.EOF
+func (b *Buffer) ReadExtended() (*ExtPacket, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	for {
+		if b.closed.Load() {
+			return nil, io.EOF
+		}
+		if b.extPackets.Len() > 0 {
+			return b.extPackets.PopFront(), nil
+		}
+		b.readCond.Wait()
+	}
+}
+
+// GetPacket 从缓存中读取指定序列号的原始包，用于重传
+func (b *Buffer) GetPacket(buff []byte, sn uint16) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed.Load() {
+		return 0, io.EOF
+	}
+	if b.bucket == nil {
+		return 0, ErrPacketNotFound
+	}
+	return b.bucket.GetPacket(buff, sn)
+}
+
+// Close 关闭缓冲，回收缓存并通知读取方
+func (b *Buffer) Close() error {
+	b.closeOnce.Do(func() {
+		b.Lock()
+		b.closed.Store(true)
+		if b.bucket != nil {
+			if b.codecType == webrtc.RTPCodecTypeVideo {
+				b.videoPool.Put(b.bucket.Src())
+			} else {
+				b.audioPool.Put(b.bucket.Src())
+			}
+			b.bucket = nil
+		}
+		var rtpStats *RTPStats
+		if b.rtpStats != nil {
+			b.rtpStats.Stop()
+			rtpStats = b.rtpStats
+			b.logger.Debugw("rtp stats", "direction", "upstream", "stats", b.rtpStats.ToString())
+		}
+		onFinalRtpStats := b.onFinalRtpStats
+		onClose := b.onClose
+		b.readCond.Broadcast()
+		b.Unlock()
+
+		if rtpStats != nil && onFinalRtpStats != nil {
+			onFinalRtpStats(rtpStats)
+		}
+		if onClose != nil {
+			onClose()
+		}
+	})
+	return nil
+}
+
+// OnClose 关闭事件
+func (b *Buffer) OnClose(fn func()) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.onClose = fn
+}
+
+// OnRtcpFeedback 需要发给发布者的 RTCP，包括接收报告、NACK、PLI 和 TWCC
+func (b *Buffer) OnRtcpFeedback(fn func(fb []rtcp.Packet)) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.onRtcpFeedback = fn
+}
+
+// OnRtcpSenderReport 收到发布者的发送报告事件
+func (b *Buffer) OnRtcpSenderReport(fn func()) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.onRtcpSenderReport = fn
+}
+
+// OnFinalRtpStats 关闭时的接收统计
+func (b *Buffer) OnFinalRtpStats(fn func(*RTPStats)) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.onFinalRtpStats = fn
+}
+
+// SendPLI 向发布者请求关键帧，force 为 false 时按间隔限流
+func (b *Buffer) SendPLI(force bool) {
+	b.RLock()
+	rtpStats := b.rtpStats
+	pliThrottle := b.pliThrottle
+	b.RUnlock()
+
+	if rtpStats == nil || (!force && rtpStats.TimeSinceLastPli() < pliThrottle) {
+		return
+	}
+
+	rtpStats.UpdatePliAndTime(1)
+	b.sendRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{SenderSSRC: b.mediaSSRC, MediaSSRC: b.mediaSSRC},
+	})
+}
+
+// SetSenderReportData 发布者的发送报告，用于对齐 RTP 时间戳和 NTP 时间
+func (b *Buffer) SetSenderReportData(rtpTime uint32, ntpTime uint64) {
+	b.RLock()
+	rtpStats := b.rtpStats
+	onRtcpSenderReport := b.onRtcpSenderReport
+	b.RUnlock()
+
+	if rtpStats == nil {
+		return
+	}
+	rtpStats.SetRtcpSenderReportData(&RTCPSenderReportData{
+		RTPTimestamp: rtpTime,
+		NTPTimestamp: mediatransportutil.NtpTime(ntpTime),
+		At:           time.Now(),
+	})
+	if onRtcpSenderReport != nil {
+		onRtcpSenderReport()
+	}
+}
+
+// GetSenderReportData 最早和最新的发送报告
+func (b *Buffer) GetSenderReportData() (*RTCPSenderReportData, *RTCPSenderReportData) {
+	b.RLock()
+	defer b.RUnlock()
+
+	if b.rtpStats == nil {
+		return nil, nil
+	}
+	return b.rtpStats.GetRtcpSenderReportData()
+}
+
+// SetRTT 往返时延，用于 NACK 的重发间隔
+func (b *Buffer) SetRTT(rtt uint32) {
+	b.Lock()
+	defer b.Unlock()
+
+	if rtt == 0 {
+		return
+	}
+	if b.nacker != nil {
+		b.nacker.SetRTT(rtt)
+	}
+	if b.rtpStats != nil {
+		b.rtpStats.UpdateRtt(rtt)
+	}
+}
+
+// SetLastFractionLostReport 订阅者报告的丢包比例，转发给发布者，仅用于音频
+func (b *Buffer) SetLastFractionLostReport(lost uint8) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.lastFractionLostToReport = lost
+}
+
+// GetAudioLevel 平滑后的音频级别
+func (b *Buffer) GetAudioLevel() (float64, bool) {
+	b.RLock()
+	defer b.RUnlock()
+
+	if b.audioLevel == nil {
+		return 0, false
+	}
+	return b.audioLevel.GetLevel()
+}
+
+// GetStats 接收统计
+func (b *Buffer) GetStats() *tc.RTPStats {
+	b.RLock()
+	defer b.RUnlock()
+
+	if b.rtpStats == nil {
+		return nil
+	}
+	return b.rtpStats.ToProto()
+}
+
+// GetDeltaStats 上次调用以来的接收统计，还没有收到数据时返回 nil
+func (b *Buffer) GetDeltaStats() *RTPDeltaInfo {
+	b.RLock()
+	defer b.RUnlock()
+
+	if b.rtpStats == nil {
+		return nil
+	}
+	return b.rtpStats.DeltaInfo(b.deltaStatsSnapshotId)
+}
+
+// GetQualityDeltaStats 连接质量评分使用的上次调用以来的接收统计，还没有收到数据时返回 nil
+func (b *Buffer) GetQualityDeltaStats() *RTPDeltaInfo {
+	b.RLock()
+	defer b.RUnlock()
+
+	if b.rtpStats == nil {
+		return nil
+	}
+	return b.rtpStats.DeltaInfo(b.qualitySnapshotId)
+}
+
+// calc 处理收到的包：更新统计、缓存、NACK 和 TWCC，解析后放入读取队列，需要持有锁
+func (b *Buffer) calc(pkt []byte, arrivalTime time.Time) {
+	var rtpPacket rtp.Packet
+	if err := rtpPacket.Unmarshal(pkt); err != nil {
+		b.logger.Warnw("could not unmarshal RTP packet", err)
+		return
+	}
+
+	flowState := b.rtpStats.Update(&rtpPacket.Header, len(rtpPacket.Payload), int(rtpPacket.PaddingSize), arrivalTime)
+	if b.nacker != nil {
+		b.nacker.Remove(rtpPacket.SequenceNumber)
+		if flowState.HasLoss {
+			for lost := flowState.LossStartInclusive; lost != flowState.LossEndExclusive; lost++ {
+				b.nacker.Push(lost)
+			}
+		}
+	}
+
+	if b.twcc != nil {
+		if ext := rtpPacket.GetExtension(b.twccExt); len(ext) > 1 {
+			b.twcc.Push(binary.BigEndian.Uint16(ext[0:2]), arrivalTime.UnixNano(), rtpPacket.Marker)
+		}
+	}
+
+	b.doReports(arrivalTime)
+
+	// 只有填充的包不需要缓存和转发
+	if len(rtpPacket.Payload) == 0 {
+		return
+	}
+
+	stored, err := b.bucket.AddPacket(pkt)
+	if err != nil {
+		if !errors.Is(err, bucket.ErrRTXPacket) {
+			b.logger.Debugw("could not add packet to bucket", "error", err, "sn", rtpPacket.SequenceNumber)
+		}
+		return
+	}
+	// 缓存中的包在重传时使用，转发的包引用缓存中的数据
+	if err = rtpPacket.Unmarshal(stored); err != nil {
+		return
+	}
+
+	if b.audioLevel != nil {
+		b.observeAudioLevel(&rtpPacket)
+	}
+
+	ep := b.getExtPacket(&rtpPacket, arrivalTime)
+	if ep == nil || b.paused {
+		return
+	}
+	b.extPackets.PushBack(ep)
+	b.readCond.Broadcast()
+}
+
+// observeAudioLevel 从头扩展中读取音频级别，持续时间按时间戳的差值计算
+func (b *Buffer) observeAudioLevel(rtpPacket *rtp.Packet) {
+	ext := rtpPacket.GetExtension(b.audioLevelExt)
+	if ext == nil {
+		return
+	}
+	var ext1 rtp.AudioLevelExtension
+	if err := ext1.Unmarshal(ext); err != nil {
+		return
+	}
+
+	if !b.latestTSForAudioLevelInitialized {
+		b.latestTSForAudioLevelInitialized = true
+		b.latestTSForAudioLevel = rtpPacket.Timestamp
+		return
+	}
+	if diff := rtpPacket.Timestamp - b.latestTSForAudioLevel; diff < 1<<31 {
+		if diff != 0 && b.clockRate != 0 {
+			b.audioLevel.Observe(ext1.Level, diff*1000/b.clockRate)
+		}
+		b.latestTSForAudioLevel = rtpPacket.Timestamp
+	}
+}
+
+// getExtPacket 解析关键帧和时间层
+func (b *Buffer) getExtPacket(rtpPacket *rtp.Packet, arrivalTime time.Time) *ExtPacket {
+	ep := &ExtPacket{
+		Packet:  rtpPacket,
+		Arrival: arrivalTime,
+		VideoLayer: VideoLayer{
+			Spatial:  InvalidLayerSpatial,
+			Temporal: InvalidLayerTemporal,
+		},
+		RawPacket: rtpPacket.Payload,
+	}
+	if b.codecType != webrtc.RTPCodecTypeVideo {
+		ep.VideoLayer = VideoLayer{}
+		return ep
+	}
+
+	ep.Temporal = 0
+	if b.ddParser != nil {
+		ddVal, videoLayer, err := b.ddParser.Parse(rtpPacket)
+		if err != nil {
+			return nil
+		}
+		if ddVal != nil {
+			ep.DependencyDescriptor = ddVal
+			ep.VideoLayer = videoLayer
+		}
+	}
+
+	switch b.mime {
+	case "video/vp8":
+		vp8Packet := VP8{}
+		if err := vp8Packet.Unmarshal(rtpPacket.Payload); err != nil {
+			b.logger.Warnw("could not unmarshal VP8 packet", err)
+			return nil
+		}
+		ep.KeyFrame = vp8Packet.IsKeyFrame
+		if ep.DependencyDescriptor == nil && vp8Packet.T {
+			ep.Temporal = int32(vp8Packet.TID)
+		}
+		ep.Payload = vp8Packet
+	case "video/h264":
+		ep.KeyFrame = IsH264KeyFrame(rtpPacket.Payload)
+	case "video/vp9":
+		ep.KeyFrame = IsVP9KeyFrame(rtpPacket.Payload)
+	case "video/av1":
+		ep.KeyFrame = IsAV1KeyFrame(rtpPacket.Payload)
+	}
+
+	if ep.KeyFrame {
+		b.rtpStats.UpdateKeyFrame(1)
+	}
+	return ep
+}
+
+// doReports 每隔 ReportDelta 向发布者发送接收报告，并发送需要重传的 NACK
+func (b *Buffer) doReports(arrivalTime time.Time) {
+	if arrivalTime.Sub(b.lastReport) < ReportDelta {
+		return
+	}
+	b.lastReport = arrivalTime
+
+	var pkts []rtcp.Packet
+	if rr := b.rtpStats.SnapshotRtcpReceptionReport(b.mediaSSRC, b.lastFractionLostToReport, b.rrSnapshotId); rr != nil {
+		pkts = append(pkts, &rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{*rr}})
+	}
+	if b.nacker != nil {
+		if nacks, numSeqNumsNacked := b.nacker.Pairs(); len(nacks) > 0 {
+			pkts = append(pkts, &rtcp.TransportLayerNack{
+				SenderSSRC: b.mediaSSRC,
+				MediaSSRC:  b.mediaSSRC,
+				Nacks:      nacks,
+			})
+			b.rtpStats.UpdateNack(uint32(numSeqNumsNacked))
+		}
+	}
+	if len(pkts) != 0 {
+		// 持有锁时不回调，避免回调中再访问缓冲
+		go b.sendRTCP(pkts)
+	}
+}
+
+func (b *Buffer) sendRTCP(pkts []rtcp.Packet) {
+	b.RLock()
+	onRtcpFeedback := b.onRtcpFeedback
+	b.RUnlock()
+
+	if onRtcpFeedback != nil {
+		onRtcpFeedback(pkts)
+	}
+}
@@ -88,3 +88,31 @@ func (f *Factory) GetRTCPReader(ssrc uint32) *RTCPReader {
 	defer f.RUnlock()
 	return f.rtcpReaders[ssrc]
 }
+
+// GetDeltaStats 全部接收缓冲上次调用以来的统计
+func (f *Factory) GetDeltaStats() []*RTPDeltaInfo {
+	f.RLock()
+	buffers := make([]*Buffer, 0, len(f.rtpBuffers))
+	for _, b := range f.rtpBuffers {
+		buffers = append(buffers, b)
+	}
+	f.RUnlock()
+
+	stats := make([]*RTPDeltaInfo, 0, len(buffers))
+	for _, b := range buffers {
+		if delta := b.GetDeltaStats(); delta != nil {
+			stats = append(stats, delta)
+		}
+	}
+	return stats
+}
+
+// CreateBufferFactory 创建共享缓冲池的Buffer工厂，每个参与者一个
+func (f *FactoryOfBufferFactory) CreateBufferFactory() *Factory {
+	return &Factory{
+		videoPool:   f.videoPool,
+		audioPool:   f.audioPool,
+		rtpBuffers:  make(map[uint32]*Buffer),
+		rtcpReaders: make(map[uint32]*RTCPReader),
+	}
+}
@@ -316,6 +316,186 @@ func (r *RTPStats) Seed(from *RTPStats) {
 	}
 }
 
+// RTPStatsState 会话迁移时发送到其他节点的 RTPStats 状态，只包括序列号、时间戳和累计计数，
+// 不包括快照和每个包的记录
+type RTPStatsState struct {
+	StartTime time.Time `json:"startTime"`
+
+	ExtStartSN uint32 `json:"extStartSN"`
+	HighestSN  uint16 `json:"highestSN"`
+	Cycles     uint16 `json:"cycles"`
+
+	ExtHighestSNOverridden uint32               `json:"extHighestSNOverridden"`
+	LastRRTime             time.Time            `json:"lastRRTime"`
+	LastRR                 rtcp.ReceptionReport `json:"lastRR"`
+
+	ExtStartTS uint64 `json:"extStartTS"`
+	HighestTS  uint32 `json:"highestTS"`
+	TSCycles   uint32 `json:"tsCycles"`
+
+	FirstTime   time.Time `json:"firstTime"`
+	HighestTime time.Time `json:"highestTime"`
+
+	Bytes                uint64 `json:"bytes"`
+	HeaderBytes          uint64 `json:"headerBytes"`
+	BytesDuplicate       uint64 `json:"bytesDuplicate"`
+	HeaderBytesDuplicate uint64 `json:"headerBytesDuplicate"`
+	BytesPadding         uint64 `json:"bytesPadding"`
+	HeaderBytesPadding   uint64 `json:"headerBytesPadding"`
+	PacketsDuplicate     uint32 `json:"packetsDuplicate"`
+	PacketsPadding       uint32 `json:"packetsPadding"`
+	PacketsOutOfOrder    uint32 `json:"packetsOutOfOrder"`
+
+	PacketsLost           uint32 `json:"packetsLost"`
+	PacketsLostOverridden uint32 `json:"packetsLostOverridden"`
+
+	Frames uint32 `json:"frames"`
+
+	Jitter              float64 `json:"jitter"`
+	MaxJitter           float64 `json:"maxJitter"`
+	JitterOverridden    float64 `json:"jitterOverridden"`
+	MaxJitterOverridden float64 `json:"maxJitterOverridden"`
+
+	Nacks        uint32 `json:"nacks"`
+	NackAcks     uint32 `json:"nackAcks"`
+	NackMisses   uint32 `json:"nackMisses"`
+	NackRepeated uint32 `json:"nackRepeated"`
+	Plis         uint32 `json:"plis"`
+	Firs         uint32 `json:"firs"`
+	KeyFrames    uint32 `json:"keyFrames"`
+
+	Rtt    uint32 `json:"rtt"`
+	MaxRtt uint32 `json:"maxRtt"`
+}
+
+// GetState 导出可以序列化的状态，没有收到过包时返回 nil
+func (r *RTPStats) GetState() *RTPStatsState {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if !r.initialized {
+		return nil
+	}
+	return &RTPStatsState{
+		StartTime:              r.startTime,
+		ExtStartSN:             r.extStartSN,
+		HighestSN:              r.highestSN,
+		Cycles:                 r.cycles,
+		ExtHighestSNOverridden: r.extHighestSNOverridden,
+		LastRRTime:             r.lastRRTime,
+		LastRR:                 r.lastRR,
+		ExtStartTS:             r.extStartTS,
+		HighestTS:              r.highestTS,
+		TSCycles:               r.tsCycles,
+		FirstTime:              r.firstTime,
+		HighestTime:            r.highestTime,
+		Bytes:                  r.bytes,
+		HeaderBytes:            r.headerBytes,
+		BytesDuplicate:         r.bytesDuplicate,
+		HeaderBytesDuplicate:   r.headerBytesDuplicate,
+		BytesPadding:           r.bytesPadding,
+		HeaderBytesPadding:     r.headerBytesPadding,
+		PacketsDuplicate:       r.packetsDuplicate,
+		PacketsPadding:         r.packetsPadding,
+		PacketsOutOfOrder:      r.packetsOutOfOrder,
+		PacketsLost:            r.packetsLost,
+		PacketsLostOverridden:  r.packetsLostOverridden,
+		Frames:                 r.frames,
+		Jitter:                 r.jitter,
+		MaxJitter:              r.maxJitter,
+		JitterOverridden:       r.jitterOverridden,
+		MaxJitterOverridden:    r.maxJitterOverridden,
+		Nacks:                  r.nacks,
+		NackAcks:               r.nackAcks,
+		NackMisses:             r.nackMisses,
+		NackRepeated:           r.nackRepeated,
+		Plis:                   r.plis,
+		Firs:                   r.firs,
+		KeyFrames:              r.keyFrames,
+		Rtt:                    r.rtt,
+		MaxRtt:                 r.maxRtt,
+	}
+}
+
+// SeedState 使用其他节点导出的状态初始化，之后的包接着之前的序列号和时间戳统计，已有的快照保持不变
+func (r *RTPStats) SeedState(state *RTPStatsState) {
+	if state == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.initialized = true
+
+	r.startTime = state.StartTime
+
+	r.extStartSN = state.ExtStartSN
+	r.highestSN = state.HighestSN
+	r.cycles = state.Cycles
+
+	r.extHighestSNOverridden = state.ExtHighestSNOverridden
+	r.lastRRTime = state.LastRRTime
+	r.lastRR = state.LastRR
+
+	r.extStartTS = state.ExtStartTS
+	r.highestTS = state.HighestTS
+	r.tsCycles = state.TSCycles
+
+	r.firstTime = state.FirstTime
+	r.highestTime = state.HighestTime
+
+	r.bytes = state.Bytes
+	r.headerBytes = state.HeaderBytes
+	r.bytesDuplicate = state.BytesDuplicate
+	r.headerBytesDuplicate = state.HeaderBytesDuplicate
+	r.bytesPadding = state.BytesPadding
+	r.headerBytesPadding = state.HeaderBytesPadding
+	r.packetsDuplicate = state.PacketsDuplicate
+	r.packetsPadding = state.PacketsPadding
+	r.packetsOutOfOrder = state.PacketsOutOfOrder
+
+	r.packetsLost = state.PacketsLost
+	r.packetsLostOverridden = state.PacketsLostOverridden
+
+	r.frames = state.Frames
+
+	r.jitter = state.Jitter
+	r.maxJitter = state.MaxJitter
+	r.jitterOverridden = state.JitterOverridden
+	r.maxJitterOverridden = state.MaxJitterOverridden
+
+	r.nacks = state.Nacks
+	r.nackAcks = state.NackAcks
+	r.nackMisses = state.NackMisses
+	r.nackRepeated = state.NackRepeated
+	r.plis = state.Plis
+	r.firs = state.Firs
+	r.keyFrames = state.KeyFrames
+
+	r.rtt = state.Rtt
+	r.maxRtt = state.MaxRtt
+
+	// 快照从导入的位置开始计算增量，之前节点上的统计不再重复上报
+	for id := uint32(FirstSnapshotId); id < r.nextSnapshotId; id++ {
+		r.snapshots[id] = &Snapshot{
+			startTime:             time.Now(),
+			extStartSN:            r.getExtHighestSN() + 1,
+			extStartSNOverridden:  r.getExtHighestSNAdjusted() + 1,
+			packetsDuplicate:      r.packetsDuplicate,
+			bytesDuplicate:        r.bytesDuplicate,
+			headerBytesDuplicate:  r.headerBytesDuplicate,
+			packetsLostOverridden: r.packetsLostOverridden,
+			nacks:                 r.nacks,
+			plis:                  r.plis,
+			firs:                  r.firs,
+			maxJitter:             r.jitter,
+			maxJitterOverridden:   r.jitterOverridden,
+			maxRtt:                r.rtt,
+		}
+	}
+}
+
 func (r *RTPStats) SetLogger(logger logger.Logger) {
 	r.logger = logger
 }
@@ -557,14 +737,20 @@ func (r *RTPStats) UpdateFromReceiverReport(rr rtcp.ReceptionReport) (rtt uint32
 		return
 	}
 
-	var err error
-	if r.srNewest != nil {
-		rtt, err = mediatransportutil.GetRttMs(&rr, r.srNewest.NTPTimestamp, r.srNewest.At)
-		if err == nil {
-			isRttChanged = rtt != r.rtt
-		} else {
-			if !errors.Is(err, mediatransportutil.ErrRttNotLastSenderReport) && !errors.Is(err, mediatransportutil.ErrRttNoLastSenderReport) {
-				r.logger.Warnw("error getting rtt", err)
+	if r.lastRRTime.IsZero() || r.extHighestSNOverridden <= rr.LastSequenceNumber {
+		r.extHighestSNOverridden = rr.LastSequenceNumber
+		r.packetsLostOverridden = rr.TotalLost
+
+		// 没有发送过发送者报告时无法计算 RTT，但是丢包和抖动仍然有效
+		if r.srNewest != nil {
+			var err error
+			rtt, err = mediatransportutil.GetRttMs(&rr, r.srNewest.NTPTimestamp, r.srNewest.At)
+			if err == nil {
+				isRttChanged = rtt != r.rtt
+			} else {
+				if !errors.Is(err, mediatransportutil.ErrRttNotLastSenderReport) && !errors.Is(err, mediatransportutil.ErrRttNoLastSenderReport) {
+					r.logger.Warnw("error getting rtt", err)
+				}
 			}
 		}
 
@@ -0,0 +1,175 @@
+package connectionquality
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+)
+
+// DefaultUpdateInterval 默认的评分周期
+const DefaultUpdateInterval = 5 * time.Second
+
+// ConnectionStatsParams 连接质量统计参数
+type ConnectionStatsParams struct {
+	UpdateInterval time.Duration
+	MimeType       string
+	// IsDTXEnabled 音频开启 DTX 时静音期间不发送数据
+	IsDTXEnabled bool
+	// IsFECEnabled 音频开启带内 FEC
+	IsFECEnabled bool
+	// GetDeltaStats 获取上次调用以来的统计，没有数据时返回 nil
+	GetDeltaStats func() *buffer.RTPDeltaInfo
+	// GetExpectedBitrate 当前期望层的码率，未知时返回 0
+	GetExpectedBitrate func() int64
+	Logger             logger.Logger
+}
+
+// ConnectionStats 周期性地对一个音轨的连接质量评分，质量等级变化时通知
+type ConnectionStats struct {
+	params ConnectionStatsParams
+
+	lock     sync.RWMutex
+	scorer   *qualityScorer
+	hasStats bool
+	quality  Quality
+
+	onQualityChanged func(cs *ConnectionStats, score float32, quality Quality)
+
+	done atomic.Bool
+	stop chan struct{}
+}
+
+// NewConnectionStats 创建连接质量统计，需要调用 Start 开始评分
+func NewConnectionStats(params ConnectionStatsParams) *ConnectionStats {
+	if params.UpdateInterval == 0 {
+		params.UpdateInterval = DefaultUpdateInterval
+	}
+	if params.Logger == nil {
+		params.Logger = logger.GetLogger()
+	}
+
+	return &ConnectionStats{
+		params: params,
+		scorer: newQualityScorer(scorerParams{
+			isVideo:      strings.HasPrefix(strings.ToLower(params.MimeType), "video/"),
+			isDTXEnabled: params.IsDTXEnabled,
+			isFECEnabled: params.IsFECEnabled,
+		}),
+		quality: QualityExcellent,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start 开始周期性评分
+func (cs *ConnectionStats) Start() {
+	go cs.updateStatsWorker()
+}
+
+// Close 停止评分
+func (cs *ConnectionStats) Close() {
+	if cs.done.Swap(true) {
+		return
+	}
+	close(cs.stop)
+}
+
+// OnQualityChanged 质量等级变化事件
+func (cs *ConnectionStats) OnQualityChanged(fn func(cs *ConnectionStats, score float32, quality Quality)) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	cs.onQualityChanged = fn
+}
+
+// UpdateMute 更新静音状态，静音期间保持最高分
+func (cs *ConnectionStats) UpdateMute(muted bool) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	cs.scorer.updateMute(muted)
+	if muted {
+		cs.quality = QualityExcellent
+	}
+}
+
+// GetScoreAndQuality 当前的分数和质量等级
+func (cs *ConnectionStats) GetScoreAndQuality() (float32, Quality) {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+
+	return cs.scorer.getScore(), cs.quality
+}
+
+// update 计算一个周期的分数，质量等级变化时通知
+func (cs *ConnectionStats) update() {
+	stats := cs.params.GetDeltaStats()
+	var expectedBitrate int64
+	if cs.params.GetExpectedBitrate != nil {
+		expectedBitrate = cs.params.GetExpectedBitrate()
+	}
+
+	cs.lock.Lock()
+	if stats == nil && !cs.hasStats {
+		// 还没有开始转发
+		cs.lock.Unlock()
+		return
+	}
+	cs.hasStats = true
+
+	score := cs.scorer.update(stats, expectedBitrate)
+	quality := Score2Quality(score)
+	if quality == cs.quality {
+		cs.lock.Unlock()
+		return
+	}
+
+	cs.params.Logger.Debugw("connection quality changed", "from", cs.quality, "to", quality, "score", score)
+	cs.quality = quality
+	onQualityChanged := cs.onQualityChanged
+	cs.lock.Unlock()
+
+	if onQualityChanged != nil {
+		onQualityChanged(cs, score, quality)
+	}
+}
+
+func (cs *ConnectionStats) updateStatsWorker() {
+	ticker := time.NewTicker(cs.params.UpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cs.stop:
+			return
+		case <-ticker.C:
+			cs.update()
+		}
+	}
+}
+
+// AggregateScoreAndQuality 多个音轨的综合质量，取最差的一个，没有音轨时为最高分
+func AggregateScoreAndQuality(scores []float32) (float32, Quality) {
+	minScore := MaxMOS
+	for _, score := range scores {
+		if score < minScore {
+			minScore = score
+		}
+	}
+	return minScore, Score2Quality(minScore)
+}
+
+// ToConnectionQualityInfo 生成参与者的连接质量信息
+func ToConnectionQualityInfo(pID tc.ParticipantID, score float32, quality Quality) *tc.ConnectionQualityInfo {
+	return &tc.ConnectionQualityInfo{
+		ParticipantSid: string(pID),
+		Quality:        quality.ToProto(),
+		Score:          score,
+	}
+}
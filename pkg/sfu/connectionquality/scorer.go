@@ -0,0 +1,202 @@
+package connectionquality
+
+import (
+	"math"
+	"time"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+)
+
+const (
+	// MaxMOS 网络没有损伤时能够得到的最高分
+	MaxMOS = float32(4.5)
+	// MinMOS 有媒体流时的最低分，没有收到任何数据时分数为 0
+	MinMOS = float32(1.0)
+
+	// 质量等级对应的最低分数
+	excellentMinMOS = float32(4.0)
+	goodMinMOS      = float32(3.0)
+
+	// scoreIncreaseFactor 质量恢复时每个周期向新分数靠近的比例，质量下降时立即生效，避免等级来回跳变
+	scoreIncreaseFactor = float32(0.5)
+
+	// E-model 的基础 R 值
+	baseRFactor = 93.2
+	// 编解码和打包带来的固定延迟
+	codecDelayMs = 20.0
+
+	// 丢包鲁棒因子，越大对丢包越不敏感，Opus 开启带内 FEC 后抗丢包能力更强
+	audioBurstR    = 10.0
+	audioFECBurstR = 20.0
+	videoBurstR    = 6.0
+
+	// 实际码率低于期望码率时对视频分数的影响，码率为 0 时保留该比例的分数
+	videoMinBitrateWeight = 0.4
+)
+
+// Quality 连接质量等级，比协议多出 LOST 表示一个周期内没有收到任何数据
+type Quality int
+
+const (
+	QualityLost Quality = iota
+	QualityPoor
+	QualityGood
+	QualityExcellent
+)
+
+func (q Quality) String() string {
+	switch q {
+	case QualityLost:
+		return "LOST"
+	case QualityPoor:
+		return "POOR"
+	case QualityGood:
+		return "GOOD"
+	case QualityExcellent:
+		return "EXCELLENT"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ToProto 转换为协议中的质量等级，协议中没有 LOST，使用 POOR 表示
+func (q Quality) ToProto() tc.ConnectionQuality {
+	switch q {
+	case QualityExcellent:
+		return tc.ConnectionQuality_EXCELLENT
+	case QualityGood:
+		return tc.ConnectionQuality_GOOD
+	default:
+		return tc.ConnectionQuality_POOR
+	}
+}
+
+// Score2Quality 分数对应的质量等级
+func Score2Quality(score float32) Quality {
+	switch {
+	case score <= 0:
+		return QualityLost
+	case score >= excellentMinMOS:
+		return QualityExcellent
+	case score >= goodMinMOS:
+		return QualityGood
+	default:
+		return QualityPoor
+	}
+}
+
+// scorerParams 评分模型参数
+type scorerParams struct {
+	isVideo      bool
+	isDTXEnabled bool
+	isFECEnabled bool
+}
+
+// qualityScorer 根据每个周期的 RTP 统计计算 MOS 风格的分数，非线程安全
+type qualityScorer struct {
+	params scorerParams
+
+	muted bool
+	score float32
+}
+
+func newQualityScorer(params scorerParams) *qualityScorer {
+	return &qualityScorer{
+		params: params,
+		score:  MaxMOS,
+	}
+}
+
+// updateMute 静音期间没有数据是正常的，保持最高分
+func (q *qualityScorer) updateMute(muted bool) {
+	q.muted = muted
+	if muted {
+		q.score = MaxMOS
+	}
+}
+
+// update 使用一个周期的统计更新分数，stats 为 nil 表示该周期内没有任何数据，
+// expectedBitrate 为当前期望层的码率，未知时为 0
+func (q *qualityScorer) update(stats *buffer.RTPDeltaInfo, expectedBitrate int64) float32 {
+	if q.muted {
+		return q.score
+	}
+
+	var score float32
+	switch {
+	case stats == nil || stats.Packets == 0:
+		if !q.params.isVideo && q.params.isDTXEnabled {
+			// 开启 DTX 后静音时不发送数据，不能认为连接已经中断
+			return q.score
+		}
+		score = 0
+	case q.params.isVideo:
+		score = videoScore(stats, expectedBitrate)
+	default:
+		burstR := audioBurstR
+		if q.params.isFECEnabled {
+			burstR = audioFECBurstR
+		}
+		score = rToMOS(rFactor(stats, burstR))
+	}
+
+	if score > q.score && q.score > 0 {
+		score = q.score + (score-q.score)*scoreIncreaseFactor
+	}
+	q.score = score
+	return q.score
+}
+
+// getScore 当前分数
+func (q *qualityScorer) getScore() float32 {
+	return q.score
+}
+
+// videoScore 在丢包和延迟的基础上，按照实际码率与期望码率的比例降低分数，
+// 订阅端被降级到低分辨率层时也应该体现为质量下降
+func videoScore(stats *buffer.RTPDeltaInfo, expectedBitrate int64) float32 {
+	mos := rToMOS(rFactor(stats, videoBurstR))
+	if expectedBitrate <= 0 || stats.Duration <= 0 {
+		return mos
+	}
+
+	bitrate := float64(stats.Bytes+stats.HeaderBytes) * 8 / stats.Duration.Seconds()
+	ratio := math.Min(bitrate/float64(expectedBitrate), 1)
+	weight := videoMinBitrateWeight + (1-videoMinBitrateWeight)*ratio
+	return MinMOS + (mos-MinMOS)*float32(weight)
+}
+
+// rFactor 简化的 E-model，R = R0 - Id - Ie_eff
+func rFactor(stats *buffer.RTPDeltaInfo, burstR float64) float64 {
+	// 单向延迟取 RTT 的一半，抖动缓冲按两倍抖动估算
+	jitterMs := stats.JitterMax / float64(time.Millisecond/time.Microsecond)
+	delayMs := float64(stats.RttMax)/2 + jitterMs*2 + codecDelayMs
+	delayImpairment := 0.024 * delayMs
+	if delayMs > 177.3 {
+		delayImpairment += 0.11 * (delayMs - 177.3)
+	}
+
+	lossPercentage := 0.0
+	// Packets 是期望收到的包数，已经包含丢失的包
+	if stats.Packets > 0 {
+		lossPercentage = float64(stats.PacketsLost) * 100 / float64(stats.Packets)
+	}
+	lossImpairment := 95 * lossPercentage / (lossPercentage + burstR)
+
+	return baseRFactor - delayImpairment - lossImpairment
+}
+
+// rToMOS R 值转换为 MOS 分数
+func rToMOS(r float64) float32 {
+	switch {
+	case r <= 0:
+		return MinMOS
+	case r >= 100:
+		return MaxMOS
+	}
+
+	mos := 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+	return float32(math.Max(float64(MinMOS), math.Min(mos, float64(MaxMOS))))
+}
@@ -0,0 +1,109 @@
+package connectionquality
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+)
+
+func audioStats(packets, lost uint32, rttMs uint32) *buffer.RTPDeltaInfo {
+	return &buffer.RTPDeltaInfo{
+		Duration:    5 * time.Second,
+		Packets:     packets,
+		PacketsLost: lost,
+		RttMax:      rttMs,
+		JitterMax:   5000,
+	}
+}
+
+func TestAudioScore(t *testing.T) {
+	t.Run("no impairment", func(t *testing.T) {
+		q := newQualityScorer(scorerParams{})
+		require.Equal(t, QualityExcellent, Score2Quality(q.update(audioStats(250, 0, 50), 0)))
+	})
+
+	t.Run("loss", func(t *testing.T) {
+		q := newQualityScorer(scorerParams{})
+		require.Equal(t, QualityGood, Score2Quality(q.update(audioStats(250, 12, 50), 0)))
+
+		q = newQualityScorer(scorerParams{})
+		require.Equal(t, QualityPoor, Score2Quality(q.update(audioStats(250, 50, 50), 0)))
+
+		// 期望的包全部丢失
+		q = newQualityScorer(scorerParams{})
+		require.Equal(t, QualityPoor, Score2Quality(q.update(audioStats(250, 250, 50), 0)))
+	})
+
+	t.Run("fec tolerates more loss", func(t *testing.T) {
+		withoutFEC := newQualityScorer(scorerParams{}).update(audioStats(250, 5, 50), 0)
+		withFEC := newQualityScorer(scorerParams{isFECEnabled: true}).update(audioStats(250, 5, 50), 0)
+		require.Greater(t, withFEC, withoutFEC)
+	})
+
+	t.Run("high rtt", func(t *testing.T) {
+		q := newQualityScorer(scorerParams{})
+		require.Less(t, q.update(audioStats(250, 0, 800), 0), newQualityScorer(scorerParams{}).update(audioStats(250, 0, 50), 0))
+	})
+
+	t.Run("dtx silence is not lost", func(t *testing.T) {
+		q := newQualityScorer(scorerParams{isDTXEnabled: true})
+		require.Equal(t, QualityExcellent, Score2Quality(q.update(nil, 0)))
+
+		q = newQualityScorer(scorerParams{})
+		require.Equal(t, QualityLost, Score2Quality(q.update(nil, 0)))
+	})
+
+	t.Run("muted", func(t *testing.T) {
+		q := newQualityScorer(scorerParams{})
+		q.updateMute(true)
+		require.Equal(t, MaxMOS, q.update(nil, 0))
+	})
+}
+
+func TestVideoScore(t *testing.T) {
+	stats := &buffer.RTPDeltaInfo{
+		Duration: time.Second,
+		Packets:  100,
+		Bytes:    125_000,
+		RttMax:   50,
+	}
+
+	q := newQualityScorer(scorerParams{isVideo: true})
+	require.Equal(t, QualityExcellent, Score2Quality(q.update(stats, 1_000_000)))
+
+	// 只收到期望码率的一小部分
+	q = newQualityScorer(scorerParams{isVideo: true})
+	require.Equal(t, QualityPoor, Score2Quality(q.update(stats, 10_000_000)))
+
+	q = newQualityScorer(scorerParams{isVideo: true})
+	require.Equal(t, QualityLost, Score2Quality(q.update(nil, 1_000_000)))
+}
+
+func TestScoreRecovery(t *testing.T) {
+	q := newQualityScorer(scorerParams{})
+	poor := q.update(audioStats(200, 50, 50), 0)
+	require.Equal(t, QualityPoor, Score2Quality(poor))
+
+	// 恢复是逐步的
+	recovered := q.update(audioStats(250, 0, 50), 0)
+	require.Greater(t, recovered, poor)
+	require.Less(t, recovered, newQualityScorer(scorerParams{}).update(audioStats(250, 0, 50), 0))
+
+	for i := 0; i < 5; i++ {
+		recovered = q.update(audioStats(250, 0, 50), 0)
+	}
+	require.Equal(t, QualityExcellent, Score2Quality(recovered))
+}
+
+func TestQualityToProto(t *testing.T) {
+	require.Equal(t, QualityPoor.ToProto(), QualityLost.ToProto())
+	score, quality := AggregateScoreAndQuality(nil)
+	require.Equal(t, MaxMOS, score)
+	require.Equal(t, QualityExcellent, quality)
+
+	_, quality = AggregateScoreAndQuality([]float32{4.4, 0, 3.5})
+	require.Equal(t, QualityLost, quality)
+}
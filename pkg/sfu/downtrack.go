@@ -1,17 +1,25 @@
 package sfu
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/liuhailove/tc-server/pkg/sfu/pacer"
 	"github.com/pion/rtcp"
 	"go.uber.org/atomic"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/liuhailove/tc-base-go/mediatransportutil/pkg/bucket"
 	"github.com/liuhailove/tc-base-go/protocol/logger"
 	"github.com/liuhailove/tc-base-go/protocol/tc"
 	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+	"github.com/liuhailove/tc-server/pkg/sfu/connectionquality"
+	"github.com/liuhailove/tc-server/pkg/sfu/dependencydescriptor"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/transport/v2/packetio"
 	"github.com/pion/webrtc/v3"
 )
 
@@ -118,6 +126,39 @@ type DownTrackState struct {
 	ForwarderState                 ForwarderState
 }
 
+// downTrackStateWire DownTrackState 在节点之间传递时的格式，快照只在同一个进程内有效，不会发送
+type downTrackStateWire struct {
+	RTPStats       *buffer.RTPStatsState `json:"rtpStats,omitempty"`
+	ForwarderState ForwarderState        `json:"forwarderState"`
+}
+
+// MarshalJSON 序列化后发送到迁移的目标节点
+func (d DownTrackState) MarshalJSON() ([]byte, error) {
+	wire := downTrackStateWire{ForwarderState: d.ForwarderState}
+	if d.RTPStats != nil {
+		wire.RTPStats = d.RTPStats.GetState()
+	}
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON 解析其他节点导出的状态，快照ID为 0 表示没有可以继承的快照
+func (d *DownTrackState) UnmarshalJSON(data []byte) error {
+	wire := downTrackStateWire{}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+
+	*d = DownTrackState{ForwarderState: wire.ForwarderState}
+	if wire.RTPStats != nil {
+		d.RTPStats = buffer.NewRTPStats(buffer.RTPStatsParams{
+			IsReceiverReportDriven: true,
+			Logger:                 logger.GetLogger(),
+		})
+		d.RTPStats.SeedState(wire.RTPStats)
+	}
+	return nil
+}
+
 func (d DownTrackState) String() string {
 	return fmt.Sprintf("DownTrackState{rtpStats: %s, delta: %d, deltaOverridden: %d, forwarder: %s}",
 		d.RTPStats.ToString(), d.DeltaStatsSnapshotId, d.DeltaStatsOverriddenSnapshotId, d.ForwarderState.String())
@@ -212,6 +253,7 @@ type DownTrack struct {
 	absSendTimeExtID          int
 	transportWideExtID        int
 	dependencyDescriptorExtID int
+	upstreamDDExtID           int // 上行包中依赖描述符的扩展ID
 	playoutDelayExtID         int
 	transceiver               atomic.Value
 	writeStream               webrtc.TrackLocalWriter
@@ -247,11 +289,16 @@ type DownTrack struct {
 	streamAllocatorListener         DownTrackStreamAllocatorListener
 	streamAllocatorReportGeneration int
 	streamAllocatorBytesCount       atomic.Uint32
+	priority                        atomic.Uint32 // 订阅者设置的优先级，1 最高，0 表示未设置
 	bytesSent                       atomic.Uint32
 	bytesRetransmitted              atomic.Uint32
 
 	playoutDelayBytes atomic.Value // 编组播放延迟的字节数
 	playoutDelayAcked atomic.Bool
+	playoutDelayLock  sync.Mutex
+	playoutDelay      PlayoutDelay
+	playoutDelaySN    uint16 // 第一个携带当前延迟的包
+	playoutDelaySent  bool
 
 	pacer pacer.Pacer
 
@@ -263,3 +310,846 @@ type DownTrack struct {
 	onRttUpdate                 func(dt *DownTrack, rtt uint32)
 	onCloseHandler              func(willBeResumed bool)
 }
+
+// NewDownTrack 创建下行音轨，codecs 为发布者协商出的全部编解码器
+func NewDownTrack(params DownTrackParams) (*DownTrack, error) {
+	if len(params.Codec) == 0 {
+		return nil, ErrUnknownKind
+	}
+
+	var kind webrtc.RTPCodecType
+	switch {
+	case strings.HasPrefix(strings.ToLower(params.Codec[0].MimeType), "audio/"):
+		kind = webrtc.RTPCodecTypeAudio
+	case strings.HasPrefix(strings.ToLower(params.Codec[0].MimeType), "video/"):
+		kind = webrtc.RTPCodecTypeVideo
+	default:
+		return nil, ErrUnknownKind
+	}
+
+	d := &DownTrack{
+		params:             params,
+		logger:             params.Logger,
+		id:                 params.Receiver.TrackID(),
+		subscriberID:       params.SubID,
+		kind:               kind,
+		streamID:           params.StreamID,
+		maxTrack:           params.MaxTrack,
+		upstreamCodecs:     params.Codec,
+		bufferFactory:      params.BufferFactory,
+		pacer:              params.Pacer,
+		maxLayerNotifierCh: make(chan struct{}, 1),
+	}
+	if d.logger == nil {
+		d.logger = logger.GetLogger()
+	}
+	d.rtpStats = buffer.NewRTPStats(buffer.RTPStatsParams{
+		ClockRate:              params.Codec[0].ClockRate,
+		IsReceiverReportDriven: true,
+		Logger:                 d.logger,
+	})
+	d.deltaStatsSnapshotId = d.rtpStats.NewSnapshotId()
+	d.deltaStatsOverriddenSnapshotId = d.rtpStats.NewSnapshotId()
+	d.forwarder = NewForwarder(kind, params.Codec[0].ClockRate, d.logger)
+
+	d.connectionStats = d.newConnectionStats(connectionquality.DefaultUpdateInterval)
+	d.connectionStats.Start()
+	d.allocateOptimal()
+	return d, nil
+}
+
+// ID 下行音轨ID，与上行音轨相同
+func (d *DownTrack) ID() string { return string(d.id) }
+
+// TrackID 下行音轨ID
+func (d *DownTrack) TrackID() tc.TrackID { return d.id }
+
+// SubscriberID 订阅者ID
+func (d *DownTrack) SubscriberID() tc.ParticipantID { return d.subscriberID }
+
+// StreamID 流ID
+func (d *DownTrack) StreamID() string { return d.streamID }
+
+// Kind 音轨类型
+func (d *DownTrack) Kind() webrtc.RTPCodecType { return d.kind }
+
+// Receiver 上行音轨的接收器
+func (d *DownTrack) Receiver() TrackReceiver { return d.params.Receiver }
+
+// SetTransceiver 设置收发器
+func (d *DownTrack) SetTransceiver(transceiver *webrtc.RTPTransceiver) {
+	d.transceiver.Store(transceiver)
+}
+
+// GetTransceiver 获取收发器
+func (d *DownTrack) GetTransceiver() *webrtc.RTPTransceiver {
+	transceiver, _ := d.transceiver.Load().(*webrtc.RTPTransceiver)
+	return transceiver
+}
+
+// RID 下行音轨只有一路流，没有 RID
+func (d *DownTrack) RID() string { return "" }
+
+// Bind 协商完成后由 pion 调用，确定编解码器、SSRC 和协商出的头扩展
+func (d *DownTrack) Bind(t webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	d.bindLock.Lock()
+	if d.bound.Load() {
+		d.bindLock.Unlock()
+		return webrtc.RTPCodecParameters{}, ErrDownTrackAlreadyBound
+	}
+
+	codec, ok := matchCodec(d.upstreamCodecs, t.CodecParameters())
+	if !ok {
+		d.bindLock.Unlock()
+		d.logger.Infow("no matching codec to bind", "upstream", d.upstreamCodecs, "negotiated", t.CodecParameters())
+		if d.onBinding != nil {
+			d.onBinding(webrtc.ErrUnsupportedCodec)
+		}
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+
+	d.ssrc = uint32(t.SSRC())
+	d.payloadType = uint8(codec.PayloadType)
+	d.mime = strings.ToLower(codec.MimeType)
+	d.codec = codec.RTPCodecCapability
+	d.writeStream = t.WriteStream()
+	for _, ext := range t.HeaderExtensions() {
+		switch ext.URI {
+		case sdp.ABSSendTimeURI:
+			d.absSendTimeExtID = ext.ID
+		case sdp.TransportCCURI:
+			d.transportWideExtID = ext.ID
+		case dependencydescriptor.ExtensionURI:
+			d.dependencyDescriptorExtID = ext.ID
+		case PlayoutDelayURI:
+			d.playoutDelayExtID = ext.ID
+		}
+	}
+	if d.dependencyDescriptorExtID != 0 {
+		for _, ext := range d.params.Receiver.HeaderExtensions() {
+			if ext.URI == dependencydescriptor.ExtensionURI {
+				d.upstreamDDExtID = ext.ID
+			}
+		}
+	}
+	d.initPlayoutDelay()
+	for _, fb := range codec.RTCPFeedback {
+		if fb.Type == webrtc.TypeRTCPFBNACK && fb.Parameter == "" {
+			d.sequencer = newSequencer(d.maxTrack, d.logger)
+			break
+		}
+	}
+
+	if rr, ok := d.bufferFactory.GetOrNew(packetio.RTCPBufferPacket, d.ssrc).(*buffer.RTCPReader); ok {
+		rr.OnPacket(d.handleRTCP)
+		d.rtcpReader = rr
+	}
+
+	d.bound.Store(true)
+	d.bindLock.Unlock()
+
+	d.logger.Debugw("bound", "codec", codec.MimeType, "ssrc", d.ssrc, "playoutDelayExtID", d.playoutDelayExtID)
+	if d.onBinding != nil {
+		d.onBinding(nil)
+	}
+	return codec, nil
+}
+
+// Unbind 停止发送时由 pion 调用
+func (d *DownTrack) Unbind(_ webrtc.TrackLocalContext) error {
+	d.bound.Store(false)
+	return nil
+}
+
+// matchCodec 在协商出的编解码器中查找与上行音轨相同的，优先匹配 fmtp
+func matchCodec(upstream []webrtc.RTPCodecParameters, negotiated []webrtc.RTPCodecParameters) (webrtc.RTPCodecParameters, bool) {
+	for _, u := range upstream {
+		for _, n := range negotiated {
+			if strings.EqualFold(u.MimeType, n.MimeType) && u.SDPFmtpLine == n.SDPFmtpLine {
+				return n, true
+			}
+		}
+	}
+	for _, u := range upstream {
+		for _, n := range negotiated {
+			if strings.EqualFold(u.MimeType, n.MimeType) {
+				return n, true
+			}
+		}
+	}
+	return webrtc.RTPCodecParameters{}, false
+}
+
+// WriteRTP 转发上行 layer 层的包，由转发器决定是否转发并改写序列号和时间戳，
+// 改写 SSRC 和负载类型，写入协商的头扩展后交给发送器
+func (d *DownTrack) WriteRTP(extPkt *buffer.ExtPacket, layer int32) error {
+	if !d.bound.Load() || d.IsClosed() {
+		return nil
+	}
+
+	tp, err := d.forwarder.GetTranslationParams(extPkt, layer)
+	if tp.shouldDrop {
+		if err != nil {
+			d.logger.Debugw("dropping packet", "error", err, "sn", extPkt.Packet.SequenceNumber, "layer", layer)
+		}
+		return nil
+	}
+	if tp.isResuming {
+		if listener := d.getStreamAllocatorListener(); listener != nil {
+			listener.OnResume(d)
+		}
+	}
+	if tp.isSwitchingToTarget {
+		d.stopKeyFrameRequester()
+	}
+	if extPkt.KeyFrame {
+		d.isNACKThrottled.Store(false)
+	}
+	if d.sequencer != nil {
+		d.sequencer.push(extPkt.Packet.SequenceNumber, tp.rtp.sequenceNumber, tp.rtp.timestamp, int8(layer))
+	}
+
+	extensions := d.forwardedExtensions(&extPkt.Packet.Header)
+	hdr := extPkt.Packet.Header
+	hdr.SSRC = d.ssrc
+	hdr.PayloadType = d.payloadType
+	hdr.SequenceNumber = tp.rtp.sequenceNumber
+	hdr.Timestamp = tp.rtp.timestamp
+	hdr.Extension = false
+	hdr.ExtensionProfile = 0
+	hdr.Extensions = nil
+
+	if playoutDelay := d.getPlayoutDelayExtension(hdr.SequenceNumber); playoutDelay != nil {
+		extensions = append(extensions, pacer.ExtensionData{ID: uint8(d.playoutDelayExtID), Payload: playoutDelay})
+	}
+
+	d.pacer.Enqueue(pacer.Packet{
+		Header:             &hdr,
+		Extensions:         extensions,
+		Payload:            extPkt.Packet.Payload,
+		AbsSendTimeExtID:   uint8(d.absSendTimeExtID),
+		TransportWideExtID: uint8(d.transportWideExtID),
+		WriteStream:        d.writeStream,
+		OnSent:             d.packetSent,
+	})
+	return nil
+}
+
+// forwardedExtensions 上行包中需要转发给订阅者的头扩展，按订阅者协商的扩展ID写入。
+// 其他扩展由发送器重新生成，依赖描述符是解码需要的信息，原样转发
+func (d *DownTrack) forwardedExtensions(hdr *rtp.Header) []pacer.ExtensionData {
+	if d.dependencyDescriptorExtID == 0 || d.upstreamDDExtID == 0 {
+		return nil
+	}
+	dd := hdr.GetExtension(uint8(d.upstreamDDExtID))
+	if len(dd) == 0 {
+		return nil
+	}
+	// 读取缓冲可能被复用，扩展需要复制
+	payload := make([]byte, len(dd))
+	copy(payload, dd)
+	return []pacer.ExtensionData{{ID: uint8(d.dependencyDescriptorExtID), Payload: payload}}
+}
+
+// packetSent 包发送之后更新发送统计
+func (d *DownTrack) packetSent(_ interface{}, hdr *rtp.Header, payloadSize int, sentTime time.Time, sendError error) {
+	if sendError != nil {
+		return
+	}
+	d.rtpStats.Update(hdr, payloadSize, 0, sentTime)
+	size := hdr.MarshalSize() + payloadSize
+	d.bytesSent.Add(uint32(size))
+
+	if listener := d.getStreamAllocatorListener(); listener != nil {
+		listener.OnPacketSent(d, size)
+	}
+}
+
+// handleRTCP 处理订阅者发来的 RTCP
+func (d *DownTrack) handleRTCP(bytes []byte) {
+	pkts, err := rtcp.Unmarshal(bytes)
+	if err != nil {
+		d.logger.Errorw("could not unmarshal rtcp", err)
+		return
+	}
+
+	for _, pkt := range pkts {
+		switch p := pkt.(type) {
+		case *rtcp.ReceiverReport:
+			for _, report := range p.Reports {
+				if report.SSRC != d.ssrc {
+					continue
+				}
+				rtt, isRttChanged := d.rtpStats.UpdateFromReceiverReport(report)
+				if isRttChanged {
+					if d.sequencer != nil {
+						d.sequencer.setRTT(rtt)
+					}
+					if onRttUpdate := d.getOnRttUpdate(); onRttUpdate != nil {
+						onRttUpdate(d, rtt)
+					}
+				}
+				d.handlePlayoutDelayReport(report)
+			}
+			if listener := d.getStreamAllocatorListener(); listener != nil {
+				listener.OnRTCPReceiverReport(d, *p)
+			}
+
+			d.listenerLock.Lock()
+			listeners := d.receiverReportListeners
+			d.listenerLock.Unlock()
+			for _, l := range listeners {
+				l(d, p)
+			}
+
+		case *rtcp.PictureLossIndication:
+			if p.MediaSSRC == d.ssrc {
+				d.rtpStats.UpdatePliAndTime(1)
+				d.requestCurrentKeyFrame()
+				if FlagStopRTXOnPLI {
+					// 订阅者等待关键帧，重传之前的包没有意义
+					d.isNACKThrottled.Store(true)
+				}
+			}
+
+		case *rtcp.TransportLayerNack:
+			if p.MediaSSRC != d.ssrc {
+				continue
+			}
+			var nacks []uint16
+			for _, pair := range p.Nacks {
+				nacks = append(nacks, pair.PacketList()...)
+			}
+			d.rtpStats.UpdateNack(uint32(len(nacks)))
+			go d.retransmitPackets(nacks)
+
+		case *rtcp.FullIntraRequest:
+			if p.MediaSSRC == d.ssrc {
+				d.rtpStats.UpdateFir(1)
+				d.rtpStats.UpdateFirTime()
+				d.requestCurrentKeyFrame()
+			}
+
+		case *rtcp.ReceiverEstimatedMaximumBitrate:
+			if listener := d.getStreamAllocatorListener(); listener != nil {
+				listener.OnREMB(d, p)
+			}
+
+		case *rtcp.TransportLayerCC:
+			if p.MediaSSRC == d.ssrc {
+				if listener := d.getStreamAllocatorListener(); listener != nil {
+					listener.OnTransportCCFeedback(d, p)
+				}
+			}
+		}
+	}
+}
+
+// retransmitPackets 从上行缓冲读取订阅者请求重传的包，按转发时的序列号和时间戳重新发送
+func (d *DownTrack) retransmitPackets(nacks []uint16) {
+	if d.sequencer == nil || d.IsClosed() {
+		return
+	}
+	if FlagStopRTXOnPLI && d.isNACKThrottled.Load() {
+		return
+	}
+
+	metas, numRepeated, numMisses := d.sequencer.getPacketsMeta(nacks)
+	d.totalRepeatedNACKs.Add(numRepeated)
+
+	var numAcks uint32
+	nackInfos := make([]NackInfo, 0, len(metas))
+	src := make([]byte, bucket.MaxPktSize)
+	for _, meta := range metas {
+		n, err := d.params.Receiver.ReadRTP(src, uint8(meta.layer), meta.sourceSeqNo)
+		if err != nil {
+			numMisses++
+			continue
+		}
+
+		var pkt rtp.Packet
+		if err = pkt.Unmarshal(src[:n]); err != nil {
+			numMisses++
+			continue
+		}
+		extensions := d.forwardedExtensions(&pkt.Header)
+		hdr := pkt.Header
+		hdr.SSRC = d.ssrc
+		hdr.PayloadType = d.payloadType
+		hdr.SequenceNumber = meta.targetSeqNo
+		hdr.Timestamp = meta.timestamp
+		hdr.Extension = false
+		hdr.ExtensionProfile = 0
+		hdr.Extensions = nil
+
+		// 读取缓冲会被下一个包复用，负载需要复制
+		payload := make([]byte, len(pkt.Payload))
+		copy(payload, pkt.Payload)
+		d.pacer.Enqueue(pacer.Packet{
+			Header:             &hdr,
+			Extensions:         extensions,
+			Payload:            payload,
+			AbsSendTimeExtID:   uint8(d.absSendTimeExtID),
+			TransportWideExtID: uint8(d.transportWideExtID),
+			WriteStream:        d.writeStream,
+			OnSent:             d.packetRetransmitted,
+		})
+
+		numAcks++
+		nackInfos = append(nackInfos, NackInfo{
+			Timestamp:      meta.timestamp,
+			SequenceNumber: meta.targetSeqNo,
+			Attempts:       meta.nacked,
+		})
+	}
+
+	d.rtpStats.UpdateNackProcessed(numAcks, numMisses, numRepeated)
+	if listener := d.getStreamAllocatorListener(); listener != nil && len(nackInfos) != 0 {
+		listener.OnNACK(d, nackInfos)
+	}
+}
+
+// packetRetransmitted 重传的包不计入发送统计，只记录重传的字节数
+func (d *DownTrack) packetRetransmitted(_ interface{}, hdr *rtp.Header, payloadSize int, _ time.Time, sendError error) {
+	if sendError != nil {
+		return
+	}
+	d.bytesRetransmitted.Add(uint32(hdr.MarshalSize() + payloadSize))
+}
+
+// AddReceiverReportListener 订阅者的接收报告事件
+func (d *DownTrack) AddReceiverReportListener(listener ReceiverReportListener) {
+	d.listenerLock.Lock()
+	defer d.listenerLock.Unlock()
+
+	d.receiverReportListeners = append(d.receiverReportListeners, listener)
+}
+
+// OnRttUpdate 往返时延变化事件
+func (d *DownTrack) OnRttUpdate(fn func(dt *DownTrack, rtt uint32)) {
+	d.cbMu.Lock()
+	defer d.cbMu.Unlock()
+
+	d.onRttUpdate = fn
+}
+
+func (d *DownTrack) getOnRttUpdate() func(dt *DownTrack, rtt uint32) {
+	d.cbMu.RLock()
+	defer d.cbMu.RUnlock()
+
+	return d.onRttUpdate
+}
+
+// OnCloseHandler 关闭事件，willBeResumed 为 true 时订阅会在迁移或者重连后恢复
+func (d *DownTrack) OnCloseHandler(fn func(willBeResumed bool)) {
+	d.cbMu.Lock()
+	defer d.cbMu.Unlock()
+
+	d.onCloseHandler = fn
+}
+
+func (d *DownTrack) getOnCloseHandler() func(willBeResumed bool) {
+	d.cbMu.RLock()
+	defer d.cbMu.RUnlock()
+
+	return d.onCloseHandler
+}
+
+// GetState 导出下行音轨的状态，迁移或者恢复订阅时用于保持 RTP 连续
+func (d *DownTrack) GetState() DownTrackState {
+	dts := DownTrackState{
+		RTPStats:                       d.rtpStats,
+		DeltaStatsSnapshotId:           d.deltaStatsSnapshotId,
+		DeltaStatsOverriddenSnapshotId: d.deltaStatsOverriddenSnapshotId,
+	}
+	if d.forwarder != nil {
+		dts.ForwarderState = d.forwarder.GetState()
+	}
+	return dts
+}
+
+// SeedState 使用之前导出的状态初始化，序列号和时间戳从之前的位置继续，需要在开始转发之前调用
+func (d *DownTrack) SeedState(state DownTrackState) {
+	switch {
+	case state.RTPStats == nil:
+	case state.DeltaStatsSnapshotId != 0:
+		// 同一个节点上恢复，快照一起继承
+		d.rtpStats.Seed(state.RTPStats)
+		d.deltaStatsSnapshotId = state.DeltaStatsSnapshotId
+		d.deltaStatsOverriddenSnapshotId = state.DeltaStatsOverriddenSnapshotId
+	default:
+		// 其他节点导出的状态，使用自己的快照从导入的位置开始统计
+		d.rtpStats.SeedState(state.RTPStats.GetState())
+	}
+	if d.forwarder != nil {
+		d.forwarder.SeedState(state.ForwarderState)
+	}
+}
+
+// Mute 订阅者禁用或者启用音轨
+func (d *DownTrack) Mute(muted bool) {
+	if !d.forwarder.Mute(muted) {
+		return
+	}
+	d.onMuteChanged()
+	if listener := d.getStreamAllocatorListener(); listener != nil {
+		listener.OnSubscriptionChanged(d)
+	}
+}
+
+// PubMute 发布者静音或者取消静音
+func (d *DownTrack) PubMute(pubMuted bool) {
+	if !d.forwarder.PubMute(pubMuted) {
+		return
+	}
+	d.onMuteChanged()
+	if listener := d.getStreamAllocatorListener(); listener != nil {
+		listener.OnSubscriptionChanged(d)
+	}
+}
+
+// onMuteChanged 静音期间没有数据不影响连接质量，恢复时重新选择转发的层
+func (d *DownTrack) onMuteChanged() {
+	d.connectionStats.UpdateMute(d.forwarder.IsMuted() || d.forwarder.IsPubMuted())
+	d.allocateOptimal()
+}
+
+// SetMaxSpatialLayer 订阅者需要的最大空间层
+func (d *DownTrack) SetMaxSpatialLayer(spatialLayer int32) {
+	changed, maxLayer := d.forwarder.SetMaxSpatialLayer(spatialLayer)
+	if !changed {
+		return
+	}
+	d.notifySubscribedLayerChanged(maxLayer)
+}
+
+// SetMaxTemporalLayer 订阅者需要的最大时间层
+func (d *DownTrack) SetMaxTemporalLayer(temporalLayer int32) {
+	changed, maxLayer := d.forwarder.SetMaxTemporalLayer(temporalLayer)
+	if !changed {
+		return
+	}
+	d.notifySubscribedLayerChanged(maxLayer)
+}
+
+func (d *DownTrack) notifySubscribedLayerChanged(maxLayer buffer.VideoLayer) {
+	if listener := d.getStreamAllocatorListener(); listener != nil {
+		listener.OnSubscribedLayerChanged(d, maxLayer)
+	} else {
+		d.allocateOptimal()
+	}
+
+	d.cbMu.RLock()
+	onMaxSubscribedLayerChanged := d.onMaxSubscribedLayerChanged
+	d.cbMu.RUnlock()
+	if onMaxSubscribedLayerChanged != nil {
+		onMaxSubscribedLayerChanged(d, maxLayer.Spatial)
+	}
+}
+
+// OnMaxSubscribedLayerChanged 订阅者需要的最大空间层变化事件
+func (d *DownTrack) OnMaxSubscribedLayerChanged(fn func(dt *DownTrack, layer int32)) {
+	d.cbMu.Lock()
+	defer d.cbMu.Unlock()
+
+	d.onMaxSubscribedLayerChanged = fn
+}
+
+// MaxLayer 订阅者需要的最大层
+func (d *DownTrack) MaxLayer() buffer.VideoLayer {
+	return d.forwarder.MaxLayer()
+}
+
+// CurrentLayer 正在转发的层
+func (d *DownTrack) CurrentLayer() buffer.VideoLayer {
+	return d.forwarder.CurrentLayer()
+}
+
+// TargetLayer 分配的目标层
+func (d *DownTrack) TargetLayer() buffer.VideoLayer {
+	return d.forwarder.TargetLayer()
+}
+
+// SetStreamAllocatorListener 设置流分配器，设置之后由流分配器选择转发的层
+func (d *DownTrack) SetStreamAllocatorListener(listener DownTrackStreamAllocatorListener) {
+	d.streamAllocatorLock.Lock()
+	d.streamAllocatorListener = listener
+	d.streamAllocatorLock.Unlock()
+
+	if listener == nil {
+		d.allocateOptimal()
+	}
+}
+
+func (d *DownTrack) getStreamAllocatorListener() DownTrackStreamAllocatorListener {
+	d.streamAllocatorLock.RLock()
+	defer d.streamAllocatorLock.RUnlock()
+
+	return d.streamAllocatorListener
+}
+
+// allocateOptimal 没有流分配器时，按订阅者的最大层和上行可用的层选择转发的层
+func (d *DownTrack) allocateOptimal() {
+	if d.kind != webrtc.RTPCodecTypeVideo || d.getStreamAllocatorListener() != nil {
+		return
+	}
+
+	availableLayers, brs := d.params.Receiver.GetLayeredBitrate()
+	alloc := d.forwarder.AllocateOptimal(availableLayers, brs)
+	d.logger.Debugw("allocated optimal", "allocation", alloc)
+	d.postKeyFrameRequest()
+}
+
+// AllocateOptimal 不考虑带宽，选择订阅者最大层之内最高的可用层
+func (d *DownTrack) AllocateOptimal() VideoAllocation {
+	availableLayers, brs := d.params.Receiver.GetLayeredBitrate()
+	alloc := d.forwarder.AllocateOptimal(availableLayers, brs)
+	d.postKeyFrameRequest()
+	return alloc
+}
+
+// Allocate 在可用带宽内选择转发的层
+func (d *DownTrack) Allocate(availableChannelCapacity int64, allowPause bool) VideoAllocation {
+	availableLayers, brs := d.params.Receiver.GetLayeredBitrate()
+	alloc := d.forwarder.Allocate(availableLayers, brs, availableChannelCapacity, allowPause)
+	d.postKeyFrameRequest()
+	return alloc
+}
+
+// Pause 带宽不足时暂停转发
+func (d *DownTrack) Pause() VideoAllocation {
+	return d.forwarder.Pause()
+}
+
+// IsMuted 订阅者禁用或者发布者静音
+func (d *DownTrack) IsMuted() bool {
+	return d.forwarder.IsMuted() || d.forwarder.IsPubMuted()
+}
+
+// IsDeficient 分配的层是否低于订阅者需要的层
+func (d *DownTrack) IsDeficient() bool {
+	return d.forwarder.IsDeficient()
+}
+
+// BandwidthRequested 最近一次分配需要的码率
+func (d *DownTrack) BandwidthRequested() int64 {
+	return d.forwarder.LastAllocation().BandwidthRequested
+}
+
+// SetPriority 订阅者设置的优先级
+func (d *DownTrack) SetPriority(priority uint32) {
+	if d.priority.Swap(priority) == priority {
+		return
+	}
+	if listener := d.getStreamAllocatorListener(); listener != nil {
+		listener.OnSubscriptionChanged(d)
+	}
+}
+
+// Priority 订阅者设置的优先级，1 最高，0 表示未设置
+func (d *DownTrack) Priority() uint32 {
+	return d.priority.Load()
+}
+
+// ---------------- TrackSender ----------------
+
+// UpTrackLayerChange 上行可用的层变化
+func (d *DownTrack) UpTrackLayerChange() {
+	if listener := d.getStreamAllocatorListener(); listener != nil {
+		listener.OnAvailableLayersChanged(d)
+		return
+	}
+	d.allocateOptimal()
+}
+
+// UpTrackBitrateAvailabilityChange 上行各层的码率变为可用
+func (d *DownTrack) UpTrackBitrateAvailabilityChange() {
+	if listener := d.getStreamAllocatorListener(); listener != nil {
+		listener.OnBitrateAvailabilityChanged(d)
+		return
+	}
+	d.allocateOptimal()
+}
+
+// UpTrackMaxPublishedLayerChange 发布者发布的最大空间层变化
+func (d *DownTrack) UpTrackMaxPublishedLayerChange(maxPublishedLayer int32) {
+	if !d.forwarder.SetMaxPublishedLayer(maxPublishedLayer) {
+		return
+	}
+	if listener := d.getStreamAllocatorListener(); listener != nil {
+		listener.OnMaxPublishedSpatialChanged(d)
+		return
+	}
+	d.allocateOptimal()
+}
+
+// UpTrackMaxTemporalLayerSeenChange 上行收到的最大时间层变化
+func (d *DownTrack) UpTrackMaxTemporalLayerSeenChange(maxTemporalLayerSeen int32) {
+	if !d.forwarder.SetMaxTemporalLayerSeen(maxTemporalLayerSeen) {
+		return
+	}
+	if listener := d.getStreamAllocatorListener(); listener != nil {
+		listener.OnMaxPublishedTemporalChanged(d)
+		return
+	}
+	d.allocateOptimal()
+}
+
+// UpTrackBitrateReport 上行各层的码率报告，没有流分配器时按最新的码率重新选择
+func (d *DownTrack) UpTrackBitrateReport(_ []int32, _ Bitrates) {
+	d.allocateOptimal()
+}
+
+// TrackInfoAvailable 上行音轨信息可用
+func (d *DownTrack) TrackInfoAvailable() {
+	d.allocateOptimal()
+}
+
+// HandleRTCPSenderReportData 上行的发送者报告，用于对齐下行统计的起始时间
+func (d *DownTrack) HandleRTCPSenderReportData(_ webrtc.PayloadType, layer int32, srData *buffer.RTCPSenderReportData) error {
+	if srData == nil {
+		return nil
+	}
+	ts, ok := d.forwarder.TranslateTimestamp(layer, srData.RTPTimestamp)
+	if !ok {
+		return nil
+	}
+
+	translated := *srData
+	translated.RTPTimestamp = ts
+	d.rtpStats.MaybeAdjustFirstPacketTime(&translated)
+	return nil
+}
+
+// CreateSenderReport 生成下行的发送者报告，订阅者在接收报告中带回，据此计算 RTT，
+// 还没有绑定或者还没有发送过包时返回 nil
+func (d *DownTrack) CreateSenderReport() *rtcp.SenderReport {
+	if !d.bound.Load() {
+		return nil
+	}
+
+	layer := d.forwarder.CurrentLayer().Spatial
+	if layer == buffer.InvalidLayerSpatial {
+		layer = 0
+	}
+	return d.rtpStats.GetRtcpSenderReport(d.ssrc, d.params.Receiver.GetCalculatedClockRate(layer))
+}
+
+// ---------------- 关键帧请求 ----------------
+
+// postKeyFrameRequest 等待切换层时周期性地向上行请求目标层的关键帧，间隔逐渐增加
+func (d *DownTrack) postKeyFrameRequest() {
+	if d.forwarder.GetRequestLayerSpatial() == buffer.InvalidLayerSpatial {
+		return
+	}
+
+	gen := d.keyFrameRequestGeneration.Inc()
+	go d.keyFrameRequester(gen)
+}
+
+func (d *DownTrack) stopKeyFrameRequester() {
+	d.keyFrameRequestGeneration.Inc()
+}
+
+func (d *DownTrack) keyFrameRequester(gen uint32) {
+	interval := KeyFrameIntervalMin * time.Millisecond
+	for {
+		if d.IsClosed() || gen != d.keyFrameRequestGeneration.Load() {
+			return
+		}
+		layer := d.forwarder.GetRequestLayerSpatial()
+		if layer == buffer.InvalidLayerSpatial {
+			return
+		}
+
+		d.logger.Debugw("requesting key frame", "layer", layer)
+		d.params.Receiver.SendPLI(layer, false)
+		d.rtpStats.UpdateLayerLockPliAndTime(1)
+
+		time.Sleep(interval)
+		if interval *= 2; interval > KeyFrameIntervalMax*time.Millisecond {
+			interval = KeyFrameIntervalMax * time.Millisecond
+		}
+	}
+}
+
+// requestCurrentKeyFrame 订阅者请求关键帧时转发给正在转发的上行层
+func (d *DownTrack) requestCurrentKeyFrame() {
+	if d.kind != webrtc.RTPCodecTypeVideo {
+		return
+	}
+	if layer := d.forwarder.CurrentLayer().Spatial; layer != buffer.InvalidLayerSpatial {
+		d.params.Receiver.SendPLI(layer, false)
+	}
+}
+
+// GetDeltaStats 上次调用以来的发送统计，还没有发送数据时返回 nil
+func (d *DownTrack) GetDeltaStats() *buffer.RTPDeltaInfo {
+	return d.rtpStats.DeltaInfo(d.deltaStatsSnapshotId)
+}
+
+// DebugInfo 调试信息
+func (d *DownTrack) DebugInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"SubscriberID": d.subscriberID,
+		"TrackID":      d.id,
+		"SSRC":         d.ssrc,
+		"MimeType":     d.codec.MimeType,
+		"Bound":        d.bound.Load(),
+		"Forwarder":    d.forwarder.DebugInfo(),
+		"RTPStats":     d.rtpStats.ToString(),
+	}
+}
+
+// newConnectionStats 下行音轨的丢包和抖动以订阅者的接收报告为准
+func (d *DownTrack) newConnectionStats(updateInterval time.Duration) *connectionquality.ConnectionStats {
+	fmtp := strings.ToLower(d.params.Codec[0].SDPFmtpLine)
+	return connectionquality.NewConnectionStats(connectionquality.ConnectionStatsParams{
+		UpdateInterval: updateInterval,
+		MimeType:       d.params.Codec[0].MimeType,
+		IsDTXEnabled:   strings.Contains(fmtp, "usedtx=1"),
+		IsFECEnabled:   strings.Contains(fmtp, "useinbandfec=1"),
+		GetDeltaStats: func() *buffer.RTPDeltaInfo {
+			return d.rtpStats.DeltaOverridden(d.deltaStatsOverriddenSnapshotId)
+		},
+		GetExpectedBitrate: func() int64 {
+			return d.forwarder.LastAllocation().BandwidthRequested
+		},
+		Logger: d.logger,
+	})
+}
+
+// GetConnectionScoreAndQuality 下行音轨的连接质量分数和等级
+func (d *DownTrack) GetConnectionScoreAndQuality() (float32, connectionquality.Quality) {
+	return d.connectionStats.GetScoreAndQuality()
+}
+
+// IsClosed 是否已经关闭
+func (d *DownTrack) IsClosed() bool {
+	return d.isClosed.Load()
+}
+
+// Close 关闭下行音轨
+func (d *DownTrack) Close() {
+	d.CloseWithFlush(true)
+}
+
+// CloseWithFlush 关闭下行音轨，flush 为 false 时表示订阅会被恢复，不发送静音帧
+func (d *DownTrack) CloseWithFlush(flush bool) {
+	if d.isClosed.Swap(true) {
+		return
+	}
+
+	d.logger.Debugw("close down track", "flush", flush)
+	d.params.Receiver.DeleteDownTrack(d.subscriberID)
+	d.connectionStats.Close()
+
+	if d.rtcpReader != nil && flush {
+		_ = d.rtcpReader.Close()
+		d.rtcpReader.OnPacket(nil)
+	}
+
+	if onCloseHandler := d.getOnCloseHandler(); onCloseHandler != nil {
+		onCloseHandler(!flush)
+	}
+}
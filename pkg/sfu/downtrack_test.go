@@ -0,0 +1,520 @@
+package sfu
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+	"github.com/liuhailove/tc-server/pkg/sfu/connectionquality"
+	"github.com/liuhailove/tc-server/pkg/sfu/pacer"
+)
+
+const (
+	testUpstreamDDExtID = 5
+	testDDExtID         = 3
+	testPlayoutDelayID  = 4
+)
+
+// fakeTrackReceiver 下行音轨的上行接收器，从内存中的包响应重传读取
+type fakeTrackReceiver struct {
+	TrackReceiver
+
+	lock    sync.Mutex
+	packets map[uint16][]byte
+}
+
+func (r *fakeTrackReceiver) TrackID() tc.TrackID                 { return "TR_video" }
+func (r *fakeTrackReceiver) DeleteDownTrack(tc.ParticipantID)    {}
+func (r *fakeTrackReceiver) SendPLI(int32, bool)                 {}
+func (r *fakeTrackReceiver) GetCalculatedClockRate(int32) uint32 { return 90000 }
+func (r *fakeTrackReceiver) GetLayeredBitrate() ([]int32, Bitrates) {
+	return []int32{0}, Bitrates{{500_000}}
+}
+
+func (r *fakeTrackReceiver) ReadRTP(buf []byte, _ uint8, sn uint16) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	pkt, ok := r.packets[sn]
+	if !ok {
+		return 0, buffer.ErrPacketNotFound
+	}
+	return copy(buf, pkt), nil
+}
+
+func (r *fakeTrackReceiver) addPacket(t *testing.T, pkt *rtp.Packet) {
+	raw, err := pkt.Marshal()
+	require.NoError(t, err)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.packets == nil {
+		r.packets = make(map[uint16][]byte)
+	}
+	r.packets[pkt.SequenceNumber] = raw
+}
+
+// fakeNackListener 记录流分配器收到的 NACK
+type fakeNackListener struct {
+	DownTrackStreamAllocatorListener
+
+	nacks chan []NackInfo
+}
+
+func (l *fakeNackListener) OnResume(*DownTrack)                                  {}
+func (l *fakeNackListener) OnPacketSent(*DownTrack, int)                         {}
+func (l *fakeNackListener) OnRTCPReceiverReport(*DownTrack, rtcp.ReceiverReport) {}
+func (l *fakeNackListener) OnNACK(_ *DownTrack, nackInfos []NackInfo) {
+	l.nacks <- nackInfos
+}
+
+// fakeWriteStream 记录发送给订阅者的包
+type fakeWriteStream struct {
+	lock    sync.Mutex
+	headers []rtp.Header
+}
+
+func (w *fakeWriteStream) WriteRTP(hdr *rtp.Header, payload []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.headers = append(w.headers, hdr.Clone())
+	return hdr.MarshalSize() + len(payload), nil
+}
+
+func (w *fakeWriteStream) Write(b []byte) (int, error) { return len(b), nil }
+
+func (w *fakeWriteStream) sent() []rtp.Header {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]rtp.Header(nil), w.headers...)
+}
+
+// newBoundTestDownTrack 创建视频下行音轨，按 Bind 的方式设置协商的结果：
+// 依赖描述符、播放延迟扩展和 NACK
+func newBoundTestDownTrack(t *testing.T, receiver *fakeTrackReceiver) (*DownTrack, *fakeWriteStream) {
+	codec := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000},
+		PayloadType:        102,
+	}
+	d, err := NewDownTrack(DownTrackParams{
+		Codec:             []webrtc.RTPCodecParameters{codec},
+		Receiver:          receiver,
+		SubID:             "PA_sub",
+		MaxTrack:          100,
+		PlayoutDelayLimit: &tc.PlayoutDelay{Enabled: true, Min: 100, Max: 2000},
+		Pacer:             pacer.NewPassThrough(logger.GetLogger()),
+		Logger:            logger.GetLogger(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(d.Close)
+
+	w := &fakeWriteStream{}
+	d.ssrc = 5678
+	d.payloadType = uint8(codec.PayloadType)
+	d.mime = "video/h264"
+	d.codec = codec.RTPCodecCapability
+	d.writeStream = w
+	d.dependencyDescriptorExtID = testDDExtID
+	d.upstreamDDExtID = testUpstreamDDExtID
+	d.playoutDelayExtID = testPlayoutDelayID
+	d.initPlayoutDelay()
+	d.sequencer = newSequencer(d.maxTrack, d.logger)
+	d.bound.Store(true)
+	return d, w
+}
+
+func newUpstreamPacket(t *testing.T, sn uint16, ts uint32) *buffer.ExtPacket {
+	extPkt := newExtPacket(1, sn, ts, true, 0)
+	require.NoError(t, extPkt.Packet.Header.SetExtension(testUpstreamDDExtID, []byte{0x80, 0x01, 0x02}))
+	return extPkt
+}
+
+func receiverReport(ssrc uint32, lastSN uint16, jitter uint32) []byte {
+	rr := &rtcp.ReceiverReport{
+		SSRC: 1,
+		Reports: []rtcp.ReceptionReport{{
+			SSRC:               ssrc,
+			LastSequenceNumber: uint32(lastSN),
+			Jitter:             jitter,
+		}},
+	}
+	raw, _ := rr.Marshal()
+	return raw
+}
+
+// replyToSenderReport 订阅者收到发送者报告 delay 之后回复的接收报告
+func replyToSenderReport(sr *rtcp.SenderReport, report rtcp.ReceptionReport, delay time.Duration) []byte {
+	report.SSRC = sr.SSRC
+	report.LastSenderReport = uint32(sr.NTPTime >> 16)
+	report.Delay = uint32(delay.Seconds() * 65536)
+	rr := &rtcp.ReceiverReport{SSRC: 1, Reports: []rtcp.ReceptionReport{report}}
+	raw, _ := rr.Marshal()
+	return raw
+}
+
+func playoutDelayOf(t *testing.T, hdr rtp.Header) *PlayoutDelay {
+	buf := hdr.GetExtension(testPlayoutDelayID)
+	if buf == nil {
+		return nil
+	}
+	var delay PlayoutDelay
+	require.NoError(t, delay.Unmarshal(buf))
+	return &delay
+}
+
+func newTestSenderRTPStats() *buffer.RTPStats {
+	return buffer.NewRTPStats(buffer.RTPStatsParams{
+		ClockRate:              48000,
+		IsReceiverReportDriven: true,
+		Logger:                 logger.GetLogger(),
+	})
+}
+
+// forwardPacket 转发一个包并按照改写后的序列号和时间戳统计发送
+func forwardPacket(t *testing.T, f *Forwarder, stats *buffer.RTPStats, extPkt *buffer.ExtPacket) *TranslationParamsRTP {
+	tp, err := f.GetTranslationParams(extPkt, 0)
+	require.NoError(t, err)
+	require.False(t, tp.shouldDrop)
+
+	hdr := &rtp.Header{
+		SSRC:           1234,
+		SequenceNumber: tp.rtp.sequenceNumber,
+		Timestamp:      tp.rtp.timestamp,
+	}
+	stats.Update(hdr, len(extPkt.Packet.Payload), 0, time.Now())
+	return tp.rtp
+}
+
+func TestDownTrackStateMigration(t *testing.T) {
+	// 源节点转发了一段时间
+	source := NewForwarder(webrtc.RTPCodecTypeAudio, 48000, logger.GetLogger())
+	sourceStats := newTestSenderRTPStats()
+	var last *TranslationParamsRTP
+	for i := 0; i < 5; i++ {
+		last = forwardPacket(t, source, sourceStats, newExtPacket(1, uint16(65533+i), uint32(1000+960*i), false, 0))
+	}
+	require.Equal(t, uint16(1), last.sequenceNumber)
+
+	state := DownTrackState{
+		RTPStats:             sourceStats,
+		DeltaStatsSnapshotId: sourceStats.NewSnapshotId(),
+		ForwarderState:       source.GetState(),
+	}
+	data, err := json.Marshal(state)
+	require.NoError(t, err)
+
+	// 目标节点解析收到的状态，快照不会跨节点传递
+	imported := DownTrackState{}
+	require.NoError(t, json.Unmarshal(data, &imported))
+	require.Zero(t, imported.DeltaStatsSnapshotId)
+	require.Equal(t, state.ForwarderState.RTP, imported.ForwarderState.RTP)
+	require.True(t, imported.ForwarderState.Started)
+	require.True(t, state.ForwarderState.LastPacketAt.Equal(imported.ForwarderState.LastPacketAt))
+	require.NotNil(t, imported.RTPStats)
+
+	target := NewForwarder(webrtc.RTPCodecTypeAudio, 48000, logger.GetLogger())
+	target.SeedState(imported.ForwarderState)
+	targetStats := newTestSenderRTPStats()
+	snapshotId := targetStats.NewSnapshotId()
+	targetStats.SeedState(imported.RTPStats.GetState())
+
+	// 目标节点上的上行是另外一路流，订阅者看到的序列号和时间戳仍然连续
+	time.Sleep(20 * time.Millisecond)
+	next := forwardPacket(t, target, targetStats, newExtPacket(2, 40000, 777777, false, 0))
+	require.Equal(t, last.sequenceNumber+1, next.sequenceNumber)
+	tsJump := next.timestamp - last.timestamp
+	require.GreaterOrEqual(t, tsJump, uint32(48000*20/1000))
+	require.Less(t, tsJump, uint32(48000))
+
+	next = forwardPacket(t, target, targetStats, newExtPacket(2, 40001, 777777+960, false, 0))
+	require.Equal(t, last.sequenceNumber+2, next.sequenceNumber)
+
+	// 发送统计接着源节点继续，没有丢包，增量只包括目标节点发送的包
+	stats := targetStats.ToProto()
+	require.Equal(t, uint32(7), stats.Packets)
+	require.Zero(t, stats.PacketsLost)
+	delta := targetStats.DeltaInfo(snapshotId)
+	require.NotNil(t, delta)
+	require.Equal(t, uint32(2), delta.Packets)
+}
+
+func TestDownTrackWriteRTPExtensions(t *testing.T) {
+	d, w := newBoundTestDownTrack(t, &fakeTrackReceiver{})
+
+	require.NoError(t, d.WriteRTP(newUpstreamPacket(t, 100, 9000), 0))
+	sent := w.sent()
+	require.Len(t, sent, 1)
+	require.Equal(t, uint32(5678), sent[0].SSRC)
+
+	// 依赖描述符按订阅者协商的扩展ID转发，上行的扩展ID不会泄露给订阅者
+	require.Equal(t, []byte{0x80, 0x01, 0x02}, sent[0].GetExtension(testDDExtID))
+	require.Nil(t, sent[0].GetExtension(testUpstreamDDExtID))
+	require.Equal(t, &PlayoutDelay{Min: 100, Max: 2000}, playoutDelayOf(t, sent[0]))
+
+	// 没有依赖描述符的包不写入
+	require.NoError(t, d.WriteRTP(newExtPacket(1, 101, 9000, false, 0), 0))
+	sent = w.sent()
+	require.Len(t, sent, 2)
+	require.Nil(t, sent[1].GetExtension(testDDExtID))
+}
+
+func TestDownTrackRttFromSenderReport(t *testing.T) {
+	d, w := newBoundTestDownTrack(t, &fakeTrackReceiver{})
+	var rtt uint32
+	d.OnRttUpdate(func(_ *DownTrack, r uint32) {
+		rtt = r
+	})
+
+	// 还没有发送过包
+	require.Nil(t, d.CreateSenderReport())
+
+	require.NoError(t, d.WriteRTP(newUpstreamPacket(t, 100, 9000), 0))
+	lastSN := w.sent()[0].SequenceNumber
+
+	// 没有发送者报告时接收报告不能计算 RTT
+	d.handleRTCP(receiverReport(d.ssrc, lastSN, 0))
+	require.Zero(t, rtt)
+
+	sr := d.CreateSenderReport()
+	require.NotNil(t, sr)
+	require.Equal(t, d.ssrc, sr.SSRC)
+	require.Equal(t, uint32(1), sr.PacketCount)
+
+	// 订阅者收到发送者报告 10ms 之后回复，来回的网络上经过了至少 20ms
+	time.Sleep(30 * time.Millisecond)
+	d.handleRTCP(replyToSenderReport(sr, rtcp.ReceptionReport{LastSequenceNumber: uint32(lastSN)}, 10*time.Millisecond))
+	require.GreaterOrEqual(t, rtt, uint32(19))
+	require.Less(t, rtt, uint32(1000))
+	require.Equal(t, rtt, d.rtpStats.GetRtt())
+}
+
+func TestDownTrackConnectionQuality(t *testing.T) {
+	writePackets := func(t *testing.T, d *DownTrack, w *fakeWriteStream, sn uint16, count int) uint16 {
+		for i := 0; i < count; i++ {
+			require.NoError(t, d.WriteRTP(newUpstreamPacket(t, sn+uint16(i), 9000+uint32(i)*3000), 0))
+		}
+		sent := w.sent()
+		return sent[len(sent)-1].SequenceNumber
+	}
+
+	t.Run("loss from receiver report", func(t *testing.T) {
+		d, w := newBoundTestDownTrack(t, &fakeTrackReceiver{})
+		lastSN := writePackets(t, d, w, 100, 10)
+
+		// 没有收到接收报告之前没有下行的丢包统计
+		require.Nil(t, d.rtpStats.DeltaOverridden(d.deltaStatsOverriddenSnapshotId))
+
+		// 没有发送过发送者报告时，接收报告中的丢包仍然有效
+		rr := &rtcp.ReceiverReport{SSRC: 1, Reports: []rtcp.ReceptionReport{{SSRC: d.ssrc, LastSequenceNumber: uint32(lastSN), TotalLost: 2}}}
+		raw, err := rr.Marshal()
+		require.NoError(t, err)
+		d.handleRTCP(raw)
+		delta := d.rtpStats.DeltaOverridden(d.deltaStatsOverriddenSnapshotId)
+		require.NotNil(t, delta)
+		require.Equal(t, uint32(10), delta.Packets)
+		require.Equal(t, uint32(2), delta.PacketsLost)
+	})
+
+	t.Run("quality from sender and receiver reports", func(t *testing.T) {
+		d, w := newBoundTestDownTrack(t, &fakeTrackReceiver{})
+		d.connectionStats.Close()
+		d.connectionStats = d.newConnectionStats(10 * time.Millisecond)
+		qualities := make(chan connectionquality.Quality, 10)
+		d.connectionStats.OnQualityChanged(func(_ *connectionquality.ConnectionStats, _ float32, quality connectionquality.Quality) {
+			qualities <- quality
+		})
+		d.connectionStats.Start()
+
+		lastSN := writePackets(t, d, w, 100, 50)
+		sr := d.CreateSenderReport()
+		require.NotNil(t, sr)
+
+		// 订阅者报告丢失了三成的包
+		time.Sleep(20 * time.Millisecond)
+		d.handleRTCP(replyToSenderReport(sr, rtcp.ReceptionReport{LastSequenceNumber: uint32(lastSN), TotalLost: 15}, 10*time.Millisecond))
+		require.NotZero(t, d.rtpStats.GetRtt())
+
+		select {
+		case quality := <-qualities:
+			require.Equal(t, connectionquality.QualityPoor, quality)
+		case <-time.After(time.Second):
+			t.Fatal("connection quality not updated")
+		}
+		score, _ := d.GetConnectionScoreAndQuality()
+		require.Less(t, score, float32(3))
+	})
+}
+
+func TestDownTrackPlayoutDelay(t *testing.T) {
+	t.Run("acknowledged by receiver report", func(t *testing.T) {
+		d, w := newBoundTestDownTrack(t, &fakeTrackReceiver{})
+		require.NoError(t, d.WriteRTP(newUpstreamPacket(t, 100, 9000), 0))
+		first := w.sent()[0].SequenceNumber
+
+		// 接收报告还没有覆盖第一个携带延迟的包，继续发送
+		d.handleRTCP(receiverReport(d.ssrc, first-1, 0))
+		require.NoError(t, d.WriteRTP(newUpstreamPacket(t, 101, 9000), 0))
+		require.NotNil(t, playoutDelayOf(t, w.sent()[1]))
+
+		// 其他 SSRC 的报告不影响
+		d.handleRTCP(receiverReport(1234, first+1, 0))
+		require.False(t, d.playoutDelayAcked.Load())
+
+		d.handleRTCP(receiverReport(d.ssrc, first, 0))
+		require.True(t, d.playoutDelayAcked.Load())
+		require.NoError(t, d.WriteRTP(newUpstreamPacket(t, 102, 12000), 0))
+		require.Nil(t, playoutDelayOf(t, w.sent()[2]))
+	})
+
+	t.Run("raised on high jitter", func(t *testing.T) {
+		d, w := newBoundTestDownTrack(t, &fakeTrackReceiver{})
+		require.NoError(t, d.WriteRTP(newUpstreamPacket(t, 100, 9000), 0))
+		first := w.sent()[0].SequenceNumber
+		d.handleRTCP(receiverReport(d.ssrc, first, 0))
+		require.True(t, d.playoutDelayAcked.Load())
+
+		// 抖动较小时不调整
+		d.handleRTCP(receiverReport(d.ssrc, first, 90*25/4))
+		require.True(t, d.playoutDelayAcked.Load())
+
+		// 100ms 的抖动需要 400ms 的最小延迟，重新发送直到确认
+		d.handleRTCP(receiverReport(d.ssrc, first, 90*100))
+		require.False(t, d.playoutDelayAcked.Load())
+		require.NoError(t, d.WriteRTP(newUpstreamPacket(t, 101, 12000), 0))
+		sent := w.sent()
+		require.Equal(t, &PlayoutDelay{Min: 400, Max: 2000}, playoutDelayOf(t, sent[len(sent)-1]))
+
+		// 不超过配置的最大延迟
+		d.handleRTCP(receiverReport(d.ssrc, first, 90*1000))
+		require.NoError(t, d.WriteRTP(newUpstreamPacket(t, 102, 15000), 0))
+		sent = w.sent()
+		require.Equal(t, &PlayoutDelay{Min: 2000, Max: 2000}, playoutDelayOf(t, sent[len(sent)-1]))
+	})
+
+	t.Run("raised up to extension limit without max", func(t *testing.T) {
+		d, w := newBoundTestDownTrack(t, &fakeTrackReceiver{})
+		d.params.PlayoutDelayLimit = &tc.PlayoutDelay{Enabled: true, Min: 100}
+		d.initPlayoutDelay()
+		require.NoError(t, d.WriteRTP(newUpstreamPacket(t, 100, 9000), 0))
+		require.Equal(t, &PlayoutDelay{Min: 100, Max: PlayoutDelayMaxValue}, playoutDelayOf(t, w.sent()[0]))
+
+		d.handleRTCP(receiverReport(d.ssrc, w.sent()[0].SequenceNumber, 90*1000))
+		require.NoError(t, d.WriteRTP(newUpstreamPacket(t, 101, 12000), 0))
+		sent := w.sent()
+		require.Equal(t, &PlayoutDelay{Min: 4000, Max: PlayoutDelayMaxValue}, playoutDelayOf(t, sent[len(sent)-1]))
+	})
+}
+
+func TestDownTrackRetransmitOnNACK(t *testing.T) {
+	receiver := &fakeTrackReceiver{}
+	d, w := newBoundTestDownTrack(t, receiver)
+	listener := &fakeNackListener{nacks: make(chan []NackInfo, 1)}
+	d.SetStreamAllocatorListener(listener)
+
+	for sn := uint16(100); sn <= 102; sn++ {
+		extPkt := newUpstreamPacket(t, sn, 9000)
+		require.NoError(t, d.WriteRTP(extPkt, 0))
+		// 101 已经不在上行缓冲中
+		if sn != 101 {
+			receiver.addPacket(t, extPkt.Packet)
+		}
+	}
+	sent := w.sent()
+	require.Len(t, sent, 3)
+
+	// 其他 SSRC 的 NACK 不处理
+	nack := func(ssrc uint32, sns ...uint16) []byte {
+		pkt := &rtcp.TransportLayerNack{MediaSSRC: ssrc, Nacks: rtcp.NackPairsFromSequenceNumbers(sns)}
+		raw, err := pkt.Marshal()
+		require.NoError(t, err)
+		return raw
+	}
+	d.handleRTCP(nack(1234, sent[0].SequenceNumber))
+
+	d.handleRTCP(nack(d.ssrc, sent[0].SequenceNumber, sent[1].SequenceNumber, sent[2].SequenceNumber))
+	var nackInfos []NackInfo
+	select {
+	case nackInfos = <-listener.nacks:
+	case <-time.After(time.Second):
+		require.Fail(t, "no nack processed")
+	}
+	require.Len(t, nackInfos, 2)
+	require.Equal(t, sent[0].SequenceNumber, nackInfos[0].SequenceNumber)
+	require.Equal(t, sent[2].SequenceNumber, nackInfos[1].SequenceNumber)
+
+	// 重传的包使用转发时的序列号、时间戳和 SSRC，依赖描述符随包重传
+	retransmitted := w.sent()[3:]
+	require.Len(t, retransmitted, 2)
+	for i, hdr := range retransmitted {
+		original := sent[i*2]
+		require.Equal(t, original.SequenceNumber, hdr.SequenceNumber)
+		require.Equal(t, original.Timestamp, hdr.Timestamp)
+		require.Equal(t, d.ssrc, hdr.SSRC)
+		require.Equal(t, []byte{0x80, 0x01, 0x02}, hdr.GetExtension(testDDExtID))
+	}
+
+	state := d.rtpStats.GetState()
+	require.Equal(t, uint32(2), state.NackAcks)
+	require.Equal(t, uint32(1), state.NackMisses)
+	require.Equal(t, uint32(3), state.Nacks)
+	require.NotZero(t, d.bytesRetransmitted.Load())
+}
+
+func TestDownTrackRetransmitIntervalFromRtt(t *testing.T) {
+	receiver := &fakeTrackReceiver{}
+	d, w := newBoundTestDownTrack(t, receiver)
+	listener := &fakeNackListener{nacks: make(chan []NackInfo, 1)}
+	d.SetStreamAllocatorListener(listener)
+
+	extPkt := newUpstreamPacket(t, 100, 9000)
+	require.NoError(t, d.WriteRTP(extPkt, 0))
+	receiver.addPacket(t, extPkt.Packet)
+	sn := w.sent()[0].SequenceNumber
+
+	// 订阅者收到发送者报告后立即回复，测得的 RTT 小于默认值
+	sr := d.CreateSenderReport()
+	require.NotNil(t, sr)
+	time.Sleep(30 * time.Millisecond)
+	d.handleRTCP(replyToSenderReport(sr, rtcp.ReceptionReport{LastSequenceNumber: uint32(sn)}, 0))
+	rtt := d.rtpStats.GetRtt()
+	require.NotZero(t, rtt)
+	require.Less(t, rtt, uint32(defaultRtt))
+
+	nack, err := (&rtcp.TransportLayerNack{MediaSSRC: d.ssrc, Nacks: rtcp.NackPairsFromSequenceNumbers([]uint16{sn})}).Marshal()
+	require.NoError(t, err)
+	waitRetransmit := func() {
+		select {
+		case <-listener.nacks:
+		case <-time.After(time.Second):
+			require.Fail(t, "no retransmission")
+		}
+	}
+
+	d.handleRTCP(nack)
+	waitRetransmit()
+	require.Len(t, w.sent(), 2)
+
+	// 一个 RTT 之内重复的请求不重传
+	d.handleRTCP(nack)
+	require.Eventually(t, func() bool {
+		return d.rtpStats.GetState().NackRepeated == 1
+	}, time.Second, time.Millisecond)
+	require.Len(t, w.sent(), 2)
+
+	// 超过测得的 RTT 但是还不到默认的 RTT，按测得的 RTT 重传
+	time.Sleep(time.Duration(rtt+(defaultRtt-rtt)/2) * time.Millisecond)
+	d.handleRTCP(nack)
+	waitRetransmit()
+	require.Len(t, w.sent(), 3)
+	require.Equal(t, sn, w.sent()[2].SequenceNumber)
+}
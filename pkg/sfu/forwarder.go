@@ -0,0 +1,580 @@
+package sfu
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+)
+
+// VideoPauseReason 视频暂停转发的原因
+type VideoPauseReason int
+
+const (
+	VideoPauseReasonNone VideoPauseReason = iota
+	VideoPauseReasonMuted
+	VideoPauseReasonPubMuted
+	VideoPauseReasonFeedDry
+	VideoPauseReasonBandwidth
+)
+
+func (v VideoPauseReason) String() string {
+	switch v {
+	case VideoPauseReasonNone:
+		return "NONE"
+	case VideoPauseReasonMuted:
+		return "MUTED"
+	case VideoPauseReasonPubMuted:
+		return "PUB_MUTED"
+	case VideoPauseReasonFeedDry:
+		return "FEED_DRY"
+	case VideoPauseReasonBandwidth:
+		return "BANDWIDTH"
+	default:
+		return fmt.Sprintf("%d", int(v))
+	}
+}
+
+// VideoAllocation 一次分配的结果
+type VideoAllocation struct {
+	PauseReason VideoPauseReason
+	// IsDeficient 没有分配到订阅者需要的最佳层
+	IsDeficient bool
+	// BandwidthRequested 目标层的码率
+	BandwidthRequested int64
+	// BandwidthDelta 相对于上次分配的码率变化
+	BandwidthDelta int64
+	// BandwidthNeeded 最佳层的码率
+	BandwidthNeeded int64
+	TargetLayer     buffer.VideoLayer
+	MaxLayer        buffer.VideoLayer
+}
+
+func (v VideoAllocation) String() string {
+	return fmt.Sprintf("VideoAllocation{pause: %s, def: %v, bwr: %d, del: %d, bwn: %d, target: %s, max: %s}",
+		v.PauseReason, v.IsDeficient, v.BandwidthRequested, v.BandwidthDelta, v.BandwidthNeeded, v.TargetLayer, v.MaxLayer)
+}
+
+// ForwarderState 迁移或者恢复订阅时保存的转发状态
+type ForwarderState struct {
+	Started bool `json:"started"`
+	// LastPacketAt 最后一个包的转发时间，恢复时按经过的时间推进时间戳
+	LastPacketAt time.Time      `json:"lastPacketAt"`
+	RTP          RTPMungerState `json:"rtp"`
+}
+
+func (f ForwarderState) String() string {
+	return fmt.Sprintf("ForwarderState{started: %v, lastPacketAt: %s, rtp: %s}",
+		f.Started, f.LastPacketAt.Format(time.RFC3339Nano), f.RTP.String())
+}
+
+// TranslationParams 转发一个包时需要的改写
+type TranslationParams struct {
+	shouldDrop bool
+	// isResuming 暂停之后转发的第一个包
+	isResuming bool
+	// isSwitchingToTarget 切换到了目标层
+	isSwitchingToTarget bool
+	rtp                 *TranslationParamsRTP
+}
+
+// Forwarder 选择转发的上行层，只在关键帧切换空间层，并改写 SSRC、序列号和时间戳，
+// 订阅者看到的是一路连续的流
+type Forwarder struct {
+	lock      sync.RWMutex
+	kind      webrtc.RTPCodecType
+	clockRate uint32
+	logger    logger.Logger
+
+	muted    bool
+	pubMuted bool
+
+	started      bool
+	seeded       ForwarderState
+	lastSSRC     uint32
+	lastPacketAt time.Time
+
+	maxLayer             buffer.VideoLayer
+	maxPublishedLayer    int32
+	maxTemporalLayerSeen int32
+
+	currentLayer   buffer.VideoLayer
+	targetLayer    buffer.VideoLayer
+	lastAllocation VideoAllocation
+
+	rtpMunger *RTPMunger
+}
+
+// NewForwarder 创建转发器，视频默认订阅最高层
+func NewForwarder(kind webrtc.RTPCodecType, clockRate uint32, logger logger.Logger) *Forwarder {
+	f := &Forwarder{
+		kind:                 kind,
+		clockRate:            clockRate,
+		logger:               logger,
+		maxLayer:             buffer.DefaultMaxLayer,
+		maxPublishedLayer:    buffer.DefaultMaxLayerSpatial,
+		maxTemporalLayerSeen: buffer.DefaultMaxLayerTemporal,
+		currentLayer:         buffer.InvalidLayer,
+		targetLayer:          buffer.InvalidLayer,
+		rtpMunger:            NewRTPMunger(logger),
+	}
+	if kind == webrtc.RTPCodecTypeAudio {
+		f.maxLayer = buffer.InvalidLayer
+	}
+	return f
+}
+
+// GetState 导出转发状态，还没有转发过包时返回空状态
+func (f *Forwarder) GetState() ForwarderState {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if !f.started {
+		return ForwarderState{}
+	}
+	return ForwarderState{
+		Started:      true,
+		LastPacketAt: f.lastPacketAt,
+		RTP:          f.rtpMunger.GetLast(),
+	}
+}
+
+// SeedState 使用之前导出的状态，第一个包接在之前最后一个包之后
+func (f *Forwarder) SeedState(state ForwarderState) {
+	if !state.Started {
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.rtpMunger.SeedLast(state.RTP)
+	f.seeded = state
+}
+
+// Mute 订阅者禁用音轨，返回状态是否变化
+func (f *Forwarder) Mute(muted bool) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.muted == muted {
+		return false
+	}
+	f.logger.Debugw("setting forwarder mute", "muted", muted)
+	f.muted = muted
+	if muted {
+		f.resetLayersLocked()
+	}
+	return true
+}
+
+// IsMuted 订阅者是否禁用音轨
+func (f *Forwarder) IsMuted() bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.muted
+}
+
+// PubMute 发布者静音，返回状态是否变化
+func (f *Forwarder) PubMute(pubMuted bool) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.pubMuted == pubMuted {
+		return false
+	}
+	f.logger.Debugw("setting forwarder pub mute", "pubMuted", pubMuted)
+	f.pubMuted = pubMuted
+	if pubMuted {
+		f.resetLayersLocked()
+	}
+	return true
+}
+
+// IsPubMuted 发布者是否静音
+func (f *Forwarder) IsPubMuted() bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.pubMuted
+}
+
+// SetMaxSpatialLayer 订阅者需要的最大空间层，返回是否变化
+func (f *Forwarder) SetMaxSpatialLayer(spatialLayer int32) (bool, buffer.VideoLayer) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.kind == webrtc.RTPCodecTypeAudio || spatialLayer == f.maxLayer.Spatial {
+		return false, f.maxLayer
+	}
+	f.logger.Debugw("setting max spatial layer", "layer", spatialLayer)
+	f.maxLayer.Spatial = spatialLayer
+	return true, f.maxLayer
+}
+
+// SetMaxTemporalLayer 订阅者需要的最大时间层，返回是否变化
+func (f *Forwarder) SetMaxTemporalLayer(temporalLayer int32) (bool, buffer.VideoLayer) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.kind == webrtc.RTPCodecTypeAudio || temporalLayer == f.maxLayer.Temporal {
+		return false, f.maxLayer
+	}
+	f.logger.Debugw("setting max temporal layer", "layer", temporalLayer)
+	f.maxLayer.Temporal = temporalLayer
+	return true, f.maxLayer
+}
+
+// MaxLayer 订阅者需要的最大层
+func (f *Forwarder) MaxLayer() buffer.VideoLayer {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.maxLayer
+}
+
+// SetMaxPublishedLayer 发布者发布的最大空间层，返回是否变化
+func (f *Forwarder) SetMaxPublishedLayer(layer int32) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if layer == f.maxPublishedLayer {
+		return false
+	}
+	f.maxPublishedLayer = layer
+	return true
+}
+
+// SetMaxTemporalLayerSeen 上行收到的最大时间层，返回是否变化
+func (f *Forwarder) SetMaxTemporalLayerSeen(layer int32) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if layer == f.maxTemporalLayerSeen {
+		return false
+	}
+	f.maxTemporalLayerSeen = layer
+	return true
+}
+
+// CurrentLayer 正在转发的层
+func (f *Forwarder) CurrentLayer() buffer.VideoLayer {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.currentLayer
+}
+
+// TargetLayer 分配的目标层
+func (f *Forwarder) TargetLayer() buffer.VideoLayer {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.targetLayer
+}
+
+// GetRequestLayerSpatial 等待关键帧切换时需要请求关键帧的空间层，不需要时返回无效层
+func (f *Forwarder) GetRequestLayerSpatial() int32 {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if f.kind == webrtc.RTPCodecTypeAudio || f.muted || f.pubMuted || f.targetLayer.Spatial == f.currentLayer.Spatial {
+		return buffer.InvalidLayerSpatial
+	}
+	return f.targetLayer.Spatial
+}
+
+// IsDeficient 没有转发订阅者需要的最佳层
+func (f *Forwarder) IsDeficient() bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.lastAllocation.IsDeficient
+}
+
+// LastAllocation 最近一次分配的结果
+func (f *Forwarder) LastAllocation() VideoAllocation {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.lastAllocation
+}
+
+// AllocateOptimal 不考虑带宽，选择订阅者最大层之内最高的可用层
+func (f *Forwarder) AllocateOptimal(availableLayers []int32, brs Bitrates) VideoAllocation {
+	return f.Allocate(availableLayers, brs, math.MaxInt64, false)
+}
+
+// Allocate 在可用带宽内选择最高的层，带宽不足以转发最低层时，allowPause 为 true 则暂停，否则转发最低层。
+// 还不知道各层码率时按订阅者的最大层转发
+func (f *Forwarder) Allocate(availableLayers []int32, brs Bitrates, availableChannelCapacity int64, allowPause bool) VideoAllocation {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.kind == webrtc.RTPCodecTypeAudio {
+		return f.lastAllocation
+	}
+
+	alloc := VideoAllocation{
+		TargetLayer: buffer.InvalidLayer,
+		MaxLayer:    f.maxLayer,
+	}
+	maxSeenLayer := buffer.VideoLayer{
+		Spatial:  min32(f.maxLayer.Spatial, f.maxPublishedLayer),
+		Temporal: min32(f.maxLayer.Temporal, f.maxTemporalLayerSeen),
+	}
+
+	switch {
+	case f.muted:
+		alloc.PauseReason = VideoPauseReasonMuted
+	case f.pubMuted:
+		alloc.PauseReason = VideoPauseReasonPubMuted
+	case !maxSeenLayer.IsValid():
+		// 订阅者不需要视频
+	case !hasBitrates(brs):
+		// 各层码率未知，按可用层机会性地转发
+		alloc.TargetLayer = maxSeenLayer
+		if spatial := highestAvailableLayer(availableLayers, maxSeenLayer.Spatial); spatial != buffer.InvalidLayerSpatial {
+			alloc.TargetLayer.Spatial = spatial
+		}
+	default:
+		optimal, lowest := buffer.InvalidLayer, buffer.InvalidLayer
+		for s := maxSeenLayer.Spatial; s >= 0; s-- {
+			if !isLayerAvailable(availableLayers, s) {
+				continue
+			}
+			for t := maxSeenLayer.Temporal; t >= 0; t-- {
+				if brs[s][t] == 0 {
+					continue
+				}
+				layer := buffer.VideoLayer{Spatial: s, Temporal: t}
+				if !optimal.IsValid() {
+					optimal = layer
+					alloc.BandwidthNeeded = brs[s][t]
+				}
+				if !alloc.TargetLayer.IsValid() && brs[s][t] <= availableChannelCapacity {
+					alloc.TargetLayer = layer
+				}
+				lowest = layer
+			}
+		}
+		if !alloc.TargetLayer.IsValid() {
+			if allowPause || !lowest.IsValid() {
+				alloc.PauseReason = VideoPauseReasonBandwidth
+			} else {
+				alloc.TargetLayer = lowest
+			}
+		}
+		if alloc.TargetLayer.IsValid() {
+			alloc.BandwidthRequested = brs[alloc.TargetLayer.Spatial][alloc.TargetLayer.Temporal]
+		}
+		alloc.IsDeficient = alloc.TargetLayer != optimal
+	}
+
+	alloc.BandwidthDelta = alloc.BandwidthRequested - f.lastAllocation.BandwidthRequested
+	f.setTargetLayerLocked(alloc.TargetLayer)
+	f.lastAllocation = alloc
+	return alloc
+}
+
+// Pause 带宽不足时暂停转发视频
+func (f *Forwarder) Pause() VideoAllocation {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	alloc := VideoAllocation{
+		PauseReason:    VideoPauseReasonBandwidth,
+		IsDeficient:    true,
+		BandwidthDelta: -f.lastAllocation.BandwidthRequested,
+		TargetLayer:    buffer.InvalidLayer,
+		MaxLayer:       f.maxLayer,
+	}
+	f.setTargetLayerLocked(alloc.TargetLayer)
+	f.lastAllocation = alloc
+	return alloc
+}
+
+func (f *Forwarder) setTargetLayerLocked(layer buffer.VideoLayer) {
+	if layer != f.targetLayer {
+		f.logger.Debugw("setting target layer", "from", f.targetLayer, "to", layer, "current", f.currentLayer)
+	}
+	f.targetLayer = layer
+	if !layer.IsValid() {
+		// 暂停立即生效，恢复时需要等待关键帧
+		f.currentLayer = buffer.InvalidLayer
+	}
+}
+
+func (f *Forwarder) resetLayersLocked() {
+	// 恢复后的第一个包重新计算偏移
+	f.lastSSRC = 0
+	f.currentLayer = buffer.InvalidLayer
+	if f.kind == webrtc.RTPCodecTypeVideo {
+		f.targetLayer = buffer.InvalidLayer
+	}
+}
+
+// GetTranslationParams 决定是否转发上行 layer 层的包，以及转发时的序列号和时间戳
+func (f *Forwarder) GetTranslationParams(extPkt *buffer.ExtPacket, layer int32) (*TranslationParams, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.muted || f.pubMuted {
+		return &TranslationParams{shouldDrop: true}, nil
+	}
+
+	tp := &TranslationParams{}
+	if f.kind == webrtc.RTPCodecTypeVideo && !f.selectLayerLocked(extPkt, layer, tp) {
+		tp.shouldDrop = true
+		return tp, nil
+	}
+
+	now := time.Now()
+	switch {
+	case !f.started:
+		if f.seeded.Started {
+			f.rtpMunger.UpdateSnTsOffsets(extPkt, 1, f.getTSJump(f.seeded.LastPacketAt, now))
+			f.seeded = ForwarderState{}
+		} else {
+			f.rtpMunger.SetLastSnTs(extPkt)
+		}
+		f.started = true
+		tp.isResuming = true
+	case extPkt.Packet.SSRC != f.lastSSRC || tp.isResuming:
+		// 切换了上行层或者暂停后恢复
+		f.rtpMunger.UpdateSnTsOffsets(extPkt, 1, f.getTSJump(f.lastPacketAt, now))
+	}
+	f.lastSSRC = extPkt.Packet.SSRC
+
+	if f.kind == webrtc.RTPCodecTypeVideo && extPkt.Temporal > f.currentLayer.Temporal {
+		// 高于目标时间层的帧不转发，后面的包序列号前移
+		f.rtpMunger.PacketDropped(extPkt)
+		tp.shouldDrop = true
+		return tp, nil
+	}
+
+	rtp, err := f.rtpMunger.UpdateAndGetSnTs(extPkt)
+	if err != nil {
+		tp.shouldDrop = true
+		return tp, err
+	}
+	if rtp.snOrdering != SequenceNumberOrderingOutOfOrder {
+		f.lastPacketAt = now
+	}
+	tp.rtp = rtp
+	return tp, nil
+}
+
+// selectLayerLocked 视频只在关键帧切换空间层，切换期间继续转发当前层
+func (f *Forwarder) selectLayerLocked(extPkt *buffer.ExtPacket, layer int32, tp *TranslationParams) bool {
+	if !f.targetLayer.IsValid() {
+		return false
+	}
+
+	if f.currentLayer.Spatial != f.targetLayer.Spatial && extPkt.KeyFrame {
+		// 目标层的关键帧，或者比当前层更接近目标层的关键帧
+		if layer == f.targetLayer.Spatial || (layer < f.targetLayer.Spatial && layer > f.currentLayer.Spatial) {
+			f.logger.Debugw("switching layer", "from", f.currentLayer, "to", layer, "target", f.targetLayer)
+			tp.isResuming = !f.currentLayer.IsValid()
+			tp.isSwitchingToTarget = layer == f.targetLayer.Spatial
+			f.currentLayer.Spatial = layer
+			f.currentLayer.Temporal = f.targetLayer.Temporal
+		}
+	}
+
+	if !f.currentLayer.IsValid() || layer != f.currentLayer.Spatial {
+		return false
+	}
+	if f.currentLayer.Spatial == f.targetLayer.Spatial {
+		f.currentLayer.Temporal = f.targetLayer.Temporal
+	}
+	return true
+}
+
+// TranslateTimestamp 把正在转发的上行层的时间戳改写为下行的时间戳，还没有开始转发时返回 false
+func (f *Forwarder) TranslateTimestamp(layer int32, ts uint32) (uint32, bool) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if !f.started || (f.kind == webrtc.RTPCodecTypeVideo && layer != f.currentLayer.Spatial) {
+		return 0, false
+	}
+	return f.rtpMunger.TranslateTimestamp(ts), true
+}
+
+// getTSJump 切换后时间戳按经过的时间推进，至少推进 1
+func (f *Forwarder) getTSJump(from time.Time, to time.Time) uint32 {
+	if from.IsZero() || f.clockRate == 0 {
+		return 1
+	}
+	jump := uint32(to.Sub(from).Seconds() * float64(f.clockRate))
+	if jump == 0 {
+		return 1
+	}
+	return jump
+}
+
+// DebugInfo 调试信息
+func (f *Forwarder) DebugInfo() map[string]interface{} {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return map[string]interface{}{
+		"Muted":          f.muted,
+		"PubMuted":       f.pubMuted,
+		"Started":        f.started,
+		"LastSSRC":       f.lastSSRC,
+		"MaxLayer":       f.maxLayer.String(),
+		"CurrentLayer":   f.currentLayer.String(),
+		"TargetLayer":    f.targetLayer.String(),
+		"LastAllocation": f.lastAllocation.String(),
+		"RTP":            f.rtpMunger.GetLast().String(),
+	}
+}
+
+// -------------------------------------------------------------------
+
+func hasBitrates(brs Bitrates) bool {
+	for _, layers := range brs {
+		for _, br := range layers {
+			if br != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isLayerAvailable(availableLayers []int32, layer int32) bool {
+	for _, l := range availableLayers {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+// highestAvailableLayer 不超过 maxLayer 的最高可用层
+func highestAvailableLayer(availableLayers []int32, maxLayer int32) int32 {
+	highest := buffer.InvalidLayerSpatial
+	for _, l := range availableLayers {
+		if l <= maxLayer && l > highest {
+			highest = l
+		}
+	}
+	return highest
+}
+
+func min32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
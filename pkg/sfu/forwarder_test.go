@@ -0,0 +1,175 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+)
+
+func newExtPacket(ssrc uint32, sn uint16, ts uint32, keyFrame bool, temporal int32) *buffer.ExtPacket {
+	return &buffer.ExtPacket{
+		VideoLayer: buffer.VideoLayer{Temporal: temporal},
+		Packet: &rtp.Packet{
+			Header:  rtp.Header{SSRC: ssrc, SequenceNumber: sn, Timestamp: ts},
+			Payload: []byte{0x01},
+		},
+		KeyFrame: keyFrame,
+	}
+}
+
+func TestRTPMunger(t *testing.T) {
+	r := NewRTPMunger(logger.GetLogger())
+	first := newExtPacket(1, 100, 1000, true, 0)
+	r.SetLastSnTs(first)
+
+	tp, err := r.UpdateAndGetSnTs(first)
+	require.NoError(t, err)
+	require.Equal(t, SequenceNumberOrderingContiguous, tp.snOrdering)
+	require.Equal(t, uint16(100), tp.sequenceNumber)
+
+	_, err = r.UpdateAndGetSnTs(first)
+	require.ErrorIs(t, err, ErrDuplicatePacket)
+
+	// 丢弃 101 之后 102 前移到 101
+	r.PacketDropped(newExtPacket(1, 101, 1000, false, 1))
+	tp, err = r.UpdateAndGetSnTs(newExtPacket(1, 102, 4000, false, 0))
+	require.NoError(t, err)
+	require.Equal(t, uint16(101), tp.sequenceNumber)
+
+	// 104 之前有空洞，103 乱序到达时使用原来的偏移
+	tp, err = r.UpdateAndGetSnTs(newExtPacket(1, 104, 7000, false, 0))
+	require.NoError(t, err)
+	require.Equal(t, SequenceNumberOrderingGap, tp.snOrdering)
+	require.Equal(t, uint16(103), tp.sequenceNumber)
+	tp, err = r.UpdateAndGetSnTs(newExtPacket(1, 103, 7000, false, 0))
+	require.NoError(t, err)
+	require.Equal(t, SequenceNumberOrderingOutOfOrder, tp.snOrdering)
+	require.Equal(t, uint16(102), tp.sequenceNumber)
+
+	// 切换到另外一路流，接在最后一个包之后
+	switched := newExtPacket(2, 5000, 90000, true, 0)
+	r.UpdateSnTsOffsets(switched, 1, 3000)
+	tp, err = r.UpdateAndGetSnTs(switched)
+	require.NoError(t, err)
+	require.Equal(t, uint16(104), tp.sequenceNumber)
+	require.Equal(t, uint32(10000), tp.timestamp)
+
+	// 切换之前的包无法改写
+	_, err = r.UpdateAndGetSnTs(newExtPacket(2, 4999, 89000, false, 0))
+	require.ErrorIs(t, err, ErrOutOfOrderSequenceNumberCacheMiss)
+}
+
+func TestForwarderAllocate(t *testing.T) {
+	f := NewForwarder(webrtc.RTPCodecTypeVideo, 90000, logger.GetLogger())
+
+	var brs Bitrates
+	brs[0][0] = 100_000
+	brs[1][0] = 300_000
+	brs[2][0] = 1_000_000
+	brs[2][1] = 1_500_000
+	layers := []int32{0, 1, 2}
+
+	alloc := f.AllocateOptimal(layers, brs)
+	require.Equal(t, buffer.VideoLayer{Spatial: 2, Temporal: 1}, alloc.TargetLayer)
+	require.False(t, alloc.IsDeficient)
+	require.Equal(t, int64(1_500_000), alloc.BandwidthNeeded)
+
+	alloc = f.Allocate(layers, brs, 500_000, false)
+	require.Equal(t, buffer.VideoLayer{Spatial: 1, Temporal: 0}, alloc.TargetLayer)
+	require.True(t, alloc.IsDeficient)
+	require.Equal(t, int64(300_000-1_500_000), alloc.BandwidthDelta)
+
+	// 带宽不足以转发最低层
+	alloc = f.Allocate(layers, brs, 50_000, false)
+	require.Equal(t, buffer.VideoLayer{Spatial: 0, Temporal: 0}, alloc.TargetLayer)
+	alloc = f.Allocate(layers, brs, 50_000, true)
+	require.Equal(t, VideoPauseReasonBandwidth, alloc.PauseReason)
+	require.False(t, alloc.TargetLayer.IsValid())
+
+	// 订阅者限制最大层
+	f.SetMaxSpatialLayer(0)
+	alloc = f.AllocateOptimal(layers, brs)
+	require.Equal(t, buffer.VideoLayer{Spatial: 0, Temporal: 0}, alloc.TargetLayer)
+	require.False(t, alloc.IsDeficient)
+
+	f.Mute(true)
+	alloc = f.AllocateOptimal(layers, brs)
+	require.Equal(t, VideoPauseReasonMuted, alloc.PauseReason)
+}
+
+func TestForwarderSwitchOnKeyFrame(t *testing.T) {
+	f := NewForwarder(webrtc.RTPCodecTypeVideo, 90000, logger.GetLogger())
+	f.AllocateOptimal([]int32{0, 1}, Bitrates{})
+	require.Equal(t, int32(1), f.GetRequestLayerSpatial())
+
+	// 等待关键帧，非关键帧不转发
+	tp, err := f.GetTranslationParams(newExtPacket(10, 10, 1000, false, 0), 0)
+	require.NoError(t, err)
+	require.True(t, tp.shouldDrop)
+
+	// 较低层的关键帧先开始转发
+	tp, err = f.GetTranslationParams(newExtPacket(10, 11, 1000, true, 0), 0)
+	require.NoError(t, err)
+	require.False(t, tp.shouldDrop)
+	require.True(t, tp.isResuming)
+	require.Equal(t, uint16(11), tp.rtp.sequenceNumber)
+	require.Equal(t, int32(1), f.GetRequestLayerSpatial())
+
+	// 目标层的非关键帧不切换
+	tp, err = f.GetTranslationParams(newExtPacket(20, 500, 50000, false, 0), 1)
+	require.NoError(t, err)
+	require.True(t, tp.shouldDrop)
+
+	tp, err = f.GetTranslationParams(newExtPacket(10, 12, 4000, false, 0), 0)
+	require.NoError(t, err)
+	require.Equal(t, uint16(12), tp.rtp.sequenceNumber)
+
+	// 目标层的关键帧切换，序列号连续
+	tp, err = f.GetTranslationParams(newExtPacket(20, 501, 53000, true, 0), 1)
+	require.NoError(t, err)
+	require.True(t, tp.isSwitchingToTarget)
+	require.Equal(t, uint16(13), tp.rtp.sequenceNumber)
+	require.Equal(t, buffer.InvalidLayerSpatial, f.GetRequestLayerSpatial())
+
+	// 切换之后不再转发原来的层
+	tp, err = f.GetTranslationParams(newExtPacket(10, 13, 7000, false, 0), 0)
+	require.NoError(t, err)
+	require.True(t, tp.shouldDrop)
+
+	// 导出状态后新的转发器接着转发
+	state := f.GetState()
+	require.True(t, state.Started)
+	require.Equal(t, uint16(13), state.RTP.LastSN)
+
+	seeded := NewForwarder(webrtc.RTPCodecTypeVideo, 90000, logger.GetLogger())
+	seeded.SeedState(state)
+	seeded.AllocateOptimal([]int32{0, 1}, Bitrates{})
+	tp, err = seeded.GetTranslationParams(newExtPacket(30, 9000, 123456, true, 0), 1)
+	require.NoError(t, err)
+	require.Equal(t, uint16(14), tp.rtp.sequenceNumber)
+}
+
+func TestForwarderTemporalFilter(t *testing.T) {
+	f := NewForwarder(webrtc.RTPCodecTypeVideo, 90000, logger.GetLogger())
+	f.SetMaxTemporalLayer(0)
+	f.AllocateOptimal([]int32{0}, Bitrates{})
+
+	tp, err := f.GetTranslationParams(newExtPacket(10, 1, 1000, true, 0), 0)
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), tp.rtp.sequenceNumber)
+
+	// 高于目标时间层的包被丢弃，后面的包序列号连续
+	tp, err = f.GetTranslationParams(newExtPacket(10, 2, 4000, false, 1), 0)
+	require.NoError(t, err)
+	require.True(t, tp.shouldDrop)
+
+	tp, err = f.GetTranslationParams(newExtPacket(10, 3, 7000, false, 0), 0)
+	require.NoError(t, err)
+	require.Equal(t, uint16(2), tp.rtp.sequenceNumber)
+}
@@ -0,0 +1,53 @@
+package pacer
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+)
+
+// PassThrough 不排队的发送器，包入队后立即发送
+type PassThrough struct {
+	logger logger.Logger
+}
+
+// NewPassThrough 创建不排队的发送器
+func NewPassThrough(logger logger.Logger) *PassThrough {
+	return &PassThrough{
+		logger: logger,
+	}
+}
+
+// Enqueue 立即发送包
+func (p *PassThrough) Enqueue(pkt Packet) {
+	sendingAt := time.Now()
+	for _, ext := range pkt.Extensions {
+		if err := pkt.Header.SetExtension(ext.ID, ext.Payload); err != nil {
+			p.logger.Warnw("could not set extension", err, "extID", ext.ID)
+		}
+	}
+	if pkt.AbsSendTimeExtID != 0 {
+		sendTime := rtp.NewAbsSendTimeExtension(sendingAt)
+		if b, err := sendTime.Marshal(); err == nil {
+			if err = pkt.Header.SetExtension(pkt.AbsSendTimeExtID, b); err != nil {
+				p.logger.Warnw("could not set abs send time", err)
+			}
+		}
+	}
+
+	_, err := pkt.WriteStream.WriteRTP(pkt.Header, pkt.Payload)
+	if pkt.OnSent != nil {
+		pkt.OnSent(pkt.Metadata, pkt.Header, len(pkt.Payload), sendingAt, err)
+	}
+}
+
+// Stop 停止，没有需要释放的资源
+func (p *PassThrough) Stop() {}
+
+// SetInterval 不排队的发送器忽略间隔
+func (p *PassThrough) SetInterval(_ time.Duration) {}
+
+// SetBitrate 不排队的发送器忽略比特率
+func (p *PassThrough) SetBitrate(_ int) {}
@@ -0,0 +1,143 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// PlayoutDelayURI 播放延迟头扩展，告诉接收端渲染前的最小和最大缓冲时间
+	PlayoutDelayURI = "http://www.webrtc.org/experiments/rtp-hdrext/playout-delay"
+
+	// playoutDelayGranularity 扩展中的延迟以 10ms 为单位
+	playoutDelayGranularity = 10
+	// PlayoutDelayMaxValue 扩展能表示的最大延迟，12 位
+	PlayoutDelayMaxValue = playoutDelayGranularity * 0xfff
+
+	playoutDelayExtensionSize = 3
+
+	// playoutDelayJitterMultiplier 根据接收报告的抖动估计需要的最小延迟
+	playoutDelayJitterMultiplier = 4
+	// playoutDelayRaiseStep 目标延迟至少比当前延迟高这么多时才提高，避免频繁重发扩展
+	playoutDelayRaiseStep = 20
+)
+
+var ErrPlayoutDelayTooSmall = errors.New("playout delay extension too small")
+
+// PlayoutDelay 播放延迟，单位毫秒
+type PlayoutDelay struct {
+	Min uint16
+	Max uint16
+}
+
+// NewPlayoutDelay 按扩展的取值范围创建播放延迟，max 为 0 时不限制最大延迟
+func NewPlayoutDelay(min, max uint32) PlayoutDelay {
+	if max == 0 || max > PlayoutDelayMaxValue {
+		max = PlayoutDelayMaxValue
+	}
+	if min > max {
+		min = max
+	}
+	return PlayoutDelay{Min: uint16(min), Max: uint16(max)}
+}
+
+func (p PlayoutDelay) String() string {
+	return fmt.Sprintf("PlayoutDelay{min: %dms, max: %dms}", p.Min, p.Max)
+}
+
+// Marshal 编组为扩展的负载，最小和最大延迟各占 12 位
+func (p PlayoutDelay) Marshal() []byte {
+	min := p.Min / playoutDelayGranularity
+	max := p.Max / playoutDelayGranularity
+	return []byte{byte(min >> 4), byte(min<<4) | byte(max>>8&0x0f), byte(max)}
+}
+
+// Unmarshal 从扩展的负载解析
+func (p *PlayoutDelay) Unmarshal(buf []byte) error {
+	if len(buf) < playoutDelayExtensionSize {
+		return ErrPlayoutDelayTooSmall
+	}
+	p.Min = (uint16(buf[0])<<4 | uint16(buf[1])>>4) * playoutDelayGranularity
+	p.Max = (uint16(buf[1]&0x0f)<<8 | uint16(buf[2])) * playoutDelayGranularity
+	return nil
+}
+
+// -------------------------------------------------------------------
+
+// initPlayoutDelay 订阅者开启了播放延迟并且协商了扩展时，按订阅者的配置开始发送
+func (d *DownTrack) initPlayoutDelay() {
+	limit := d.params.PlayoutDelayLimit
+	if d.kind != webrtc.RTPCodecTypeVideo || d.playoutDelayExtID == 0 || limit == nil || !limit.Enabled {
+		return
+	}
+
+	d.playoutDelayLock.Lock()
+	defer d.playoutDelayLock.Unlock()
+
+	d.setPlayoutDelayLocked(NewPlayoutDelay(limit.Min, limit.Max))
+}
+
+func (d *DownTrack) setPlayoutDelayLocked(delay PlayoutDelay) {
+	d.playoutDelay = delay
+	d.playoutDelaySent = false
+	d.playoutDelayBytes.Store(delay.Marshal())
+	d.playoutDelayAcked.Store(false)
+}
+
+// getPlayoutDelayExtension 需要写入序列号为 sn 的包的播放延迟扩展，接收端确认之后不再发送
+func (d *DownTrack) getPlayoutDelayExtension(sn uint16) []byte {
+	if d.playoutDelayExtID == 0 || d.playoutDelayAcked.Load() {
+		return nil
+	}
+
+	d.playoutDelayLock.Lock()
+	defer d.playoutDelayLock.Unlock()
+
+	bytes, _ := d.playoutDelayBytes.Load().([]byte)
+	if bytes == nil {
+		return nil
+	}
+	if !d.playoutDelaySent {
+		d.playoutDelaySN = sn
+		d.playoutDelaySent = true
+	}
+	return bytes
+}
+
+// handlePlayoutDelayReport 接收报告覆盖了第一个携带当前延迟的包时视为确认，抖动较大时提高最小延迟
+func (d *DownTrack) handlePlayoutDelayReport(report rtcp.ReceptionReport) {
+	if d.playoutDelayExtID == 0 {
+		return
+	}
+
+	d.playoutDelayLock.Lock()
+	defer d.playoutDelayLock.Unlock()
+
+	if d.playoutDelayBytes.Load() == nil {
+		return
+	}
+
+	if d.playoutDelaySent && !d.playoutDelayAcked.Load() && int16(uint16(report.LastSequenceNumber)-d.playoutDelaySN) >= 0 {
+		d.playoutDelayAcked.Store(true)
+		d.logger.Debugw("playout delay acked", "delay", d.playoutDelay)
+	}
+
+	if d.codec.ClockRate == 0 {
+		return
+	}
+	target := uint64(report.Jitter) * 1000 / uint64(d.codec.ClockRate) * playoutDelayJitterMultiplier
+	if target > uint64(d.playoutDelay.Max) {
+		target = uint64(d.playoutDelay.Max)
+	}
+	target -= target % playoutDelayGranularity
+	if target < uint64(d.playoutDelay.Min)+playoutDelayRaiseStep {
+		return
+	}
+
+	delay := PlayoutDelay{Min: uint16(target), Max: d.playoutDelay.Max}
+	d.logger.Infow("raising playout delay on high jitter", "jitter", report.Jitter, "from", d.playoutDelay, "to", delay)
+	d.setPlayoutDelayLocked(delay)
+}
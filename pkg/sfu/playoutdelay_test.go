@@ -0,0 +1,37 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlayoutDelayMarshal(t *testing.T) {
+	delay := NewPlayoutDelay(100, 2000)
+	buf := delay.Marshal()
+	require.Len(t, buf, playoutDelayExtensionSize)
+	// 100ms 和 2000ms 按 10ms 编码为 10 和 200
+	require.Equal(t, []byte{0x00, 0xa0, 0xc8}, buf)
+
+	var parsed PlayoutDelay
+	require.NoError(t, parsed.Unmarshal(buf))
+	require.Equal(t, delay, parsed)
+
+	require.ErrorIs(t, parsed.Unmarshal(buf[:2]), ErrPlayoutDelayTooSmall)
+}
+
+func TestNewPlayoutDelay(t *testing.T) {
+	// 没有设置最大延迟时使用扩展能表示的最大值
+	delay := NewPlayoutDelay(200, 0)
+	require.Equal(t, uint16(200), delay.Min)
+	require.Equal(t, uint16(PlayoutDelayMaxValue), delay.Max)
+
+	var parsed PlayoutDelay
+	require.NoError(t, parsed.Unmarshal(delay.Marshal()))
+	require.Equal(t, delay, parsed)
+
+	// 最小延迟不超过最大延迟
+	delay = NewPlayoutDelay(500, 300)
+	require.Equal(t, uint16(300), delay.Min)
+	require.Equal(t, uint16(300), delay.Max)
+}
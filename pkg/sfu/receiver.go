@@ -2,16 +2,33 @@ package sfu
 
 import (
 	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/proto"
+
 	"github.com/liuhailove/tc-base-go/protocol/logger"
 	"github.com/liuhailove/tc-base-go/protocol/tc"
 	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
-	"github.com/pion/webrtc/v3"
+	"github.com/liuhailove/tc-server/pkg/sfu/connectionquality"
 )
 
 var (
 	ErrReceiverClosed        = errors.New("receiver closed")
 	ErrDownTrackAlreadyExist = errors.New("DownTrack already exist")
 	ErrBufferNotFound        = errors.New("buffer not found")
+
+	ErrReferenceLayerUnavailable = errors.New("reference layer sender report unavailable")
+)
+
+const (
+	// bitrateReportInterval 各层码率的统计周期
+	bitrateReportInterval = time.Second
 )
 
 type AudioLevelHandle func(level uint8, duration uint32)
@@ -54,9 +71,528 @@ type TrackReceiver interface {
 
 	GetCalculatedClockRate(layer int32) uint32
 	GetReferenceLayerRTPTimestamp(ts uint32, layer int32, referenceLayer int32) (uint32, error)
+
+	// GetConnectionScoreAndQuality 发布者上行的连接质量
+	GetConnectionScoreAndQuality() (float32, connectionquality.Quality)
 }
 
-// WebRTCReceiver 接收媒体轨道
+// WebRTCReceiver 接收媒体轨道，每个空间层一个上行音轨和缓冲，
+// 从缓冲读取的包转发给全部下行音轨
 type WebRTCReceiver struct {
 	logger logger.Logger
+
+	trackInfoLock sync.RWMutex
+	trackInfo     *tc.TrackInfo
+
+	receiver *webrtc.RTPReceiver
+	codec    webrtc.RTPCodecParameters
+	kind     webrtc.RTPCodecType
+	trackID  tc.TrackID
+	streamID string
+	onRTCP   func([]rtcp.Packet)
+
+	closed      atomic.Bool
+	closeOnce   sync.Once
+	numLoops    atomic.Int32
+	paused      atomic.Bool
+	maxExpLayer atomic.Int32
+
+	upTrackMu sync.RWMutex
+	upTracks  [buffer.DefaultMaxLayerSpatial + 1]*webrtc.TrackRemote
+	buffers   [buffer.DefaultMaxLayerSpatial + 1]*buffer.Buffer
+
+	downTrackMu sync.RWMutex
+	downTracks  map[tc.ParticipantID]TrackSender
+
+	bitrateMu            sync.Mutex
+	layerBytes           [buffer.DefaultMaxLayerSpatial + 1][buffer.DefaultMaxLayerTemporal + 1]int64
+	bitrates             Bitrates
+	maxTemporalLayerSeen int32
+	lastBitrateReport    time.Time
+
+	connectionStats *connectionquality.ConnectionStats
+
+	onCloseHandler func()
+}
+
+// NewWebRTCReceiver 创建接收器，联播时每个空间层的上行音轨通过 AddUpTrack 添加，
+// onRTCP 用于向发布者发送接收报告、NACK 和 PLI
+func NewWebRTCReceiver(
+	receiver *webrtc.RTPReceiver,
+	track *webrtc.TrackRemote,
+	trackInfo *tc.TrackInfo,
+	logger logger.Logger,
+	onRTCP func([]rtcp.Packet),
+) *WebRTCReceiver {
+	w := &WebRTCReceiver{
+		logger:               logger,
+		trackInfo:            proto.Clone(trackInfo).(*tc.TrackInfo),
+		receiver:             receiver,
+		codec:                track.Codec(),
+		kind:                 track.Kind(),
+		trackID:              tc.TrackID(trackInfo.Sid),
+		streamID:             track.StreamID(),
+		onRTCP:               onRTCP,
+		downTracks:           make(map[tc.ParticipantID]TrackSender),
+		maxTemporalLayerSeen: buffer.InvalidLayerTemporal,
+		lastBitrateReport:    time.Now(),
+	}
+	w.maxExpLayer.Store(buffer.DefaultMaxLayerSpatial)
+
+	// 上行音轨的丢包和抖动以全部层的接收统计为准
+	fmtp := strings.ToLower(w.codec.SDPFmtpLine)
+	w.connectionStats = connectionquality.NewConnectionStats(connectionquality.ConnectionStatsParams{
+		MimeType:      w.codec.MimeType,
+		IsDTXEnabled:  strings.Contains(fmtp, "usedtx=1"),
+		IsFECEnabled:  strings.Contains(fmtp, "useinbandfec=1"),
+		GetDeltaStats: w.getQualityDeltaStats,
+		Logger:        logger,
+	})
+	w.connectionStats.Start()
+
+	go w.bitrateWorker()
+	return w
+}
+
+// AddUpTrack 添加上行音轨和它的接收缓冲，空间层由 rid 决定，添加后开始转发
+func (w *WebRTCReceiver) AddUpTrack(track *webrtc.TrackRemote, buff *buffer.Buffer) {
+	if w.closed.Load() {
+		return
+	}
+
+	layer := int32(0)
+	if w.kind == webrtc.RTPCodecTypeVideo {
+		layer = buffer.RidToSpatialLayer(track.RID(), w.TrackInfo())
+	}
+	if layer < 0 || int(layer) >= len(w.upTracks) {
+		w.logger.Warnw("invalid layer", nil, "rid", track.RID(), "layer", layer)
+		return
+	}
+
+	w.upTrackMu.Lock()
+	if w.upTracks[layer] != nil {
+		w.upTrackMu.Unlock()
+		w.logger.Debugw("up track already added", "rid", track.RID(), "layer", layer)
+		return
+	}
+	w.upTracks[layer] = track
+	w.buffers[layer] = buff
+	w.upTrackMu.Unlock()
+
+	buff.SetPaused(w.paused.Load())
+	buff.OnRtcpFeedback(w.sendRTCP)
+	buff.OnRtcpSenderReport(func() {
+		w.handleSenderReport(layer, buff)
+	})
+
+	w.numLoops.Inc()
+	go w.forwardRTP(layer, buff)
+
+	w.logger.Debugw("added up track", "rid", track.RID(), "layer", layer, "ssrc", track.SSRC())
+	for _, dt := range w.getDownTracks() {
+		dt.UpTrackLayerChange()
+	}
+}
+
+// TrackID 音轨ID
+func (w *WebRTCReceiver) TrackID() tc.TrackID {
+	return w.trackID
+}
+
+// StreamID 流ID
+func (w *WebRTCReceiver) StreamID() string {
+	return w.streamID
+}
+
+// Codec 编解码器
+func (w *WebRTCReceiver) Codec() webrtc.RTPCodecParameters {
+	return w.codec
+}
+
+// Kind 音轨类型
+func (w *WebRTCReceiver) Kind() webrtc.RTPCodecType {
+	return w.kind
+}
+
+// HeaderExtensions 发布者协商出的头扩展
+func (w *WebRTCReceiver) HeaderExtensions() []webrtc.RTPHeaderExtensionParameter {
+	return w.receiver.GetParameters().HeaderExtensions
+}
+
+// IsClosed 是否已经关闭
+func (w *WebRTCReceiver) IsClosed() bool {
+	return w.closed.Load()
+}
+
+// TrackInfo 音轨信息
+func (w *WebRTCReceiver) TrackInfo() *tc.TrackInfo {
+	w.trackInfoLock.RLock()
+	defer w.trackInfoLock.RUnlock()
+
+	return w.trackInfo
+}
+
+// UpdateTrackInfo 发布者更新音轨信息，层信息变化时重新选择转发的层
+func (w *WebRTCReceiver) UpdateTrackInfo(ti *tc.TrackInfo) {
+	w.trackInfoLock.Lock()
+	w.trackInfo = proto.Clone(ti).(*tc.TrackInfo)
+	w.trackInfoLock.Unlock()
+
+	for _, dt := range w.getDownTracks() {
+		dt.TrackInfoAvailable()
+	}
+}
+
+// OnCloseHandler 全部上行音轨结束后的事件
+func (w *WebRTCReceiver) OnCloseHandler(fn func()) {
+	w.upTrackMu.Lock()
+	defer w.upTrackMu.Unlock()
+
+	w.onCloseHandler = fn
+}
+
+// ReadRTP 从 layer 层的缓冲读取原始包，用于重传
+func (w *WebRTCReceiver) ReadRTP(buf []byte, layer uint8, sn uint16) (int, error) {
+	buff := w.getBuffer(int32(layer))
+	if buff == nil {
+		return 0, ErrBufferNotFound
+	}
+	return buff.GetPacket(buf, sn)
+}
+
+// GetLayeredBitrate 正在接收的空间层和各层码率，码率是该层直到对应时间层的累计码率
+func (w *WebRTCReceiver) GetLayeredBitrate() ([]int32, Bitrates) {
+	w.bitrateMu.Lock()
+	brs := w.bitrates
+	w.bitrateMu.Unlock()
+
+	var availableLayers []int32
+	w.upTrackMu.RLock()
+	for layer, buff := range w.buffers {
+		if buff != nil {
+			availableLayers = append(availableLayers, int32(layer))
+		}
+	}
+	w.upTrackMu.RUnlock()
+	return availableLayers, brs
+}
+
+// GetAudioLevel 音频级别
+func (w *WebRTCReceiver) GetAudioLevel() (float64, bool) {
+	if w.kind != webrtc.RTPCodecTypeAudio {
+		return 0, false
+	}
+	buff := w.getBuffer(0)
+	if buff == nil {
+		return 0, false
+	}
+	return buff.GetAudioLevel()
+}
+
+// SendPLI 向发布者请求 layer 层的关键帧
+func (w *WebRTCReceiver) SendPLI(layer int32, force bool) {
+	if buff := w.getBuffer(layer); buff != nil {
+		buff.SendPLI(force)
+	}
+}
+
+// SetUpTrackPaused 发布者静音时暂停转发
+func (w *WebRTCReceiver) SetUpTrackPaused(paused bool) {
+	w.paused.Store(paused)
+	w.connectionStats.UpdateMute(paused)
+
+	w.upTrackMu.RLock()
+	buffers := w.buffers
+	w.upTrackMu.RUnlock()
+	for _, buff := range buffers {
+		if buff != nil {
+			buff.SetPaused(paused)
+		}
+	}
+}
+
+// SetMaxExpectedSpatialLayer 发布者预期发送的最大空间层
+func (w *WebRTCReceiver) SetMaxExpectedSpatialLayer(layer int32) {
+	w.maxExpLayer.Store(layer)
+}
+
+// AddDownTrack 添加下行音轨
+func (w *WebRTCReceiver) AddDownTrack(track TrackSender) error {
+	if w.closed.Load() {
+		return ErrReceiverClosed
+	}
+
+	w.downTrackMu.Lock()
+	if _, ok := w.downTracks[track.SubscriberID()]; ok {
+		w.downTrackMu.Unlock()
+		return ErrDownTrackAlreadyExist
+	}
+	w.downTracks[track.SubscriberID()] = track
+	w.downTrackMu.Unlock()
+
+	track.UpTrackMaxPublishedLayerChange(w.maxExpLayer.Load())
+	w.bitrateMu.Lock()
+	maxTemporalLayerSeen := w.maxTemporalLayerSeen
+	w.bitrateMu.Unlock()
+	if maxTemporalLayerSeen != buffer.InvalidLayerTemporal {
+		track.UpTrackMaxTemporalLayerSeenChange(maxTemporalLayerSeen)
+	}
+	track.TrackInfoAvailable()
+	return nil
+}
+
+// DeleteDownTrack 移除下行音轨
+func (w *WebRTCReceiver) DeleteDownTrack(participantID tc.ParticipantID) {
+	w.downTrackMu.Lock()
+	defer w.downTrackMu.Unlock()
+
+	delete(w.downTracks, participantID)
+}
+
+// DebugInfo 调试信息
+func (w *WebRTCReceiver) DebugInfo() map[string]interface{} {
+	availableLayers, brs := w.GetLayeredBitrate()
+	w.downTrackMu.RLock()
+	numDownTracks := len(w.downTracks)
+	w.downTrackMu.RUnlock()
+
+	return map[string]interface{}{
+		"TrackID":         w.trackID,
+		"MimeType":        w.codec.MimeType,
+		"Closed":          w.closed.Load(),
+		"AvailableLayers": availableLayers,
+		"Bitrates":        brs,
+		"DownTracks":      numDownTracks,
+	}
+}
+
+// GetPrimaryReceiverForRed 不区分 RED，返回自身
+func (w *WebRTCReceiver) GetPrimaryReceiverForRed() TrackReceiver {
+	return w
+}
+
+// GetRedReceiver 不区分 RED，返回自身
+func (w *WebRTCReceiver) GetRedReceiver() TrackReceiver {
+	return w
+}
+
+// GetTemporalLayerFpsForSpatial 不统计帧率
+func (w *WebRTCReceiver) GetTemporalLayerFpsForSpatial(_ int32) []float32 {
+	return nil
+}
+
+// GetCalculatedClockRate 时钟频率
+func (w *WebRTCReceiver) GetCalculatedClockRate(_ int32) uint32 {
+	return w.codec.ClockRate
+}
+
+// GetReferenceLayerRTPTimestamp 根据两层最新的发送报告，把 layer 层的时间戳换算到参考层
+func (w *WebRTCReceiver) GetReferenceLayerRTPTimestamp(ts uint32, layer int32, referenceLayer int32) (uint32, error) {
+	if layer == referenceLayer {
+		return ts, nil
+	}
+
+	buff, refBuff := w.getBuffer(layer), w.getBuffer(referenceLayer)
+	if buff == nil || refBuff == nil {
+		return 0, ErrReferenceLayerUnavailable
+	}
+	_, srLayer := buff.GetSenderReportData()
+	_, srRef := refBuff.GetSenderReportData()
+	if srLayer == nil || srRef == nil {
+		return 0, ErrReferenceLayerUnavailable
+	}
+
+	// 把 layer 层的发送报告时间戳推算到参考层发送报告的时刻，两者的差值即为层间偏移
+	ntpDiff := srRef.NTPTimestamp.Time().Sub(srLayer.NTPTimestamp.Time())
+	rtpDiff := ntpDiff.Nanoseconds() * int64(w.codec.ClockRate) / 1e9
+	normalized := srLayer.RTPTimestamp + uint32(rtpDiff)
+	return ts + (srRef.RTPTimestamp - normalized), nil
+}
+
+// GetConnectionScoreAndQuality 发布者上行的连接质量
+func (w *WebRTCReceiver) GetConnectionScoreAndQuality() (float32, connectionquality.Quality) {
+	return w.connectionStats.GetScoreAndQuality()
+}
+
+// getQualityDeltaStats 全部层上次评分以来的接收统计，还没有收到数据时返回 nil
+func (w *WebRTCReceiver) getQualityDeltaStats() *buffer.RTPDeltaInfo {
+	w.upTrackMu.RLock()
+	buffers := w.buffers
+	w.upTrackMu.RUnlock()
+
+	var deltas []*buffer.RTPDeltaInfo
+	for _, buff := range buffers {
+		if buff == nil {
+			continue
+		}
+		if delta := buff.GetQualityDeltaStats(); delta != nil {
+			deltas = append(deltas, delta)
+		}
+	}
+	return buffer.AggregateRTPDeltaInfo(deltas)
+}
+
+func (w *WebRTCReceiver) getBuffer(layer int32) *buffer.Buffer {
+	if w.kind != webrtc.RTPCodecTypeVideo {
+		layer = 0
+	}
+	if layer < 0 || int(layer) >= len(w.buffers) {
+		return nil
+	}
+
+	w.upTrackMu.RLock()
+	defer w.upTrackMu.RUnlock()
+
+	return w.buffers[layer]
+}
+
+func (w *WebRTCReceiver) getDownTracks() []TrackSender {
+	w.downTrackMu.RLock()
+	defer w.downTrackMu.RUnlock()
+
+	downTracks := make([]TrackSender, 0, len(w.downTracks))
+	for _, dt := range w.downTracks {
+		downTracks = append(downTracks, dt)
+	}
+	return downTracks
+}
+
+func (w *WebRTCReceiver) sendRTCP(pkts []rtcp.Packet) {
+	if w.onRTCP != nil && !w.closed.Load() {
+		w.onRTCP(pkts)
+	}
+}
+
+// handleSenderReport 发布者的发送报告转给下行音轨，用于对齐统计的起始时间
+func (w *WebRTCReceiver) handleSenderReport(layer int32, buff *buffer.Buffer) {
+	_, srNewest := buff.GetSenderReportData()
+	if srNewest == nil {
+		return
+	}
+	for _, dt := range w.getDownTracks() {
+		_ = dt.HandleRTCPSenderReportData(w.codec.PayloadType, layer, srNewest)
+	}
+}
+
+// forwardRTP 从 layer 层的缓冲读取包并转发给全部下行音轨，缓冲关闭后结束，
+// 全部层结束后关闭接收器
+func (w *WebRTCReceiver) forwardRTP(layer int32, buff *buffer.Buffer) {
+	defer func() {
+		if w.numLoops.Dec() == 0 {
+			w.close()
+		}
+	}()
+
+	for {
+		pkt, err := buff.ReadExtended()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			continue
+		}
+
+		w.recordPacket(layer, pkt)
+		for _, dt := range w.getDownTracks() {
+			if err := dt.WriteRTP(pkt, layer); err != nil {
+				w.logger.Debugw("could not write to down track", "error", err, "subscriberID", dt.SubscriberID())
+			}
+		}
+	}
+}
+
+// recordPacket 按层统计字节数，时间层变化时通知下行音轨
+func (w *WebRTCReceiver) recordPacket(layer int32, pkt *buffer.ExtPacket) {
+	temporal := pkt.Temporal
+	if temporal < 0 || temporal > buffer.DefaultMaxLayerTemporal {
+		temporal = 0
+	}
+	if pkt.Spatial >= 0 && pkt.Spatial <= buffer.DefaultMaxLayerSpatial && pkt.DependencyDescriptor != nil {
+		// SVC 的空间层在依赖描述符中
+		layer = pkt.Spatial
+	}
+
+	w.bitrateMu.Lock()
+	w.layerBytes[layer][temporal] += int64(len(pkt.Packet.Payload))
+	maxTemporalLayerSeenChanged := temporal > w.maxTemporalLayerSeen
+	if maxTemporalLayerSeenChanged {
+		w.maxTemporalLayerSeen = temporal
+	}
+	w.bitrateMu.Unlock()
+
+	if maxTemporalLayerSeenChanged {
+		for _, dt := range w.getDownTracks() {
+			dt.UpTrackMaxTemporalLayerSeenChange(temporal)
+		}
+	}
+}
+
+// bitrateWorker 周期性计算各层码率，可用的层变化时通知下行音轨重新选择
+func (w *WebRTCReceiver) bitrateWorker() {
+	ticker := time.NewTicker(bitrateReportInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if w.closed.Load() {
+			return
+		}
+
+		w.bitrateMu.Lock()
+		elapsed := time.Since(w.lastBitrateReport).Seconds()
+		w.lastBitrateReport = time.Now()
+		var brs Bitrates
+		for s := range w.layerBytes {
+			var cumulative int64
+			for t := range w.layerBytes[s] {
+				cumulative += w.layerBytes[s][t]
+				if w.layerBytes[s][t] != 0 {
+					brs[s][t] = int64(float64(cumulative*8) / elapsed)
+				}
+				w.layerBytes[s][t] = 0
+			}
+		}
+		availabilityChanged := layersPresent(brs) != layersPresent(w.bitrates)
+		w.bitrates = brs
+		w.bitrateMu.Unlock()
+
+		availableLayers, _ := w.GetLayeredBitrate()
+		for _, dt := range w.getDownTracks() {
+			if availabilityChanged {
+				dt.UpTrackBitrateAvailabilityChange()
+			}
+			dt.UpTrackBitrateReport(availableLayers, brs)
+		}
+	}
+}
+
+// close 关闭接收器和全部下行音轨
+func (w *WebRTCReceiver) close() {
+	w.closeOnce.Do(func() {
+		w.closed.Store(true)
+		w.connectionStats.Close()
+		for _, dt := range w.getDownTracks() {
+			dt.Close()
+		}
+
+		w.upTrackMu.RLock()
+		onCloseHandler := w.onCloseHandler
+		w.upTrackMu.RUnlock()
+		if onCloseHandler != nil {
+			onCloseHandler()
+		}
+		w.logger.Debugw("receiver closed", "mime", w.codec.MimeType)
+	})
+}
+
+// layersPresent 有码率的层的位图
+func layersPresent(brs Bitrates) uint32 {
+	var present uint32
+	for s := range brs {
+		for t := range brs[s] {
+			if brs[s][t] != 0 {
+				present |= 1 << (s*len(brs[s]) + t)
+			}
+		}
+	}
+	return present
 }
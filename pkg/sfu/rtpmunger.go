@@ -0,0 +1,178 @@
+package sfu
+
+import (
+	"fmt"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+)
+
+// SequenceNumberOrdering 收到的包相对于已转发的最高序列号的顺序
+type SequenceNumberOrdering int
+
+const (
+	SequenceNumberOrderingContiguous SequenceNumberOrdering = iota
+	SequenceNumberOrderingOutOfOrder
+	SequenceNumberOrderingGap
+	SequenceNumberOrderingDuplicate
+)
+
+// snRangeMapSize 保留的序列号偏移变化次数，用于乱序包查找对应的偏移
+const snRangeMapSize = 64
+
+// RTPMungerState 迁移时保存的最后一个转发包，恢复后序列号和时间戳从这里继续
+type RTPMungerState struct {
+	LastSN     uint16 `json:"lastSN"`
+	LastTS     uint32 `json:"lastTS"`
+	LastMarker bool   `json:"lastMarker"`
+}
+
+func (r RTPMungerState) String() string {
+	return fmt.Sprintf("RTPMungerState{lastSN: %d, lastTS: %d, lastMarker: %v}", r.LastSN, r.LastTS, r.LastMarker)
+}
+
+// TranslationParamsRTP 改写后的序列号和时间戳
+type TranslationParamsRTP struct {
+	snOrdering     SequenceNumberOrdering
+	sequenceNumber uint16
+	timestamp      uint32
+}
+
+// snRange 从 start 开始的包使用的序列号偏移
+type snRange struct {
+	start  uint16
+	offset uint16
+}
+
+// RTPMunger 改写序列号和时间戳，切换上行层或者丢弃包之后订阅者仍然看到连续的流
+type RTPMunger struct {
+	logger logger.Logger
+
+	highestIncomingSN uint16
+	lastSN            uint16
+	lastTS            uint32
+	lastMarker        bool
+
+	snOffset uint16
+	tsOffset uint32
+	// snRanges 当前上行层的序列号偏移变化，最早的一项是切换到该层的第一个包
+	snRanges []snRange
+}
+
+// NewRTPMunger 创建改写器
+func NewRTPMunger(logger logger.Logger) *RTPMunger {
+	return &RTPMunger{
+		logger: logger,
+	}
+}
+
+// GetLast 最后一个转发的包
+func (r *RTPMunger) GetLast() RTPMungerState {
+	return RTPMungerState{
+		LastSN:     r.lastSN,
+		LastTS:     r.lastTS,
+		LastMarker: r.lastMarker,
+	}
+}
+
+// SeedLast 使用之前保存的状态，下一个包通过 UpdateSnTsOffsets 接在后面
+func (r *RTPMunger) SeedLast(state RTPMungerState) {
+	r.lastSN = state.LastSN
+	r.lastTS = state.LastTS
+	r.lastMarker = state.LastMarker
+}
+
+// SetLastSnTs 第一个转发的包，不改写
+func (r *RTPMunger) SetLastSnTs(extPkt *buffer.ExtPacket) {
+	hdr := extPkt.Packet.Header
+	r.highestIncomingSN = hdr.SequenceNumber - 1
+	r.lastSN = hdr.SequenceNumber - 1
+	r.lastTS = hdr.Timestamp
+	r.snOffset = 0
+	r.tsOffset = 0
+	r.snRanges = append(r.snRanges[:0], snRange{start: hdr.SequenceNumber, offset: 0})
+}
+
+// UpdateSnTsOffsets 切换到新的上行层时，新层的第一个包接在最后一个转发的包之后，
+// 序列号增加 snAdjust，时间戳增加 tsAdjust
+func (r *RTPMunger) UpdateSnTsOffsets(extPkt *buffer.ExtPacket, snAdjust uint16, tsAdjust uint32) {
+	hdr := extPkt.Packet.Header
+	r.highestIncomingSN = hdr.SequenceNumber - 1
+	r.snOffset = hdr.SequenceNumber - r.lastSN - snAdjust
+	r.tsOffset = hdr.Timestamp - r.lastTS - tsAdjust
+	r.snRanges = append(r.snRanges[:0], snRange{start: hdr.SequenceNumber, offset: r.snOffset})
+}
+
+// PacketDropped 丢弃了一个按序到达的包，后面的包序列号前移
+func (r *RTPMunger) PacketDropped(extPkt *buffer.ExtPacket) {
+	sn := extPkt.Packet.SequenceNumber
+	if int16(sn-r.highestIncomingSN) <= 0 {
+		return
+	}
+
+	r.highestIncomingSN = sn
+	r.snOffset++
+	r.snRanges = append(r.snRanges, snRange{start: sn + 1, offset: r.snOffset})
+	if len(r.snRanges) > snRangeMapSize {
+		r.snRanges = r.snRanges[len(r.snRanges)-snRangeMapSize:]
+	}
+}
+
+// UpdateAndGetSnTs 改写一个要转发的包
+func (r *RTPMunger) UpdateAndGetSnTs(extPkt *buffer.ExtPacket) (*TranslationParamsRTP, error) {
+	hdr := extPkt.Packet.Header
+	diff := int16(hdr.SequenceNumber - r.highestIncomingSN)
+
+	if diff < 0 {
+		// 乱序包使用它到达位置的偏移，切换层之前的包已经无法改写
+		offset, ok := r.getSnOffset(hdr.SequenceNumber)
+		if !ok {
+			return &TranslationParamsRTP{snOrdering: SequenceNumberOrderingOutOfOrder}, ErrOutOfOrderSequenceNumberCacheMiss
+		}
+		return &TranslationParamsRTP{
+			snOrdering:     SequenceNumberOrderingOutOfOrder,
+			sequenceNumber: hdr.SequenceNumber - offset,
+			timestamp:      hdr.Timestamp - r.tsOffset,
+		}, nil
+	}
+
+	if diff == 0 {
+		return &TranslationParamsRTP{snOrdering: SequenceNumberOrderingDuplicate}, ErrDuplicatePacket
+	}
+
+	ordering := SequenceNumberOrderingContiguous
+	if diff > 1 {
+		ordering = SequenceNumberOrderingGap
+	}
+
+	r.highestIncomingSN = hdr.SequenceNumber
+	r.lastSN = hdr.SequenceNumber - r.snOffset
+	r.lastTS = hdr.Timestamp - r.tsOffset
+	r.lastMarker = hdr.Marker
+
+	return &TranslationParamsRTP{
+		snOrdering:     ordering,
+		sequenceNumber: r.lastSN,
+		timestamp:      r.lastTS,
+	}, nil
+}
+
+// IsOnFrameBoundary 最后一个转发的包是否是一帧的结尾
+func (r *RTPMunger) IsOnFrameBoundary() bool {
+	return r.lastMarker
+}
+
+func (r *RTPMunger) getSnOffset(sn uint16) (uint16, bool) {
+	for i := len(r.snRanges) - 1; i >= 0; i-- {
+		if int16(sn-r.snRanges[i].start) >= 0 {
+			return r.snRanges[i].offset, true
+		}
+	}
+	return 0, false
+}
+
+// TranslateTimestamp 按当前上行层的偏移改写时间戳
+func (r *RTPMunger) TranslateTimestamp(ts uint32) uint32 {
+	return ts - r.tsOffset
+}
@@ -0,0 +1,142 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+)
+
+const (
+	// defaultSequencerSize 没有指定时记录的包数量，与上行缓冲的默认大小相同
+	defaultSequencerSize = 500
+	// maxRetransmitAttempts 一个包最多重传的次数
+	maxRetransmitAttempts = 3
+	// maxRetransmitInterval 同一个包两次重传之间最长需要等待的时间，RTT 较小时按 RTT 等待
+	maxRetransmitInterval = 100 * time.Millisecond
+	// defaultRtt 还没有收到接收报告时使用的 RTT，单位毫秒
+	defaultRtt = 70
+)
+
+// packetMeta 转发的包在上行的位置，重传时从上行缓冲读取
+type packetMeta struct {
+	// sourceSeqNo 上行的序列号
+	sourceSeqNo uint16
+	// targetSeqNo 改写后的序列号
+	targetSeqNo uint16
+	// timestamp 改写后的时间戳
+	timestamp uint32
+	// layer 上行的空间层
+	layer int8
+	// nacked 已经重传的次数
+	nacked uint8
+	// lastNack 最后一次重传的时间
+	lastNack time.Time
+}
+
+// sequencer 环形缓冲，按改写后的序列号记录最近转发的包，收到 NACK 时找回上行的层和序列号
+type sequencer struct {
+	sync.Mutex
+	logger logger.Logger
+
+	meta        []packetMeta
+	valid       []bool
+	initialized bool
+	// extHighestSN 扩展的最高序列号，序列号回绕后环形缓冲的位置仍然连续
+	extHighestSN uint64
+	rtt          uint32
+}
+
+func newSequencer(size int, logger logger.Logger) *sequencer {
+	if size <= 0 {
+		size = defaultSequencerSize
+	}
+	return &sequencer{
+		logger: logger,
+		meta:   make([]packetMeta, size),
+		valid:  make([]bool, size),
+		rtt:    defaultRtt,
+	}
+}
+
+// setRTT 订阅者的往返时延，单位毫秒
+func (s *sequencer) setRTT(rtt uint32) {
+	s.Lock()
+	defer s.Unlock()
+
+	if rtt == 0 {
+		s.rtt = defaultRtt
+	} else {
+		s.rtt = rtt
+	}
+}
+
+// push 记录一个转发的包
+func (s *sequencer) push(sn, targetSN uint16, timestamp uint32, layer int8) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		// 从较大的值开始，早于第一个包的乱序包也不会小于 0
+		s.extHighestSN = 1<<16 + uint64(targetSN)
+		s.initialized = true
+	}
+	extSN := s.getExtSN(targetSN)
+	if extSN > s.extHighestSN {
+		s.extHighestSN = extSN
+	}
+
+	idx := int(extSN % uint64(len(s.meta)))
+	s.meta[idx] = packetMeta{
+		sourceSeqNo: sn,
+		targetSeqNo: targetSN,
+		timestamp:   timestamp,
+		layer:       layer,
+	}
+	s.valid[idx] = true
+}
+
+// getPacketsMeta 查找订阅者请求重传的包，超过重传次数或者距离上次重传不足一个 RTT 的包不再重传。
+// 返回需要重传的包、重复请求的数量和已经不在缓冲中的数量
+func (s *sequencer) getPacketsMeta(seqNo []uint16) ([]packetMeta, uint32, uint32) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	interval := time.Duration(s.rtt) * time.Millisecond
+	if interval > maxRetransmitInterval {
+		interval = maxRetransmitInterval
+	}
+
+	var numRepeated, numMisses uint32
+	meta := make([]packetMeta, 0, len(seqNo))
+	for _, sn := range seqNo {
+		if !s.initialized {
+			numMisses++
+			continue
+		}
+		extSN := s.getExtSN(sn)
+		idx := int(extSN % uint64(len(s.meta)))
+		if extSN > s.extHighestSN || s.extHighestSN-extSN >= uint64(len(s.meta)) || !s.valid[idx] || s.meta[idx].targetSeqNo != sn {
+			numMisses++
+			continue
+		}
+
+		m := &s.meta[idx]
+		if m.nacked > 0 {
+			numRepeated++
+		}
+		if m.nacked >= maxRetransmitAttempts || now.Sub(m.lastNack) < interval {
+			continue
+		}
+		m.nacked++
+		m.lastNack = now
+		meta = append(meta, *m)
+	}
+	return meta, numRepeated, numMisses
+}
+
+// getExtSN 按与最高序列号的距离扩展序列号
+func (s *sequencer) getExtSN(sn uint16) uint64 {
+	return uint64(int64(s.extHighestSN) + int64(int16(sn-uint16(s.extHighestSN))))
+}
@@ -0,0 +1,64 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+)
+
+func TestSequencer(t *testing.T) {
+	s := newSequencer(100, logger.GetLogger())
+	for i := uint16(0); i < 150; i++ {
+		// 上行序列号从 1000 开始，空间层 1
+		s.push(1000+i, i, uint32(i)*3000, 1)
+	}
+
+	metas, numRepeated, numMisses := s.getPacketsMeta([]uint16{120, 140, 10})
+	require.Len(t, metas, 2)
+	require.Equal(t, uint32(0), numRepeated)
+	// 10 已经被覆盖
+	require.Equal(t, uint32(1), numMisses)
+	require.Equal(t, uint16(1120), metas[0].sourceSeqNo)
+	require.Equal(t, uint16(120), metas[0].targetSeqNo)
+	require.Equal(t, uint32(120*3000), metas[0].timestamp)
+	require.Equal(t, int8(1), metas[0].layer)
+	require.Equal(t, uint8(1), metas[0].nacked)
+
+	// 一个 RTT 内重复请求不重传
+	metas, numRepeated, _ = s.getPacketsMeta([]uint16{120})
+	require.Empty(t, metas)
+	require.Equal(t, uint32(1), numRepeated)
+}
+
+func TestSequencerRetransmitLimits(t *testing.T) {
+	s := newSequencer(100, logger.GetLogger())
+	s.setRTT(1)
+	s.push(500, 10, 1000, 0)
+
+	for i := 0; i < maxRetransmitAttempts; i++ {
+		metas, _, _ := s.getPacketsMeta([]uint16{10})
+		require.Len(t, metas, 1)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// 超过重传次数
+	metas, numRepeated, _ := s.getPacketsMeta([]uint16{10})
+	require.Empty(t, metas)
+	require.Equal(t, uint32(1), numRepeated)
+}
+
+func TestSequencerWrap(t *testing.T) {
+	s := newSequencer(100, logger.GetLogger())
+	for i := uint16(65500); i != 50; i++ {
+		s.push(i, i, uint32(i), 0)
+	}
+
+	metas, _, numMisses := s.getPacketsMeta([]uint16{65530, 20})
+	require.Len(t, metas, 2)
+	require.Equal(t, uint32(0), numMisses)
+	require.Equal(t, uint16(65530), metas[0].sourceSeqNo)
+	require.Equal(t, uint16(20), metas[1].sourceSeqNo)
+}
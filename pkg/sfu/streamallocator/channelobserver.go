@@ -0,0 +1,197 @@
+package streamallocator
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/liuhailove/tc-server/pkg/config"
+)
+
+// ChannelTrend 下行信道的状态
+type ChannelTrend int
+
+const (
+	ChannelTrendNeutral ChannelTrend = iota
+	ChannelTrendClearing
+	ChannelTrendCongesting
+)
+
+func (c ChannelTrend) String() string {
+	switch c {
+	case ChannelTrendNeutral:
+		return "NEUTRAL"
+	case ChannelTrendClearing:
+		return "CLEARING"
+	case ChannelTrendCongesting:
+		return "CONGESTING"
+	default:
+		return fmt.Sprintf("%d", int(c))
+	}
+}
+
+// ChannelCongestionReason 判断为拥塞的原因
+type ChannelCongestionReason int
+
+const (
+	ChannelCongestionReasonNone ChannelCongestionReason = iota
+	ChannelCongestionReasonEstimate
+	ChannelCongestionReasonLoss
+)
+
+func (c ChannelCongestionReason) String() string {
+	switch c {
+	case ChannelCongestionReasonNone:
+		return "NONE"
+	case ChannelCongestionReasonEstimate:
+		return "ESTIMATE"
+	case ChannelCongestionReasonLoss:
+		return "LOSS"
+	default:
+		return fmt.Sprintf("%d", int(c))
+	}
+}
+
+// ChannelObserverParams 信道观察参数，探测期间和平时使用不同的配置
+type ChannelObserverParams struct {
+	Name   string
+	Config config.CongestionControlChannelObserverConfig
+}
+
+// ChannelObserver 根据带宽估计的趋势和重复 NACK 的比例判断信道是否拥塞
+type ChannelObserver struct {
+	params ChannelObserverParams
+
+	estimateTrend *TrendDetector
+	nackTracker   *nackTracker
+}
+
+// NewChannelObserver 创建信道观察
+func NewChannelObserver(params ChannelObserverParams) *ChannelObserver {
+	return &ChannelObserver{
+		params: params,
+		estimateTrend: NewTrendDetector(TrendDetectorParams{
+			Name:                   params.Name + "-estimate",
+			RequiredSamples:        params.Config.EstimateRequiredSamples,
+			RequiredSamplesMin:     params.Config.EstimateRequiredSamplesMin,
+			DownwardTrendThreshold: params.Config.EstimateDownwardTrendThreshold,
+			DownwardTrendMaxWait:   params.Config.EstimateDownwardTrendMaxWait,
+			CollapseThreshold:      params.Config.EstimateCollapseThreshold,
+			ValidityWindow:         params.Config.EstimateValidityWindow,
+		}),
+		nackTracker: newNackTracker(
+			params.Config.NackWindowMinDuration,
+			params.Config.NackWindowMaxDuration,
+			params.Config.NackRatioThreshold,
+		),
+	}
+}
+
+// AddEstimate 订阅者的带宽估计
+func (c *ChannelObserver) AddEstimate(estimate int64) {
+	c.estimateTrend.AddValue(estimate)
+}
+
+// AddNack 一段时间内发送的包数和重复请求重传的数量
+func (c *ChannelObserver) AddNack(packets uint32, repeatedNacks uint32) {
+	c.nackTracker.Add(packets, repeatedNacks)
+}
+
+// GetLowestEstimate 观察开始以来最低的带宽估计
+func (c *ChannelObserver) GetLowestEstimate() int64 {
+	return c.estimateTrend.GetLowest()
+}
+
+// GetHighestEstimate 观察开始以来最高的带宽估计
+func (c *ChannelObserver) GetHighestEstimate() int64 {
+	return c.estimateTrend.GetHighest()
+}
+
+// GetNackRatio 当前窗口内重复 NACK 的比例
+func (c *ChannelObserver) GetNackRatio() float64 {
+	return c.nackTracker.GetRatio()
+}
+
+// GetTrend 信道的状态，估计值下降优先于丢包
+func (c *ChannelObserver) GetTrend() (ChannelTrend, ChannelCongestionReason) {
+	estimateDirection := c.estimateTrend.GetDirection()
+	switch {
+	case estimateDirection == TrendDirectionDownward:
+		return ChannelTrendCongesting, ChannelCongestionReasonEstimate
+	case c.nackTracker.IsTriggered():
+		return ChannelTrendCongesting, ChannelCongestionReasonLoss
+	case estimateDirection == TrendDirectionUpward:
+		return ChannelTrendClearing, ChannelCongestionReasonNone
+	}
+	return ChannelTrendNeutral, ChannelCongestionReasonNone
+}
+
+func (c *ChannelObserver) String() string {
+	return fmt.Sprintf("name: %s, estimate: {%s}, nack: {%s}", c.params.Name, c.estimateTrend, c.nackTracker)
+}
+
+// ------------------------------------------------
+
+// nackTracker 统计窗口内重复 NACK 占发送包数的比例，窗口超过最长时间后重新开始
+type nackTracker struct {
+	windowMinDuration time.Duration
+	windowMaxDuration time.Duration
+	ratioThreshold    float64
+
+	windowStartTime time.Time
+	packets         uint32
+	repeatedNacks   uint32
+}
+
+func newNackTracker(windowMinDuration, windowMaxDuration time.Duration, ratioThreshold float64) *nackTracker {
+	return &nackTracker{
+		windowMinDuration: windowMinDuration,
+		windowMaxDuration: windowMaxDuration,
+		ratioThreshold:    ratioThreshold,
+	}
+}
+
+// Add 累加发送的包数和重复 NACK 数
+func (n *nackTracker) Add(packets uint32, repeatedNacks uint32) {
+	if n.windowMaxDuration != 0 && !n.windowStartTime.IsZero() && time.Since(n.windowStartTime) > n.windowMaxDuration {
+		n.windowStartTime = time.Time{}
+		n.packets = 0
+		n.repeatedNacks = 0
+	}
+
+	// 有重复 NACK 之后才开始计时
+	if n.windowStartTime.IsZero() && repeatedNacks > 0 {
+		n.windowStartTime = time.Now()
+	}
+
+	n.packets += packets
+	n.repeatedNacks += repeatedNacks
+}
+
+// GetRatio 重复 NACK 的比例
+func (n *nackTracker) GetRatio() float64 {
+	if n.packets == 0 {
+		return 0
+	}
+
+	ratio := float64(n.repeatedNacks) / float64(n.packets)
+	if ratio > 1.0 {
+		ratio = 1.0
+	}
+	return ratio
+}
+
+// IsTriggered 窗口达到最短时间并且比例超过阈值
+func (n *nackTracker) IsTriggered() bool {
+	if n.windowStartTime.IsZero() || time.Since(n.windowStartTime) < n.windowMinDuration {
+		return false
+	}
+	return n.GetRatio() > n.ratioThreshold
+}
+
+func (n *nackTracker) String() string {
+	window := time.Duration(0)
+	if !n.windowStartTime.IsZero() {
+		window = time.Since(n.windowStartTime)
+	}
+	return fmt.Sprintf("w: %+v, p: %d, rn: %d, nr: %.2f", window, n.packets, n.repeatedNacks, n.GetRatio())
+}
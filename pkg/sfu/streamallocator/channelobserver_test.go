@@ -0,0 +1,89 @@
+package streamallocator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-server/pkg/config"
+)
+
+func newTestObserverConfig() config.CongestionControlChannelObserverConfig {
+	return config.CongestionControlChannelObserverConfig{
+		EstimateRequiredSamples:        4,
+		EstimateRequiredSamplesMin:     4,
+		EstimateDownwardTrendThreshold: -0.5,
+		EstimateDownwardTrendMaxWait:   5 * time.Second,
+		EstimateValidityWindow:         10 * time.Second,
+		NackWindowMinDuration:          0,
+		NackWindowMaxDuration:          time.Second,
+		NackRatioThreshold:             0.1,
+	}
+}
+
+func TestKendallsTau(t *testing.T) {
+	samples := func(values ...int64) []trendSample {
+		s := make([]trendSample, 0, len(values))
+		for _, v := range values {
+			s = append(s, trendSample{value: v})
+		}
+		return s
+	}
+
+	require.Equal(t, 1.0, kendallsTau(samples(1, 2, 3, 4)))
+	require.Equal(t, -1.0, kendallsTau(samples(4, 3, 2, 1)))
+	require.Equal(t, 0.0, kendallsTau(samples(1, 1, 1, 1)))
+	require.Equal(t, 0.0, kendallsTau(samples(1)))
+}
+
+func TestChannelObserverEstimate(t *testing.T) {
+	c := NewChannelObserver(ChannelObserverParams{Name: "test", Config: newTestObserverConfig()})
+
+	// 样本不够时不判断
+	c.AddEstimate(1_000_000)
+	c.AddEstimate(900_000)
+	trend, _ := c.GetTrend()
+	require.Equal(t, ChannelTrendNeutral, trend)
+
+	c.AddEstimate(800_000)
+	c.AddEstimate(700_000)
+	trend, reason := c.GetTrend()
+	require.Equal(t, ChannelTrendCongesting, trend)
+	require.Equal(t, ChannelCongestionReasonEstimate, reason)
+	require.Equal(t, int64(700_000), c.GetLowestEstimate())
+	require.Equal(t, int64(1_000_000), c.GetHighestEstimate())
+
+	c = NewChannelObserver(ChannelObserverParams{Name: "test", Config: newTestObserverConfig()})
+	for _, estimate := range []int64{500_000, 600_000, 700_000, 800_000} {
+		c.AddEstimate(estimate)
+	}
+	trend, _ = c.GetTrend()
+	require.Equal(t, ChannelTrendClearing, trend)
+}
+
+func TestChannelObserverCollapse(t *testing.T) {
+	conf := newTestObserverConfig()
+	conf.EstimateCollapseThreshold = time.Second
+	c := NewChannelObserver(ChannelObserverParams{Name: "test", Config: conf})
+
+	// 重复的估计值只记录一次
+	for i := 0; i < 10; i++ {
+		c.AddEstimate(1_000_000)
+	}
+	require.Len(t, c.estimateTrend.samples, 1)
+}
+
+func TestChannelObserverNack(t *testing.T) {
+	c := NewChannelObserver(ChannelObserverParams{Name: "test", Config: newTestObserverConfig()})
+
+	c.AddNack(100, 0)
+	trend, _ := c.GetTrend()
+	require.Equal(t, ChannelTrendNeutral, trend)
+
+	c.AddNack(100, 30)
+	trend, reason := c.GetTrend()
+	require.Equal(t, ChannelTrendCongesting, trend)
+	require.Equal(t, ChannelCongestionReasonLoss, reason)
+	require.InDelta(t, 0.15, c.GetNackRatio(), 0.001)
+}
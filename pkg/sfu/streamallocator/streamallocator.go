@@ -0,0 +1,621 @@
+package streamallocator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/atomic"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+
+	"github.com/liuhailove/tc-server/pkg/config"
+	"github.com/liuhailove/tc-server/pkg/sfu"
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+)
+
+const (
+	// ChannelCapacityInfinity 还没有检测到拥塞时按最优层转发
+	ChannelCapacityInfinity = int64(math.MaxInt64)
+
+	// eventChanSize 事件队列长度
+	eventChanSize = 1024
+	// processInterval 检查丢包和探测的周期
+	processInterval = 250 * time.Millisecond
+)
+
+type streamAllocatorState int
+
+const (
+	streamAllocatorStateStable streamAllocatorState = iota
+	streamAllocatorStateDeficient
+)
+
+func (s streamAllocatorState) String() string {
+	switch s {
+	case streamAllocatorStateStable:
+		return "STABLE"
+	case streamAllocatorStateDeficient:
+		return "DEFICIENT"
+	default:
+		return fmt.Sprintf("%d", int(s))
+	}
+}
+
+type eventType int
+
+const (
+	eventTypeAllocateAll eventType = iota
+	eventTypeEstimate
+	eventTypeAddTrack
+	eventTypeRemoveTrack
+	eventTypeSetAllowPause
+	eventTypeSetChannelCapacity
+	eventTypeProcess
+)
+
+type event struct {
+	typ       eventType
+	downTrack *sfu.DownTrack
+	value     int64
+	flag      bool
+}
+
+// track 分配器管理的一路视频下行
+type track struct {
+	downTrack     *sfu.DownTrack
+	isScreenshare bool
+	// order 加入的顺序，优先级相同时先订阅的先分配
+	order uint64
+}
+
+// Params 流分配器参数
+type Params struct {
+	Config config.CongestionControlConfig
+	Logger logger.Logger
+}
+
+// probe 媒体探测：按目标码率提升转发的层，观察期间带宽估计达到目标码率才确认新的信道容量
+type probe struct {
+	active  bool
+	goalBps int64
+	// highestEstimate 探测期间收到的最高带宽估计
+	highestEstimate int64
+	startedAt       time.Time
+	endedAt         time.Time
+	interval        time.Duration
+}
+
+// StreamAllocator 按订阅者的下行带宽在各路视频之间分配码率。
+// 带宽按优先级分配，订阅者禁用的视频不占带宽，带宽不足时允许的情况下暂停视频
+type StreamAllocator struct {
+	params Params
+
+	eventCh  chan event
+	stopOnce sync.Once
+	stopCh   chan struct{}
+
+	// 以下字段只在事件处理协程中访问
+	tracks                   map[tc.TrackID]*track
+	nextOrder                uint64
+	allowPause               bool
+	overriddenCapacity       int64
+	committedChannelCapacity int64
+	lastReceivedEstimate     int64
+	channelObserver          *ChannelObserver
+	probe                    probe
+	state                    streamAllocatorState
+
+	packetsSent   atomic.Uint32
+	repeatedNacks atomic.Uint32
+}
+
+// NewStreamAllocator 创建流分配器
+func NewStreamAllocator(params Params) *StreamAllocator {
+	s := &StreamAllocator{
+		params:                   params,
+		eventCh:                  make(chan event, eventChanSize),
+		stopCh:                   make(chan struct{}),
+		tracks:                   make(map[tc.TrackID]*track),
+		allowPause:               params.Config.AllowPause,
+		committedChannelCapacity: ChannelCapacityInfinity,
+	}
+	s.probe.interval = params.Config.ProbeConfig.BaseInterval
+	s.resetChannelObserver(false)
+	return s
+}
+
+// Start 开始处理事件
+func (s *StreamAllocator) Start() {
+	go s.processEvents()
+	go s.ping()
+}
+
+// Stop 停止处理事件
+func (s *StreamAllocator) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+// AddTrack 由分配器选择视频下行转发的层
+func (s *StreamAllocator) AddTrack(downTrack *sfu.DownTrack) {
+	if downTrack.Kind() != webrtc.RTPCodecTypeVideo {
+		return
+	}
+
+	downTrack.SetStreamAllocatorListener(s)
+	s.postEvent(event{typ: eventTypeAddTrack, downTrack: downTrack})
+}
+
+// RemoveTrack 视频下行不再由分配器管理
+func (s *StreamAllocator) RemoveTrack(downTrack *sfu.DownTrack) {
+	if downTrack.Kind() != webrtc.RTPCodecTypeVideo {
+		return
+	}
+
+	downTrack.SetStreamAllocatorListener(nil)
+	s.postEvent(event{typ: eventTypeRemoveTrack, downTrack: downTrack})
+}
+
+// SetAllowPause 订阅者是否允许带宽不足时暂停视频，配置允许时总是允许
+func (s *StreamAllocator) SetAllowPause(allowPause bool) {
+	s.postEvent(event{typ: eventTypeSetAllowPause, flag: allowPause})
+}
+
+// SetChannelCapacity 指定信道容量，不再使用带宽估计，0 表示取消
+func (s *StreamAllocator) SetChannelCapacity(channelCapacity int64) {
+	s.postEvent(event{typ: eventTypeSetChannelCapacity, value: channelCapacity})
+}
+
+// ---------------- DownTrackStreamAllocatorListener ----------------
+
+// OnREMB 订阅者的接收端带宽估计，通道容量以 REMB 为准
+func (s *StreamAllocator) OnREMB(_ *sfu.DownTrack, remb *rtcp.ReceiverEstimatedMaximumBitrate) {
+	s.postEvent(event{typ: eventTypeEstimate, value: int64(remb.Bitrate)})
+}
+
+// OnTransportCCFeedback 还没有实现发送端带宽估计，忽略反馈，以 REMB 为准
+func (s *StreamAllocator) OnTransportCCFeedback(_ *sfu.DownTrack, _ *rtcp.TransportLayerCC) {
+}
+
+// OnAvailableLayersChanged 上行可用的层变化
+func (s *StreamAllocator) OnAvailableLayersChanged(downTrack *sfu.DownTrack) {
+	s.postEvent(event{typ: eventTypeAllocateAll, downTrack: downTrack})
+}
+
+// OnBitrateAvailabilityChanged 上行各层码率可用
+func (s *StreamAllocator) OnBitrateAvailabilityChanged(downTrack *sfu.DownTrack) {
+	s.postEvent(event{typ: eventTypeAllocateAll, downTrack: downTrack})
+}
+
+// OnMaxPublishedSpatialChanged 发布者发布的最大空间层变化
+func (s *StreamAllocator) OnMaxPublishedSpatialChanged(downTrack *sfu.DownTrack) {
+	s.postEvent(event{typ: eventTypeAllocateAll, downTrack: downTrack})
+}
+
+// OnMaxPublishedTemporalChanged 发布者发布的最大时间层变化
+func (s *StreamAllocator) OnMaxPublishedTemporalChanged(downTrack *sfu.DownTrack) {
+	s.postEvent(event{typ: eventTypeAllocateAll, downTrack: downTrack})
+}
+
+// OnSubscriptionChanged 订阅者禁用、启用或者修改优先级
+func (s *StreamAllocator) OnSubscriptionChanged(downTrack *sfu.DownTrack) {
+	s.postEvent(event{typ: eventTypeAllocateAll, downTrack: downTrack})
+}
+
+// OnSubscribedLayerChanged 订阅者需要的最大层变化
+func (s *StreamAllocator) OnSubscribedLayerChanged(downTrack *sfu.DownTrack, _ buffer.VideoLayer) {
+	s.postEvent(event{typ: eventTypeAllocateAll, downTrack: downTrack})
+}
+
+// OnResume 恢复转发不改变分配
+func (s *StreamAllocator) OnResume(_ *sfu.DownTrack) {
+}
+
+// OnPacketSent 统计发送的包数，用于计算重复 NACK 的比例
+func (s *StreamAllocator) OnPacketSent(_ *sfu.DownTrack, _ int) {
+	s.packetsSent.Inc()
+}
+
+// OnNACK 重传过的包再次被请求说明信道在丢包
+func (s *StreamAllocator) OnNACK(_ *sfu.DownTrack, nackInfos []sfu.NackInfo) {
+	var repeated uint32
+	for _, nackInfo := range nackInfos {
+		if nackInfo.Attempts > 1 {
+			repeated++
+		}
+	}
+	if repeated != 0 {
+		s.repeatedNacks.Add(repeated)
+	}
+}
+
+// OnRTCPReceiverReport 接收报告不参与分配
+func (s *StreamAllocator) OnRTCPReceiverReport(_ *sfu.DownTrack, _ rtcp.ReceiverReport) {
+}
+
+// ---------------- 事件处理 ----------------
+
+func (s *StreamAllocator) postEvent(e event) {
+	select {
+	case <-s.stopCh:
+		return
+	default:
+	}
+
+	select {
+	case s.eventCh <- e:
+	default:
+		s.params.Logger.Warnw("stream allocator: event queue full", nil, "event", e.typ)
+	}
+}
+
+func (s *StreamAllocator) ping() {
+	ticker := time.NewTicker(processInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.postEvent(event{typ: eventTypeProcess})
+		}
+	}
+}
+
+func (s *StreamAllocator) processEvents() {
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case e := <-s.eventCh:
+			s.handleEvent(e)
+		}
+	}
+}
+
+func (s *StreamAllocator) handleEvent(e event) {
+	switch e.typ {
+	case eventTypeAllocateAll:
+		s.allocateAllTracks()
+
+	case eventTypeEstimate:
+		s.lastReceivedEstimate = e.value
+		if s.probe.active && e.value > s.probe.highestEstimate {
+			s.probe.highestEstimate = e.value
+		}
+		s.channelObserver.AddEstimate(e.value)
+		s.maybeCommitCapacity()
+
+	case eventTypeAddTrack:
+		s.tracks[e.downTrack.TrackID()] = &track{
+			downTrack:     e.downTrack,
+			isScreenshare: isScreenshare(e.downTrack),
+			order:         s.nextOrder,
+		}
+		s.nextOrder++
+		s.allocateAllTracks()
+
+	case eventTypeRemoveTrack:
+		if t, ok := s.tracks[e.downTrack.TrackID()]; ok && t.downTrack == e.downTrack {
+			delete(s.tracks, e.downTrack.TrackID())
+			// 释放的带宽分给其他视频
+			s.allocateAllTracks()
+		}
+
+	case eventTypeSetAllowPause:
+		allowPause := s.params.Config.AllowPause || e.flag
+		if s.allowPause != allowPause {
+			s.allowPause = allowPause
+			s.allocateAllTracks()
+		}
+
+	case eventTypeSetChannelCapacity:
+		if s.overriddenCapacity != e.value {
+			s.params.Logger.Infow("stream allocator: channel capacity overridden", "capacity", e.value)
+			s.overriddenCapacity = e.value
+			if s.probe.active {
+				s.finishProbe(false)
+			} else {
+				s.allocateAllTracks()
+			}
+		}
+
+	case eventTypeProcess:
+		s.channelObserver.AddNack(s.packetsSent.Swap(0), s.repeatedNacks.Swap(0))
+		s.maybeCommitCapacity()
+		s.maybeProbe()
+	}
+}
+
+// maybeCommitCapacity 检测到拥塞时降低信道容量并重新分配，探测期间拥塞则探测失败
+func (s *StreamAllocator) maybeCommitCapacity() {
+	trend, reason := s.channelObserver.GetTrend()
+	if trend != ChannelTrendCongesting {
+		return
+	}
+
+	if s.probe.active {
+		s.params.Logger.Infow("stream allocator: probe failed", "reason", reason, "goal", s.probe.goalBps, "observer", s.channelObserver)
+		s.finishProbe(false)
+		return
+	}
+
+	expectedUsage := s.getExpectedBandwidthUsage()
+	var capacity int64
+	switch reason {
+	case ChannelCongestionReasonEstimate:
+		capacity = s.channelObserver.GetLowestEstimate()
+	case ChannelCongestionReasonLoss:
+		capacity = int64(float64(expectedUsage) * (1.0 - s.params.Config.NackRatioAttenuator*s.channelObserver.GetNackRatio()))
+	}
+	if capacity < s.params.Config.MinChannelCapacity {
+		capacity = s.params.Config.MinChannelCapacity
+	}
+
+	s.resetChannelObserver(false)
+	if capacity >= s.committedChannelCapacity {
+		return
+	}
+
+	s.params.Logger.Infow(
+		"stream allocator: channel congestion detected, updating channel capacity",
+		"reason", reason,
+		"old", s.committedChannelCapacity,
+		"new", capacity,
+		"lastReceived", s.lastReceivedEstimate,
+		"expectedUsage", expectedUsage,
+	)
+	s.committedChannelCapacity = capacity
+	// 估计值仍然接近正在使用的码率时不需要降低转发的层
+	if float64(capacity) < float64(expectedUsage)*s.params.Config.ExpectedUsageThreshold {
+		s.allocateAllTracks()
+	}
+}
+
+// maybeProbe 有视频低于需要的层并且信道没有拥塞时，按探测周期尝试提高信道容量
+func (s *StreamAllocator) maybeProbe() {
+	probeConfig := s.params.Config.ProbeConfig
+	if s.probe.active {
+		elapsed := time.Since(s.probe.startedAt)
+		if elapsed < probeConfig.SettleWait {
+			return
+		}
+		minDuration := probeConfig.TrendWait
+		if probeConfig.MinDuration > minDuration {
+			minDuration = probeConfig.MinDuration
+		}
+		if elapsed < minDuration {
+			return
+		}
+		// 没有拥塞不代表信道能承载目标码率，估计值达到目标码率才确认
+		if s.probe.highestEstimate >= s.probe.goalBps {
+			s.finishProbe(true)
+			return
+		}
+		if probeConfig.MaxDuration <= 0 || elapsed >= probeConfig.MaxDuration {
+			s.params.Logger.Debugw("stream allocator: probe did not reach goal", "goal", s.probe.goalBps, "highestEstimate", s.probe.highestEstimate)
+			s.finishProbe(false)
+		}
+		return
+	}
+
+	if s.state != streamAllocatorStateDeficient || s.overriddenCapacity > 0 || s.committedChannelCapacity == ChannelCapacityInfinity {
+		return
+	}
+	if !s.probe.endedAt.IsZero() && time.Since(s.probe.endedAt) < s.probe.interval {
+		return
+	}
+	if trend, _ := s.channelObserver.GetTrend(); trend == ChannelTrendCongesting {
+		return
+	}
+
+	s.startProbe()
+}
+
+// startProbe 媒体探测直接按目标码率分配，订阅者收到更高的层；
+// 下行没有填充包，ProbeMode 为 padding 时也按媒体探测
+func (s *StreamAllocator) startProbe() {
+	probeConfig := s.params.Config.ProbeConfig
+	goal := s.committedChannelCapacity * probeConfig.OveragePct / 100
+	if goal-s.committedChannelCapacity < probeConfig.MinBps {
+		goal = s.committedChannelCapacity + probeConfig.MinBps
+	}
+
+	s.probe.active = true
+	s.probe.goalBps = goal
+	s.probe.highestEstimate = 0
+	s.probe.startedAt = time.Now()
+	s.resetChannelObserver(true)
+
+	s.params.Logger.Debugw(
+		"stream allocator: starting probe",
+		"mode", s.params.Config.ProbeMode,
+		"committed", s.committedChannelCapacity,
+		"goal", goal,
+		"interval", s.probe.interval,
+	)
+	s.allocateAllTracks()
+}
+
+// finishProbe 探测成功则确认新的信道容量并恢复基础探测周期，失败则延长探测周期
+func (s *StreamAllocator) finishProbe(success bool) {
+	probeConfig := s.params.Config.ProbeConfig
+	s.probe.active = false
+	s.probe.endedAt = time.Now()
+	if success {
+		s.params.Logger.Debugw(
+			"stream allocator: probe succeeded",
+			"old", s.committedChannelCapacity,
+			"new", s.probe.goalBps,
+			"observer", s.channelObserver,
+		)
+		s.committedChannelCapacity = s.probe.goalBps
+		s.probe.interval = probeConfig.BaseInterval
+	} else {
+		s.probe.interval = time.Duration(float64(s.probe.interval) * probeConfig.BackoffFactor)
+		if probeConfig.MaxInterval > 0 && s.probe.interval > probeConfig.MaxInterval {
+			s.probe.interval = probeConfig.MaxInterval
+		}
+	}
+	s.resetChannelObserver(false)
+	s.allocateAllTracks()
+}
+
+func (s *StreamAllocator) resetChannelObserver(isProbe bool) {
+	if isProbe {
+		s.channelObserver = NewChannelObserver(ChannelObserverParams{
+			Name:   "probe",
+			Config: s.params.Config.ChannelObserverProbeConfig,
+		})
+	} else {
+		s.channelObserver = NewChannelObserver(ChannelObserverParams{
+			Name:   "non-probe",
+			Config: s.params.Config.ChannelObserverNonProbeConfig,
+		})
+	}
+}
+
+// getAvailableChannelCapacity 指定的容量优先，探测期间使用探测的目标码率
+func (s *StreamAllocator) getAvailableChannelCapacity() int64 {
+	if s.overriddenCapacity > 0 {
+		return s.overriddenCapacity
+	}
+	if s.probe.active {
+		return s.probe.goalBps
+	}
+	return s.committedChannelCapacity
+}
+
+func (s *StreamAllocator) getExpectedBandwidthUsage() int64 {
+	var expected int64
+	for _, t := range s.tracks {
+		expected += t.downTrack.BandwidthRequested()
+	}
+	return expected
+}
+
+// allocateAllTracks 按优先级分配带宽。先保证能放下的低优先级视频至少转发最低层，
+// 再把剩余的带宽按优先级从高到低分给各路视频
+func (s *StreamAllocator) allocateAllTracks() {
+	tracks := s.getSortedTracks()
+	capacity := s.getAvailableChannelCapacity()
+
+	if capacity == ChannelCapacityInfinity {
+		for _, t := range tracks {
+			t.downTrack.AllocateOptimal()
+		}
+		s.updateState(tracks)
+		return
+	}
+
+	// reserved[i] 为 i 之后能够转发最低层的视频预留的码率
+	lowest := make([]int64, len(tracks))
+	var fit int64
+	for i, t := range tracks {
+		bps := getLowestBitrate(t.downTrack)
+		if fit+bps > capacity {
+			break
+		}
+		fit += bps
+		lowest[i] = bps
+	}
+	reserved := make([]int64, len(tracks)+1)
+	for i := len(tracks) - 1; i >= 0; i-- {
+		reserved[i] = reserved[i+1] + lowest[i]
+	}
+
+	remaining := capacity
+	for i, t := range tracks {
+		available := remaining - reserved[i+1]
+		if available < 0 {
+			available = 0
+		}
+		alloc := t.downTrack.Allocate(available, s.allowPause)
+		remaining -= alloc.BandwidthRequested
+	}
+	s.updateState(tracks)
+}
+
+func (s *StreamAllocator) updateState(tracks []*track) {
+	state := streamAllocatorStateStable
+	for _, t := range tracks {
+		if t.downTrack.IsDeficient() {
+			state = streamAllocatorStateDeficient
+			break
+		}
+	}
+	if s.state != state {
+		s.params.Logger.Debugw("stream allocator: state changed", "from", s.state, "to", state)
+		s.state = state
+	}
+}
+
+// getSortedTracks 订阅者设置的优先级从高到低，未设置的排在后面；
+// 优先级相同时屏幕共享在前，再按订阅的先后
+func (s *StreamAllocator) getSortedTracks() []*track {
+	tracks := make([]*track, 0, len(s.tracks))
+	for id, t := range s.tracks {
+		if t.downTrack.IsClosed() {
+			delete(s.tracks, id)
+			continue
+		}
+		tracks = append(tracks, t)
+	}
+
+	sort.Slice(tracks, func(i, j int) bool {
+		pi, pj := effectivePriority(tracks[i].downTrack.Priority()), effectivePriority(tracks[j].downTrack.Priority())
+		if pi != pj {
+			return pi < pj
+		}
+		if tracks[i].isScreenshare != tracks[j].isScreenshare {
+			return tracks[i].isScreenshare
+		}
+		return tracks[i].order < tracks[j].order
+	})
+	return tracks
+}
+
+func effectivePriority(priority uint32) uint32 {
+	if priority == 0 {
+		return math.MaxUint32
+	}
+	return priority
+}
+
+func isScreenshare(downTrack *sfu.DownTrack) bool {
+	ti := downTrack.Receiver().TrackInfo()
+	return ti != nil && ti.Source == tc.TrackSource_SCREEN_SHARE
+}
+
+// getLowestBitrate 订阅者需要的层之内最低层的码率，禁用的视频或者码率未知时为 0
+func getLowestBitrate(downTrack *sfu.DownTrack) int64 {
+	if downTrack.IsMuted() {
+		return 0
+	}
+
+	var lowest int64
+	availableLayers, brs := downTrack.Receiver().GetLayeredBitrate()
+	maxLayer := downTrack.MaxLayer()
+	for _, spatial := range availableLayers {
+		if spatial < 0 || spatial > maxLayer.Spatial || int(spatial) >= len(brs) {
+			continue
+		}
+		for t := int32(0); t <= maxLayer.Temporal && int(t) < len(brs[spatial]); t++ {
+			if bps := brs[spatial][t]; bps != 0 {
+				if lowest == 0 || bps < lowest {
+					lowest = bps
+				}
+				break
+			}
+		}
+	}
+	return lowest
+}
@@ -0,0 +1,101 @@
+package streamallocator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+
+	"github.com/liuhailove/tc-server/pkg/config"
+)
+
+func newTestStreamAllocator() *StreamAllocator {
+	return NewStreamAllocator(Params{
+		Config: config.CongestionControlConfig{
+			Enabled: true,
+			ProbeConfig: config.CongestionControlProbeConfig{
+				BaseInterval:  3 * time.Second,
+				BackoffFactor: 1.5,
+				MaxInterval:   2 * time.Minute,
+				OveragePct:    120,
+				MinBps:        200_000,
+				MinDuration:   200 * time.Millisecond,
+				MaxDuration:   20 * time.Second,
+			},
+			ChannelObserverProbeConfig:    newTestObserverConfig(),
+			ChannelObserverNonProbeConfig: newTestObserverConfig(),
+		},
+		Logger: logger.GetLogger(),
+	})
+}
+
+func TestStreamAllocatorREMB(t *testing.T) {
+	s := newTestStreamAllocator()
+	s.OnREMB(nil, &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 1_000_000})
+
+	select {
+	case e := <-s.eventCh:
+		require.Equal(t, eventTypeEstimate, e.typ)
+		require.Equal(t, int64(1_000_000), e.value)
+		s.handleEvent(e)
+	default:
+		require.Fail(t, "no estimate event")
+	}
+	require.Equal(t, int64(1_000_000), s.lastReceivedEstimate)
+}
+
+func TestStreamAllocatorCongestion(t *testing.T) {
+	s := newTestStreamAllocator()
+	for _, estimate := range []int64{1_000_000, 900_000, 800_000, 700_000} {
+		s.handleEvent(event{typ: eventTypeEstimate, value: estimate})
+	}
+	require.Equal(t, int64(700_000), s.committedChannelCapacity)
+
+	// 估计值上升不会提高信道容量
+	for _, estimate := range []int64{800_000, 900_000, 1_000_000, 1_100_000} {
+		s.handleEvent(event{typ: eventTypeEstimate, value: estimate})
+	}
+	require.Equal(t, int64(700_000), s.committedChannelCapacity)
+}
+
+func TestStreamAllocatorProbe(t *testing.T) {
+	s := newTestStreamAllocator()
+	s.committedChannelCapacity = 1_000_000
+
+	// 没有估计值达到目标码率，探测超时失败，信道容量不变并延长探测周期
+	s.startProbe()
+	require.True(t, s.probe.active)
+	require.Equal(t, int64(1_200_000), s.probe.goalBps)
+	require.Equal(t, int64(1_200_000), s.getAvailableChannelCapacity())
+
+	s.probe.startedAt = time.Now().Add(-time.Second)
+	s.handleEvent(event{typ: eventTypeProcess})
+	require.True(t, s.probe.active)
+
+	s.handleEvent(event{typ: eventTypeEstimate, value: 1_100_000})
+	s.probe.startedAt = time.Now().Add(-20 * time.Second)
+	s.handleEvent(event{typ: eventTypeProcess})
+	require.False(t, s.probe.active)
+	require.Equal(t, int64(1_000_000), s.committedChannelCapacity)
+	require.Equal(t, 4500*time.Millisecond, s.probe.interval)
+
+	// 估计值达到目标码率，探测成功
+	s.startProbe()
+	s.handleEvent(event{typ: eventTypeEstimate, value: 1_300_000})
+	s.probe.startedAt = time.Now().Add(-time.Second)
+	s.handleEvent(event{typ: eventTypeProcess})
+	require.False(t, s.probe.active)
+	require.Equal(t, int64(1_200_000), s.committedChannelCapacity)
+	require.Equal(t, 3*time.Second, s.probe.interval)
+
+	// 探测期间拥塞，探测失败
+	s.startProbe()
+	for _, estimate := range []int64{1_300_000, 1_200_000, 1_100_000, 1_000_000} {
+		s.handleEvent(event{typ: eventTypeEstimate, value: estimate})
+	}
+	require.False(t, s.probe.active)
+	require.Equal(t, int64(1_200_000), s.committedChannelCapacity)
+}
@@ -0,0 +1,182 @@
+package streamallocator
+
+import (
+	"fmt"
+	"time"
+)
+
+// TrendDirection 估计值的变化趋势
+type TrendDirection int
+
+const (
+	TrendDirectionNeutral TrendDirection = iota
+	TrendDirectionUpward
+	TrendDirectionDownward
+)
+
+func (t TrendDirection) String() string {
+	switch t {
+	case TrendDirectionNeutral:
+		return "NEUTRAL"
+	case TrendDirectionUpward:
+		return "UPWARD"
+	case TrendDirectionDownward:
+		return "DOWNWARD"
+	default:
+		return fmt.Sprintf("%d", int(t))
+	}
+}
+
+// TrendDetectorParams 趋势检测参数
+type TrendDetectorParams struct {
+	Name string
+	// RequiredSamples 窗口内保留的样本数，样本满了才判断趋势
+	RequiredSamples int
+	// RequiredSamplesMin 最早的样本超过 DownwardTrendMaxWait 之后，有这么多样本也可以判断趋势
+	RequiredSamplesMin     int
+	DownwardTrendThreshold float64
+	DownwardTrendMaxWait   time.Duration
+	// CollapseThreshold 连续相同的值在这段时间内只记录一次，0 表示每个值都记录
+	CollapseThreshold time.Duration
+	// ValidityWindow 超过这段时间的样本不再参与判断
+	ValidityWindow time.Duration
+}
+
+type trendSample struct {
+	value int64
+	at    time.Time
+}
+
+// TrendDetector 使用 Kendall's tau 判断一段时间内估计值的趋势
+type TrendDetector struct {
+	params TrendDetectorParams
+
+	startTime    time.Time
+	numSamples   int
+	samples      []trendSample
+	lowestValue  int64
+	highestValue int64
+
+	direction TrendDirection
+}
+
+// NewTrendDetector 创建趋势检测
+func NewTrendDetector(params TrendDetectorParams) *TrendDetector {
+	return &TrendDetector{
+		params:    params,
+		startTime: time.Now(),
+		direction: TrendDirectionNeutral,
+	}
+}
+
+// AddValue 添加一个估计值
+func (t *TrendDetector) AddValue(value int64) {
+	t.numSamples++
+	if t.lowestValue == 0 || value < t.lowestValue {
+		t.lowestValue = value
+	}
+	if value > t.highestValue {
+		t.highestValue = value
+	}
+
+	now := time.Now()
+	// 带宽稳定时接收端重复发送相同的估计值，合并这些值避免窗口被填满而看不出趋势
+	if len(t.samples) != 0 && t.params.CollapseThreshold > 0 {
+		last := t.samples[len(t.samples)-1]
+		if last.value == value && now.Sub(last.at) < t.params.CollapseThreshold {
+			return
+		}
+	}
+
+	t.prune(now)
+	t.samples = append(t.samples, trendSample{value: value, at: now})
+	if t.params.RequiredSamples > 0 && len(t.samples) > t.params.RequiredSamples {
+		t.samples = t.samples[len(t.samples)-t.params.RequiredSamples:]
+	}
+
+	t.updateDirection(now)
+}
+
+// GetLowest 检测开始以来最低的估计值
+func (t *TrendDetector) GetLowest() int64 {
+	return t.lowestValue
+}
+
+// GetHighest 检测开始以来最高的估计值
+func (t *TrendDetector) GetHighest() int64 {
+	return t.highestValue
+}
+
+// GetDirection 当前的趋势
+func (t *TrendDetector) GetDirection() TrendDirection {
+	return t.direction
+}
+
+func (t *TrendDetector) String() string {
+	return fmt.Sprintf("n: %s, t: %+v|%+v, v: %d|%d|%d|%.2f, d: %s",
+		t.params.Name,
+		t.startTime.Format(time.UnixDate),
+		time.Since(t.startTime),
+		t.numSamples,
+		t.lowestValue,
+		t.highestValue,
+		kendallsTau(t.samples),
+		t.direction,
+	)
+}
+
+func (t *TrendDetector) prune(now time.Time) {
+	if t.params.ValidityWindow <= 0 {
+		return
+	}
+
+	idx := 0
+	for ; idx < len(t.samples); idx++ {
+		if now.Sub(t.samples[idx].at) < t.params.ValidityWindow {
+			break
+		}
+	}
+	t.samples = t.samples[idx:]
+}
+
+func (t *TrendDetector) updateDirection(now time.Time) {
+	required := t.params.RequiredSamples
+	if len(t.samples) != 0 && now.Sub(t.samples[0].at) >= t.params.DownwardTrendMaxWait {
+		required = t.params.RequiredSamplesMin
+	}
+	if len(t.samples) < required || len(t.samples) < 2 {
+		t.direction = TrendDirectionNeutral
+		return
+	}
+
+	tau := kendallsTau(t.samples)
+	switch {
+	case tau < t.params.DownwardTrendThreshold:
+		t.direction = TrendDirectionDownward
+	case tau > 0:
+		t.direction = TrendDirectionUpward
+	default:
+		t.direction = TrendDirectionNeutral
+	}
+}
+
+// kendallsTau 按时间顺序比较每一对样本，同向的对数减去反向的对数再除以总对数
+func kendallsTau(samples []trendSample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+
+	concordant, discordant := 0, 0
+	for i := 0; i < len(samples); i++ {
+		for j := i + 1; j < len(samples); j++ {
+			switch {
+			case samples[j].value > samples[i].value:
+				concordant++
+			case samples[j].value < samples[i].value:
+				discordant++
+			}
+		}
+	}
+	pairs := len(samples) * (len(samples) - 1) / 2
+	return float64(concordant-discordant) / float64(pairs)
+}
@@ -0,0 +1,104 @@
+package prometheus
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrCPUStatsNotSupported = errors.New("cpu stats are only supported on linux")
+
+const (
+	procStatPath    = "/proc/stat"
+	procLoadAvgPath = "/proc/loadavg"
+)
+
+var (
+	cpuLock   sync.Mutex
+	lastTotal uint64
+	lastIdle  uint64
+)
+
+// cpuLoad 距离上次调用期间的CPU使用率，取值 0~1，第一次调用时为开机以来的平均值
+func cpuLoad() (float32, error) {
+	if runtime.GOOS != "linux" {
+		return 0, ErrCPUStatsNotSupported
+	}
+
+	total, idle, err := readCPUTimes()
+	if err != nil {
+		return 0, err
+	}
+
+	cpuLock.Lock()
+	defer cpuLock.Unlock()
+
+	deltaTotal := total - lastTotal
+	deltaIdle := idle - lastIdle
+	lastTotal, lastIdle = total, idle
+	if deltaTotal == 0 {
+		return 0, nil
+	}
+	return 1 - float32(deltaIdle)/float32(deltaTotal), nil
+}
+
+// readCPUTimes 读取 /proc/stat 第一行的累计CPU时间，idle 包括 iowait
+func readCPUTimes() (total uint64, idle uint64, err error) {
+	f, err := os.Open(procStatPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return 0, 0, errors.New("empty " + procStatPath)
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, errors.New("unexpected " + procStatPath + " format")
+	}
+	for i, field := range fields[1:] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += v
+		// idle 和 iowait
+		if i == 3 || i == 4 {
+			idle += v
+		}
+	}
+	return total, idle, nil
+}
+
+// loadAvg 系统最近 1、5、15 分钟的平均负载
+func loadAvg() (load1, load5, load15 float32, err error) {
+	if runtime.GOOS != "linux" {
+		return 0, 0, 0, ErrCPUStatsNotSupported
+	}
+
+	data, err := os.ReadFile(procLoadAvgPath)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return 0, 0, 0, errors.New("unexpected " + procLoadAvgPath + " format")
+	}
+	loads := make([]float32, 3)
+	for i := range loads {
+		v, err := strconv.ParseFloat(fields[i], 32)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		loads[i] = float32(v)
+	}
+	return loads[0], loads[1], loads[2], nil
+}
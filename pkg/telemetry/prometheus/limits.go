@@ -0,0 +1,124 @@
+package prometheus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+)
+
+const (
+	LimitNumTracks   = "num_tracks"
+	LimitBytesPerSec = "bytes_per_sec"
+
+	// ingressSampleInterval 采样节点接收字节数的间隔
+	ingressSampleInterval = time.Second
+	// ingressRateWindow 计算接收码率的窗口，参与者每秒汇总一次接收统计，窗口需要覆盖多次汇总避免码率跳变
+	ingressRateWindow = 5 * time.Second
+)
+
+var (
+	trackPublishedCurrent  atomic.Int32
+	trackSubscribedCurrent atomic.Int32
+
+	// 节点收到的全部字节数，用于计算接收码率
+	bytesIn     atomic.Uint64
+	ingressRate = &rateSampler{window: ingressRateWindow}
+
+	promLimitRejected      *prometheus.CounterVec
+	promIngressBytesPerSec prometheus.Gauge
+)
+
+// rateSampler 根据定期采样的累计字节数计算一个窗口内的速率
+type rateSampler struct {
+	window time.Duration
+
+	lock        sync.Mutex
+	samples     []rateSample
+	bytesPerSec float64
+}
+
+type rateSample struct {
+	at    time.Time
+	bytes uint64
+}
+
+// sample 记录一次累计字节数，用窗口内最早的采样计算速率
+func (r *rateSampler) sample(now time.Time, total uint64) float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.samples = append(r.samples, rateSample{at: now, bytes: total})
+	for len(r.samples) > 2 && now.Sub(r.samples[1].at) >= r.window {
+		r.samples = r.samples[1:]
+	}
+
+	oldest, newest := r.samples[0], r.samples[len(r.samples)-1]
+	if elapsed := newest.at.Sub(oldest.at); elapsed > 0 {
+		r.bytesPerSec = float64(newest.bytes-oldest.bytes) / elapsed.Seconds()
+	}
+	return r.bytesPerSec
+}
+
+func (r *rateSampler) rate() float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.bytesPerSec
+}
+
+func initLimitStats(constLabels prometheus.Labels) {
+	promLimitRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "limit",
+		Name:        "rejected_total",
+		Help:        "超过节点限制而被拒绝的发布请求数量",
+		ConstLabels: constLabels,
+	}, []string{"limit"})
+	promIngressBytesPerSec = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "packet",
+		Name:        "bytes_in_per_sec",
+		Help:        "当前节点的接收码率",
+		ConstLabels: constLabels,
+	})
+
+	prometheus.MustRegister(promLimitRejected)
+	prometheus.MustRegister(promIngressBytesPerSec)
+}
+
+// RecordLimitRejected 记录因超过限制被拒绝的请求，limit 为 LimitNumTracks 或 LimitBytesPerSec
+func RecordLimitRejected(limit string) {
+	if !initialized.Load() {
+		return
+	}
+	promLimitRejected.WithLabelValues(limit).Inc()
+}
+
+// TrackCount 当前节点上发布和订阅的音轨数量
+func TrackCount() (published int32, subscribed int32) {
+	return trackPublishedCurrent.Load(), trackSubscribedCurrent.Load()
+}
+
+// IngressBytesPerSec 当前节点最近一个窗口内的接收码率，由 Init 启动的采样更新
+func IngressBytesPerSec() float64 {
+	return ingressRate.rate()
+}
+
+// sampleIngress 采样节点收到的字节数并更新接收码率
+func sampleIngress(now time.Time) {
+	bytesPerSec := ingressRate.sample(now, bytesIn.Load())
+	if initialized.Load() {
+		promIngressBytesPerSec.Set(bytesPerSec)
+	}
+}
+
+func ingressSampleWorker() {
+	ticker := time.NewTicker(ingressSampleInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		sampleIngress(now)
+	}
+}
@@ -0,0 +1,40 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateSampler(t *testing.T) {
+	r := &rateSampler{window: 5 * time.Second}
+	start := time.Now()
+	require.Zero(t, r.sample(start, 0))
+
+	require.Equal(t, float64(1000), r.sample(start.Add(time.Second), 1000))
+	require.Equal(t, float64(2000), r.sample(start.Add(2*time.Second), 4000))
+	require.Equal(t, float64(2000), r.rate())
+
+	// 窗口覆盖多次采样，一次采样内到达两批统计不会让码率翻倍
+	require.InDelta(t, float64(6000)/3, r.sample(start.Add(3*time.Second), 6000), 0.001)
+
+	// 空闲超过一个窗口后码率回到 0，而不是长时间的平均值
+	var rate float64
+	for i := 4; i <= 10; i++ {
+		rate = r.sample(start.Add(time.Duration(i)*time.Second), 6000)
+	}
+	require.Zero(t, rate)
+	require.LessOrEqual(t, len(r.samples), 7)
+}
+
+func TestIngressBytesPerSec(t *testing.T) {
+	before := bytesIn.Load()
+	IncrementBytes(Outgoing, 5000, false)
+	require.Equal(t, before, bytesIn.Load())
+
+	IncrementBytes(Incoming, 1000, false)
+	require.Equal(t, before+1000, bytesIn.Load())
+	sampleIngress(time.Now())
+	require.Equal(t, ingressRate.rate(), IngressBytesPerSec())
+}
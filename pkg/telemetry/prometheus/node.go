@@ -0,0 +1,82 @@
+package prometheus
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/atomic"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+const (
+	namespace = "tc"
+)
+
+var (
+	initOnce    sync.Once
+	initialized atomic.Bool
+)
+
+// Init 注册全部指标并开始采样接收码率，node_id 和 node_type 作为常量标签，多次调用只生效一次，调用之前记录的指标会被忽略
+func Init(nodeID string, nodeType tc.NodeType) {
+	initOnce.Do(func() {
+		constLabels := prometheus.Labels{"node_id": nodeID, "node_type": nodeType.String()}
+		initRoomStats(constLabels)
+		initPacketStats(constLabels)
+		initSignalStats(constLabels)
+		initLimitStats(constLabels)
+		initialized.Store(true)
+
+		sampleIngress(time.Now())
+		go ingressSampleWorker()
+	})
+}
+
+// NewServer 创建暴露 /metrics 的 HTTP 服务，port 为 0 时返回 nil
+func NewServer(port uint32) *http.Server {
+	if port == 0 {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
+	}
+}
+
+// GetUpdatedNodeStats 根据当前节点的计数和CPU使用率生成新的节点统计，prev 为上一次的统计
+func GetUpdatedNodeStats(prev *tc.NodeStats) (*tc.NodeStats, error) {
+	load, err := cpuLoad()
+	if err != nil {
+		return nil, err
+	}
+	load1, load5, load15, err := loadAvg()
+	if err != nil {
+		return nil, err
+	}
+
+	published, subscribed := TrackCount()
+	return &tc.NodeStats{
+		StartedAt:        prev.GetStartedAt(),
+		UpdatedAt:        time.Now().Unix(),
+		NumRooms:         RoomCount(),
+		NumClients:       ParticipantCount(),
+		NumTracksIn:      published,
+		NumTracksOut:     subscribed,
+		BytesIn:          bytesIn.Load(),
+		BytesInPerSec:    float32(IngressBytesPerSec()),
+		NumCpus:          uint32(runtime.NumCPU()),
+		CpuLoad:          load,
+		LoadAvgLast1Min:  load1,
+		LoadAvgLast5Min:  load5,
+		LoadAvgLast15Min: load15,
+	}, nil
+}
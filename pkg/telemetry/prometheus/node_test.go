@@ -0,0 +1,48 @@
+package prometheus
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+func TestNodeCounters(t *testing.T) {
+	Init("ND_test", tc.NodeType_SERVER)
+
+	rooms, participants := RoomCount(), ParticipantCount()
+	RoomStarted()
+	AddParticipant()
+	AddParticipant()
+	require.Equal(t, rooms+1, RoomCount())
+	require.Equal(t, participants+2, ParticipantCount())
+	require.Equal(t, float64(rooms+1), testutil.ToFloat64(promRoomCurrent))
+	require.Equal(t, float64(participants+2), testutil.ToFloat64(promParticipantCurrent))
+
+	joined := testutil.ToFloat64(promParticipantJoin.WithLabelValues(JoinStateJoined))
+	IncrementParticipantJoin(JoinStateJoined)
+	require.Equal(t, joined+1, testutil.ToFloat64(promParticipantJoin.WithLabelValues(JoinStateJoined)))
+
+	if runtime.GOOS == "linux" {
+		stats, err := GetUpdatedNodeStats(&tc.NodeStats{StartedAt: 100})
+		require.NoError(t, err)
+		require.Equal(t, int64(100), stats.StartedAt)
+		require.Equal(t, rooms+1, stats.NumRooms)
+		require.Equal(t, participants+2, stats.NumClients)
+	}
+
+	SubParticipant()
+	SubParticipant()
+	RoomEnded()
+	require.Equal(t, rooms, RoomCount())
+	require.Equal(t, participants, ParticipantCount())
+	require.Equal(t, float64(rooms), testutil.ToFloat64(promRoomCurrent))
+}
+
+func TestNewServer(t *testing.T) {
+	require.Nil(t, NewServer(0))
+	require.Equal(t, ":7889", NewServer(7889).Addr)
+}
@@ -0,0 +1,145 @@
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Direction 数据方向
+type Direction string
+
+const (
+	Incoming Direction = "incoming"
+	Outgoing Direction = "outgoing"
+
+	transmissionInitial    = "initial"
+	transmissionRetransmit = "retransmit"
+)
+
+var (
+	promPacketTotal *prometheus.CounterVec
+	promPacketBytes *prometheus.CounterVec
+	promPacketLoss  *prometheus.CounterVec
+	promNackTotal   *prometheus.CounterVec
+	promPliTotal    *prometheus.CounterVec
+	promFirTotal    *prometheus.CounterVec
+	promRTT         prometheus.Histogram
+)
+
+func initPacketStats(constLabels prometheus.Labels) {
+	promPacketTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "packet",
+		Name:        "total",
+		Help:        "RTP包数量",
+		ConstLabels: constLabels,
+	}, []string{"direction", "transmission"})
+	promPacketBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "packet",
+		Name:        "bytes",
+		Help:        "RTP包字节数",
+		ConstLabels: constLabels,
+	}, []string{"direction", "transmission"})
+	promPacketLoss = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "packet",
+		Name:        "loss",
+		Help:        "丢失的RTP包数量",
+		ConstLabels: constLabels,
+	}, []string{"direction"})
+	promNackTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "nack",
+		Name:        "total",
+		Help:        "NACK数量",
+		ConstLabels: constLabels,
+	}, []string{"direction"})
+	promPliTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "pli",
+		Name:        "total",
+		Help:        "PLI数量",
+		ConstLabels: constLabels,
+	}, []string{"direction"})
+	promFirTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "fir",
+		Name:        "total",
+		Help:        "FIR数量",
+		ConstLabels: constLabels,
+	}, []string{"direction"})
+	promRTT = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Subsystem:   "conn",
+		Name:        "rtt_ms",
+		Help:        "媒体连接的往返时延",
+		ConstLabels: constLabels,
+		Buckets:     []float64{10, 25, 50, 75, 100, 150, 200, 300, 500, 1000},
+	})
+
+	prometheus.MustRegister(promPacketTotal)
+	prometheus.MustRegister(promPacketBytes)
+	prometheus.MustRegister(promPacketLoss)
+	prometheus.MustRegister(promNackTotal)
+	prometheus.MustRegister(promPliTotal)
+	prometheus.MustRegister(promFirTotal)
+	prometheus.MustRegister(promRTT)
+}
+
+// IncrementPackets 增加RTP包数量
+func IncrementPackets(direction Direction, count uint64, retransmit bool) {
+	if !initialized.Load() {
+		return
+	}
+	promPacketTotal.WithLabelValues(string(direction), transmissionLabel(retransmit)).Add(float64(count))
+}
+
+// IncrementBytes 增加RTP包字节数
+func IncrementBytes(direction Direction, count uint64, retransmit bool) {
+	if direction == Incoming {
+		bytesIn.Add(count)
+	}
+	if !initialized.Load() {
+		return
+	}
+	promPacketBytes.WithLabelValues(string(direction), transmissionLabel(retransmit)).Add(float64(count))
+}
+
+// IncrementPacketLoss 增加丢包数量
+func IncrementPacketLoss(direction Direction, count uint32) {
+	if !initialized.Load() || count == 0 {
+		return
+	}
+	promPacketLoss.WithLabelValues(string(direction)).Add(float64(count))
+}
+
+// IncrementRTCP 增加RTCP反馈数量
+func IncrementRTCP(direction Direction, nack, pli, fir uint32) {
+	if !initialized.Load() {
+		return
+	}
+	if nack > 0 {
+		promNackTotal.WithLabelValues(string(direction)).Add(float64(nack))
+	}
+	if pli > 0 {
+		promPliTotal.WithLabelValues(string(direction)).Add(float64(pli))
+	}
+	if fir > 0 {
+		promFirTotal.WithLabelValues(string(direction)).Add(float64(fir))
+	}
+}
+
+// RecordRTT 记录往返时延，单位毫秒
+func RecordRTT(rtt uint32) {
+	if !initialized.Load() || rtt == 0 {
+		return
+	}
+	promRTT.Observe(float64(rtt))
+}
+
+func transmissionLabel(retransmit bool) string {
+	if retransmit {
+		return transmissionRetransmit
+	}
+	return transmissionInitial
+}
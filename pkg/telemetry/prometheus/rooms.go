@@ -0,0 +1,187 @@
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+// 参与者加入的各个阶段
+const (
+	JoinStateSignalConnected = "signal_connected"
+	JoinStateJoined          = "joined"
+	JoinStateRTCConnected    = "rtc_connected"
+)
+
+var (
+	roomCurrent        atomic.Int32
+	participantCurrent atomic.Int32
+
+	promRoomCurrent            prometheus.Gauge
+	promParticipantCurrent     prometheus.Gauge
+	promTrackPublishedCurrent  *prometheus.GaugeVec
+	promTrackSubscribedCurrent *prometheus.GaugeVec
+	promParticipantJoin        *prometheus.CounterVec
+	promJoinLatency            prometheus.Histogram
+)
+
+func initRoomStats(constLabels prometheus.Labels) {
+	promRoomCurrent = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "room",
+		Name:        "total",
+		Help:        "当前节点上的房间数量",
+		ConstLabels: constLabels,
+	})
+	promParticipantCurrent = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "participant",
+		Name:        "total",
+		Help:        "当前节点上的参与者数量",
+		ConstLabels: constLabels,
+	})
+	promTrackPublishedCurrent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "track",
+		Name:        "published_total",
+		Help:        "当前节点上发布的音轨数量",
+		ConstLabels: constLabels,
+	}, []string{"kind"})
+	promTrackSubscribedCurrent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "track",
+		Name:        "subscribed_total",
+		Help:        "当前节点上订阅的音轨数量",
+		ConstLabels: constLabels,
+	}, []string{"kind"})
+	promParticipantJoin = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "participant",
+		Name:        "join",
+		Help:        "参与者加入各阶段的次数",
+		ConstLabels: constLabels,
+	}, []string{"state"})
+	promJoinLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Subsystem:   "participant",
+		Name:        "join_latency_seconds",
+		Help:        "从收到加入请求到参与者进入 ACTIVE 状态的时间",
+		ConstLabels: constLabels,
+		Buckets:     []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 20},
+	})
+
+	prometheus.MustRegister(promRoomCurrent)
+	prometheus.MustRegister(promParticipantCurrent)
+	prometheus.MustRegister(promTrackPublishedCurrent)
+	prometheus.MustRegister(promTrackSubscribedCurrent)
+	prometheus.MustRegister(promParticipantJoin)
+	prometheus.MustRegister(promJoinLatency)
+}
+
+// RoomStarted 房间创建
+func RoomStarted() {
+	roomCurrent.Inc()
+	if initialized.Load() {
+		promRoomCurrent.Add(1)
+	}
+}
+
+// RoomEnded 房间关闭
+func RoomEnded() {
+	roomCurrent.Dec()
+	if initialized.Load() {
+		promRoomCurrent.Sub(1)
+	}
+}
+
+// AddParticipant 参与者加入
+func AddParticipant() {
+	participantCurrent.Inc()
+	if initialized.Load() {
+		promParticipantCurrent.Add(1)
+	}
+}
+
+// SubParticipant 参与者离开
+func SubParticipant() {
+	participantCurrent.Dec()
+	if initialized.Load() {
+		promParticipantCurrent.Sub(1)
+	}
+}
+
+// IncrementParticipantJoin 参与者加入各阶段的计数，state 取值为 JoinState 开头的常量
+func IncrementParticipantJoin(state string) {
+	if !initialized.Load() {
+		return
+	}
+	promParticipantJoin.WithLabelValues(state).Inc()
+}
+
+// RecordJoinLatency 记录参与者加入耗时
+func RecordJoinLatency(latency time.Duration) {
+	if !initialized.Load() {
+		return
+	}
+	promJoinLatency.Observe(latency.Seconds())
+}
+
+// AddPublishedTrack 发布音轨
+func AddPublishedTrack(kind tc.TrackType) {
+	trackPublishedCurrent.Inc()
+	if !initialized.Load() {
+		return
+	}
+	promTrackPublishedCurrent.WithLabelValues(kindLabel(kind)).Add(1)
+}
+
+// SubPublishedTrack 取消发布音轨
+func SubPublishedTrack(kind tc.TrackType) {
+	trackPublishedCurrent.Dec()
+	if !initialized.Load() {
+		return
+	}
+	promTrackPublishedCurrent.WithLabelValues(kindLabel(kind)).Sub(1)
+}
+
+// AddSubscribedTrack 订阅音轨
+func AddSubscribedTrack(kind tc.TrackType) {
+	trackSubscribedCurrent.Inc()
+	if !initialized.Load() {
+		return
+	}
+	promTrackSubscribedCurrent.WithLabelValues(kindLabel(kind)).Add(1)
+}
+
+// SubSubscribedTrack 取消订阅音轨
+func SubSubscribedTrack(kind tc.TrackType) {
+	trackSubscribedCurrent.Dec()
+	if !initialized.Load() {
+		return
+	}
+	promTrackSubscribedCurrent.WithLabelValues(kindLabel(kind)).Sub(1)
+}
+
+// RoomCount 当前房间数量
+func RoomCount() int32 {
+	return roomCurrent.Load()
+}
+
+// ParticipantCount 当前参与者数量
+func ParticipantCount() int32 {
+	return participantCurrent.Load()
+}
+
+func kindLabel(kind tc.TrackType) string {
+	switch kind {
+	case tc.TrackType_AUDIO:
+		return "audio"
+	case tc.TrackType_VIDEO:
+		return "video"
+	default:
+		return "data"
+	}
+}
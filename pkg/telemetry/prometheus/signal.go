@@ -0,0 +1,68 @@
+package prometheus
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+)
+
+var (
+	promSignalMessage   *prometheus.CounterVec
+	promOpsQueueDropped prometheus.Counter
+)
+
+func initSignalStats(constLabels prometheus.Labels) {
+	promSignalMessage = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "signal",
+		Name:        "message_total",
+		Help:        "信令消息数量，type 为 SignalRequest/SignalResponse 中的消息类型",
+		ConstLabels: constLabels,
+	}, []string{"direction", "type"})
+	promOpsQueueDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "ops_queue",
+		Name:        "dropped_total",
+		Help:        "队列已满而被丢弃的操作数量",
+		ConstLabels: constLabels,
+	})
+
+	prometheus.MustRegister(promSignalMessage)
+	prometheus.MustRegister(promOpsQueueDropped)
+}
+
+// RecordSignalRequest 记录收到的信令消息
+func RecordSignalRequest(req *tc.SignalRequest) {
+	if !initialized.Load() || req == nil || req.Message == nil {
+		return
+	}
+	promSignalMessage.WithLabelValues(string(Incoming), messageType(req.Message, "SignalRequest_")).Inc()
+}
+
+// RecordSignalResponse 记录发送的信令消息
+func RecordSignalResponse(res *tc.SignalResponse) {
+	if !initialized.Load() || res == nil || res.Message == nil {
+		return
+	}
+	promSignalMessage.WithLabelValues(string(Outgoing), messageType(res.Message, "SignalResponse_")).Inc()
+}
+
+// IncrementOpsQueueDropped 记录被丢弃的操作
+func IncrementOpsQueueDropped() {
+	if !initialized.Load() {
+		return
+	}
+	promOpsQueueDropped.Inc()
+}
+
+// messageType 由 oneof 的包装类型得到消息类型，取值范围有限，如 SignalRequest_Offer -> offer
+func messageType(msg interface{}, prefix string) string {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.ToLower(strings.TrimPrefix(t.Name(), prefix))
+}
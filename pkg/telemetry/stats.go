@@ -0,0 +1,24 @@
+package telemetry
+
+import (
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+)
+
+// RecordRTPDelta 将一个统计周期内的 RTPStats 增量汇总到节点级别的指标中，
+// 字节数按照线路上传输的计算，包括RTP头部和填充包，节点的接收码率限制以此为准。
+// 往返时延不在增量中汇总，由下行音轨的 RTT 变化事件记录
+func RecordRTPDelta(direction prometheus.Direction, delta *buffer.RTPDeltaInfo) {
+	if delta == nil {
+		return
+	}
+
+	prometheus.IncrementPackets(direction, uint64(delta.Packets+delta.PacketsPadding), false)
+	prometheus.IncrementBytes(direction, delta.Bytes+delta.HeaderBytes+delta.BytesPadding+delta.HeaderBytesPadding, false)
+	if delta.PacketsDuplicate > 0 {
+		prometheus.IncrementPackets(direction, uint64(delta.PacketsDuplicate), true)
+		prometheus.IncrementBytes(direction, delta.BytesDuplicate+delta.HeaderBytesDuplicate, true)
+	}
+	prometheus.IncrementPacketLoss(direction, delta.PacketsLost)
+	prometheus.IncrementRTCP(direction, delta.Nacks, delta.Plis, delta.Firs)
+}
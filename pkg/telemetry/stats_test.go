@@ -0,0 +1,92 @@
+package telemetry
+
+import (
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liuhailove/tc-base-go/protocol/tc"
+	"github.com/liuhailove/tc-server/pkg/sfu/buffer"
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
+)
+
+// counterValue 从默认注册表中读取带有指定标签的计数
+func counterValue(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := prom.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if v, ok := labels[label.GetName()]; ok && v != label.GetValue() {
+					continue metrics
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestRecordRTPDelta(t *testing.T) {
+	prometheus.Init("ND_test", tc.NodeType_SERVER)
+
+	initial := map[string]string{"direction": string(prometheus.Incoming), "transmission": "initial"}
+	retransmit := map[string]string{"direction": string(prometheus.Incoming), "transmission": "retransmit"}
+	packets := counterValue(t, "tc_packet_total", initial)
+	bytes := counterValue(t, "tc_packet_bytes", initial)
+	retransmitBytes := counterValue(t, "tc_packet_bytes", retransmit)
+	loss := counterValue(t, "tc_packet_loss", map[string]string{"direction": string(prometheus.Incoming)})
+
+	RecordRTPDelta(prometheus.Incoming, nil)
+	RecordRTPDelta(prometheus.Incoming, &buffer.RTPDeltaInfo{
+		Packets:              10,
+		Bytes:                1000,
+		HeaderBytes:          120,
+		PacketsDuplicate:     2,
+		BytesDuplicate:       200,
+		HeaderBytesDuplicate: 24,
+		PacketsPadding:       3,
+		BytesPadding:         300,
+		HeaderBytesPadding:   36,
+		PacketsLost:          4,
+	})
+
+	// 包括头部和填充包
+	require.Equal(t, packets+13, counterValue(t, "tc_packet_total", initial))
+	require.Equal(t, bytes+1456, counterValue(t, "tc_packet_bytes", initial))
+	require.Equal(t, retransmitBytes+224, counterValue(t, "tc_packet_bytes", retransmit))
+	require.Equal(t, loss+4, counterValue(t, "tc_packet_loss", map[string]string{"direction": string(prometheus.Incoming)}))
+}
+
+// histogramCount 从默认注册表中读取直方图的样本数量
+func histogramCount(t *testing.T, name string) uint64 {
+	families, err := prom.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name && len(family.GetMetric()) > 0 {
+			return family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestRecordRTT(t *testing.T) {
+	prometheus.Init("ND_test", tc.NodeType_SERVER)
+	samples := histogramCount(t, "tc_conn_rtt_ms")
+
+	// 增量中的 RTT 不作为样本
+	RecordRTPDelta(prometheus.Outgoing, &buffer.RTPDeltaInfo{Packets: 10, RttMax: 40})
+	require.Equal(t, samples, histogramCount(t, "tc_conn_rtt_ms"))
+
+	// 还没有测得 RTT 时不记录
+	prometheus.RecordRTT(0)
+	require.Equal(t, samples, histogramCount(t, "tc_conn_rtt_ms"))
+
+	prometheus.RecordRTT(40)
+	require.Equal(t, samples+1, histogramCount(t, "tc_conn_rtt_ms"))
+}
@@ -1,8 +1,11 @@
 package utils
 
 import (
-	"github.com/liuhailove/tc-base-go/protocol/logger"
 	"sync"
+
+	"github.com/liuhailove/tc-base-go/protocol/logger"
+
+	"github.com/liuhailove/tc-server/pkg/telemetry/prometheus"
 )
 
 type OpsQueue struct {
@@ -46,6 +49,7 @@ func (oq *OpsQueue) Stop() {
 	oq.lock.Lock()
 	if oq.isStopped {
 		oq.lock.Unlock()
+		return
 	}
 
 	oq.isStopped = true
@@ -70,6 +74,7 @@ func (oq *OpsQueue) Enqueue(op func()) {
 	select {
 	case oq.ops <- op:
 	default:
+		prometheus.IncrementOpsQueueDropped()
 		oq.logger.Errorw("ops queue full", nil, "name", oq.name, "size", oq.size)
 	}
 	oq.lock.RUnlock()